@@ -0,0 +1,114 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// StoreStateCode is the two-digit GST state code of the store. Sales to a
+// different place of supply, and purchases from suppliers registered in
+// another state, are charged IGST instead of CGST + SGST.
+var StoreStateCode string
+
+// StoreGSTIN is the store's own GST registration number.
+var StoreGSTIN string
+
+// Oversell policies: what happens when an invoice is confirmed for more units
+// than are in stock.
+const (
+	OversellBlock = "block" // reject the invoice
+	OversellWarn  = "warn"  // post it, and report the short lines
+	OversellAllow = "allow" // post it silently
+)
+
+// OversellPolicy is one of the Oversell* constants, from OVERSELL_POLICY.
+var OversellPolicy string
+
+// Valuation methods: how outgoing stock is costed.
+const (
+	ValuationWAC  = "wac"  // moving weighted average
+	ValuationFIFO = "fifo" // oldest receipts first
+)
+
+// ValuationMethod is one of the Valuation* constants, from VALUATION_METHOD.
+var ValuationMethod string
+
+// Scale barcode kinds: what the value digits of an EAN-13 printed by a
+// weighing scale hold.
+const (
+	ScalePrice  = "price"  // amount payable, in paise
+	ScaleWeight = "weight" // weight, in grams
+)
+
+// ScaleBarcodePrefixes maps the two-digit prefixes of scale-printed EAN-13s
+// to their kind, from SCALE_BARCODE_PREFIXES ("21:price,22:weight"). Such
+// codes read PP IIIII VVVVV C: prefix, product id, value and check digit.
+// Prefix 20 is left for the store's own EAN-13s.
+var ScaleBarcodePrefixes = map[string]string{"21": ScalePrice, "22": ScaleWeight}
+
+// AdjustmentApprovalLimit is the stock value (at purchase price) above which a
+// manual stock adjustment waits for an admin before it is posted.
+var AdjustmentApprovalLimit = 5000.0
+
+func InitConfig() {
+	StoreGSTIN = os.Getenv("STORE_GSTIN")
+	StoreStateCode = os.Getenv("STORE_STATE_CODE")
+
+	if StoreStateCode == "" && len(StoreGSTIN) >= 2 {
+		StoreStateCode = StoreGSTIN[:2]
+	}
+
+	if StoreStateCode == "" {
+		log.Println("⚠️ STORE_STATE_CODE not set, all sales and purchases treated as intra-state")
+	}
+
+	OversellPolicy = strings.ToLower(strings.TrimSpace(os.Getenv("OVERSELL_POLICY")))
+	switch OversellPolicy {
+	case OversellBlock, OversellWarn, OversellAllow:
+	default:
+		if OversellPolicy != "" {
+			log.Printf("⚠️ unknown OVERSELL_POLICY %q, using %q", OversellPolicy, OversellWarn)
+		}
+		OversellPolicy = OversellWarn
+	}
+
+	ValuationMethod = strings.ToLower(strings.TrimSpace(os.Getenv("VALUATION_METHOD")))
+	switch ValuationMethod {
+	case ValuationWAC, ValuationFIFO:
+	default:
+		if ValuationMethod != "" {
+			log.Printf("⚠️ unknown VALUATION_METHOD %q, using %q", ValuationMethod, ValuationWAC)
+		}
+		ValuationMethod = ValuationWAC
+	}
+
+	if v := os.Getenv("SCALE_BARCODE_PREFIXES"); v != "" {
+		prefixes := map[string]string{}
+		valid := true
+		for _, part := range strings.Split(v, ",") {
+			prefix, kind, ok := strings.Cut(strings.TrimSpace(part), ":")
+			prefix, kind = strings.TrimSpace(prefix), strings.ToLower(strings.TrimSpace(kind))
+			if !ok || len(prefix) != 2 || prefix[0] != '2' || prefix[1] < '1' || prefix[1] > '9' || (kind != ScalePrice && kind != ScaleWeight) {
+				valid = false
+				break
+			}
+			prefixes[prefix] = kind
+		}
+		if valid {
+			ScaleBarcodePrefixes = prefixes
+		} else {
+			log.Printf("⚠️ invalid SCALE_BARCODE_PREFIXES %q, using 21:price,22:weight", v)
+		}
+	}
+
+	if v := os.Getenv("ADJUSTMENT_APPROVAL_LIMIT"); v != "" {
+		limit, err := strconv.ParseFloat(v, 64)
+		if err != nil || limit < 0 {
+			log.Printf("⚠️ invalid ADJUSTMENT_APPROVAL_LIMIT %q, using %.2f", v, AdjustmentApprovalLimit)
+		} else {
+			AdjustmentApprovalLimit = limit
+		}
+	}
+}
@@ -72,11 +72,32 @@ func Login(c *gin.Context) {
 		roles = append(roles, r)
 	}
 
+	// Fetch permissions granted through roles
+	permRows, err := db.DB.Query(c, `
+		SELECT DISTINCT p.name
+		FROM permissions p
+		JOIN role_permissions rp ON rp.permission_id = p.id
+		JOIN user_roles ur ON ur.role_id = rp.role_id
+		WHERE ur.user_id = $1
+	`, userID)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "cannot fetch permissions")
+		return
+	}
+
+	permissions := []string{}
+	for permRows.Next() {
+		var p string
+		permRows.Scan(&p)
+		permissions = append(permissions, p)
+	}
+
 	// Create JWT token
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": userID,
-		"roles":   roles,
-		"exp":     time.Now().Add(24 * time.Hour).Unix(),
+		"user_id":     userID,
+		"roles":       roles,
+		"permissions": permissions,
+		"exp":         time.Now().Add(24 * time.Hour).Unix(),
 	})
 
 	tokenString, _ := token.SignedString(jwtSecret)
@@ -84,9 +105,10 @@ func Login(c *gin.Context) {
 	utils.SendSuccessResponse(c, http.StatusOK, gin.H{
 		"token": tokenString,
 		"user": gin.H{
-			"id":    userID,
-			"email": input.Email,
-			"roles": roles,
+			"id":          userID,
+			"email":       input.Email,
+			"roles":       roles,
+			"permissions": permissions,
 		},
 	}, "Login successful")
 }
@@ -0,0 +1,298 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"tulsi-pos/db"
+	"tulsi-pos/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// ---------- Request DTOs ----------
+
+// GoodsReceiptItemInput names the PO line directly, or just the product when
+// receiving by scan.
+type GoodsReceiptItemInput struct {
+	PurchaseOrderItemID int64 `json:"purchase_order_item_id"`
+	ProductID           int64 `json:"product_id"`
+	Quantity            int   `json:"quantity" binding:"required,min=1"`
+}
+
+type GoodsReceiptInput struct {
+	SupplierChallan string                  `json:"supplier_challan"`
+	Notes           string                  `json:"notes"`
+	Items           []GoodsReceiptItemInput `json:"items" binding:"required,min=1,dive"`
+}
+
+var ErrReceiptItemNotOnOrder = errors.New("received item is not on the purchase order")
+
+// ---------- Public Handlers ----------
+
+// POST /purchase-orders/:id/receipts
+// Records goods arriving against a SENT or PARTIAL purchase order and brings
+// them into stock. Receiving more than ordered is allowed and shows up as the
+// line's over quantity.
+func CreateGoodsReceipt(c *gin.Context) {
+	poID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || poID <= 0 {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid purchase order id")
+		return
+	}
+
+	var in GoodsReceiptInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid payload: "+err.Error())
+		return
+	}
+
+	id, number, status, err := createGoodsReceipt(c.Request.Context(), poID, c.GetInt("user_id"), in)
+	if err != nil {
+		sendPurchaseOrderError(c, err)
+		return
+	}
+
+	utils.SendSuccessResponse(c, http.StatusCreated, gin.H{
+		"id":         id,
+		"grn_number": number,
+		"po_status":  status,
+	}, "goods receipt recorded")
+}
+
+// GET /goods-receipts/:id
+func GetGoodsReceiptByID(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid goods receipt id")
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	var header struct {
+		ID              int64  `json:"id"`
+		GRNNumber       string `json:"grn_number"`
+		PurchaseOrderID int64  `json:"purchase_order_id"`
+		PONumber        string `json:"po_number"`
+		SupplierName    string `json:"supplier_name"`
+		SupplierChallan string `json:"supplier_challan"`
+		Notes           string `json:"notes"`
+		CreatedAt       string `json:"created_at"`
+	}
+
+	var createdAt time.Time
+	err = db.DB.QueryRow(ctx, `
+		SELECT gr.id, gr.grn_number, gr.purchase_order_id, po.po_number, s.name,
+		       COALESCE(gr.supplier_challan, ''), COALESCE(gr.notes, ''), gr.created_at
+		FROM goods_receipts gr
+		JOIN purchase_orders po ON po.id = gr.purchase_order_id
+		JOIN suppliers s ON s.id = gr.supplier_id
+		WHERE gr.id = $1
+	`, id).Scan(&header.ID, &header.GRNNumber, &header.PurchaseOrderID, &header.PONumber,
+		&header.SupplierName, &header.SupplierChallan, &header.Notes, &createdAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			utils.SendErrorResponse(c, http.StatusNotFound, "goods receipt not found")
+			return
+		}
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	header.CreatedAt = utils.FormatDateTime(createdAt)
+
+	rows, err := db.DB.Query(ctx, `
+		SELECT gri.id, gri.purchase_order_item_id, gri.product_id, p.name,
+		       COALESCE(p.sku, ''), gri.quantity
+		FROM goods_receipt_items gri
+		JOIN products p ON p.id = gri.product_id
+		WHERE gri.goods_receipt_id = $1
+		ORDER BY gri.id
+	`, id)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	items := []gin.H{}
+	for rows.Next() {
+		var (
+			itemID, poItemID, productID int64
+			name, sku                   string
+			qty                         int
+		)
+		if err := rows.Scan(&itemID, &poItemID, &productID, &name, &sku, &qty); err != nil {
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		items = append(items, gin.H{
+			"id":                     itemID,
+			"purchase_order_item_id": poItemID,
+			"product_id":             productID,
+			"product_name":           name,
+			"sku":                    sku,
+			"quantity":               qty,
+		})
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, gin.H{
+		"goods_receipt": header,
+		"items":         items,
+	}, "Goods receipt fetched successfully")
+}
+
+// ---------- Internal Logic ----------
+
+func createGoodsReceipt(ctx context.Context, poID int64, userID int, in GoodsReceiptInput) (int64, string, string, error) {
+	tx, err := db.DB.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, "", "", fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	status, supplierID, err := lockPurchaseOrder(ctx, tx, poID)
+	if err != nil {
+		return 0, "", "", err
+	}
+	if status != POSent && status != POPartial {
+		return 0, "", "", fmt.Errorf("%w: goods can only be received on SENT or PARTIAL orders", ErrPurchaseOrderStatus)
+	}
+
+	// resolve each received line to its PO line
+	type receiptLine struct {
+		poItemID  int64
+		productID int64
+		qty       int
+		unitPrice float64
+	}
+	lines := make([]receiptLine, 0, len(in.Items))
+	for _, it := range in.Items {
+		var l receiptLine
+		err := tx.QueryRow(ctx, `
+			SELECT id, product_id, COALESCE(unit_price, -1)
+			FROM purchase_order_items
+			WHERE purchase_order_id = $1
+			  AND (id = $2 OR ($2 = 0 AND product_id = $3))
+			ORDER BY id
+			LIMIT 1
+		`, poID, it.PurchaseOrderItemID, it.ProductID).Scan(&l.poItemID, &l.productID, &l.unitPrice)
+		if err == pgx.ErrNoRows {
+			return 0, "", "", fmt.Errorf("%w: item %d / product %d", ErrReceiptItemNotOnOrder, it.PurchaseOrderItemID, it.ProductID)
+		}
+		if err != nil {
+			return 0, "", "", fmt.Errorf("resolve receipt item: %w", err)
+		}
+		l.qty = it.Quantity
+		lines = append(lines, l)
+	}
+
+	number, err := nextDocumentNumber(ctx, tx, SeriesGoodsReceipt, time.Now())
+	if err != nil {
+		return 0, "", "", err
+	}
+
+	var id int64
+	err = tx.QueryRow(ctx, `
+		INSERT INTO goods_receipts (
+			grn_number, purchase_order_id, supplier_id, supplier_challan, notes, created_by
+		) VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), $6)
+		RETURNING id
+	`, number, poID, supplierID, strings.TrimSpace(in.SupplierChallan),
+		strings.TrimSpace(in.Notes), nullableUserID(userID)).Scan(&id)
+	if err != nil {
+		return 0, "", "", fmt.Errorf("insert goods receipt: %w", err)
+	}
+
+	for _, l := range lines {
+		_, err = tx.Exec(ctx, `
+			INSERT INTO goods_receipt_items (goods_receipt_id, purchase_order_item_id, product_id, quantity)
+			VALUES ($1, $2, $3, $4)
+		`, id, l.poItemID, l.productID, l.qty)
+		if err != nil {
+			return 0, "", "", fmt.Errorf("insert goods receipt item: %w", err)
+		}
+
+		_, err = tx.Exec(ctx, `
+			UPDATE purchase_order_items
+			SET received_qty = received_qty + $1
+			WHERE id = $2
+		`, l.qty, l.poItemID)
+		if err != nil {
+			return 0, "", "", fmt.Errorf("update received quantity: %w", err)
+		}
+
+		// received at the ordered price; the supplier's bill does not revalue it
+		if _, err := postInventoryCost(ctx, tx, l.productID, l.qty, l.unitPrice, RefGoodsReceipt, id, userID); err != nil {
+			return 0, "", "", err
+		}
+	}
+
+	var pending int
+	err = tx.QueryRow(ctx, `
+		SELECT COUNT(*)
+		FROM purchase_order_items
+		WHERE purchase_order_id = $1 AND received_qty < quantity
+	`, poID).Scan(&pending)
+	if err != nil {
+		return 0, "", "", fmt.Errorf("check pending lines: %w", err)
+	}
+
+	newStatus := POReceived
+	if pending > 0 {
+		newStatus = POPartial
+	}
+	if _, err := tx.Exec(ctx, `UPDATE purchase_orders SET status = $1 WHERE id = $2`, newStatus, poID); err != nil {
+		return 0, "", "", fmt.Errorf("update purchase order status: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, "", "", fmt.Errorf("commit tx: %w", err)
+	}
+
+	return id, number, newStatus, nil
+}
+
+func loadGoodsReceipts(ctx context.Context, poID int64) ([]gin.H, error) {
+	rows, err := db.DB.Query(ctx, `
+		SELECT gr.id, gr.grn_number, COALESCE(gr.supplier_challan, ''),
+		       COALESCE(SUM(gri.quantity), 0), gr.created_at
+		FROM goods_receipts gr
+		LEFT JOIN goods_receipt_items gri ON gri.goods_receipt_id = gr.id
+		WHERE gr.purchase_order_id = $1
+		GROUP BY gr.id
+		ORDER BY gr.id
+	`, poID)
+	if err != nil {
+		return nil, fmt.Errorf("load goods receipts: %w", err)
+	}
+	defer rows.Close()
+
+	receipts := []gin.H{}
+	for rows.Next() {
+		var (
+			id              int64
+			number, challan string
+			qty             int
+			createdAt       time.Time
+		)
+		if err := rows.Scan(&id, &number, &challan, &qty, &createdAt); err != nil {
+			return nil, err
+		}
+		receipts = append(receipts, gin.H{
+			"id":               id,
+			"grn_number":       number,
+			"supplier_challan": challan,
+			"total_quantity":   qty,
+			"created_at":       utils.FormatDateTime(createdAt),
+		})
+	}
+
+	return receipts, rows.Err()
+}
@@ -0,0 +1,871 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"tulsi-pos/config"
+	"tulsi-pos/db"
+	"tulsi-pos/services"
+	"tulsi-pos/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// B2C invoices to another state above this value are reported invoice-wise
+// in B2CL instead of being summarised in B2CS.
+const b2clThreshold = 100000.0
+
+// Unit quantity code reported in the HSN summary.
+const defaultUQC = "PCS"
+
+// gstr1DateFormat is the dd-mm-yyyy layout used by the GST offline tool.
+const gstr1DateFormat = "02-01-2006"
+
+// ---------- GST offline-tool payloads ----------
+
+type gstItemDetail struct {
+	Rate  float64 `json:"rt"`
+	TxVal float64 `json:"txval"`
+	IAmt  float64 `json:"iamt"`
+	CAmt  float64 `json:"camt"`
+	SAmt  float64 `json:"samt"`
+	CsAmt float64 `json:"csamt"`
+}
+
+func (d *gstItemDetail) add(o gstItemDetail, sign float64) {
+	d.TxVal += sign * o.TxVal
+	d.IAmt += sign * o.IAmt
+	d.CAmt += sign * o.CAmt
+	d.SAmt += sign * o.SAmt
+	d.CsAmt += sign * o.CsAmt
+}
+
+func (d *gstItemDetail) round() {
+	d.TxVal = round2(d.TxVal)
+	d.IAmt = round2(d.IAmt)
+	d.CAmt = round2(d.CAmt)
+	d.SAmt = round2(d.SAmt)
+	d.CsAmt = round2(d.CsAmt)
+}
+
+type gstItem struct {
+	Num    int           `json:"num"`
+	Detail gstItemDetail `json:"itm_det"`
+}
+
+type gstr1Invoice struct {
+	Inum   string    `json:"inum"`
+	Idt    string    `json:"idt"`
+	Val    float64   `json:"val"`
+	Pos    string    `json:"pos"`
+	Rchrg  string    `json:"rchrg,omitempty"`
+	InvTyp string    `json:"inv_typ,omitempty"`
+	Itms   []gstItem `json:"itms"`
+}
+
+type gstr1B2B struct {
+	Ctin string         `json:"ctin"`
+	Inv  []gstr1Invoice `json:"inv"`
+}
+
+type gstr1B2CL struct {
+	Pos string         `json:"pos"`
+	Inv []gstr1Invoice `json:"inv"`
+}
+
+type gstr1B2CS struct {
+	SplyTy string `json:"sply_ty"` // INTRA / INTER
+	Pos    string `json:"pos"`
+	Typ    string `json:"typ"`
+	gstItemDetail
+}
+
+type gstr1Note struct {
+	Typ    string    `json:"typ,omitempty"` // cdnur only
+	Ntty   string    `json:"ntty"`
+	NtNum  string    `json:"nt_num"`
+	NtDt   string    `json:"nt_dt"`
+	Val    float64   `json:"val"`
+	Pos    string    `json:"pos"`
+	Rchrg  string    `json:"rchrg,omitempty"`
+	InvTyp string    `json:"inv_typ,omitempty"`
+	Itms   []gstItem `json:"itms"`
+}
+
+type gstr1CDNR struct {
+	Ctin string      `json:"ctin"`
+	Nt   []gstr1Note `json:"nt"`
+}
+
+type gstr1HSN struct {
+	Num   int     `json:"num"`
+	HsnSc string  `json:"hsn_sc"`
+	Desc  string  `json:"desc"`
+	Uqc   string  `json:"uqc"`
+	Qty   float64 `json:"qty"`
+	Val   float64 `json:"val"`
+	gstItemDetail
+}
+
+type gstr1Doc struct {
+	Num      int    `json:"num"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+	TotNum   int    `json:"totnum"`
+	Cancel   int    `json:"cancel"`
+	NetIssue int    `json:"net_issue"`
+}
+
+type gstr1DocDet struct {
+	DocNum int        `json:"doc_num"`
+	Docs   []gstr1Doc `json:"docs"`
+}
+
+type GSTR1 struct {
+	GSTIN string      `json:"gstin"`
+	Fp    string      `json:"fp"`
+	B2B   []gstr1B2B  `json:"b2b"`
+	B2CL  []gstr1B2CL `json:"b2cl"`
+	B2CS  []gstr1B2CS `json:"b2cs"`
+	CDNR  []gstr1CDNR `json:"cdnr"`
+	CDNUR []gstr1Note `json:"cdnur"`
+	HSN   struct {
+		Data []gstr1HSN `json:"data"`
+	} `json:"hsn"`
+	DocIssue struct {
+		DocDet []gstr1DocDet `json:"doc_det"`
+	} `json:"doc_issue"`
+}
+
+type GSTR3B struct {
+	GSTIN      string `json:"gstin"`
+	RetPeriod  string `json:"ret_period"`
+	SupDetails struct {
+		OsupDet     gstItemDetail `json:"osup_det"`
+		OsupNilExmp gstItemDetail `json:"osup_nil_exmp"`
+	} `json:"sup_details"`
+	InterSup struct {
+		UnregDetails []gstInterSup `json:"unreg_details"`
+	} `json:"inter_sup"`
+	ITCElg struct {
+		ITCAvl []gstITC `json:"itc_avl"`
+		ITCRev []gstITC `json:"itc_rev"`
+		ITCNet gstITC   `json:"itc_net"`
+	} `json:"itc_elg"`
+}
+
+type gstInterSup struct {
+	Pos   string  `json:"pos"`
+	TxVal float64 `json:"txval"`
+	IAmt  float64 `json:"iamt"`
+}
+
+type gstITC struct {
+	Ty    string  `json:"ty,omitempty"`
+	IAmt  float64 `json:"iamt"`
+	CAmt  float64 `json:"camt"`
+	SAmt  float64 `json:"samt"`
+	CsAmt float64 `json:"csamt"`
+}
+
+// ---------- Public Handlers ----------
+
+// GET /reports/gstr1?from=&to=[&format=json|csv&section=]
+// Without format the report comes back in the usual API envelope; with
+// format=json the raw offline-tool file is downloaded, and format=csv
+// downloads one section (b2b, b2cl, b2cs, cdnr, cdnur, hsn, docs).
+func GetGSTR1(c *gin.Context) {
+	from, to, err := parsePeriod(c)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	report, err := buildGSTR1(c.Request.Context(), from, to)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	switch c.Query("format") {
+	case "":
+		utils.SendSuccessResponse(c, http.StatusOK, report, "GSTR-1 generated successfully")
+	case "json":
+		body, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="GSTR1_%s.json"`, report.Fp))
+		c.Data(http.StatusOK, "application/json", body)
+	case "csv":
+		section := c.Query("section")
+		records, err := gstr1CSV(report, section)
+		if err != nil {
+			utils.SendErrorResponse(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		sendCSV(c, fmt.Sprintf("GSTR1_%s_%s.csv", report.Fp, section), records)
+	default:
+		utils.SendErrorResponse(c, http.StatusBadRequest, "format must be json or csv")
+	}
+}
+
+// GET /reports/gstr3b?from=&to=[&format=json]
+func GetGSTR3B(c *gin.Context) {
+	from, to, err := parsePeriod(c)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	report, err := buildGSTR3B(c.Request.Context(), from, to)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if c.Query("format") == "json" {
+		body, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="GSTR3B_%s.json"`, report.RetPeriod))
+		c.Data(http.StatusOK, "application/json", body)
+		return
+	}
+
+	payable, carried := offsetTax(report.SupDetails.OsupDet, report.ITCElg.ITCNet)
+
+	utils.SendSuccessResponse(c, http.StatusOK, gin.H{
+		"gstr3b":              report,
+		"tax_payable":         payable,
+		"itc_carried_forward": carried,
+	}, "GSTR-3B generated successfully")
+}
+
+// GET /reports/hsn-summary?from=&to=[&format=csv]
+func GetHSNSummaryReport(c *gin.Context) {
+	from, to, err := parsePeriod(c)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	summary, err := services.LoadPeriodHSNSummary(c.Request.Context(), db.DB, from, to)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if c.Query("format") == "csv" {
+		records := [][]string{{"HSN", "GST %", "Quantity", "Total Value", "Taxable Value",
+			"CGST", "SGST", "IGST", "Total Tax"}}
+		for _, h := range summary {
+			records = append(records, []string{h.HSNCode, fmt.Sprintf("%.2f", h.GSTPercent),
+				fmt.Sprintf("%d", h.Quantity), fmt.Sprintf("%.2f", h.TotalValue),
+				fmt.Sprintf("%.2f", h.Taxable), fmt.Sprintf("%.2f", h.CGST),
+				fmt.Sprintf("%.2f", h.SGST), fmt.Sprintf("%.2f", h.IGST),
+				fmt.Sprintf("%.2f", h.TotalTax)})
+		}
+		sendCSV(c, fmt.Sprintf("HSN_%s_%s.csv", c.Query("from"), c.Query("to")), records)
+		return
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, gin.H{
+		"from":    c.Query("from"),
+		"to":      c.Query("to"),
+		"summary": summary,
+	}, "HSN summary fetched successfully")
+}
+
+// ---------- Internal Logic ----------
+
+// parsePeriod reads the from/to (YYYY-MM-DD, inclusive) query parameters and
+// returns a half-open [from, to) range.
+func parsePeriod(c *gin.Context) (time.Time, time.Time, error) {
+	from, err := time.ParseInLocation(utils.DateFormat, c.Query("from"), time.Local)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("from must be YYYY-MM-DD")
+	}
+	to, err := time.ParseInLocation(utils.DateFormat, c.Query("to"), time.Local)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("to must be YYYY-MM-DD")
+	}
+	if to.Before(from) {
+		return time.Time{}, time.Time{}, fmt.Errorf("to must not be before from")
+	}
+	return from, to.AddDate(0, 0, 1), nil
+}
+
+// outwardDoc is one invoice or credit note with its tax grouped by rate.
+type outwardDoc struct {
+	Number        string
+	Date          time.Time
+	CustomerGSTIN string
+	Pos           string
+	Value         float64
+	InvoiceValue  float64 // original invoice value, used to classify B2CL
+	Rates         []gstItemDetail
+}
+
+func (d outwardDoc) items() []gstItem {
+	items := make([]gstItem, 0, len(d.Rates))
+	for i, r := range d.Rates {
+		r.round()
+		items = append(items, gstItem{Num: i + 1, Detail: r})
+	}
+	return items
+}
+
+// category returns B2B, B2CL or B2CS for the document.
+func (d outwardDoc) category() string {
+	if d.CustomerGSTIN != "" {
+		return "B2B"
+	}
+	if isInterState(d.Pos) && d.InvoiceValue > b2clThreshold {
+		return "B2CL"
+	}
+	return "B2CS"
+}
+
+func (d outwardDoc) supplyType() string {
+	if isInterState(d.Pos) {
+		return "INTER"
+	}
+	return "INTRA"
+}
+
+// loadOutwardDocs loads invoices (credit == false) or credit notes issued in
+// the period, one row per document and GST rate.
+func loadOutwardDocs(ctx context.Context, q db.Querier, from, to time.Time, credit bool) ([]outwardDoc, error) {
+	query := `
+		SELECT si.invoice_number, si.invoiced_at, COALESCE(si.customer_gstin, ''),
+		       COALESCE(si.place_of_supply, ''), si.total_invoice_amount, si.total_invoice_amount,
+		       sii.gst_percent,
+		       SUM(sii.line_total - sii.gst_amount),
+		       SUM(COALESCE(sii.igst_amount, 0)),
+		       SUM(COALESCE(sii.cgst_amount, 0)),
+		       SUM(COALESCE(sii.sgst_amount, 0))
+		FROM sales_invoices si
+		JOIN sales_invoice_items sii ON sii.sales_invoice_id = si.id AND sii.deleted_at IS NULL
+		WHERE si.status = 'INVOICED' AND si.deleted_at IS NULL
+		  AND si.invoiced_at >= $1 AND si.invoiced_at < $2
+		GROUP BY si.id, sii.gst_percent
+		ORDER BY si.invoiced_at, si.id, sii.gst_percent
+	`
+	if credit {
+		query = `
+			SELECT sr.credit_note_number, sr.created_at, COALESCE(si.customer_gstin, ''),
+			       COALESCE(si.place_of_supply, ''), sr.total_amount, si.total_invoice_amount,
+			       sri.gst_percent,
+			       SUM(sri.taxable_amount),
+			       SUM(sri.igst_amount),
+			       SUM(sri.cgst_amount),
+			       SUM(sri.sgst_amount)
+			FROM sales_returns sr
+			JOIN sales_invoices si ON si.id = sr.sales_invoice_id
+			JOIN sales_return_items sri ON sri.sales_return_id = sr.id
+			WHERE sr.created_at >= $1 AND sr.created_at < $2
+			GROUP BY sr.id, si.id, sri.gst_percent
+			ORDER BY sr.created_at, sr.id, sri.gst_percent
+		`
+	}
+
+	rows, err := q.Query(ctx, query, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("load outward supplies: %w", err)
+	}
+	defer rows.Close()
+
+	var docs []outwardDoc
+	for rows.Next() {
+		var d outwardDoc
+		var r gstItemDetail
+		if err := rows.Scan(&d.Number, &d.Date, &d.CustomerGSTIN, &d.Pos, &d.Value, &d.InvoiceValue,
+			&r.Rate, &r.TxVal, &r.IAmt, &r.CAmt, &r.SAmt); err != nil {
+			return nil, err
+		}
+		if d.Pos == "" {
+			d.Pos = config.StoreStateCode
+		}
+
+		if n := len(docs); n > 0 && docs[n-1].Number == d.Number {
+			docs[n-1].Rates = append(docs[n-1].Rates, r)
+			continue
+		}
+		d.Rates = []gstItemDetail{r}
+		docs = append(docs, d)
+	}
+
+	return docs, rows.Err()
+}
+
+func buildGSTR1(ctx context.Context, from, to time.Time) (GSTR1, error) {
+	report := GSTR1{
+		GSTIN: config.StoreGSTIN,
+		Fp:    from.Format("012006"),
+		B2B:   []gstr1B2B{},
+		B2CL:  []gstr1B2CL{},
+		B2CS:  []gstr1B2CS{},
+		CDNR:  []gstr1CDNR{},
+		CDNUR: []gstr1Note{},
+	}
+	report.HSN.Data = []gstr1HSN{}
+	report.DocIssue.DocDet = []gstr1DocDet{}
+
+	invoices, err := loadOutwardDocs(ctx, db.DB, from, to, false)
+	if err != nil {
+		return report, err
+	}
+	notes, err := loadOutwardDocs(ctx, db.DB, from, to, true)
+	if err != nil {
+		return report, err
+	}
+
+	b2bIdx := map[string]int{}
+	b2clIdx := map[string]int{}
+	b2cs := map[string]*gstr1B2CS{}
+
+	addB2CS := func(d outwardDoc, sign float64) {
+		for _, r := range d.Rates {
+			key := fmt.Sprintf("%s|%s|%.2f", d.supplyType(), d.Pos, r.Rate)
+			row, ok := b2cs[key]
+			if !ok {
+				row = &gstr1B2CS{SplyTy: d.supplyType(), Pos: d.Pos, Typ: "OE"}
+				row.Rate = r.Rate
+				b2cs[key] = row
+			}
+			row.add(r, sign)
+		}
+	}
+
+	for _, d := range invoices {
+		inv := gstr1Invoice{
+			Inum: d.Number,
+			Idt:  d.Date.Format(gstr1DateFormat),
+			Val:  d.Value,
+			Pos:  d.Pos,
+			Itms: d.items(),
+		}
+
+		switch d.category() {
+		case "B2B":
+			inv.Rchrg = "N"
+			inv.InvTyp = "R"
+			i, ok := b2bIdx[d.CustomerGSTIN]
+			if !ok {
+				i = len(report.B2B)
+				b2bIdx[d.CustomerGSTIN] = i
+				report.B2B = append(report.B2B, gstr1B2B{Ctin: d.CustomerGSTIN})
+			}
+			report.B2B[i].Inv = append(report.B2B[i].Inv, inv)
+		case "B2CL":
+			i, ok := b2clIdx[d.Pos]
+			if !ok {
+				i = len(report.B2CL)
+				b2clIdx[d.Pos] = i
+				report.B2CL = append(report.B2CL, gstr1B2CL{Pos: d.Pos})
+			}
+			report.B2CL[i].Inv = append(report.B2CL[i].Inv, inv)
+		default:
+			addB2CS(d, 1)
+		}
+	}
+
+	// Credit notes against registered buyers go to CDNR, against B2CL invoices
+	// to CDNUR; the rest reduce the B2CS summary they were originally part of.
+	cdnrIdx := map[string]int{}
+	for _, d := range notes {
+		note := gstr1Note{
+			Ntty:  "C",
+			NtNum: d.Number,
+			NtDt:  d.Date.Format(gstr1DateFormat),
+			Val:   d.Value,
+			Pos:   d.Pos,
+			Itms:  d.items(),
+		}
+
+		switch d.category() {
+		case "B2B":
+			note.Rchrg = "N"
+			note.InvTyp = "R"
+			i, ok := cdnrIdx[d.CustomerGSTIN]
+			if !ok {
+				i = len(report.CDNR)
+				cdnrIdx[d.CustomerGSTIN] = i
+				report.CDNR = append(report.CDNR, gstr1CDNR{Ctin: d.CustomerGSTIN})
+			}
+			report.CDNR[i].Nt = append(report.CDNR[i].Nt, note)
+		case "B2CL":
+			note.Typ = "B2CL"
+			report.CDNUR = append(report.CDNUR, note)
+		default:
+			addB2CS(d, -1)
+		}
+	}
+
+	keys := make([]string, 0, len(b2cs))
+	for k := range b2cs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		row := *b2cs[k]
+		row.round()
+		report.B2CS = append(report.B2CS, row)
+	}
+
+	hsn, err := services.LoadPeriodHSNSummary(ctx, db.DB, from, to)
+	if err != nil {
+		return report, err
+	}
+	for i, h := range hsn {
+		report.HSN.Data = append(report.HSN.Data, gstr1HSN{
+			Num:   i + 1,
+			HsnSc: h.HSNCode,
+			Uqc:   defaultUQC,
+			Qty:   float64(h.Quantity),
+			Val:   h.TotalValue,
+			gstItemDetail: gstItemDetail{
+				Rate:  h.GSTPercent,
+				TxVal: h.Taxable,
+				IAmt:  h.IGST,
+				CAmt:  h.CGST,
+				SAmt:  h.SGST,
+			},
+		})
+	}
+
+	invoiceDocs, err := loadDocumentSummary(ctx, from, to, false)
+	if err != nil {
+		return report, err
+	}
+	if len(invoiceDocs) > 0 {
+		report.DocIssue.DocDet = append(report.DocIssue.DocDet, gstr1DocDet{DocNum: 1, Docs: invoiceDocs})
+	}
+	noteDocs, err := loadDocumentSummary(ctx, from, to, true)
+	if err != nil {
+		return report, err
+	}
+	if len(noteDocs) > 0 {
+		report.DocIssue.DocDet = append(report.DocIssue.DocDet, gstr1DocDet{DocNum: 5, Docs: noteDocs})
+	}
+
+	return report, nil
+}
+
+// loadDocumentSummary returns the number ranges issued in the period, one
+// range per series prefix (everything before the trailing running number).
+func loadDocumentSummary(ctx context.Context, from, to time.Time, credit bool) ([]gstr1Doc, error) {
+	query := `
+		SELECT regexp_replace(invoice_number, '[0-9]+$', '') AS series,
+		       MIN(invoice_number), MAX(invoice_number), COUNT(*),
+		       COUNT(*) FILTER (WHERE deleted_at IS NOT NULL)
+		FROM sales_invoices
+		WHERE invoice_number IS NOT NULL
+		  AND invoiced_at >= $1 AND invoiced_at < $2
+		GROUP BY series
+		ORDER BY series
+	`
+	if credit {
+		query = `
+			SELECT regexp_replace(credit_note_number, '[0-9]+$', '') AS series,
+			       MIN(credit_note_number), MAX(credit_note_number), COUNT(*), 0
+			FROM sales_returns
+			WHERE created_at >= $1 AND created_at < $2
+			GROUP BY series
+			ORDER BY series
+		`
+	}
+
+	rows, err := db.DB.Query(ctx, query, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("load document summary: %w", err)
+	}
+	defer rows.Close()
+
+	var docs []gstr1Doc
+	for rows.Next() {
+		var series string
+		var d gstr1Doc
+		if err := rows.Scan(&series, &d.From, &d.To, &d.TotNum, &d.Cancel); err != nil {
+			return nil, err
+		}
+		d.Num = len(docs) + 1
+		d.NetIssue = d.TotNum - d.Cancel
+		docs = append(docs, d)
+	}
+
+	return docs, rows.Err()
+}
+
+func buildGSTR3B(ctx context.Context, from, to time.Time) (GSTR3B, error) {
+	report := GSTR3B{
+		GSTIN:     config.StoreGSTIN,
+		RetPeriod: from.Format("012006"),
+	}
+	report.InterSup.UnregDetails = []gstInterSup{}
+
+	invoices, err := loadOutwardDocs(ctx, db.DB, from, to, false)
+	if err != nil {
+		return report, err
+	}
+	notes, err := loadOutwardDocs(ctx, db.DB, from, to, true)
+	if err != nil {
+		return report, err
+	}
+
+	interSup := map[string]*gstInterSup{}
+	addOutward := func(docs []outwardDoc, sign float64) {
+		for _, d := range docs {
+			for _, r := range d.Rates {
+				if r.Rate == 0 {
+					report.SupDetails.OsupNilExmp.add(r, sign)
+					continue
+				}
+				report.SupDetails.OsupDet.add(r, sign)
+
+				if d.CustomerGSTIN == "" && isInterState(d.Pos) {
+					row, ok := interSup[d.Pos]
+					if !ok {
+						row = &gstInterSup{Pos: d.Pos}
+						interSup[d.Pos] = row
+					}
+					row.TxVal += sign * r.TxVal
+					row.IAmt += sign * r.IAmt
+				}
+			}
+		}
+	}
+	addOutward(invoices, 1)
+	addOutward(notes, -1)
+	report.SupDetails.OsupDet.round()
+	report.SupDetails.OsupNilExmp.round()
+
+	positions := make([]string, 0, len(interSup))
+	for pos := range interSup {
+		positions = append(positions, pos)
+	}
+	sort.Strings(positions)
+	for _, pos := range positions {
+		row := *interSup[pos]
+		row.TxVal = round2(row.TxVal)
+		row.IAmt = round2(row.IAmt)
+		report.InterSup.UnregDetails = append(report.InterSup.UnregDetails, row)
+	}
+
+	itc, err := loadInputTax(ctx, from, to)
+	if err != nil {
+		return report, err
+	}
+	itc.Ty = "OTH"
+	report.ITCElg.ITCAvl = []gstITC{itc}
+
+	// debit notes raised on suppliers give back credit already taken
+	rev, err := loadInputTaxReversal(ctx, from, to)
+	if err != nil {
+		return report, err
+	}
+	rev.Ty = "OTH"
+	report.ITCElg.ITCRev = []gstITC{rev}
+
+	report.ITCElg.ITCNet = gstITC{
+		IAmt:  round2(itc.IAmt - rev.IAmt),
+		CAmt:  round2(itc.CAmt - rev.CAmt),
+		SAmt:  round2(itc.SAmt - rev.SAmt),
+		CsAmt: round2(itc.CsAmt - rev.CsAmt),
+	}
+
+	return report, nil
+}
+
+// loadInputTax sums the GST charged on supplier bills dated in the period.
+func loadInputTax(ctx context.Context, from, to time.Time) (gstITC, error) {
+	var itc gstITC
+	err := db.DB.QueryRow(ctx, `
+		SELECT COALESCE(SUM(pii.igst_amount), 0),
+		       COALESCE(SUM(pii.cgst_amount), 0),
+		       COALESCE(SUM(pii.sgst_amount), 0)
+		FROM purchase_invoice_items pii
+		JOIN purchase_invoices pi ON pi.id = pii.purchase_invoice_id
+		WHERE pi.deleted_at IS NULL
+		  AND COALESCE(pi.invoice_date, pi.created_at::date) >= $1
+		  AND COALESCE(pi.invoice_date, pi.created_at::date) < $2
+	`, from, to).Scan(&itc.IAmt, &itc.CAmt, &itc.SAmt)
+	if err != nil {
+		return itc, fmt.Errorf("load input tax: %w", err)
+	}
+
+	itc.IAmt = round2(itc.IAmt)
+	itc.CAmt = round2(itc.CAmt)
+	itc.SAmt = round2(itc.SAmt)
+	return itc, nil
+}
+
+// loadInputTaxReversal sums the GST on purchase returns (debit notes) raised
+// in the period. A note against a bill dated later is reversed with the
+// bill's credit, never before it.
+func loadInputTaxReversal(ctx context.Context, from, to time.Time) (gstITC, error) {
+	var rev gstITC
+	err := db.DB.QueryRow(ctx, `
+		SELECT COALESCE(SUM(pr.total_igst), 0),
+		       COALESCE(SUM(pr.total_cgst), 0),
+		       COALESCE(SUM(pr.total_sgst), 0)
+		FROM purchase_returns pr
+		JOIN purchase_invoices pi ON pi.id = pr.purchase_invoice_id
+		WHERE GREATEST(pr.created_at::date, COALESCE(pi.invoice_date, pi.created_at::date)) >= $1
+		  AND GREATEST(pr.created_at::date, COALESCE(pi.invoice_date, pi.created_at::date)) < $2
+	`, from, to).Scan(&rev.IAmt, &rev.CAmt, &rev.SAmt)
+	if err != nil {
+		return rev, fmt.Errorf("load input tax reversal: %w", err)
+	}
+
+	rev.IAmt = round2(rev.IAmt)
+	rev.CAmt = round2(rev.CAmt)
+	rev.SAmt = round2(rev.SAmt)
+	return rev, nil
+}
+
+// offsetTax sets input tax credit off against output tax in the statutory
+// order: IGST credit against IGST, then CGST, then SGST; CGST and SGST credit
+// against their own head first and then IGST. CGST and SGST never offset
+// each other. It returns the cash payable and the credit carried forward.
+func offsetTax(output gstItemDetail, itc gstITC) (gstITC, gstITC) {
+	due := map[string]float64{"I": output.IAmt, "C": output.CAmt, "S": output.SAmt}
+	credit := map[string]float64{"I": itc.IAmt, "C": itc.CAmt, "S": itc.SAmt}
+
+	use := func(from, against string) {
+		amt := math.Min(credit[from], due[against])
+		if amt <= 0 {
+			return
+		}
+		credit[from] -= amt
+		due[against] -= amt
+	}
+
+	use("I", "I")
+	use("I", "C")
+	use("I", "S")
+	use("C", "C")
+	use("C", "I")
+	use("S", "S")
+	use("S", "I")
+
+	payable := gstITC{IAmt: round2(due["I"]), CAmt: round2(due["C"]), SAmt: round2(due["S"])}
+	carried := gstITC{IAmt: round2(credit["I"]), CAmt: round2(credit["C"]), SAmt: round2(credit["S"])}
+	return payable, carried
+}
+
+// gstr1CSV lays a GSTR-1 section out like the offline tool's CSV templates.
+func gstr1CSV(r GSTR1, section string) ([][]string, error) {
+	money := func(v float64) string { return fmt.Sprintf("%.2f", v) }
+	pos := func(code string) string { return code + "-" + utils.GSTStateNames[code] }
+
+	var records [][]string
+	switch section {
+	case "b2b":
+		records = append(records, []string{"GSTIN/UIN of Recipient", "Invoice Number", "Invoice date",
+			"Invoice Value", "Place Of Supply", "Reverse Charge", "Invoice Type", "Rate",
+			"Taxable Value", "Integrated Tax", "Central Tax", "State/UT Tax", "Cess Amount"})
+		for _, b := range r.B2B {
+			for _, inv := range b.Inv {
+				for _, it := range inv.Itms {
+					d := it.Detail
+					records = append(records, []string{b.Ctin, inv.Inum, inv.Idt, money(inv.Val),
+						pos(inv.Pos), inv.Rchrg, "Regular", money(d.Rate), money(d.TxVal),
+						money(d.IAmt), money(d.CAmt), money(d.SAmt), money(d.CsAmt)})
+				}
+			}
+		}
+	case "b2cl":
+		records = append(records, []string{"Invoice Number", "Invoice date", "Invoice Value",
+			"Place Of Supply", "Rate", "Taxable Value", "Integrated Tax", "Cess Amount"})
+		for _, b := range r.B2CL {
+			for _, inv := range b.Inv {
+				for _, it := range inv.Itms {
+					d := it.Detail
+					records = append(records, []string{inv.Inum, inv.Idt, money(inv.Val),
+						pos(b.Pos), money(d.Rate), money(d.TxVal), money(d.IAmt), money(d.CsAmt)})
+				}
+			}
+		}
+	case "b2cs":
+		records = append(records, []string{"Type", "Place Of Supply", "Supply Type", "Rate",
+			"Taxable Value", "Integrated Tax", "Central Tax", "State/UT Tax", "Cess Amount"})
+		for _, b := range r.B2CS {
+			records = append(records, []string{b.Typ, pos(b.Pos), b.SplyTy, money(b.Rate),
+				money(b.TxVal), money(b.IAmt), money(b.CAmt), money(b.SAmt), money(b.CsAmt)})
+		}
+	case "cdnr":
+		records = append(records, []string{"GSTIN/UIN of Recipient", "Note Number", "Note Date",
+			"Note Type", "Place Of Supply", "Reverse Charge", "Note Supply Type", "Note Value",
+			"Rate", "Taxable Value", "Integrated Tax", "Central Tax", "State/UT Tax", "Cess Amount"})
+		for _, b := range r.CDNR {
+			for _, nt := range b.Nt {
+				for _, it := range nt.Itms {
+					d := it.Detail
+					records = append(records, []string{b.Ctin, nt.NtNum, nt.NtDt, nt.Ntty,
+						pos(nt.Pos), nt.Rchrg, "Regular", money(nt.Val), money(d.Rate),
+						money(d.TxVal), money(d.IAmt), money(d.CAmt), money(d.SAmt), money(d.CsAmt)})
+				}
+			}
+		}
+	case "cdnur":
+		records = append(records, []string{"UR Type", "Note Number", "Note Date", "Note Type",
+			"Place Of Supply", "Note Value", "Rate", "Taxable Value", "Integrated Tax", "Cess Amount"})
+		for _, nt := range r.CDNUR {
+			for _, it := range nt.Itms {
+				d := it.Detail
+				records = append(records, []string{nt.Typ, nt.NtNum, nt.NtDt, nt.Ntty,
+					pos(nt.Pos), money(nt.Val), money(d.Rate), money(d.TxVal), money(d.IAmt), money(d.CsAmt)})
+			}
+		}
+	case "hsn":
+		records = append(records, []string{"HSN", "Description", "UQC", "Total Quantity",
+			"Total Value", "Rate", "Taxable Value", "Integrated Tax Amount",
+			"Central Tax Amount", "State/UT Tax Amount", "Cess Amount"})
+		for _, h := range r.HSN.Data {
+			records = append(records, []string{h.HsnSc, h.Desc, h.Uqc, fmt.Sprintf("%.0f", h.Qty),
+				money(h.Val), money(h.Rate), money(h.TxVal), money(h.IAmt), money(h.CAmt),
+				money(h.SAmt), money(h.CsAmt)})
+		}
+	case "docs":
+		natures := map[int]string{1: "Invoices for outward supply", 5: "Credit Note"}
+		records = append(records, []string{"Nature of Document", "Sr. No. From", "Sr. No. To",
+			"Total Number", "Cancelled"})
+		for _, det := range r.DocIssue.DocDet {
+			for _, d := range det.Docs {
+				records = append(records, []string{natures[det.DocNum], d.From, d.To,
+					fmt.Sprintf("%d", d.TotNum), fmt.Sprintf("%d", d.Cancel)})
+			}
+		}
+	default:
+		return nil, fmt.Errorf("section must be one of b2b, b2cl, b2cs, cdnr, cdnur, hsn, docs")
+	}
+
+	return records, nil
+}
+
+// sendCSV writes records as a downloadable CSV file.
+func sendCSV(c *gin.Context, filename string, records [][]string) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.WriteAll(records); err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, strings.ReplaceAll(filename, `"`, "")))
+	c.Data(http.StatusOK, "text/csv", buf.Bytes())
+}
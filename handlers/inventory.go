@@ -0,0 +1,349 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"tulsi-pos/db"
+	"tulsi-pos/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// Reference types written to inventory_transactions.ref_type.
+const (
+	RefPurchase       = "purchase"
+	RefPurchaseReturn = "purchase_return"
+	RefSale           = "sale"
+	RefSaleReturn     = "sale_return"
+	RefAdjustment     = "adjustment"
+	RefGoodsReceipt   = "goods_receipt"
+)
+
+// refLinks maps a movement's ref_type to the API path of its source document.
+var refLinks = map[string]string{
+	RefPurchase:       "/purchases/%d",
+	RefSale:           "/sales/invoices/%d",
+	RefSaleReturn:     "/sales/returns/%d",
+	RefAdjustment:     "/inventory/adjustments/%d",
+	RefGoodsReceipt:   "/goods-receipts/%d",
+	RefPurchaseReturn: "/purchases/returns/%d",
+}
+
+type StockRow struct {
+	ProductID int64  `json:"product_id"`
+	Name      string `json:"name"`
+	SKU       string `json:"sku"`
+	Category  string `json:"category"`
+	Gender    string `json:"gender"`
+	StyleID   *int64 `json:"style_id,omitempty"`
+	Size      string `json:"size,omitempty"`
+	Colour    string `json:"colour,omitempty"`
+	OnHand    int    `json:"on_hand"`
+	UpdatedAt string `json:"updated_at,omitempty"`
+}
+
+// StockShortage is a product an invoice sells more of than is on hand.
+type StockShortage struct {
+	ProductID int64  `json:"product_id"`
+	Name      string `json:"name"`
+	SKU       string `json:"sku"`
+	Lines     []int  `json:"lines,omitempty"` // positions of the request items for the product, from 0
+	Requested int    `json:"requested"`
+	Available int    `json:"available"`
+	Short     int    `json:"short_by"`
+}
+
+var ErrInsufficientStock = errors.New("insufficient stock")
+
+// InsufficientStockError carries the short lines of a blocked sale.
+type InsufficientStockError struct {
+	Lines []StockShortage
+}
+
+func (e *InsufficientStockError) Error() string {
+	parts := make([]string, 0, len(e.Lines))
+	for _, l := range e.Lines {
+		parts = append(parts, fmt.Sprintf("product %d short by %d", l.ProductID, l.Short))
+	}
+	return fmt.Sprintf("%s: %s", ErrInsufficientStock, strings.Join(parts, ", "))
+}
+
+func (e *InsufficientStockError) Unwrap() error { return ErrInsufficientStock }
+
+type LedgerEntry struct {
+	ID           int64   `json:"id"`
+	Date         string  `json:"date"`
+	RefType      string  `json:"ref_type"`
+	RefID        int64   `json:"ref_id"`
+	RefNumber    string  `json:"ref_number"`
+	RefLink      string  `json:"ref_link,omitempty"`
+	QuantityIn   int     `json:"quantity_in"`
+	QuantityOut  int     `json:"quantity_out"`
+	BalanceAfter int     `json:"balance_after"`
+	UnitCost     float64 `json:"unit_cost"`
+	Value        float64 `json:"value"`       // signed value moved
+	ValueAfter   float64 `json:"value_after"` // stock value after the movement
+}
+
+// ---------- Public Handlers ----------
+
+// GET /inventory/stock?category=&gender=&sku=&style_id=
+func GetStock(c *gin.Context) {
+	where := "WHERE p.deleted_at IS NULL"
+	params := []interface{}{}
+	for _, f := range []struct{ param, column string }{
+		{"category", "p.category"},
+		{"gender", "p.gender"},
+		{"sku", "p.sku"},
+		{"style_id", "p.style_id::text"},
+	} {
+		if v := c.Query(f.param); v != "" {
+			params = append(params, v)
+			where += fmt.Sprintf(" AND %s = $%d", f.column, len(params))
+		}
+	}
+
+	rows, err := db.DB.Query(c.Request.Context(), `
+		SELECT p.id, p.name, COALESCE(p.sku, ''), COALESCE(p.category, ''),
+		       COALESCE(p.gender, ''), p.style_id, COALESCE(p.size, ''), COALESCE(p.colour, ''),
+		       COALESCE(ps.on_hand, 0), ps.updated_at
+		FROM products p
+		LEFT JOIN product_stock ps ON ps.product_id = p.id
+		`+where+`
+		ORDER BY p.name
+	`, params...)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	stock := []StockRow{}
+	for rows.Next() {
+		var s StockRow
+		var updatedAt *time.Time
+		if err := rows.Scan(&s.ProductID, &s.Name, &s.SKU, &s.Category, &s.Gender,
+			&s.StyleID, &s.Size, &s.Colour, &s.OnHand, &updatedAt); err != nil {
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if updatedAt != nil {
+			s.UpdatedAt = utils.FormatDateTime(*updatedAt)
+		}
+		stock = append(stock, s)
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, stock, "Stock fetched successfully")
+}
+
+// GET /inventory/products/:id/ledger?from=&to=
+func GetProductLedger(c *gin.Context) {
+	productID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || productID <= 0 {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid product id")
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	var product StockRow
+	err = db.DB.QueryRow(ctx, `
+		SELECT p.id, p.name, COALESCE(p.sku, ''), COALESCE(ps.on_hand, 0)
+		FROM products p
+		LEFT JOIN product_stock ps ON ps.product_id = p.id
+		WHERE p.id = $1 AND p.deleted_at IS NULL
+	`, productID).Scan(&product.ProductID, &product.Name, &product.SKU, &product.OnHand)
+	if err == pgx.ErrNoRows {
+		utils.SendErrorResponse(c, http.StatusNotFound, "product not found")
+		return
+	}
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	where := "WHERE it.product_id = $1"
+	params := []interface{}{productID}
+	if from := c.Query("from"); from != "" {
+		t, err := time.ParseInLocation(utils.DateFormat, from, time.Local)
+		if err != nil {
+			utils.SendErrorResponse(c, http.StatusBadRequest, "from must be YYYY-MM-DD")
+			return
+		}
+		params = append(params, t)
+		where += fmt.Sprintf(" AND it.created_at >= $%d", len(params))
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := time.ParseInLocation(utils.DateFormat, to, time.Local)
+		if err != nil {
+			utils.SendErrorResponse(c, http.StatusBadRequest, "to must be YYYY-MM-DD")
+			return
+		}
+		params = append(params, t.AddDate(0, 0, 1))
+		where += fmt.Sprintf(" AND it.created_at < $%d", len(params))
+	}
+
+	rows, err := db.DB.Query(ctx, `
+		SELECT it.id, it.created_at, COALESCE(it.ref_type, ''), COALESCE(it.ref_id, 0),
+		       COALESCE(si.invoice_number, sr.credit_note_number, pi.invoice_number,
+		                sa.adjustment_number, gr.grn_number,
+		                prt.debit_note_number, ''),
+		       it.quantity, COALESCE(it.balance_after, 0), COALESCE(it.unit_cost, 0),
+		       COALESCE(it.value, 0), COALESCE(it.value_after, 0)
+		FROM inventory_transactions it
+		LEFT JOIN sales_invoices si ON it.ref_type = 'sale' AND si.id = it.ref_id
+		LEFT JOIN sales_returns sr ON it.ref_type = 'sale_return' AND sr.id = it.ref_id
+		LEFT JOIN purchase_invoices pi ON it.ref_type = 'purchase' AND pi.id = it.ref_id
+		LEFT JOIN stock_adjustments sa ON it.ref_type = 'adjustment' AND sa.id = it.ref_id
+		LEFT JOIN goods_receipts gr ON it.ref_type = 'goods_receipt' AND gr.id = it.ref_id
+		LEFT JOIN purchase_returns prt ON it.ref_type = 'purchase_return' AND prt.id = it.ref_id
+		`+where+`
+		ORDER BY it.id
+	`, params...)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	ledger := []LedgerEntry{}
+	for rows.Next() {
+		var e LedgerEntry
+		var createdAt time.Time
+		var qty int
+		if err := rows.Scan(&e.ID, &createdAt, &e.RefType, &e.RefID, &e.RefNumber,
+			&qty, &e.BalanceAfter, &e.UnitCost, &e.Value, &e.ValueAfter); err != nil {
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		e.Date = utils.FormatDateTime(createdAt)
+		if qty >= 0 {
+			e.QuantityIn = qty
+		} else {
+			e.QuantityOut = -qty
+		}
+		if link, ok := refLinks[e.RefType]; ok {
+			e.RefLink = fmt.Sprintf(link, e.RefID)
+		}
+		ledger = append(ledger, e)
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, gin.H{
+		"product": product,
+		"ledger":  ledger,
+	}, "Stock ledger fetched successfully")
+}
+
+// ---------- Internal Logic ----------
+
+// checkStock locks the stock rows of the products being sold, in product id
+// order so two counters confirming overlapping invoices cannot deadlock, and
+// returns every product whose requested quantity exceeds what is on hand. The
+// locks are held until tx ends, so the same units cannot be sold twice.
+func checkStock(ctx context.Context, tx pgx.Tx, requested map[int64]int) ([]StockShortage, error) {
+	ids := make([]int64, 0, len(requested))
+	for id := range requested {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	// products that never moved have no stock row yet; create it so it can be locked
+	_, err := tx.Exec(ctx, `
+		INSERT INTO product_stock (product_id, on_hand)
+		SELECT UNNEST($1::bigint[]), 0
+		ON CONFLICT (product_id) DO NOTHING
+	`, ids)
+	if err != nil {
+		return nil, fmt.Errorf("init stock rows: %w", err)
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT ps.product_id, ps.on_hand, p.name, COALESCE(p.sku, '')
+		FROM product_stock ps
+		JOIN products p ON p.id = ps.product_id
+		WHERE ps.product_id = ANY($1)
+		ORDER BY ps.product_id
+		FOR UPDATE OF ps
+	`, ids)
+	if err != nil {
+		return nil, fmt.Errorf("lock stock rows: %w", err)
+	}
+	defer rows.Close()
+
+	var short []StockShortage
+	for rows.Next() {
+		var s StockShortage
+		if err := rows.Scan(&s.ProductID, &s.Available, &s.Name, &s.SKU); err != nil {
+			return nil, err
+		}
+		s.Requested = requested[s.ProductID]
+		if s.Requested > s.Available {
+			s.Short = s.Requested - s.Available
+			short = append(short, s)
+		}
+	}
+
+	return short, rows.Err()
+}
+
+// lockStockRows locks the stock rows of the given products in product id
+// order, creating any that are missing, until tx ends. Documents that move
+// several products take these locks before posting, whatever the oversell
+// policy, so two of them with the same products in a different order cannot
+// deadlock on the locks postInventory takes line by line.
+func lockStockRows(ctx context.Context, tx pgx.Tx, productIDs []int64) error {
+	ids := append([]int64(nil), productIDs...)
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	_, err := tx.Exec(ctx, `
+		INSERT INTO product_stock (product_id, on_hand)
+		SELECT DISTINCT UNNEST($1::bigint[]), 0
+		ON CONFLICT (product_id) DO NOTHING
+	`, ids)
+	if err != nil {
+		return fmt.Errorf("init stock rows: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		SELECT product_id
+		FROM product_stock
+		WHERE product_id = ANY($1)
+		ORDER BY product_id
+		FOR UPDATE
+	`, ids)
+	if err != nil {
+		return fmt.Errorf("lock stock rows: %w", err)
+	}
+	return nil
+}
+
+// markShortLines points each shortage at the request items, by position,
+// that ask for its product. productIDs holds the product of each item.
+func markShortLines(shortages []StockShortage, productIDs []int64) {
+	for i := range shortages {
+		for pos, id := range productIDs {
+			if id == shortages[i].ProductID {
+				shortages[i].Lines = append(shortages[i].Lines, pos)
+			}
+		}
+	}
+}
+
+// postInventory records a stock movement and moves the product's on-hand
+// balance with it. The product_stock row stays locked until tx ends, so
+// concurrent movements of one product are applied one after another and
+// balance_after is always the true running balance. It returns that balance.
+// Incoming units are valued at the current average cost; use
+// postInventoryCost when the cost is known.
+func postInventory(ctx context.Context, tx pgx.Tx, productID int64, qty int, refType string, refID int64, userID int) (int, error) {
+	p, err := postInventoryCost(ctx, tx, productID, qty, -1, refType, refID, userID)
+	return p.Balance, err
+}
@@ -0,0 +1,396 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"tulsi-pos/db"
+	"tulsi-pos/services"
+	"tulsi-pos/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+type LabelItem struct {
+	ProductID int64 `json:"product_id" binding:"required"`
+	Quantity  int   `json:"quantity" binding:"required,min=1"`
+}
+
+type LabelRequest struct {
+	Sheet string      `json:"sheet"`
+	Skip  int         `json:"skip" binding:"min=0"` // positions already used on the first sheet
+	Items []LabelItem `json:"items" binding:"required,min=1,dive"`
+}
+
+type BarcodeRequest struct {
+	ProductIDs []int64 `json:"product_ids"` // empty: every product without a barcode
+	Symbology  string  `json:"symbology"`   // ean13 (default) or code128
+}
+
+type GeneratedBarcode struct {
+	ProductID int64  `json:"product_id"`
+	Name      string `json:"name"`
+	Barcode   string `json:"barcode,omitempty"`
+	Reason    string `json:"reason,omitempty"` // why no barcode was generated
+}
+
+const maxLabels = 5000
+
+var (
+	ErrBarcodeTaken  = errors.New("barcode is already used by another product")
+	ErrUnknownSheet  = errors.New("unknown label sheet")
+	ErrTooManyLabels = fmt.Errorf("at most %d labels can be printed at once", maxLabels)
+)
+
+// GET /labels/sheets
+func GetLabelSheets(c *gin.Context) {
+	sheets := make([]services.LabelSheet, 0, len(services.LabelSheets))
+	for _, s := range services.LabelSheets {
+		sheets = append(sheets, s)
+	}
+	sort.Slice(sheets, func(i, j int) bool { return sheets[i].Name < sheets[j].Name })
+
+	utils.SendSuccessResponse(c, http.StatusOK, gin.H{
+		"default": services.DefaultLabelSheet,
+		"sheets":  sheets,
+	}, "Label sheets fetched successfully")
+}
+
+// POST /labels
+// A PDF of price tags, quantity copies per product. Products without a
+// barcode are given an in-store EAN-13 first.
+func PrintLabels(c *gin.Context) {
+	var req LabelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid input")
+		return
+	}
+
+	sendLabelsPDF(c, req.Sheet, req.Skip, req.Items, "labels.pdf")
+}
+
+// POST /purchases/:id/labels?sheet=&skip=
+// Tags for everything received on a purchase invoice, one per unit.
+func PrintPurchaseLabels(c *gin.Context) {
+	purchaseID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || purchaseID <= 0 {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid purchase id")
+		return
+	}
+	skip, err := strconv.Atoi(c.DefaultQuery("skip", "0"))
+	if err != nil || skip < 0 {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "skip must be a non-negative number")
+		return
+	}
+
+	ctx := c.Request.Context()
+	var invoiceNumber, status string
+	err = db.DB.QueryRow(ctx, `
+		SELECT invoice_number, status FROM purchase_invoices WHERE id = $1
+	`, purchaseID).Scan(&invoiceNumber, &status)
+	if err == pgx.ErrNoRows {
+		utils.SendErrorResponse(c, http.StatusNotFound, ErrPurchaseNotFound.Error())
+		return
+	}
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if status == PurchaseCancelled {
+		utils.SendErrorResponse(c, http.StatusBadRequest, ErrPurchaseCancelled.Error())
+		return
+	}
+
+	rows, err := db.DB.Query(ctx, `
+		SELECT product_id, SUM(quantity)
+		FROM purchase_invoice_items
+		WHERE purchase_invoice_id = $1
+		GROUP BY product_id
+		HAVING SUM(quantity) > 0
+		ORDER BY MIN(id)
+	`, purchaseID)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	var items []LabelItem
+	for rows.Next() {
+		var it LabelItem
+		if err := rows.Scan(&it.ProductID, &it.Quantity); err != nil {
+			rows.Close()
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		items = append(items, it)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if len(items) == 0 {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "purchase invoice has no items")
+		return
+	}
+
+	sendLabelsPDF(c, c.Query("sheet"), skip, items, fmt.Sprintf("labels-%s.pdf", invoiceNumber))
+}
+
+// POST /products/barcodes
+// Gives products without a barcode an in-store EAN-13, or a Code 128 of
+// their SKU. Products that already have one are left alone.
+func GenerateBarcodes(c *gin.Context) {
+	var req BarcodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid input")
+		return
+	}
+	req.Symbology = strings.ToLower(strings.TrimSpace(req.Symbology))
+	if req.Symbology == "" {
+		req.Symbology = utils.BarcodeEAN13
+	}
+	if req.Symbology != utils.BarcodeEAN13 && req.Symbology != utils.BarcodeCode128 {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "symbology must be ean13 or code128")
+		return
+	}
+	if req.ProductIDs == nil {
+		req.ProductIDs = []int64{}
+	}
+
+	ctx := c.Request.Context()
+	tx, err := db.DB.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "failed to start tx")
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		SELECT id, name, COALESCE(sku, '')
+		FROM products
+		WHERE deleted_at IS NULL AND COALESCE(barcode, '') = ''
+		  AND (cardinality($1::bigint[]) = 0 OR id = ANY($1))
+		ORDER BY id
+		FOR UPDATE
+	`, req.ProductIDs)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	type pending struct {
+		id        int64
+		name, sku string
+	}
+	var products []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.id, &p.name, &p.sku); err != nil {
+			rows.Close()
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		products = append(products, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	generated := []GeneratedBarcode{}
+	skipped := []GeneratedBarcode{}
+	for _, p := range products {
+		code := utils.InStoreEAN13(p.id)
+		if req.Symbology == utils.BarcodeCode128 {
+			code = p.sku
+			if code == "" {
+				skipped = append(skipped, GeneratedBarcode{ProductID: p.id, Name: p.name, Reason: "product has no SKU"})
+				continue
+			}
+		}
+		err := assignBarcode(ctx, tx, p.id, code)
+		if err != nil {
+			if errors.Is(err, ErrBarcodeTaken) || errors.Is(err, utils.ErrBarcodeCharset) {
+				skipped = append(skipped, GeneratedBarcode{ProductID: p.id, Name: p.name, Reason: err.Error()})
+				continue
+			}
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		generated = append(generated, GeneratedBarcode{ProductID: p.id, Name: p.name, Barcode: code})
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "failed to commit tx")
+		return
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, gin.H{
+		"generated": generated,
+		"skipped":   skipped,
+	}, fmt.Sprintf("%d barcodes generated", len(generated)))
+}
+
+// ---------- Internal Logic ----------
+
+// sendLabelsPDF renders the tags for items and sends the PDF inline.
+func sendLabelsPDF(c *gin.Context, sheetName string, skip int, items []LabelItem, filename string) {
+	if sheetName == "" {
+		sheetName = services.DefaultLabelSheet
+	}
+	sheet, ok := services.LabelSheets[sheetName]
+	if !ok {
+		utils.SendErrorResponse(c, http.StatusBadRequest, fmt.Sprintf("%s: %s", ErrUnknownSheet, sheetName))
+		return
+	}
+	if skip >= sheet.PerPage() {
+		utils.SendErrorResponse(c, http.StatusBadRequest, fmt.Sprintf("skip must be less than %d for %s", sheet.PerPage(), sheet.Name))
+		return
+	}
+
+	labels, err := loadLabels(c.Request.Context(), items)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrProductNotFound):
+			utils.SendErrorResponse(c, http.StatusNotFound, err.Error())
+		case errors.Is(err, ErrTooManyLabels):
+			utils.SendErrorResponse(c, http.StatusBadRequest, err.Error())
+		case errors.Is(err, ErrBarcodeTaken), errors.Is(err, utils.ErrBarcodeCharset):
+			utils.SendErrorResponse(c, http.StatusConflict, err.Error())
+		default:
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	pdf, err := services.RenderLabelsPDF(sheet, skip, labels)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`inline; filename="%s"`, strings.ReplaceAll(filename, `"`, "")))
+	c.Data(http.StatusOK, "application/pdf", pdf)
+}
+
+// loadLabels builds quantity tags per item, in item order. Products without
+// a barcode get their in-store EAN-13. Products with no MRP set are tagged
+// with their sales price plus GST, since an MRP includes all taxes.
+func loadLabels(ctx context.Context, items []LabelItem) ([]services.Label, error) {
+	total := 0
+	ids := make([]int64, 0, len(items))
+	for _, it := range items {
+		total += it.Quantity
+		ids = append(ids, it.ProductID)
+	}
+	if total > maxLabels {
+		return nil, ErrTooManyLabels
+	}
+
+	tx, err := db.DB.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		SELECT id, name, COALESCE(size, ''), COALESCE(colour, ''),
+		       COALESCE(mrp, 0), COALESCE(sales_price, 0), COALESCE(barcode, '')
+		FROM products
+		WHERE id = ANY($1) AND deleted_at IS NULL
+		FOR UPDATE
+	`, ids)
+	if err != nil {
+		return nil, fmt.Errorf("load products: %w", err)
+	}
+	products := map[int64]services.Label{}
+	salesPrices := map[int64]float64{}
+	for rows.Next() {
+		var id int64
+		var l services.Label
+		var salesPrice float64
+		if err := rows.Scan(&id, &l.Name, &l.Size, &l.Colour, &l.MRP, &salesPrice, &l.Barcode); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		products[id] = l
+		salesPrices[id] = salesPrice
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for id, l := range products {
+		if l.MRP > 0 {
+			continue
+		}
+		rate, err := productGSTPercent(ctx, tx, id, salesPrices[id], now)
+		if err != nil {
+			return nil, err
+		}
+		l.MRP = round2(salesPrices[id] * (1 + rate/100))
+		products[id] = l
+	}
+
+	var labels []services.Label
+	for _, it := range items {
+		l, ok := products[it.ProductID]
+		if !ok {
+			return nil, fmt.Errorf("%w: %d", ErrProductNotFound, it.ProductID)
+		}
+		if l.Barcode == "" {
+			l.Barcode = utils.InStoreEAN13(it.ProductID)
+			if err := assignBarcode(ctx, tx, it.ProductID, l.Barcode); err != nil {
+				return nil, err
+			}
+			products[it.ProductID] = l
+		}
+		if _, err := utils.EncodeBarcode(l.Barcode); err != nil {
+			return nil, fmt.Errorf("%w: %s has barcode %q", err, l.Name, l.Barcode)
+		}
+		for i := 0; i < it.Quantity; i++ {
+			labels = append(labels, l)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("commit tx: %w", err)
+	}
+	return labels, nil
+}
+
+// assignBarcode sets a product's barcode unless another product already
+// uses it.
+func assignBarcode(ctx context.Context, tx pgx.Tx, productID int64, code string) error {
+	if _, err := utils.EncodeBarcode(code); err != nil {
+		return err
+	}
+
+	var taken bool
+	err := tx.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM products WHERE barcode = $1 AND id <> $2 AND deleted_at IS NULL)
+	`, code, productID).Scan(&taken)
+	if err != nil {
+		return fmt.Errorf("check barcode: %w", err)
+	}
+	if taken {
+		return fmt.Errorf("%w: %s", ErrBarcodeTaken, code)
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE products SET barcode = $1, updated_at = NOW() WHERE id = $2
+	`, code, productID)
+	if err != nil {
+		return fmt.Errorf("set barcode: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"tulsi-pos/db"
+	"tulsi-pos/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ProductMargin struct {
+	ProductID     int64   `json:"product_id"`
+	Name          string  `json:"name"`
+	SKU           string  `json:"sku"`
+	Category      string  `json:"category"`
+	QuantitySold  int     `json:"quantity_sold"` // net of returns
+	NetSales      float64 `json:"net_sales"`     // taxable value, net of returns
+	UnitCost      float64 `json:"unit_cost"`
+	CostSource    string  `json:"cost_source"` // cogs (recorded at sale), landed (purchase lines) or product (master price)
+	Cost          float64 `json:"cost"`
+	Margin        float64 `json:"margin"`
+	MarginPercent float64 `json:"margin_percent"`
+}
+
+// GET /reports/margin?from=&to=&category=
+// Gross margin per product on sales invoiced in the period, net of returns.
+// Units are costed at the COGS recorded when they were invoiced. Sales from
+// before costs were recorded fall back to the average landed cost of
+// purchases booked up to the end of the period, then the product's purchase
+// price.
+func GetMarginReport(c *gin.Context) {
+	from, to, err := parsePeriod(c)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	category := strings.TrimSpace(c.Query("category"))
+
+	rows, err := db.DB.Query(c.Request.Context(), `
+		WITH sold AS (
+			SELECT sii.product_id, SUM(sii.quantity) AS qty,
+			       SUM(sii.line_total - sii.gst_amount) AS taxable,
+			       SUM(COALESCE(sii.cogs, 0)) AS cogs,
+			       SUM(CASE WHEN sii.cogs IS NULL THEN sii.quantity ELSE 0 END) AS uncosted
+			FROM sales_invoice_items sii
+			JOIN sales_invoices si ON si.id = sii.sales_invoice_id
+			WHERE si.status = 'INVOICED' AND si.deleted_at IS NULL AND sii.deleted_at IS NULL
+			  AND si.invoiced_at >= $1 AND si.invoiced_at < $2
+			GROUP BY sii.product_id
+		), returned AS (
+			SELECT sri.product_id, SUM(sri.quantity) AS qty, SUM(sri.taxable_amount) AS taxable,
+			       SUM(COALESCE(sri.cogs, 0)) AS cogs,
+			       SUM(CASE WHEN sri.cogs IS NULL THEN sri.quantity ELSE 0 END) AS uncosted
+			FROM sales_return_items sri
+			JOIN sales_returns sr ON sr.id = sri.sales_return_id
+			WHERE sr.created_at >= $1 AND sr.created_at < $2
+			GROUP BY sri.product_id
+		), landed AS (
+			SELECT pii.product_id,
+			       SUM(pii.quantity * COALESCE(pii.landed_unit_cost, pii.purchase_price))
+			           / NULLIF(SUM(pii.quantity), 0) AS unit_cost
+			FROM purchase_invoice_items pii
+			JOIN purchase_invoices pi ON pi.id = pii.purchase_invoice_id
+			WHERE pi.deleted_at IS NULL
+			  AND COALESCE(pi.invoice_date, pi.created_at::date) < $2::date
+			GROUP BY pii.product_id
+		)
+		SELECT p.id, p.name, COALESCE(p.sku, ''), COALESCE(p.category, ''),
+		       COALESCE(s.qty, 0) - COALESCE(r.qty, 0),
+		       COALESCE(s.taxable, 0) - COALESCE(r.taxable, 0),
+		       COALESCE(s.cogs, 0) - COALESCE(r.cogs, 0),
+		       COALESCE(s.uncosted, 0) - COALESCE(r.uncosted, 0),
+		       COALESCE(l.unit_cost, p.purchase_price, 0),
+		       l.unit_cost IS NOT NULL
+		FROM products p
+		LEFT JOIN sold s ON s.product_id = p.id
+		LEFT JOIN returned r ON r.product_id = p.id
+		LEFT JOIN landed l ON l.product_id = p.id
+		WHERE (s.product_id IS NOT NULL OR r.product_id IS NOT NULL)
+		  AND ($3 = '' OR p.category = $3)
+		ORDER BY p.name, p.id
+	`, from, to, category)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	products := []ProductMargin{}
+	var totalSales, totalCost float64
+	for rows.Next() {
+		var m ProductMargin
+		var cogs, fallbackCost float64
+		var uncosted int
+		var landed bool
+		if err := rows.Scan(&m.ProductID, &m.Name, &m.SKU, &m.Category,
+			&m.QuantitySold, &m.NetSales, &cogs, &uncosted, &fallbackCost, &landed); err != nil {
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		switch {
+		case uncosted == 0:
+			m.CostSource = "cogs"
+		case landed:
+			m.CostSource = "landed"
+		default:
+			m.CostSource = "product"
+		}
+		m.NetSales = round2(m.NetSales)
+		m.Cost = round2(cogs + float64(uncosted)*fallbackCost)
+		if m.QuantitySold != 0 {
+			m.UnitCost = roundCost(m.Cost / float64(m.QuantitySold))
+		}
+		m.Margin = round2(m.NetSales - m.Cost)
+		if m.NetSales != 0 {
+			m.MarginPercent = round2(m.Margin / m.NetSales * 100)
+		}
+		totalSales += m.NetSales
+		totalCost += m.Cost
+		products = append(products, m)
+	}
+	if err := rows.Err(); err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	margin := round2(totalSales - totalCost)
+	marginPercent := 0.0
+	if totalSales != 0 {
+		marginPercent = round2(margin / totalSales * 100)
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, gin.H{
+		"from":           utils.FormatDate(from),
+		"to":             utils.FormatDate(to.AddDate(0, 0, -1)),
+		"products":       products,
+		"net_sales":      round2(totalSales),
+		"cost":           round2(totalCost),
+		"margin":         margin,
+		"margin_percent": marginPercent,
+	}, "Margin report fetched successfully")
+}
@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"tulsi-pos/db"
+	"tulsi-pos/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// Series codes used by the application. Prefixes and padding live in the
+// document_series table so they can be changed without a release. Each code
+// is also the document type of its series; further series, such as a second
+// counter's invoices, name one of these as their type.
+const (
+	SeriesSalesInvoice  = "SALES_INVOICE"
+	SeriesCreditNote    = "CREDIT_NOTE"
+	SeriesDebitNote     = "DEBIT_NOTE"
+	SeriesAdjustment    = "ADJUSTMENT"
+	SeriesPurchaseOrder = "PURCHASE_ORDER"
+	SeriesGoodsReceipt  = "GOODS_RECEIPT"
+)
+
+// documentTypes are the documents a series can number.
+var documentTypes = []string{
+	SeriesSalesInvoice, SeriesCreditNote, SeriesDebitNote,
+	SeriesAdjustment, SeriesPurchaseOrder, SeriesGoodsReceipt,
+}
+
+var (
+	ErrSeriesNotFound    = errors.New("document series not found or inactive")
+	ErrSeriesWrongType   = errors.New("document series numbers a different document type")
+	ErrInvalidSeriesType = errors.New("document_type must be one of " + strings.Join(documentTypes, ", "))
+)
+
+type DocumentSeries struct {
+	ID           int64  `json:"id"`
+	Code         string `json:"code" binding:"required"`
+	DocumentType string `json:"document_type"` // defaults to SALES_INVOICE
+	Prefix       string `json:"prefix" binding:"required"`
+	Padding      int    `json:"padding"`
+	IsActive     bool   `json:"is_active"`
+	CurrentYear  string `json:"current_financial_year"`
+	LastNumber   int    `json:"last_number"`
+	NextPreview  string `json:"next_number_preview"`
+}
+
+// nextDocumentNumber allocates the next number of one of the application's
+// own series, whose document type is its code.
+func nextDocumentNumber(ctx context.Context, tx pgx.Tx, seriesCode string, at time.Time) (string, error) {
+	return allocateDocumentNumber(ctx, tx, seriesCode, seriesCode, at)
+}
+
+// allocateDocumentNumber allocates the next number of a series for the
+// financial year that contains at, e.g. TUL/2026-27/000123, refusing a series
+// that numbers some other document type. The counter row stays locked until
+// tx ends, so numbers are handed out in commit order and a rolled back
+// transaction gives its number back — the sequence has no gaps.
+func allocateDocumentNumber(ctx context.Context, tx pgx.Tx, docType, seriesCode string, at time.Time) (string, error) {
+	var (
+		seriesID   int64
+		prefix     string
+		padding    int
+		seriesType string
+	)
+	err := tx.QueryRow(ctx, `
+		SELECT id, prefix, padding, document_type
+		FROM document_series
+		WHERE code = $1 AND is_active = TRUE
+	`, seriesCode).Scan(&seriesID, &prefix, &padding, &seriesType)
+	if err == pgx.ErrNoRows {
+		return "", fmt.Errorf("%w: %s", ErrSeriesNotFound, seriesCode)
+	}
+	if err != nil {
+		return "", fmt.Errorf("load series: %w", err)
+	}
+	if seriesType != docType {
+		return "", fmt.Errorf("%w: %s numbers %s, not %s", ErrSeriesWrongType, seriesCode, seriesType, docType)
+	}
+
+	fy := utils.FinancialYear(at)
+
+	var n int
+	err = tx.QueryRow(ctx, `
+		INSERT INTO document_series_counters (series_id, financial_year, last_number)
+		VALUES ($1, $2, 1)
+		ON CONFLICT (series_id, financial_year)
+		DO UPDATE SET last_number = document_series_counters.last_number + 1
+		RETURNING last_number
+	`, seriesID, fy).Scan(&n)
+	if err != nil {
+		return "", fmt.Errorf("allocate number: %w", err)
+	}
+
+	return formatDocumentNumber(prefix, fy, padding, n), nil
+}
+
+func isDocumentType(t string) bool {
+	for _, d := range documentTypes {
+		if d == t {
+			return true
+		}
+	}
+	return false
+}
+
+func formatDocumentNumber(prefix, fy string, padding, n int) string {
+	return fmt.Sprintf("%s/%s/%0*d", prefix, fy, padding, n)
+}
+
+// GET /document-series
+func ListDocumentSeries(c *gin.Context) {
+	fy := utils.FinancialYear(time.Now())
+
+	rows, err := db.DB.Query(c.Request.Context(), `
+		SELECT ds.id, ds.code, ds.document_type, ds.prefix, ds.padding, ds.is_active,
+		       COALESCE(dsc.last_number, 0)
+		FROM document_series ds
+		LEFT JOIN document_series_counters dsc
+		       ON dsc.series_id = ds.id AND dsc.financial_year = $1
+		ORDER BY ds.code
+	`, fy)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	series := []DocumentSeries{}
+	for rows.Next() {
+		var s DocumentSeries
+		if err := rows.Scan(&s.ID, &s.Code, &s.DocumentType, &s.Prefix, &s.Padding, &s.IsActive, &s.LastNumber); err != nil {
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		s.CurrentYear = fy
+		s.NextPreview = formatDocumentNumber(s.Prefix, fy, s.Padding, s.LastNumber+1)
+		series = append(series, s)
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, series, "Document series fetched successfully")
+}
+
+// POST /document-series
+func CreateDocumentSeries(c *gin.Context) {
+	var s DocumentSeries
+	if err := c.ShouldBindJSON(&s); err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid input")
+		return
+	}
+	if s.Padding <= 0 {
+		s.Padding = 6
+	}
+	s.DocumentType = strings.ToUpper(strings.TrimSpace(s.DocumentType))
+	if s.DocumentType == "" {
+		s.DocumentType = SeriesSalesInvoice
+	}
+	if !isDocumentType(s.DocumentType) {
+		utils.SendErrorResponse(c, http.StatusBadRequest, ErrInvalidSeriesType.Error())
+		return
+	}
+
+	var id int64
+	err := db.DB.QueryRow(c.Request.Context(), `
+		INSERT INTO document_series (code, document_type, prefix, padding)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`, strings.ToUpper(strings.TrimSpace(s.Code)), s.DocumentType, strings.TrimSpace(s.Prefix), s.Padding).Scan(&id)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SendSuccessResponse(c, http.StatusCreated, gin.H{"id": id}, "document series created")
+}
+
+// PUT /document-series/:code
+// Only the presentation of future numbers changes; counters are untouched so
+// the sequence for the running financial year continues without a gap.
+func UpdateDocumentSeries(c *gin.Context) {
+	var in struct {
+		Prefix   string `json:"prefix" binding:"required"`
+		Padding  int    `json:"padding"`
+		IsActive *bool  `json:"is_active"`
+	}
+	if err := c.ShouldBindJSON(&in); err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid input")
+		return
+	}
+	if in.Padding <= 0 {
+		in.Padding = 6
+	}
+
+	res, err := db.DB.Exec(c.Request.Context(), `
+		UPDATE document_series
+		SET prefix = $1, padding = $2, is_active = COALESCE($3, is_active)
+		WHERE code = $4
+	`, strings.TrimSpace(in.Prefix), in.Padding, in.IsActive, strings.ToUpper(c.Param("code")))
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if res.RowsAffected() == 0 {
+		utils.SendErrorResponse(c, http.StatusNotFound, "document series not found")
+		return
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, nil, "document series updated")
+}
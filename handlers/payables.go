@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"tulsi-pos/db"
+	"tulsi-pos/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AgingBuckets splits an outstanding amount by days past the due date.
+type AgingBuckets struct {
+	NotDue     float64 `json:"not_due"`
+	Days0To30  float64 `json:"days_0_30"`
+	Days31To60 float64 `json:"days_31_60"`
+	Days61To90 float64 `json:"days_61_90"`
+	Over90     float64 `json:"days_90_plus"`
+	Total      float64 `json:"total"`
+}
+
+func (b *AgingBuckets) add(daysOverdue int, amount float64) {
+	switch {
+	case daysOverdue < 0:
+		b.NotDue = round2(b.NotDue + amount)
+	case daysOverdue <= 30:
+		b.Days0To30 = round2(b.Days0To30 + amount)
+	case daysOverdue <= 60:
+		b.Days31To60 = round2(b.Days31To60 + amount)
+	case daysOverdue <= 90:
+		b.Days61To90 = round2(b.Days61To90 + amount)
+	default:
+		b.Over90 = round2(b.Over90 + amount)
+	}
+	b.Total = round2(b.Total + amount)
+}
+
+type PayableInvoice struct {
+	PurchaseInvoiceID int64   `json:"purchase_invoice_id"`
+	InvoiceNumber     string  `json:"invoice_number"`
+	InvoiceDate       string  `json:"invoice_date"`
+	DueDate           string  `json:"due_date"`
+	DaysOverdue       int     `json:"days_overdue"`
+	InvoiceAmount     float64 `json:"invoice_amount"`
+	Balance           float64 `json:"balance"`
+}
+
+type SupplierPayables struct {
+	SupplierID   int64            `json:"supplier_id"`
+	SupplierName string           `json:"supplier_name"`
+	Aging        AgingBuckets     `json:"aging"`
+	Advance      float64          `json:"advance"` // payments not allocated to any invoice
+	Credit       float64          `json:"credit"`  // debit notes beyond what was still owed on their bills
+	NetPayable   float64          `json:"net_payable"`
+	Invoices     []PayableInvoice `json:"invoices"`
+}
+
+// purchaseBalanceAsOfSQL is what was owed on purchase invoice pi at the end
+// of day $1, after the payments and debit notes dated by then.
+const purchaseBalanceAsOfSQL = `(pi.total_invoice_amount - COALESCE((
+	SELECT SUM(a.amount)
+	FROM supplier_payment_allocations a
+	JOIN supplier_payments sp ON sp.id = a.supplier_payment_id
+	WHERE a.purchase_invoice_id = pi.id AND sp.deleted_at IS NULL AND sp.payment_date <= $1::date
+), 0) - COALESCE((
+	SELECT SUM(pr.total_amount) FROM purchase_returns pr
+	WHERE pr.purchase_invoice_id = pi.id AND pr.created_at::date <= $1::date
+), 0))`
+
+// GET /reports/payables?as_of=&supplier_id=
+// Open purchase invoices by supplier as of the end of the given day (default
+// today): balances after the payments and debit notes dated by then, aged on
+// days past their due date. Bills the debit notes took below zero are listed
+// too and count as a credit against the supplier.
+func GetPayablesReport(c *gin.Context) {
+	asOf := time.Now()
+	if v := c.Query("as_of"); v != "" {
+		t, err := time.ParseInLocation(utils.DateFormat, v, time.Local)
+		if err != nil {
+			utils.SendErrorResponse(c, http.StatusBadRequest, "as_of must be YYYY-MM-DD")
+			return
+		}
+		asOf = t
+	}
+
+	var supplierID int64
+	if v := c.Query("supplier_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id <= 0 {
+			utils.SendErrorResponse(c, http.StatusBadRequest, "invalid supplier_id")
+			return
+		}
+		supplierID = id
+	}
+
+	ctx := c.Request.Context()
+
+	rows, err := db.DB.Query(ctx, `
+		SELECT s.id, s.name, pi.id, pi.invoice_number,
+		       COALESCE(pi.invoice_date, pi.created_at::date),
+		       COALESCE(pi.due_date, pi.invoice_date, pi.created_at::date),
+		       $1::date - COALESCE(pi.due_date, pi.invoice_date, pi.created_at::date),
+		       COALESCE(pi.total_invoice_amount, 0), `+purchaseBalanceAsOfSQL+`
+		FROM purchase_invoices pi
+		JOIN suppliers s ON s.id = pi.supplier_id
+		WHERE pi.deleted_at IS NULL
+		  AND COALESCE(pi.invoice_date, pi.created_at::date) <= $1::date
+		  AND ($2 = 0 OR s.id = $2)
+		  AND ABS(`+purchaseBalanceAsOfSQL+`) > 0.005
+		ORDER BY s.name, s.id, 6, pi.id
+	`, asOf.Format(utils.DateFormat), supplierID)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	suppliers := []*SupplierPayables{}
+	bySupplier := map[int64]*SupplierPayables{}
+	var totals AgingBuckets
+	totalCredit := 0.0
+	for rows.Next() {
+		var (
+			sid                  int64
+			name                 string
+			inv                  PayableInvoice
+			invoiceDate, dueDate time.Time
+		)
+		if err := rows.Scan(&sid, &name, &inv.PurchaseInvoiceID, &inv.InvoiceNumber,
+			&invoiceDate, &dueDate, &inv.DaysOverdue, &inv.InvoiceAmount, &inv.Balance); err != nil {
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		inv.InvoiceDate = utils.FormatDate(invoiceDate)
+		inv.DueDate = utils.FormatDate(dueDate)
+		inv.Balance = round2(inv.Balance)
+
+		sp, ok := bySupplier[sid]
+		if !ok {
+			sp = &SupplierPayables{SupplierID: sid, SupplierName: name, Invoices: []PayableInvoice{}}
+			bySupplier[sid] = sp
+			suppliers = append(suppliers, sp)
+		}
+		sp.Invoices = append(sp.Invoices, inv)
+		// a debit note on a bill already paid leaves the supplier owing us;
+		// that credit is netted off like an advance rather than aged
+		if inv.Balance < 0 {
+			sp.Credit = round2(sp.Credit - inv.Balance)
+			totalCredit -= inv.Balance
+			continue
+		}
+		sp.Aging.add(inv.DaysOverdue, inv.Balance)
+		totals.add(inv.DaysOverdue, inv.Balance)
+	}
+	if err := rows.Err(); err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	rows.Close()
+
+	// unallocated payments are advances that will settle future invoices;
+	// money allocated to a bill dated after as_of was still an advance then
+	advRows, err := db.DB.Query(ctx, `
+		SELECT sp.supplier_id, s.name, SUM(sp.amount - COALESCE(a.allocated, 0))
+		FROM supplier_payments sp
+		JOIN suppliers s ON s.id = sp.supplier_id
+		LEFT JOIN (
+			SELECT spa.supplier_payment_id, SUM(spa.amount) AS allocated
+			FROM supplier_payment_allocations spa
+			JOIN purchase_invoices pi ON pi.id = spa.purchase_invoice_id
+			WHERE COALESCE(pi.invoice_date, pi.created_at::date) <= $1::date
+			GROUP BY spa.supplier_payment_id
+		) a ON a.supplier_payment_id = sp.id
+		WHERE sp.deleted_at IS NULL AND sp.payment_date <= $1::date
+		  AND ($2 = 0 OR sp.supplier_id = $2)
+		GROUP BY sp.supplier_id, s.name
+	`, asOf.Format(utils.DateFormat), supplierID)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer advRows.Close()
+
+	// a supplier with only an advance has no open invoices but is still listed
+	totalAdvance := 0.0
+	for advRows.Next() {
+		var sid int64
+		var name string
+		var advance float64
+		if err := advRows.Scan(&sid, &name, &advance); err != nil {
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if advance <= 0.005 {
+			continue
+		}
+		sp, ok := bySupplier[sid]
+		if !ok {
+			sp = &SupplierPayables{SupplierID: sid, SupplierName: name, Invoices: []PayableInvoice{}}
+			bySupplier[sid] = sp
+			suppliers = append(suppliers, sp)
+		}
+		sp.Advance = round2(advance)
+		totalAdvance += advance
+	}
+	if err := advRows.Err(); err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	sort.SliceStable(suppliers, func(i, j int) bool {
+		if suppliers[i].SupplierName != suppliers[j].SupplierName {
+			return suppliers[i].SupplierName < suppliers[j].SupplierName
+		}
+		return suppliers[i].SupplierID < suppliers[j].SupplierID
+	})
+
+	for _, sp := range suppliers {
+		sp.NetPayable = round2(sp.Aging.Total - sp.Advance - sp.Credit)
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, gin.H{
+		"as_of":       utils.FormatDate(asOf),
+		"suppliers":   suppliers,
+		"totals":      totals,
+		"advance":     round2(totalAdvance),
+		"credit":      round2(totalCredit),
+		"net_payable": round2(totals.Total - totalAdvance - totalCredit),
+	}, "Payables fetched successfully")
+}
@@ -2,13 +2,21 @@ package handlers
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"tulsi-pos/db"
 	"tulsi-pos/utils"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
 type Product struct {
@@ -20,7 +28,10 @@ type Product struct {
 	Category      string  `json:"category"`
 	PurchasePrice float64 `json:"purchase_price"`
 	SalesPrice    float64 `json:"sales_price"`
+	MRP           float64 `json:"mrp"`
 	GSTPercent    float64 `json:"gst_percent"`
+	ReorderPoint  int     `json:"reorder_point" binding:"min=0"`
+	ReorderQty    int     `json:"reorder_qty" binding:"min=0"`
 }
 
 // POST /products
@@ -32,64 +43,221 @@ func CreateProduct(c *gin.Context) {
 		return
 	}
 
-	query := `
-		INSERT INTO products
-		(name, sku, barcode, hsn_code, gender, category, purchase_price, sales_price, gst_percent)
-		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)
-		RETURNING id
-	`
+	ctx := c.Request.Context()
+	if fieldErrs := validateProduct(&p); len(fieldErrs) > 0 {
+		utils.SendErrorResponseWithData(c, http.StatusBadRequest, "invalid product", fieldErrs)
+		return
+	}
+	if msg, err := mrpError(ctx, db.DB, p); err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	} else if msg != "" {
+		utils.SendErrorResponseWithData(c, http.StatusBadRequest, "invalid product", map[string]string{"sales_price": msg})
+		return
+	}
 
-	var id int
-	err := db.DB.QueryRow(
-		context.Background(),
-		query,
-		p.Name, p.SKU, p.Barcode, p.HSNCode, p.Gender, p.Category,
-		p.PurchasePrice, p.SalesPrice, p.GSTPercent,
-	).Scan(&id)
+	tx, err := db.DB.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "failed to start tx")
+		return
+	}
+	defer tx.Rollback(ctx)
 
+	id, err := insertProduct(ctx, tx, p, c.GetInt("user_id"))
+	if errors.Is(err, ErrDuplicateSKU) {
+		utils.SendErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "failed to commit tx")
 		return
 	}
 
 	utils.SendSuccessResponse(c, http.StatusCreated, gin.H{"id": id}, "Product created")
 }
 
-// GET /products
+type ProductListItem struct {
+	ID         int64   `json:"id"`
+	Name       string  `json:"name"`
+	SKU        string  `json:"sku"`
+	Barcode    string  `json:"barcode"`
+	HSNCode    string  `json:"hsn_code"`
+	Category   string  `json:"category"`
+	Gender     string  `json:"gender"`
+	StyleID    *int64  `json:"style_id,omitempty"`
+	Size       string  `json:"size,omitempty"`
+	Colour     string  `json:"colour,omitempty"`
+	SalesPrice float64 `json:"sales_price"`
+	MRP        float64 `json:"mrp"`
+	GSTPercent float64 `json:"gst_percent"`
+	OnHand     int     `json:"on_hand"`
+}
+
+// GET /products?q=&category=&gender=&hsn_code=&min_price=&max_price=&in_stock=&sort=&limit=&cursor=
+// q matches names loosely (trigram similarity, so typos still hit) and SKUs
+// and barcodes by prefix. hsn_code matches as a prefix. sort is name, price,
+// created_at or relevance, with a leading "-" for descending; relevance
+// needs q and is the default when q is given. Pages are keyset based: pass
+// next_cursor back as cursor, with the same filters and sort, for the next
+// page. total counts every match.
 func GetProducts(c *gin.Context) {
-	rows, err := db.DB.Query(context.Background(), `
-		SELECT id, name, sku, barcode, category, gender, sales_price
-		FROM products
-		WHERE deleted_at IS NULL
-	`)
+	ctx := c.Request.Context()
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit < 1 || limit > 200 {
+		limit = 50
+	}
+
+	where := "WHERE p.deleted_at IS NULL"
+	params := []interface{}{}
+	add := func(cond string, v interface{}) {
+		params = append(params, v)
+		where += fmt.Sprintf(" AND "+cond, len(params))
+	}
+
+	q := strings.TrimSpace(c.Query("q"))
+	score := "0::real"
+	if q != "" {
+		params = append(params, q)
+		n := len(params)
+		where += fmt.Sprintf(` AND ($%[1]d <%% p.name OR p.name ILIKE '%%' || $%[1]d || '%%'
+			OR p.sku ILIKE $%[1]d || '%%' OR p.barcode = $%[1]d)`, n)
+		score = fmt.Sprintf("word_similarity($%d, p.name)", n)
+	}
+	for _, f := range []struct{ key, cond string }{
+		{"category", "p.category = $%d"},
+		{"gender", "p.gender = $%d"},
+		{"hsn_code", "p.hsn_code LIKE $%d || '%%'"},
+	} {
+		if v := strings.TrimSpace(c.Query(f.key)); v != "" {
+			add(f.cond, v)
+		}
+	}
+	for _, f := range []struct{ key, cond string }{
+		{"min_price", "COALESCE(p.sales_price, 0) >= $%d"},
+		{"max_price", "COALESCE(p.sales_price, 0) <= $%d"},
+	} {
+		if v := c.Query(f.key); v != "" {
+			price, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				utils.SendErrorResponse(c, http.StatusBadRequest, "invalid "+f.key)
+				return
+			}
+			add(f.cond, price)
+		}
+	}
+	if v := c.Query("in_stock"); v != "" {
+		inStock, err := strconv.ParseBool(v)
+		if err != nil {
+			utils.SendErrorResponse(c, http.StatusBadRequest, "in_stock must be true or false")
+			return
+		}
+		if inStock {
+			where += " AND COALESCE(ps.on_hand, 0) > 0"
+		} else {
+			where += " AND COALESCE(ps.on_hand, 0) <= 0"
+		}
+	}
 
+	sortName := c.Query("sort")
+	if sortName == "" {
+		sortName = "name"
+		if q != "" {
+			sortName = "-relevance"
+		}
+	}
+	if strings.TrimPrefix(sortName, "-") == "relevance" && q == "" {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "sort by relevance needs q")
+		return
+	}
+	key, desc, ok := productSortKey(sortName, score)
+	if !ok {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "sort must be name, price, created_at or relevance, optionally prefixed with -")
+		return
+	}
+
+	const from = `
+		FROM products p
+		LEFT JOIN product_stock ps ON ps.product_id = p.id
+	`
+
+	var total int
+	if err := db.DB.QueryRow(ctx, `SELECT COUNT(*)`+from+where, params...).Scan(&total); err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	dir, cmp := "ASC", ">"
+	if desc {
+		dir, cmp = "DESC", "<"
+	}
+	if v := c.Query("cursor"); v != "" {
+		cur, err := decodeProductCursor(v)
+		if err != nil || cur.Sort != sortName {
+			utils.SendErrorResponse(c, http.StatusBadRequest, "invalid cursor")
+			return
+		}
+		params = append(params, cur.Key, cur.ID)
+		where += fmt.Sprintf(" AND (%s, p.id) %s ($%d::%s, $%d)",
+			key.expr, cmp, len(params)-1, key.cast, len(params))
+	}
+
+	params = append(params, limit+1)
+	rows, err := db.DB.Query(ctx, `
+		SELECT p.id, p.name, COALESCE(p.sku, ''), COALESCE(p.barcode, ''), COALESCE(p.hsn_code, ''),
+		       COALESCE(p.category, ''), COALESCE(p.gender, ''), p.style_id,
+		       COALESCE(p.size, ''), COALESCE(p.colour, ''),
+		       COALESCE(p.sales_price, 0), COALESCE(p.mrp, 0), COALESCE(p.gst_percent, 0),
+		       COALESCE(ps.on_hand, 0), (`+key.expr+`)::text
+		`+from+where+`
+		ORDER BY `+key.expr+` `+dir+`, p.id `+dir+`
+		LIMIT $`+strconv.Itoa(len(params)), params...)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 	defer rows.Close()
 
-	products := []map[string]interface{}{}
-
+	products := []ProductListItem{}
+	var lastKey string
+	more := false
 	for rows.Next() {
-		var id int
-		var name, sku, barcode, category, gender string
-		var salesPrice float64
-
-		rows.Scan(&id, &name, &sku, &barcode, &category, &gender, &salesPrice)
-
-		products = append(products, gin.H{
-			"id":          id,
-			"name":        name,
-			"sku":         sku,
-			"barcode":     barcode,
-			"category":    category,
-			"gender":      gender,
-			"sales_price": salesPrice,
-		})
+		if len(products) == limit {
+			// a row past the limit: there is another page
+			more = true
+			break
+		}
+		var p ProductListItem
+		if err := rows.Scan(&p.ID, &p.Name, &p.SKU, &p.Barcode, &p.HSNCode, &p.Category, &p.Gender,
+			&p.StyleID, &p.Size, &p.Colour, &p.SalesPrice, &p.MRP, &p.GSTPercent,
+			&p.OnHand, &lastKey); err != nil {
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		products = append(products, p)
+	}
+	if err := rows.Err(); err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	nextCursor := ""
+	if more {
+		last := products[len(products)-1]
+		nextCursor = encodeProductCursor(productCursor{Sort: sortName, Key: lastKey, ID: last.ID})
 	}
 
-	utils.SendSuccessResponse(c, http.StatusOK, products, "Products fetched successfully")
+	utils.SendSuccessResponse(c, http.StatusOK, gin.H{
+		"limit":       limit,
+		"total":       total,
+		"next_cursor": nextCursor,
+		"products":    products,
+	}, "Products fetched successfully")
 }
 
 func GetProductByID(c *gin.Context) {
@@ -110,17 +278,26 @@ func GetProductByID(c *gin.Context) {
 		Category      string  `json:"category"`
 		PurchasePrice float64 `json:"purchase_price"`
 		SalesPrice    float64 `json:"sales_price"`
+		MRP           float64 `json:"mrp"`
 		GSTPercent    float64 `json:"gst_percent"`
+		ReorderPoint  int     `json:"reorder_point"`
+		ReorderQty    int     `json:"reorder_qty"`
+		StyleID       *int64  `json:"style_id,omitempty"`
+		Size          string  `json:"size,omitempty"`
+		Colour        string  `json:"colour,omitempty"`
 	}
 
 	err = db.DB.QueryRow(c.Request.Context(), `
 		SELECT id, name, sku, barcode, hsn_code, gender, category,
-		       purchase_price, sales_price, gst_percent
+		       purchase_price, sales_price, COALESCE(mrp, 0), gst_percent, reorder_point, reorder_qty,
+		       style_id, COALESCE(size, ''), COALESCE(colour, '')
 		FROM products
 		WHERE id = $1 AND deleted_at IS NULL
 	`, productID).Scan(
 		&p.ID, &p.Name, &p.SKU, &p.Barcode, &p.HSNCode,
-		&p.Gender, &p.Category, &p.PurchasePrice, &p.SalesPrice, &p.GSTPercent,
+		&p.Gender, &p.Category, &p.PurchasePrice, &p.SalesPrice, &p.MRP, &p.GSTPercent,
+		&p.ReorderPoint, &p.ReorderQty,
+		&p.StyleID, &p.Size, &p.Colour,
 	)
 
 	if err != nil {
@@ -130,3 +307,576 @@ func GetProductByID(c *gin.Context) {
 
 	utils.SendSuccessResponse(c, http.StatusOK, p, "Product details fetched successfully")
 }
+
+// PUT /products/:id/reorder
+func UpdateProductReorder(c *gin.Context) {
+	productID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || productID <= 0 {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid product id")
+		return
+	}
+
+	var in struct {
+		ReorderPoint int `json:"reorder_point" binding:"min=0"`
+		ReorderQty   int `json:"reorder_qty" binding:"min=0"`
+	}
+	if err := c.ShouldBindJSON(&in); err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid input")
+		return
+	}
+
+	res, err := db.DB.Exec(c.Request.Context(), `
+		UPDATE products
+		SET reorder_point = $1, reorder_qty = $2
+		WHERE id = $3 AND deleted_at IS NULL
+	`, in.ReorderPoint, in.ReorderQty, productID)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if res.RowsAffected() == 0 {
+		utils.SendErrorResponse(c, http.StatusNotFound, "product not found")
+		return
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, nil, "reorder levels updated")
+}
+
+// PUT /products/:id
+// Replaces the product's details. Changes to the purchase price, sales price
+// or GST rate are kept in the price history.
+func UpdateProduct(c *gin.Context) {
+	productID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || productID <= 0 {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid product id")
+		return
+	}
+
+	var p Product
+	if err := c.ShouldBindJSON(&p); err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid input")
+		return
+	}
+
+	if fieldErrs := validateProduct(&p); len(fieldErrs) > 0 {
+		utils.SendErrorResponseWithData(c, http.StatusBadRequest, "invalid product", fieldErrs)
+		return
+	}
+	changes, err := updateProduct(c.Request.Context(), productID, p, c.GetInt("user_id"))
+	if err != nil {
+		var mrpErr *ProductMRPError
+		switch {
+		case errors.Is(err, ErrProductNotFound):
+			utils.SendErrorResponse(c, http.StatusNotFound, err.Error())
+		case errors.As(err, &mrpErr):
+			utils.SendErrorResponseWithData(c, http.StatusBadRequest, "invalid product", map[string]string{"sales_price": mrpErr.Message})
+		case errors.Is(err, ErrDuplicateSKU):
+			utils.SendErrorResponse(c, http.StatusBadRequest, err.Error())
+		default:
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, gin.H{
+		"id":            productID,
+		"price_changes": changes,
+	}, "product updated")
+}
+
+// DELETE /products/:id
+// Soft-deletes a product. Refused while it has stock on hand or is on an
+// open draft (sales invoice, purchase order, stock adjustment or stock take).
+func DeleteProduct(c *gin.Context) {
+	productID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || productID <= 0 {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid product id")
+		return
+	}
+
+	blockers, err := deleteProduct(c.Request.Context(), productID)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrProductNotFound):
+			utils.SendErrorResponse(c, http.StatusNotFound, err.Error())
+		case errors.Is(err, ErrProductInUse):
+			utils.SendErrorResponseWithData(c, http.StatusConflict, err.Error(), blockers)
+		default:
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, nil, "product deleted")
+}
+
+// GET /products/:id/price-history?field=
+func GetProductPriceHistory(c *gin.Context) {
+	productID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || productID <= 0 {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid product id")
+		return
+	}
+
+	field := c.Query("field")
+	if field != "" && field != PriceFieldPurchase && field != PriceFieldSales && field != PriceFieldGST {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "field must be purchase_price, sales_price or gst_percent")
+		return
+	}
+
+	rows, err := db.DB.Query(c.Request.Context(), `
+		SELECT h.id, h.field, h.old_value, h.new_value, h.effective_at,
+		       h.changed_by, COALESCE(u.name, '')
+		FROM product_price_history h
+		LEFT JOIN users u ON u.id = h.changed_by
+		WHERE h.product_id = $1 AND ($2 = '' OR h.field = $2)
+		ORDER BY h.effective_at DESC, h.id DESC
+	`, productID, field)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	history := []gin.H{}
+	for rows.Next() {
+		var (
+			id          int64
+			f           string
+			oldValue    *float64
+			newValue    float64
+			effectiveAt time.Time
+			changedBy   *int64
+			changedName string
+		)
+		if err := rows.Scan(&id, &f, &oldValue, &newValue, &effectiveAt, &changedBy, &changedName); err != nil {
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		history = append(history, gin.H{
+			"id":              id,
+			"field":           f,
+			"old_value":       oldValue,
+			"new_value":       newValue,
+			"effective_at":    utils.FormatDateTime(effectiveAt),
+			"changed_by":      changedBy,
+			"changed_by_name": changedName,
+		})
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, history, "Price history fetched successfully")
+}
+
+// ---------- Internal Logic ----------
+
+var (
+	ErrDuplicateSKU = errors.New("another product already has this SKU")
+	ErrProductInUse = errors.New("product is in stock or on an open document")
+)
+
+// Fields tracked in product_price_history.
+const (
+	PriceFieldPurchase = "purchase_price"
+	PriceFieldSales    = "sales_price"
+	PriceFieldGST      = "gst_percent"
+)
+
+type productPrices struct {
+	PurchasePrice float64
+	SalesPrice    float64
+	GSTPercent    float64
+}
+
+func (p Product) prices() productPrices {
+	return productPrices{PurchasePrice: p.PurchasePrice, SalesPrice: p.SalesPrice, GSTPercent: p.GSTPercent}
+}
+
+type PriceChange struct {
+	Field    string   `json:"field"`
+	OldValue *float64 `json:"old_value"`
+	NewValue float64  `json:"new_value"`
+}
+
+// ProductBlocker is something that stops a product being deleted.
+type ProductBlocker struct {
+	Type   string `json:"type"` // stock, sales_invoice, purchase_order, stock_adjustment, stock_take
+	ID     int64  `json:"id,omitempty"`
+	Number string `json:"number,omitempty"`
+	OnHand int    `json:"on_hand,omitempty"`
+}
+
+func validateProduct(p *Product) map[string]string {
+	p.Name = strings.TrimSpace(p.Name)
+	p.SKU = strings.TrimSpace(p.SKU)
+	p.Barcode = strings.TrimSpace(p.Barcode)
+	p.HSNCode = strings.TrimSpace(p.HSNCode)
+
+	errs := map[string]string{}
+	if p.Name == "" {
+		errs["name"] = "name is required"
+	}
+	if p.PurchasePrice < 0 {
+		errs["purchase_price"] = "purchase_price must not be negative"
+	}
+	if p.SalesPrice < 0 {
+		errs["sales_price"] = "sales_price must not be negative"
+	}
+	if p.MRP < 0 {
+		errs["mrp"] = "mrp must not be negative"
+	}
+	if !utils.IsValidGSTRate(p.GSTPercent) {
+		errs["gst_percent"] = fmt.Sprintf("gst_percent must be one of %v", utils.GSTRates)
+	}
+	if p.ReorderPoint < 0 {
+		errs["reorder_point"] = "reorder_point must not be negative"
+	}
+	if p.ReorderQty < 0 {
+		errs["reorder_qty"] = "reorder_qty must not be negative"
+	}
+	return errs
+}
+
+// mrpError checks that the product bills within its MRP, which includes all
+// taxes: the sales price plus GST at the tax rule's rate for that price, or
+// the product's own rate. It returns the field error, if any.
+func mrpError(ctx context.Context, q db.Querier, p Product) (string, error) {
+	if p.MRP <= 0 {
+		return "", nil
+	}
+	rate, ok, err := resolveGSTPercent(ctx, q, p.HSNCode, p.SalesPrice, time.Now())
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		rate = p.GSTPercent
+	}
+	if price := round2(p.SalesPrice * (1 + rate/100)); price > p.MRP {
+		return fmt.Sprintf("sales_price plus %g%% GST (%.2f) must not exceed mrp", rate, price), nil
+	}
+	return "", nil
+}
+
+// insertProduct creates a product and records its opening prices.
+func insertProduct(ctx context.Context, tx pgx.Tx, p Product, userID int) (int64, error) {
+	var id int64
+	err := tx.QueryRow(ctx, `
+		INSERT INTO products
+		(name, sku, barcode, hsn_code, gender, category, purchase_price, sales_price, gst_percent,
+		 reorder_point, reorder_qty, mrp)
+		VALUES ($1,NULLIF($2, ''),$3,$4,$5,$6,$7,$8,$9,$10,$11,NULLIF($12, 0))
+		RETURNING id
+	`,
+		p.Name, p.SKU, p.Barcode, p.HSNCode, p.Gender, p.Category,
+		p.PurchasePrice, p.SalesPrice, p.GSTPercent,
+		p.ReorderPoint, p.ReorderQty, p.MRP,
+	).Scan(&id)
+	if isUniqueViolation(err, "products_sku_key") {
+		return 0, fmt.Errorf("%w: %s", ErrDuplicateSKU, p.SKU)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("insert product: %w", err)
+	}
+
+	if _, err := recordPriceChanges(ctx, tx, id, nil, p.prices(), userID); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// productSortColumn is an expression products can be ordered by, and the
+// type its text form is cast back to in a cursor.
+type productSortColumn struct {
+	expr string
+	cast string
+}
+
+// productSortKey maps a sort option to its column and direction. score is
+// the relevance expression for the search term.
+func productSortKey(sortName, score string) (productSortColumn, bool, bool) {
+	desc := strings.HasPrefix(sortName, "-")
+	switch strings.TrimPrefix(sortName, "-") {
+	case "name":
+		return productSortColumn{"p.name", "text"}, desc, true
+	case "price":
+		return productSortColumn{"COALESCE(p.sales_price, 0)", "numeric"}, desc, true
+	case "created_at":
+		return productSortColumn{"COALESCE(p.created_at, 'epoch'::timestamp)", "timestamp"}, desc, true
+	case "relevance":
+		return productSortColumn{score, "real"}, desc, true
+	}
+	return productSortColumn{}, false, false
+}
+
+// productCursor marks the last product of a page: the sort it was read
+// with, that row's sort key as text and its id.
+type productCursor struct {
+	Sort string `json:"s"`
+	Key  string `json:"k"`
+	ID   int64  `json:"id"`
+}
+
+func encodeProductCursor(cur productCursor) string {
+	b, _ := json.Marshal(cur)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeProductCursor(v string) (productCursor, error) {
+	var cur productCursor
+	b, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil {
+		return cur, err
+	}
+	if err := json.Unmarshal(b, &cur); err != nil {
+		return cur, err
+	}
+	if cur.ID <= 0 {
+		return cur, errors.New("cursor has no id")
+	}
+	return cur, nil
+}
+
+// recordPriceChanges writes a history row for each price that differs
+// between old and cur; old is nil when the product is created.
+func recordPriceChanges(ctx context.Context, tx pgx.Tx, productID int64, old *productPrices, cur productPrices, userID int) ([]PriceChange, error) {
+	fields := []struct {
+		name     string
+		old, new float64
+	}{
+		{PriceFieldPurchase, 0, cur.PurchasePrice},
+		{PriceFieldSales, 0, cur.SalesPrice},
+		{PriceFieldGST, 0, cur.GSTPercent},
+	}
+	if old != nil {
+		fields[0].old = old.PurchasePrice
+		fields[1].old = old.SalesPrice
+		fields[2].old = old.GSTPercent
+	}
+
+	changes := []PriceChange{}
+	for _, f := range fields {
+		ch := PriceChange{Field: f.name, NewValue: round2(f.new)}
+		if old != nil {
+			if round2(f.old) == ch.NewValue {
+				continue
+			}
+			v := round2(f.old)
+			ch.OldValue = &v
+		}
+
+		_, err := tx.Exec(ctx, `
+			INSERT INTO product_price_history (product_id, field, old_value, new_value, changed_by)
+			VALUES ($1, $2, $3, $4, $5)
+		`, productID, ch.Field, ch.OldValue, ch.NewValue, nullableUserID(userID))
+		if err != nil {
+			return nil, fmt.Errorf("record price history: %w", err)
+		}
+		changes = append(changes, ch)
+	}
+	return changes, nil
+}
+
+func updateProduct(ctx context.Context, productID int64, p Product, userID int) ([]PriceChange, error) {
+	tx, err := db.DB.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	changes, err := updateProductTx(ctx, tx, productID, p, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("commit tx: %w", err)
+	}
+	return changes, nil
+}
+
+// updateProductTx is updateProduct inside the caller's transaction.
+func updateProductTx(ctx context.Context, tx pgx.Tx, productID int64, p Product, userID int) ([]PriceChange, error) {
+	var old productPrices
+	err := tx.QueryRow(ctx, `
+		SELECT COALESCE(purchase_price, 0), COALESCE(sales_price, 0), COALESCE(gst_percent, 0)
+		FROM products
+		WHERE id = $1 AND deleted_at IS NULL
+		FOR UPDATE
+	`, productID).Scan(&old.PurchasePrice, &old.SalesPrice, &old.GSTPercent)
+	if err == pgx.ErrNoRows {
+		return nil, ErrProductNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load product: %w", err)
+	}
+
+	if err := applyStyleAttributes(ctx, tx, productID, &p); err != nil {
+		return nil, err
+	}
+	// a variant is billed at its style's GST rate, so the MRP is checked at that
+	msg, err := mrpError(ctx, tx, p)
+	if err != nil {
+		return nil, err
+	}
+	if msg != "" {
+		return nil, &ProductMRPError{Message: msg}
+	}
+
+	if p.SKU != "" {
+		var taken bool
+		err = tx.QueryRow(ctx, `
+			SELECT EXISTS (SELECT 1 FROM products WHERE sku = $1 AND id <> $2)
+		`, p.SKU, productID).Scan(&taken)
+		if err != nil {
+			return nil, fmt.Errorf("check sku: %w", err)
+		}
+		if taken {
+			return nil, fmt.Errorf("%w: %s", ErrDuplicateSKU, p.SKU)
+		}
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE products
+		SET name = $1, sku = NULLIF($2, ''), barcode = $3, hsn_code = $4, gender = $5, category = $6,
+		    purchase_price = $7, sales_price = $8, gst_percent = $9,
+		    reorder_point = $10, reorder_qty = $11, mrp = NULLIF($12, 0), updated_at = NOW()
+		WHERE id = $13
+	`, p.Name, p.SKU, p.Barcode, p.HSNCode, p.Gender, p.Category,
+		p.PurchasePrice, p.SalesPrice, p.GSTPercent,
+		p.ReorderPoint, p.ReorderQty, p.MRP, productID)
+	if isUniqueViolation(err, "products_sku_key") {
+		return nil, fmt.Errorf("%w: %s", ErrDuplicateSKU, p.SKU)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("update product: %w", err)
+	}
+
+	return recordPriceChanges(ctx, tx, productID, &old, p.prices(), userID)
+}
+
+// ProductMRPError is an update that would bill the product above its MRP.
+type ProductMRPError struct {
+	Message string
+}
+
+func (e *ProductMRPError) Error() string { return "invalid product: " + e.Message }
+
+// isUniqueViolation reports whether err broke the named unique constraint.
+func isUniqueViolation(err error, constraint string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == constraint
+}
+
+// applyStyleAttributes gives a style's variant the attributes it keeps from
+// the style: HSN code, gender, category and GST rate. Only prices and stock
+// settings are a variant's own. Other products are left as they are.
+func applyStyleAttributes(ctx context.Context, q db.Querier, productID int64, p *Product) error {
+	var style Style
+	err := scanStyle(q.QueryRow(ctx, `
+		SELECT `+styleColumns+` FROM product_styles
+		WHERE id = (SELECT style_id FROM products WHERE id = $1)
+	`, productID), &style)
+	if err == pgx.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("load style: %w", err)
+	}
+	p.HSNCode, p.Gender, p.Category, p.GSTPercent = style.HSNCode, style.Gender, style.Category, style.GSTPercent
+	return nil
+}
+
+// deleteProduct soft-deletes a product, or returns what is holding it with
+// ErrProductInUse.
+func deleteProduct(ctx context.Context, productID int64) ([]ProductBlocker, error) {
+	tx, err := db.DB.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// stock postings lock the product_stock row, not the product, so that row
+	// is locked too: nothing can come in after the on-hand check below
+	_, err = tx.Exec(ctx, `
+		INSERT INTO product_stock (product_id, on_hand)
+		SELECT id, 0 FROM products WHERE id = $1
+		ON CONFLICT (product_id) DO NOTHING
+	`, productID)
+	if err != nil {
+		return nil, fmt.Errorf("init stock row: %w", err)
+	}
+
+	var onHand int
+	err = tx.QueryRow(ctx, `
+		SELECT ps.on_hand
+		FROM products p
+		JOIN product_stock ps ON ps.product_id = p.id
+		WHERE p.id = $1 AND p.deleted_at IS NULL
+		FOR UPDATE OF p, ps
+	`, productID).Scan(&onHand)
+	if err == pgx.ErrNoRows {
+		return nil, ErrProductNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load product: %w", err)
+	}
+
+	blockers := []ProductBlocker{}
+	if onHand != 0 {
+		blockers = append(blockers, ProductBlocker{Type: "stock", OnHand: onHand})
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT 'sales_invoice', si.id, COALESCE(si.invoice_number, '')
+		FROM sales_invoices si
+		JOIN sales_invoice_items sii ON sii.sales_invoice_id = si.id AND sii.deleted_at IS NULL
+		WHERE sii.product_id = $1 AND si.status = 'DRAFT' AND si.deleted_at IS NULL
+		UNION
+		SELECT 'purchase_order', po.id, po.po_number
+		FROM purchase_orders po
+		JOIN purchase_order_items poi ON poi.purchase_order_id = po.id
+		WHERE poi.product_id = $1 AND po.status IN ('DRAFT', 'SENT', 'PARTIAL') AND po.deleted_at IS NULL
+		UNION
+		SELECT 'stock_adjustment', sa.id, sa.adjustment_number
+		FROM stock_adjustments sa
+		JOIN stock_adjustment_items sai ON sai.stock_adjustment_id = sa.id
+		WHERE sai.product_id = $1 AND sa.status = 'PENDING'
+		UNION
+		SELECT 'stock_take', st.id, ''
+		FROM stock_takes st
+		JOIN stock_take_items sti ON sti.stock_take_id = st.id
+		WHERE sti.product_id = $1 AND st.status = 'OPEN'
+		ORDER BY 1, 2
+	`, productID)
+	if err != nil {
+		return nil, fmt.Errorf("load open documents: %w", err)
+	}
+	for rows.Next() {
+		var b ProductBlocker
+		if err := rows.Scan(&b.Type, &b.ID, &b.Number); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		blockers = append(blockers, b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(blockers) > 0 {
+		return blockers, ErrProductInUse
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE products SET deleted_at = NOW(), updated_at = NOW() WHERE id = $1
+	`, productID)
+	if err != nil {
+		return nil, fmt.Errorf("delete product: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("commit tx: %w", err)
+	}
+	return nil, nil
+}
@@ -0,0 +1,610 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"tulsi-pos/db"
+	"tulsi-pos/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/xuri/excelize/v2"
+)
+
+// productFileColumns is the column layout of product imports and exports.
+var productFileColumns = []string{
+	"sku", "name", "barcode", "hsn_code", "gender", "category",
+	"purchase_price", "sales_price", "mrp", "gst_percent", "reorder_point", "reorder_qty",
+}
+
+const (
+	ImportDryRun = "dry_run"
+	ImportCommit = "commit"
+
+	maxImportRows  = 5000
+	maxImportBytes = 10 << 20
+)
+
+var ErrInvalidImportFile = errors.New("invalid import file")
+
+// ImportRowError is one problem found on a row of an import file. Row is the
+// line in the file, counting the header as row 1.
+type ImportRowError struct {
+	Row     int    `json:"row"`
+	SKU     string `json:"sku"`
+	Column  string `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+type ImportReport struct {
+	Mode      string           `json:"mode"`
+	TotalRows int              `json:"total_rows"`
+	ErrorRows int              `json:"error_rows"`
+	Created   int              `json:"created"`
+	Updated   int              `json:"updated"`
+	Committed bool             `json:"committed"`
+	Errors    []ImportRowError `json:"errors"`
+}
+
+// importRow is a data row of an import file, with the product it becomes.
+type importRow struct {
+	line      int
+	record    []string
+	productID int64 // existing product with the row's SKU; 0 when created
+	product   Product
+	errors    []ImportRowError
+}
+
+func (r *importRow) fail(column, message string) {
+	r.errors = append(r.errors, ImportRowError{Row: r.line, SKU: r.product.SKU, Column: column, Message: message})
+}
+
+func (r *importRow) failed(column string) bool {
+	for _, e := range r.errors {
+		if e.Column == column {
+			return true
+		}
+	}
+	return false
+}
+
+// POST /products/import?mode=dry_run|commit (multipart: file)
+// Loads products from a CSV or XLSX file in the export's column layout,
+// creating or updating them by SKU. Columns other than sku may be left out,
+// and blank cells keep the product's current value. Every row is checked
+// first; the file is only written in commit mode and only when no row has an
+// error. A dry run reports what a commit would do.
+func ImportProducts(c *gin.Context) {
+	mode := c.DefaultQuery("mode", ImportDryRun)
+	if mode != ImportDryRun && mode != ImportCommit {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "mode must be dry_run or commit")
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportBytes)
+	fh, err := c.FormFile("file")
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "file is required (CSV or XLSX, up to 10 MB)")
+		return
+	}
+
+	records, err := readImportFile(fh)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	report, err := importProducts(c.Request.Context(), records, mode == ImportCommit, c.GetInt("user_id"))
+	if err != nil {
+		if errors.Is(err, ErrInvalidImportFile) {
+			utils.SendErrorResponse(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if mode == ImportCommit && !report.Committed {
+		utils.SendErrorResponseWithData(c, http.StatusUnprocessableEntity, "import has errors; nothing was saved", report)
+		return
+	}
+
+	message := "Import checked"
+	if report.Committed {
+		message = "Products imported"
+	}
+	utils.SendSuccessResponse(c, http.StatusOK, report, message)
+}
+
+// GET /products/export?format=csv|xlsx
+// The full catalogue in the import's column layout.
+func ExportProducts(c *gin.Context) {
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "xlsx" {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "format must be csv or xlsx")
+		return
+	}
+
+	rows, err := db.DB.Query(c.Request.Context(), `
+		SELECT COALESCE(sku, ''), name, COALESCE(barcode, ''), COALESCE(hsn_code, ''),
+		       COALESCE(gender, ''), COALESCE(category, ''),
+		       COALESCE(purchase_price, 0), COALESCE(sales_price, 0), COALESCE(mrp, 0), COALESCE(gst_percent, 0),
+		       reorder_point, reorder_qty
+		FROM products
+		WHERE deleted_at IS NULL
+		ORDER BY sku, id
+	`)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	records := [][]string{productFileColumns}
+	for rows.Next() {
+		var p Product
+		if err := rows.Scan(&p.SKU, &p.Name, &p.Barcode, &p.HSNCode, &p.Gender, &p.Category,
+			&p.PurchasePrice, &p.SalesPrice, &p.MRP, &p.GSTPercent, &p.ReorderPoint, &p.ReorderQty); err != nil {
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		records = append(records, []string{
+			p.SKU, p.Name, p.Barcode, p.HSNCode, p.Gender, p.Category,
+			strconv.FormatFloat(p.PurchasePrice, 'f', 2, 64),
+			strconv.FormatFloat(p.SalesPrice, 'f', 2, 64),
+			strconv.FormatFloat(p.MRP, 'f', 2, 64),
+			strconv.FormatFloat(p.GSTPercent, 'f', -1, 64),
+			strconv.Itoa(p.ReorderPoint),
+			strconv.Itoa(p.ReorderQty),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	filename := "products-" + time.Now().Format("20060102")
+	if format == "csv" {
+		sendCSV(c, filename+".csv", records)
+		return
+	}
+	sendXLSX(c, filename+".xlsx", "Products", records)
+}
+
+// ---------- Internal Logic ----------
+
+// readImportFile returns the rows of an uploaded CSV or XLSX file; for XLSX
+// the first sheet is read.
+func readImportFile(fh *multipart.FileHeader) ([][]string, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return nil, fmt.Errorf("open upload: %w", err)
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(fh.Filename)) {
+	case ".csv":
+		r := csv.NewReader(f)
+		r.FieldsPerRecord = -1
+		r.TrimLeadingSpace = true
+		records, err := r.ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("read csv: %w", err)
+		}
+		if len(records) > 0 && len(records[0]) > 0 {
+			// spreadsheet programs often save UTF-8 CSV with a byte order mark
+			records[0][0] = strings.TrimPrefix(records[0][0], "\ufeff")
+		}
+		return records, nil
+
+	case ".xlsx":
+		book, err := excelize.OpenReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("read xlsx: %w", err)
+		}
+		defer book.Close()
+		sheets := book.GetSheetList()
+		if len(sheets) == 0 {
+			return nil, errors.New("xlsx has no sheets")
+		}
+		records, err := book.GetRows(sheets[0])
+		if err != nil {
+			return nil, fmt.Errorf("read xlsx: %w", err)
+		}
+		return records, nil
+	}
+	return nil, errors.New("file must be .csv or .xlsx")
+}
+
+// importProducts checks every row of records and, when commit is set and no
+// row failed, writes them all in one transaction.
+func importProducts(ctx context.Context, records [][]string, commit bool, userID int) (ImportReport, error) {
+	report := ImportReport{Mode: ImportDryRun, Errors: []ImportRowError{}}
+	if commit {
+		report.Mode = ImportCommit
+	}
+
+	if len(records) == 0 {
+		return report, fmt.Errorf("%w: file is empty", ErrInvalidImportFile)
+	}
+	columns, err := importColumns(records[0])
+	if err != nil {
+		return report, err
+	}
+
+	var rows []*importRow
+	for i, rec := range records[1:] {
+		if isBlankRecord(rec) {
+			continue
+		}
+		row := &importRow{line: i + 2, record: rec}
+		row.product.SKU = strings.TrimSpace(importCell(rec, columns, "sku"))
+		rows = append(rows, row)
+		if len(rows) > maxImportRows {
+			return report, fmt.Errorf("%w: more than %d rows", ErrInvalidImportFile, maxImportRows)
+		}
+	}
+	if len(rows) == 0 {
+		return report, fmt.Errorf("%w: file has no product rows", ErrInvalidImportFile)
+	}
+	report.TotalRows = len(rows)
+
+	tx, err := db.DB.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return report, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	existing, err := loadImportProducts(ctx, tx, rows)
+	if err != nil {
+		return report, err
+	}
+
+	skuRows := map[string]int{}
+	barcodeRows := map[string]int{}
+	for _, row := range rows {
+		parseImportRow(row, columns, existing)
+		if len(row.errors) > 0 && row.product.SKU == "" {
+			continue
+		}
+		if first, ok := skuRows[row.product.SKU]; ok {
+			row.fail("sku", fmt.Sprintf("sku is repeated from row %d", first))
+		} else if row.product.SKU != "" {
+			skuRows[row.product.SKU] = row.line
+		}
+		if b := row.product.Barcode; b != "" {
+			if first, ok := barcodeRows[b]; ok {
+				row.fail("barcode", fmt.Sprintf("barcode is repeated from row %d", first))
+			} else {
+				barcodeRows[b] = row.line
+			}
+		}
+	}
+
+	if err := checkImportBarcodes(ctx, tx, rows); err != nil {
+		return report, err
+	}
+	for _, row := range rows {
+		if row.failed("sales_price") || row.failed("mrp") || row.failed("hsn_code") || row.failed("gst_percent") {
+			continue
+		}
+		if row.productID != 0 {
+			if err := applyStyleAttributes(ctx, tx, row.productID, &row.product); err != nil {
+				return report, err
+			}
+		}
+		msg, err := mrpError(ctx, tx, row.product)
+		if err != nil {
+			return report, err
+		}
+		if msg != "" {
+			row.fail("sales_price", msg)
+		}
+	}
+
+	for _, row := range rows {
+		if len(row.errors) > 0 {
+			report.ErrorRows++
+			report.Errors = append(report.Errors, row.errors...)
+			continue
+		}
+		if row.productID == 0 {
+			report.Created++
+		} else {
+			report.Updated++
+		}
+	}
+
+	if !commit || report.ErrorRows > 0 {
+		return report, nil
+	}
+
+	for _, row := range rows {
+		if row.productID == 0 {
+			_, err = insertProduct(ctx, tx, row.product, userID)
+		} else {
+			_, err = updateProductTx(ctx, tx, row.productID, row.product, userID)
+		}
+		if err != nil {
+			return report, fmt.Errorf("row %d: %w", row.line, err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return report, fmt.Errorf("commit tx: %w", err)
+	}
+	report.Committed = true
+	return report, nil
+}
+
+// importColumns maps the header row to column positions.
+func importColumns(header []string) (map[string]int, error) {
+	known := map[string]bool{}
+	for _, name := range productFileColumns {
+		known[name] = true
+	}
+
+	columns := map[string]int{}
+	for i, h := range header {
+		name := strings.ToLower(strings.TrimSpace(h))
+		if name == "" {
+			continue
+		}
+		if !known[name] {
+			return nil, fmt.Errorf("%w: unknown column %q", ErrInvalidImportFile, h)
+		}
+		if _, ok := columns[name]; ok {
+			return nil, fmt.Errorf("%w: column %q appears twice", ErrInvalidImportFile, name)
+		}
+		columns[name] = i
+	}
+	if _, ok := columns["sku"]; !ok {
+		return nil, fmt.Errorf("%w: sku column is required", ErrInvalidImportFile)
+	}
+	return columns, nil
+}
+
+func importCell(rec []string, columns map[string]int, name string) string {
+	i, ok := columns[name]
+	if !ok || i >= len(rec) {
+		return ""
+	}
+	return rec[i]
+}
+
+func isBlankRecord(rec []string) bool {
+	for _, v := range rec {
+		if strings.TrimSpace(v) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// existingImportProduct is a product already on file under an imported SKU.
+type existingImportProduct struct {
+	id      int64
+	product Product
+	deleted bool
+}
+
+// loadImportProducts returns the products, deleted ones included, whose SKUs
+// appear in rows, and locks them so a concurrent update is not overwritten.
+func loadImportProducts(ctx context.Context, tx pgx.Tx, rows []*importRow) (map[string]existingImportProduct, error) {
+	skus := make([]string, 0, len(rows))
+	for _, row := range rows {
+		if row.product.SKU != "" {
+			skus = append(skus, row.product.SKU)
+		}
+	}
+
+	dbRows, err := tx.Query(ctx, `
+		SELECT id, sku, name, COALESCE(barcode, ''), COALESCE(hsn_code, ''),
+		       COALESCE(gender, ''), COALESCE(category, ''),
+		       COALESCE(purchase_price, 0), COALESCE(sales_price, 0), COALESCE(mrp, 0), COALESCE(gst_percent, 0),
+		       reorder_point, reorder_qty, deleted_at IS NOT NULL
+		FROM products
+		WHERE sku = ANY($1)
+		ORDER BY id
+		FOR UPDATE
+	`, skus)
+	if err != nil {
+		return nil, fmt.Errorf("load products: %w", err)
+	}
+	defer dbRows.Close()
+
+	existing := map[string]existingImportProduct{}
+	for dbRows.Next() {
+		var e existingImportProduct
+		p := &e.product
+		if err := dbRows.Scan(&e.id, &p.SKU, &p.Name, &p.Barcode, &p.HSNCode, &p.Gender, &p.Category,
+			&p.PurchasePrice, &p.SalesPrice, &p.MRP, &p.GSTPercent, &p.ReorderPoint, &p.ReorderQty, &e.deleted); err != nil {
+			return nil, err
+		}
+		existing[p.SKU] = e
+	}
+	return existing, dbRows.Err()
+}
+
+// parseImportRow fills row.product from the existing product with its SKU
+// and the record's non-blank cells, then validates it. HSN code is required
+// for new products; a stored one is only checked when the file changes it.
+func parseImportRow(row *importRow, columns map[string]int, existing map[string]existingImportProduct) {
+	sku := row.product.SKU
+	if sku == "" {
+		row.fail("sku", "sku is required")
+		return
+	}
+	storedHSN := ""
+	if e, ok := existing[sku]; ok {
+		if e.deleted {
+			row.fail("sku", "sku belongs to a deleted product")
+			return
+		}
+		row.productID = e.id
+		row.product = e.product
+		storedHSN = e.product.HSNCode
+	}
+
+	text := func(name string, dst *string) {
+		if v := strings.TrimSpace(importCell(row.record, columns, name)); v != "" {
+			*dst = v
+		}
+	}
+	number := func(name string, dst *float64) {
+		v := strings.TrimSpace(importCell(row.record, columns, name))
+		if v == "" {
+			return
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			row.fail(name, name+" must be a number")
+			return
+		}
+		*dst = f
+	}
+	integer := func(name string, dst *int) {
+		v := strings.TrimSpace(importCell(row.record, columns, name))
+		if v == "" {
+			return
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			row.fail(name, name+" must be a whole number")
+			return
+		}
+		*dst = n
+	}
+
+	p := &row.product
+	text("name", &p.Name)
+	text("barcode", &p.Barcode)
+	text("hsn_code", &p.HSNCode)
+	text("gender", &p.Gender)
+	text("category", &p.Category)
+	number("purchase_price", &p.PurchasePrice)
+	number("sales_price", &p.SalesPrice)
+	number("mrp", &p.MRP)
+	number("gst_percent", &p.GSTPercent)
+	integer("reorder_point", &p.ReorderPoint)
+	integer("reorder_qty", &p.ReorderQty)
+
+	fieldErrs := validateProduct(p)
+	for _, name := range productFileColumns {
+		if msg, ok := fieldErrs[name]; ok && !row.failed(name) {
+			row.fail(name, msg)
+		}
+	}
+	if p.HSNCode == "" && row.productID == 0 {
+		row.fail("hsn_code", "hsn_code is required")
+	} else if p.HSNCode != storedHSN && !utils.IsValidHSN(p.HSNCode) {
+		row.fail("hsn_code", "hsn_code must be 4, 6 or 8 digits")
+	}
+	if !row.failed("gst_percent") && !utils.IsValidGSTRate(p.GSTPercent) {
+		row.fail("gst_percent", fmt.Sprintf("gst_percent must be one of %v", utils.GSTRates))
+	}
+	if len(p.Barcode) == 13 && !utils.IsValidEAN13(p.Barcode) {
+		row.fail("barcode", "barcode is not a valid EAN-13")
+	}
+}
+
+// checkImportBarcodes fails rows whose barcode belongs to a product with a
+// different SKU.
+func checkImportBarcodes(ctx context.Context, tx pgx.Tx, rows []*importRow) error {
+	bySKU := map[string]*importRow{}
+	var barcodes []string
+	for _, row := range rows {
+		if row.product.Barcode != "" && row.product.SKU != "" {
+			barcodes = append(barcodes, row.product.Barcode)
+			bySKU[row.product.SKU] = row
+		}
+	}
+	if len(barcodes) == 0 {
+		return nil
+	}
+
+	dbRows, err := tx.Query(ctx, `
+		SELECT barcode, COALESCE(sku, ''), name
+		FROM products
+		WHERE barcode = ANY($1) AND deleted_at IS NULL
+	`, barcodes)
+	if err != nil {
+		return fmt.Errorf("check barcodes: %w", err)
+	}
+	defer dbRows.Close()
+
+	type owner struct{ sku, name string }
+	owners := map[string][]owner{}
+	for dbRows.Next() {
+		var barcode string
+		var o owner
+		if err := dbRows.Scan(&barcode, &o.sku, &o.name); err != nil {
+			return err
+		}
+		owners[barcode] = append(owners[barcode], o)
+	}
+	if err := dbRows.Err(); err != nil {
+		return err
+	}
+
+	for sku, row := range bySKU {
+		for _, o := range owners[row.product.Barcode] {
+			if o.sku == sku {
+				continue
+			}
+			if other, ok := bySKU[o.sku]; ok && other.product.Barcode != row.product.Barcode {
+				// the owner gives the barcode up in the same file
+				continue
+			}
+			row.fail("barcode", fmt.Sprintf("barcode is already used by %s (%s)", o.name, o.sku))
+			break
+		}
+	}
+	return nil
+}
+
+// sendXLSX writes records to a single-sheet workbook as an attachment. Cells
+// are written as text so codes like barcodes keep their leading zeros.
+func sendXLSX(c *gin.Context, filename, sheet string, records [][]string) {
+	book := excelize.NewFile()
+	defer book.Close()
+	if err := book.SetSheetName("Sheet1", sheet); err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	for i, rec := range records {
+		cell, err := excelize.CoordinatesToCellName(1, i+1)
+		if err != nil {
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		values := make([]interface{}, len(rec))
+		for j, v := range rec {
+			values[j] = v
+		}
+		if err := book.SetSheetRow(sheet, cell, &values); err != nil {
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := book.Write(&buf); err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, strings.ReplaceAll(filename, `"`, "")))
+	c.Data(http.StatusOK, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", buf.Bytes())
+}
@@ -0,0 +1,291 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"tulsi-pos/config"
+	"tulsi-pos/db"
+	"tulsi-pos/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// How a scanned code was matched to a product.
+const (
+	MatchBarcode     = "barcode"
+	MatchSKU         = "sku"
+	MatchVariantCode = "variant_code" // style code, size and colour
+	MatchScalePrice  = "scale_price"
+	MatchScaleWeight = "scale_weight"
+)
+
+var ErrAmbiguousCode = errors.New("code matches more than one product")
+
+// ProductLookup is what the billing counter needs to add a scanned product
+// to an invoice.
+type ProductLookup struct {
+	ProductID  int64            `json:"product_id"`
+	Name       string           `json:"name"`
+	SKU        string           `json:"sku"`
+	Barcode    string           `json:"barcode"`
+	HSNCode    string           `json:"hsn_code"`
+	Category   string           `json:"category"`
+	StyleID    *int64           `json:"style_id,omitempty"`
+	Size       string           `json:"size,omitempty"`
+	Colour     string           `json:"colour,omitempty"`
+	MatchedBy  string           `json:"matched_by"`
+	MRP        float64          `json:"mrp"`
+	SalesRate  float64          `json:"sales_rate"`  // per unit, before GST
+	GSTPercent float64          `json:"gst_percent"` // tax rule rate on the line's taxable value
+	Available  int              `json:"available"`
+	WeightKg   *float64         `json:"weight_kg,omitempty"`   // from a weight-embedded code
+	ScalePrice *float64         `json:"scale_price,omitempty"` // amount on a price-embedded code, GST included
+	Promotions []Promotion      `json:"promotions"`
+	Promotion  *Promotion       `json:"applied_promotion,omitempty"`
+	Item       InvoiceItemInput `json:"item"` // one unit, best promotion applied as a line discount
+}
+
+// GET /products/lookup?code=
+// Resolves a scanned or typed code: a product barcode first, then a scale
+// barcode, then a SKU, then a variant code (STYLE-SIZE-COLOUR). Codes from
+// a weighing scale become a single unit priced for the weight or amount
+// they carry.
+func LookupProduct(c *gin.Context) {
+	code := strings.TrimSpace(c.Query("code"))
+	if code == "" {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "code is required")
+		return
+	}
+
+	ctx := c.Request.Context()
+	result, err := lookupProduct(ctx, code, time.Now())
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrProductNotFound):
+			utils.SendErrorResponse(c, http.StatusNotFound, err.Error())
+		case errors.Is(err, ErrAmbiguousCode):
+			utils.SendErrorResponse(c, http.StatusConflict, err.Error())
+		default:
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, result, "Product found")
+}
+
+// ---------- Internal Logic ----------
+
+// scaleReading is what a scale-printed EAN-13 carries.
+type scaleReading struct {
+	ProductID int64
+	Kind      string  // config.ScalePrice or config.ScaleWeight
+	Value     float64 // rupees or kilograms
+}
+
+// parseScaleBarcode reads a PP IIIII VVVVV C code whose prefix is set up in
+// config.ScaleBarcodePrefixes.
+func parseScaleBarcode(code string) (scaleReading, bool) {
+	var r scaleReading
+	if !utils.IsValidEAN13(code) {
+		return r, false
+	}
+	kind, ok := config.ScaleBarcodePrefixes[code[:2]]
+	if !ok {
+		return r, false
+	}
+
+	id, _ := strconv.ParseInt(code[2:7], 10, 64)
+	value, _ := strconv.Atoi(code[7:12])
+	if id == 0 || value == 0 {
+		return r, false
+	}
+
+	r.ProductID, r.Kind = id, kind
+	switch kind {
+	case config.ScalePrice:
+		r.Value = float64(value) / 100
+	case config.ScaleWeight:
+		r.Value = float64(value) / 1000
+	}
+	return r, true
+}
+
+func lookupProduct(ctx context.Context, code string, at time.Time) (ProductLookup, error) {
+	var res ProductLookup
+
+	productID, matchedBy, err := resolveLookupCode(ctx, code)
+	if err != nil {
+		return res, err
+	}
+	scale, isScale := scaleReading{}, false
+	if matchedBy == MatchScalePrice || matchedBy == MatchScaleWeight {
+		scale, isScale = parseScaleBarcode(code)
+	}
+
+	var salesPrice float64
+	err = db.DB.QueryRow(ctx, `
+		SELECT p.id, p.name, COALESCE(p.sku, ''), COALESCE(p.barcode, ''), COALESCE(p.hsn_code, ''),
+		       COALESCE(p.category, ''), p.style_id, COALESCE(p.size, ''), COALESCE(p.colour, ''),
+		       COALESCE(p.sales_price, 0), COALESCE(p.mrp, 0), COALESCE(ps.on_hand, 0)
+		FROM products p
+		LEFT JOIN product_stock ps ON ps.product_id = p.id
+		WHERE p.id = $1 AND p.deleted_at IS NULL
+	`, productID).Scan(&res.ProductID, &res.Name, &res.SKU, &res.Barcode, &res.HSNCode,
+		&res.Category, &res.StyleID, &res.Size, &res.Colour, &salesPrice, &res.MRP, &res.Available)
+	if err != nil {
+		return res, fmt.Errorf("load product: %w", err)
+	}
+	res.MatchedBy = matchedBy
+	res.SalesRate = salesPrice
+
+	if isScale {
+		switch scale.Kind {
+		case config.ScaleWeight:
+			// the product is priced per kilogram
+			res.WeightKg = &scale.Value
+			res.SalesRate = round2(salesPrice * scale.Value)
+			res.MRP = round2(res.MRP * scale.Value)
+		case config.ScalePrice:
+			res.ScalePrice = &scale.Value
+			res.SalesRate, err = rateBeforeGST(ctx, productID, scale.Value, at)
+			if err != nil {
+				return res, err
+			}
+			res.MRP = 0
+		}
+	}
+
+	res.Promotions, err = activePromotions(ctx, db.DB, productID, at)
+	if err != nil {
+		return res, err
+	}
+
+	res.Item = InvoiceItemInput{
+		ProductID: productID,
+		Quantity:  1,
+		MRP:       res.MRP,
+		SalesRate: res.SalesRate,
+	}
+	if best := bestPromotion(res.Promotions, res.SalesRate, res.Item.Quantity); best != nil {
+		// an INR amount on the line covers the whole line, not each unit; a
+		// client changing the quantity re-derives it from applied_promotion
+		res.Promotion = best
+		res.Item.DiscountType, res.Item.DiscountValue = promotionLineDiscount(*best, res.Item.Quantity)
+	}
+
+	line := computeInvoiceLine(res.Item)
+	res.GSTPercent, err = productGSTPercent(ctx, db.DB, productID, line.Taxable, at)
+	if err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+// resolveLookupCode finds the product a code refers to and how it matched.
+func resolveLookupCode(ctx context.Context, code string) (int64, string, error) {
+	id, err := uniqueProductID(ctx, `
+		SELECT id FROM products WHERE barcode = $1 AND deleted_at IS NULL LIMIT 2
+	`, code)
+	if err == nil || !errors.Is(err, ErrProductNotFound) {
+		return id, MatchBarcode, err
+	}
+
+	if scale, ok := parseScaleBarcode(code); ok {
+		var exists bool
+		err := db.DB.QueryRow(ctx, `
+			SELECT EXISTS (SELECT 1 FROM products WHERE id = $1 AND deleted_at IS NULL)
+		`, scale.ProductID).Scan(&exists)
+		if err != nil {
+			return 0, "", fmt.Errorf("load product: %w", err)
+		}
+		if !exists {
+			return 0, "", fmt.Errorf("%w: scale code %s is for product %d", ErrProductNotFound, code, scale.ProductID)
+		}
+		if scale.Kind == config.ScaleWeight {
+			return scale.ProductID, MatchScaleWeight, nil
+		}
+		return scale.ProductID, MatchScalePrice, nil
+	}
+
+	id, err = uniqueProductID(ctx, `
+		SELECT id FROM products WHERE LOWER(sku) = LOWER($1) AND deleted_at IS NULL LIMIT 2
+	`, code)
+	if err == nil || !errors.Is(err, ErrProductNotFound) {
+		return id, MatchSKU, err
+	}
+
+	// variant SKUs can be edited, so match on what they were built from
+	id, err = uniqueProductID(ctx, `
+		SELECT p.id
+		FROM products p
+		JOIN product_styles s ON s.id = p.style_id
+		WHERE p.deleted_at IS NULL AND s.deleted_at IS NULL
+		  AND UPPER(CONCAT_WS('-', s.style_code,
+		        NULLIF(REGEXP_REPLACE(p.size, '\s', '', 'g'), ''),
+		        NULLIF(REGEXP_REPLACE(p.colour, '\s', '', 'g'), ''))) = UPPER($1)
+		LIMIT 2
+	`, code)
+	if err != nil {
+		if errors.Is(err, ErrProductNotFound) {
+			return 0, "", fmt.Errorf("%w: no product for code %s", ErrProductNotFound, code)
+		}
+		return 0, "", err
+	}
+	return id, MatchVariantCode, nil
+}
+
+// uniqueProductID runs a query for product ids and expects exactly one.
+func uniqueProductID(ctx context.Context, query, code string) (int64, error) {
+	rows, err := db.DB.Query(ctx, query, code)
+	if err != nil {
+		return 0, fmt.Errorf("look up code: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	switch len(ids) {
+	case 0:
+		return 0, ErrProductNotFound
+	case 1:
+		return ids[0], nil
+	}
+	return 0, fmt.Errorf("%w: %s", ErrAmbiguousCode, code)
+}
+
+// rateBeforeGST takes the GST out of an amount that includes it. The rate
+// can depend on the value before tax, so it is resolved on the first
+// estimate and, if that lands in another slab, once more.
+func rateBeforeGST(ctx context.Context, productID int64, inclusive float64, at time.Time) (float64, error) {
+	rate, err := productGSTPercent(ctx, db.DB, productID, inclusive, at)
+	if err != nil {
+		return 0, err
+	}
+	value := round2(inclusive / (1 + rate/100))
+
+	again, err := productGSTPercent(ctx, db.DB, productID, value, at)
+	if err != nil {
+		return 0, err
+	}
+	if again != rate {
+		value = round2(inclusive / (1 + again/100))
+	}
+	return value, nil
+}
@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"tulsi-pos/db"
+	"tulsi-pos/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Promotion struct {
+	ID            int64   `json:"id"`
+	Name          string  `json:"name" binding:"required"`
+	DiscountType  string  `json:"discount_type" binding:"required"` // INR (per unit) or %
+	DiscountValue float64 `json:"discount_value" binding:"gt=0"`
+	ProductID     *int64  `json:"product_id"`
+	StyleID       *int64  `json:"style_id"`
+	Category      string  `json:"category"`
+	MinQuantity   int     `json:"min_quantity" binding:"min=0"`
+	EffectiveFrom string  `json:"effective_from" binding:"required"` // YYYY-MM-DD
+	EffectiveTo   *string `json:"effective_to"`
+}
+
+const promotionColumns = `pr.id, pr.name, pr.discount_type, pr.discount_value, pr.product_id, pr.style_id,
+	COALESCE(pr.category, ''), pr.min_quantity, pr.effective_from, pr.effective_to`
+
+// POST /promotions
+// A promotion covers one product, one style, one category, or the whole
+// store when none is given.
+func CreatePromotion(c *gin.Context) {
+	var p Promotion
+	if err := c.ShouldBindJSON(&p); err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid input")
+		return
+	}
+
+	p.Name = strings.TrimSpace(p.Name)
+	p.Category = strings.TrimSpace(p.Category)
+	p.DiscountType = strings.ToUpper(strings.TrimSpace(p.DiscountType))
+	if p.MinQuantity == 0 {
+		p.MinQuantity = 1
+	}
+
+	fieldErrs := map[string]string{}
+	switch p.DiscountType {
+	case "INR":
+	case "%":
+		if p.DiscountValue > 100 {
+			fieldErrs["discount_value"] = "a percentage discount cannot exceed 100"
+		}
+	default:
+		fieldErrs["discount_type"] = "discount_type must be INR or %"
+	}
+	scopes := 0
+	for _, set := range []bool{p.ProductID != nil, p.StyleID != nil, p.Category != ""} {
+		if set {
+			scopes++
+		}
+	}
+	if scopes > 1 {
+		fieldErrs["scope"] = "set at most one of product_id, style_id and category"
+	}
+	from, err := time.Parse(utils.DateFormat, p.EffectiveFrom)
+	if err != nil {
+		fieldErrs["effective_from"] = "effective_from must be YYYY-MM-DD"
+	}
+	if p.EffectiveTo != nil {
+		to, err := time.Parse(utils.DateFormat, *p.EffectiveTo)
+		if err != nil || to.Before(from) {
+			fieldErrs["effective_to"] = "effective_to must be a YYYY-MM-DD date on or after effective_from"
+		}
+	}
+	if len(fieldErrs) > 0 {
+		utils.SendErrorResponseWithData(c, http.StatusBadRequest, "invalid promotion", fieldErrs)
+		return
+	}
+
+	var id int64
+	err = db.DB.QueryRow(c.Request.Context(), `
+		INSERT INTO promotions
+		(name, discount_type, discount_value, product_id, style_id, category, min_quantity,
+		 effective_from, effective_to, created_by)
+		VALUES ($1,$2,$3,$4,$5,NULLIF($6, ''),$7,$8,$9,$10)
+		RETURNING id
+	`, p.Name, p.DiscountType, p.DiscountValue, p.ProductID, p.StyleID, p.Category, p.MinQuantity,
+		p.EffectiveFrom, p.EffectiveTo, nullableUserID(c.GetInt("user_id"))).Scan(&id)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SendSuccessResponse(c, http.StatusCreated, gin.H{"id": id}, "promotion created")
+}
+
+// GET /promotions?active=true
+func ListPromotions(c *gin.Context) {
+	where := "WHERE pr.deleted_at IS NULL"
+	if active, _ := strconv.ParseBool(c.Query("active")); active {
+		where += " AND pr.effective_from <= CURRENT_DATE AND (pr.effective_to IS NULL OR pr.effective_to >= CURRENT_DATE)"
+	}
+
+	rows, err := db.DB.Query(c.Request.Context(), `
+		SELECT `+promotionColumns+`
+		FROM promotions pr
+		`+where+`
+		ORDER BY pr.effective_from DESC, pr.id DESC
+	`)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	promotions, err := scanPromotions(rows)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, promotions, "Promotions fetched successfully")
+}
+
+// DELETE /promotions/:id
+func DeletePromotion(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	res, err := db.DB.Exec(c.Request.Context(), `
+		UPDATE promotions
+		SET deleted_at = NOW()
+		WHERE id = $1 AND deleted_at IS NULL
+	`, id)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if res.RowsAffected() == 0 {
+		utils.SendErrorResponse(c, http.StatusNotFound, "promotion not found")
+		return
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, nil, "promotion deleted")
+}
+
+// ---------- Internal Logic ----------
+
+type promotionRows interface {
+	Next() bool
+	Scan(dest ...interface{}) error
+	Err() error
+}
+
+func scanPromotions(rows promotionRows) ([]Promotion, error) {
+	promotions := []Promotion{}
+	for rows.Next() {
+		var p Promotion
+		var from time.Time
+		var to *time.Time
+		if err := rows.Scan(&p.ID, &p.Name, &p.DiscountType, &p.DiscountValue, &p.ProductID,
+			&p.StyleID, &p.Category, &p.MinQuantity, &from, &to); err != nil {
+			return nil, err
+		}
+		p.EffectiveFrom = utils.FormatDate(from)
+		if to != nil {
+			s := utils.FormatDate(*to)
+			p.EffectiveTo = &s
+		}
+		promotions = append(promotions, p)
+	}
+	return promotions, rows.Err()
+}
+
+// activePromotions returns the promotions running on the given day that
+// cover a product, directly or through its style or category.
+func activePromotions(ctx context.Context, q db.Querier, productID int64, at time.Time) ([]Promotion, error) {
+	rows, err := q.Query(ctx, `
+		SELECT `+promotionColumns+`
+		FROM promotions pr
+		JOIN products p ON p.id = $1
+		WHERE pr.deleted_at IS NULL
+		  AND pr.effective_from <= $2
+		  AND (pr.effective_to IS NULL OR pr.effective_to >= $2)
+		  AND (pr.product_id = p.id
+		       OR pr.style_id = p.style_id
+		       OR pr.category = p.category
+		       OR (pr.product_id IS NULL AND pr.style_id IS NULL AND pr.category IS NULL))
+		ORDER BY pr.id
+	`, productID, at.Format(utils.DateFormat))
+	if err != nil {
+		return nil, fmt.Errorf("load promotions: %w", err)
+	}
+	defer rows.Close()
+	return scanPromotions(rows)
+}
+
+// bestPromotion picks the promotion worth most on quantity units at rate,
+// among those the quantity qualifies for.
+func bestPromotion(promotions []Promotion, rate float64, quantity int) *Promotion {
+	var best *Promotion
+	bestAmount := 0.0
+	for i := range promotions {
+		p := &promotions[i]
+		if quantity < p.MinQuantity {
+			continue
+		}
+		if amount := promotionDiscount(*p, rate, quantity); amount > bestAmount {
+			best, bestAmount = p, amount
+		}
+	}
+	return best
+}
+
+// promotionLineDiscount turns a promotion into the discount type and value of
+// an invoice line of quantity units. A line's INR discount is one amount for
+// the whole line, so a flat promotion's per-unit amount is multiplied out.
+func promotionLineDiscount(p Promotion, quantity int) (string, float64) {
+	if p.DiscountType == "INR" {
+		return "INR", round2(p.DiscountValue * float64(quantity))
+	}
+	return p.DiscountType, p.DiscountValue
+}
+
+// promotionDiscount is what a promotion takes off quantity units at rate.
+// Flat promotions are per unit; the discount never exceeds the gross.
+func promotionDiscount(p Promotion, rate float64, quantity int) float64 {
+	gross := rate * float64(quantity)
+	value := p.DiscountValue
+	if p.DiscountType == "INR" {
+		value *= float64(quantity)
+	}
+	return min(discountAmount(gross, p.DiscountType, value), gross)
+}
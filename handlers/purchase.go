@@ -2,13 +2,22 @@ package handlers
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"tulsi-pos/config"
 	"tulsi-pos/db"
 	"tulsi-pos/utils"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
 type PurchaseItem struct {
@@ -16,143 +25,494 @@ type PurchaseItem struct {
 	Quantity      int     `json:"quantity"`
 	PurchasePrice float64 `json:"purchase_price"`
 	GSTPercent    float64 `json:"gst_percent"`
+	DiscountType  string  `json:"discount_type"`  // "INR" or "%"
+	DiscountValue float64 `json:"discount_value"` // flat or percent
+}
+
+// PurchaseCharge is a cost billed on the invoice outside the goods lines
+// (freight, packing). It is not taxed and is spread over the lines into
+// their landed cost.
+type PurchaseCharge struct {
+	Name   string  `json:"name"`
+	Amount float64 `json:"amount"`
+	Basis  string  `json:"basis"` // VALUE (default) or QUANTITY
 }
 
 type PurchaseRequest struct {
-	SupplierID  int            `json:"supplier_id"`
-	InvoiceNo   string         `json:"invoice_number"`
-	Notes       string         `json:"notes"`
-	Items       []PurchaseItem `json:"items"`
-	CreatedByID int            `json:"created_by"` // user id
+	SupplierID  int              `json:"supplier_id"`
+	InvoiceNo   string           `json:"invoice_number"`
+	InvoiceDate string           `json:"invoice_date"` // YYYY-MM-DD, defaults to today
+	Notes       string           `json:"notes"`
+	Items       []PurchaseItem   `json:"items"`
+	CreatedByID int              `json:"created_by"` // user id
+	Charges     []PurchaseCharge `json:"charges"`
+
+	// Bill-level discount, applied after line discounts and shared across
+	// lines by value before GST.
+	DiscountType  string  `json:"discount_type"`  // "INR" or "%"
+	DiscountValue float64 `json:"discount_value"` // flat or percent
+
+	// PurchaseOrderID bills goods already received on GRNs against this PO;
+	// stock is not posted again and the invoice is matched to the PO instead.
+	PurchaseOrderID int64 `json:"purchase_order_id"`
+}
+
+// Bases for spreading a charge across purchase lines.
+const (
+	ChargeByValue    = "VALUE"
+	ChargeByQuantity = "QUANTITY"
+)
+
+type CancelPurchaseInput struct {
+	Reason string `json:"reason"`
 }
 
+// Purchase invoice statuses. Cancelled invoices also get deleted_at so every
+// report that skips deleted rows leaves them out.
+const (
+	PurchasePosted    = "POSTED"
+	PurchaseCancelled = "CANCELLED"
+)
+
+var (
+	ErrInvalidPurchase          = errors.New("invalid purchase")
+	ErrDuplicatePurchaseInvoice = errors.New("invoice number already entered for this supplier")
+	ErrPurchaseCancelled        = errors.New("purchase invoice is cancelled")
+	ErrPurchaseHasReturns       = errors.New("purchase invoice has returns against it")
+	ErrPurchasePaid             = errors.New("purchase invoice has payments allocated")
+	ErrPurchaseSupplierChange   = errors.New("supplier cannot be changed; cancel the invoice and enter it again")
+	ErrPurchaseOrderSupplier    = errors.New("purchase order belongs to a different supplier")
+)
+
+// purchaseResult is what saving a purchase invoice reports back.
+type purchaseResult struct {
+	ID              int64
+	TotalAmount     float64
+	TotalDiscount   float64
+	TotalCharges    float64
+	TotalQuantity   int
+	DueDate         time.Time
+	MatchStatus     string
+	MatchExceptions []MatchException
+	StockWarnings   []StockShortage
+}
+
+func (r purchaseResult) response() gin.H {
+	resp := gin.H{
+		"purchase_id":    r.ID,
+		"total_amount":   r.TotalAmount,
+		"total_discount": r.TotalDiscount,
+		"total_charges":  r.TotalCharges,
+		"total_quantity": r.TotalQuantity,
+		"due_date":       utils.FormatDate(r.DueDate),
+	}
+	if r.MatchStatus != "" {
+		resp["match_status"] = r.MatchStatus
+		resp["match_exceptions"] = r.MatchExceptions
+	}
+	if len(r.StockWarnings) > 0 {
+		resp["stock_warnings"] = r.StockWarnings
+	}
+	return resp
+}
+
+// POST /purchases
 func CreatePurchase(c *gin.Context) {
 	var req PurchaseRequest
-	if err := c.BindJSON(&req); err != nil {
+	if err := c.ShouldBindJSON(&req); err != nil {
 		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid JSON")
 		return
 	}
 
-	if len(req.Items) == 0 {
-		utils.SendErrorResponse(c, http.StatusBadRequest, "at least one item is required")
+	invoiceDate, err := normalizePurchaseRequest(&req)
+	if err != nil {
+		sendPurchaseError(c, err)
 		return
 	}
 
-	ctx := context.Background()
-	tx, err := db.DB.Begin(ctx)
+	userID := c.GetInt("user_id")
+	if userID == 0 {
+		userID = req.CreatedByID
+	}
+
+	ctx := c.Request.Context()
+	tx, err := db.DB.BeginTx(ctx, pgx.TxOptions{})
 	if err != nil {
 		utils.SendErrorResponse(c, http.StatusInternalServerError, "failed to start tx")
 		return
 	}
 	defer tx.Rollback(ctx)
 
-	var totalAmountBeforeDiscount float64
-	var totalGST float64
-	var totalAmount float64
-	var totalQty int
+	res, err := savePurchase(ctx, tx, 0, req, invoiceDate, userID)
+	if err != nil {
+		sendPurchaseError(c, err)
+		return
+	}
 
-	// calculate totals
-	for _, item := range req.Items {
-		lineBase := float64(item.Quantity) * item.PurchasePrice
-		gstAmt := lineBase * item.GSTPercent / 100.0
-		lineTotal := lineBase + gstAmt
+	if err := tx.Commit(ctx); err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "failed to commit tx")
+		return
+	}
 
-		totalAmountBeforeDiscount += lineBase
-		totalGST += gstAmt
-		totalAmount += lineTotal
-		totalQty += item.Quantity
+	utils.SendSuccessResponse(c, http.StatusCreated, res.response(), "Purchase recorded")
+}
+
+// PUT /purchases/:id
+// Replaces the header and lines of a purchase invoice. The old lines' stock
+// is reversed and the new lines posted, so the ledger shows both sides. The
+// supplier and purchase order link cannot change.
+func UpdatePurchase(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid purchase id")
+		return
 	}
 
-	// insert into purchase_invoices
-	var purchaseID int64
-	err = tx.QueryRow(ctx, `
-		INSERT INTO purchase_invoices
-		(invoice_number, supplier_id, total_amount_before_discount, discount_amount,
-		 total_gst, total_invoice_amount, total_items, total_quantity, notes, created_by)
-		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)
-		RETURNING id
-	`,
-		req.InvoiceNo,
-		req.SupplierID,
-		totalAmountBeforeDiscount,
-		0, // discount_amount for now
-		totalGST,
-		totalAmount,
-		len(req.Items),
-		totalQty,
-		req.Notes,
-		req.CreatedByID,
-	).Scan(&purchaseID)
+	var req PurchaseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid JSON")
+		return
+	}
 
+	invoiceDate, err := normalizePurchaseRequest(&req)
 	if err != nil {
-		utils.SendErrorResponse(c, http.StatusInternalServerError, "failed to insert purchase header: "+err.Error())
+		sendPurchaseError(c, err)
 		return
 	}
 
-	// insert items + inventory_transactions
-	for _, item := range req.Items {
-		lineBase := float64(item.Quantity) * item.PurchasePrice
-		gstAmt := lineBase * item.GSTPercent / 100.0
-		lineTotal := lineBase + gstAmt
+	res, err := updatePurchase(c.Request.Context(), id, req, invoiceDate, c.GetInt("user_id"))
+	if err != nil {
+		sendPurchaseError(c, err)
+		return
+	}
 
-		_, err = tx.Exec(ctx, `
-			INSERT INTO purchase_invoice_items
-			(purchase_invoice_id, product_id, quantity, purchase_price,
-			 gst_percent, gst_amount, line_total)
-			VALUES ($1,$2,$3,$4,$5,$6,$7)
-		`,
-			purchaseID,
-			item.ProductID,
-			item.Quantity,
-			item.PurchasePrice,
-			item.GSTPercent,
-			gstAmt,
-			lineTotal,
-		)
-		if err != nil {
-			utils.SendErrorResponse(c, http.StatusInternalServerError, "failed to insert purchase item: "+err.Error())
+	utils.SendSuccessResponse(c, http.StatusOK, res.response(), "Purchase updated")
+}
+
+// POST /purchases/:id/cancel
+// Takes the invoice's stock back out (unless it came in on GRNs) and drops it
+// from payables and input tax. Invoices with returns or payments against them
+// must have those reversed first.
+func CancelPurchase(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid purchase id")
+		return
+	}
+
+	var in CancelPurchaseInput
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&in); err != nil {
+			utils.SendErrorResponse(c, http.StatusBadRequest, "invalid payload: "+err.Error())
 			return
 		}
+	}
 
-		// inventory + stock (positive quantity)
-		_, err = tx.Exec(ctx, `
-			INSERT INTO inventory_transactions
-			(product_id, quantity, ref_type, ref_id, created_by)
-			VALUES ($1,$2,$3,$4,$5)
-		`,
-			item.ProductID,
-			item.Quantity,
-			"purchase",
-			purchaseID,
-			req.CreatedByID,
-		)
-		if err != nil {
-			utils.SendErrorResponse(c, http.StatusInternalServerError, "failed to insert inventory tx: "+err.Error())
+	warnings, err := cancelPurchase(c.Request.Context(), id, strings.TrimSpace(in.Reason), c.GetInt("user_id"))
+	if err != nil {
+		sendPurchaseError(c, err)
+		return
+	}
+
+	resp := gin.H{"purchase_id": id, "status": PurchaseCancelled}
+	if len(warnings) > 0 {
+		resp["stock_warnings"] = warnings
+	}
+	utils.SendSuccessResponse(c, http.StatusOK, resp, "Purchase cancelled")
+}
+
+// GET /purchases/:id
+func GetPurchaseByID(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid purchase id")
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	var header struct {
+		ID                        int64   `json:"id"`
+		InvoiceNumber             string  `json:"invoice_number"`
+		InvoiceDate               string  `json:"invoice_date"`
+		DueDate                   string  `json:"due_date"`
+		SupplierID                int64   `json:"supplier_id"`
+		SupplierName              string  `json:"supplier_name"`
+		SupplierGSTIN             string  `json:"supplier_gstin"`
+		SupplierStateCode         string  `json:"supplier_state_code"`
+		Status                    string  `json:"status"`
+		TotalAmountBeforeDiscount float64 `json:"total_amount_before_discount"`
+		DiscountType              string  `json:"discount_type"`
+		DiscountValue             float64 `json:"discount_value"`
+		DiscountAmount            float64 `json:"discount_amount"`
+		TaxableAmount             float64 `json:"taxable_amount"`
+		TotalGST                  float64 `json:"total_gst"`
+		TotalCGST                 float64 `json:"total_cgst"`
+		TotalSGST                 float64 `json:"total_sgst"`
+		TotalIGST                 float64 `json:"total_igst"`
+		TotalCharges              float64 `json:"total_charges"`
+		TotalInvoiceAmount        float64 `json:"total_invoice_amount"`
+		TotalItems                int     `json:"total_items"`
+		TotalQuantity             int     `json:"total_quantity"`
+		AmountPaid                float64 `json:"amount_paid"`
+		Returned                  float64 `json:"returned"`
+		Balance                   float64 `json:"balance"`
+		PurchaseOrderID           *int64  `json:"purchase_order_id"`
+		MatchStatus               string  `json:"match_status"`
+		Notes                     string  `json:"notes"`
+		CancelReason              string  `json:"cancel_reason,omitempty"`
+		CreatedAt                 string  `json:"created_at"`
+		CancelledAt               string  `json:"cancelled_at,omitempty"`
+	}
+
+	var (
+		invoiceDate, dueDate *time.Time
+		createdAt            time.Time
+		cancelledAt          *time.Time
+	)
+	err = db.DB.QueryRow(ctx, `
+		SELECT pi.id, pi.invoice_number, pi.invoice_date, pi.due_date,
+		       pi.supplier_id, s.name, COALESCE(s.gstin, ''), COALESCE(pi.supplier_state_code, ''),
+		       pi.status, COALESCE(pi.total_amount_before_discount, 0),
+		       COALESCE(pi.discount_type, 'INR'), COALESCE(pi.discount_value, 0), COALESCE(pi.discount_amount, 0),
+		       COALESCE(pi.taxable_amount, pi.total_amount_before_discount, 0),
+		       COALESCE(pi.total_gst, 0), COALESCE(pi.total_cgst, 0), COALESCE(pi.total_sgst, 0),
+		       COALESCE(pi.total_igst, 0), pi.total_charges, COALESCE(pi.total_invoice_amount, 0),
+		       COALESCE(pi.total_items, 0), COALESCE(pi.total_quantity, 0), pi.amount_paid,
+		       COALESCE((SELECT SUM(pr.total_amount) FROM purchase_returns pr WHERE pr.purchase_invoice_id = pi.id), 0),
+		       pi.purchase_order_id, COALESCE(pi.match_status, ''), COALESCE(pi.notes, ''),
+		       COALESCE(pi.cancel_reason, ''), pi.created_at, pi.cancelled_at
+		FROM purchase_invoices pi
+		JOIN suppliers s ON s.id = pi.supplier_id
+		WHERE pi.id = $1
+	`, id).Scan(
+		&header.ID, &header.InvoiceNumber, &invoiceDate, &dueDate,
+		&header.SupplierID, &header.SupplierName, &header.SupplierGSTIN, &header.SupplierStateCode,
+		&header.Status, &header.TotalAmountBeforeDiscount,
+		&header.DiscountType, &header.DiscountValue, &header.DiscountAmount,
+		&header.TaxableAmount,
+		&header.TotalGST, &header.TotalCGST, &header.TotalSGST,
+		&header.TotalIGST, &header.TotalCharges, &header.TotalInvoiceAmount,
+		&header.TotalItems, &header.TotalQuantity, &header.AmountPaid,
+		&header.Returned,
+		&header.PurchaseOrderID, &header.MatchStatus, &header.Notes,
+		&header.CancelReason, &createdAt, &cancelledAt,
+	)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			utils.SendErrorResponse(c, http.StatusNotFound, "purchase invoice not found")
 			return
 		}
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
 	}
+	if invoiceDate != nil {
+		header.InvoiceDate = utils.FormatDate(*invoiceDate)
+	}
+	if dueDate != nil {
+		header.DueDate = utils.FormatDate(*dueDate)
+	}
+	if cancelledAt != nil {
+		header.CancelledAt = utils.FormatDateTime(*cancelledAt)
+	}
+	header.CreatedAt = utils.FormatDateTime(createdAt)
+	header.Balance = round2(header.TotalInvoiceAmount - header.AmountPaid - header.Returned)
 
-	if err := tx.Commit(ctx); err != nil {
-		utils.SendErrorResponse(c, http.StatusInternalServerError, "failed to commit tx")
+	rows, err := db.DB.Query(ctx, `
+		SELECT pii.id, pii.product_id, p.name, COALESCE(p.sku, ''), COALESCE(p.hsn_code, ''),
+		       pii.quantity, pii.purchase_price, pii.discount_amount,
+		       COALESCE(pii.taxable_amount, pii.line_total - pii.gst_amount),
+		       pii.gst_percent, pii.gst_amount,
+		       COALESCE(pii.cgst_amount, 0), COALESCE(pii.sgst_amount, 0),
+		       COALESCE(pii.igst_amount, 0), pii.line_total, pii.charges_amount,
+		       COALESCE(pii.landed_unit_cost, pii.purchase_price),
+		       COALESCE((SELECT SUM(pri.quantity) FROM purchase_return_items pri
+		                 WHERE pri.purchase_invoice_item_id = pii.id), 0)
+		FROM purchase_invoice_items pii
+		JOIN products p ON p.id = pii.product_id
+		WHERE pii.purchase_invoice_id = $1
+		ORDER BY pii.id
+	`, id)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	items := []gin.H{}
+	for rows.Next() {
+		var it struct {
+			ID            int64
+			ProductID     int64
+			ProductName   string
+			SKU           string
+			HSNCode       string
+			Quantity      int
+			PurchasePrice float64
+			Discount      float64
+			Taxable       float64
+			GSTPercent    float64
+			GSTAmount     float64
+			CGSTAmount    float64
+			SGSTAmount    float64
+			IGSTAmount    float64
+			LineTotal     float64
+			Charges       float64
+			LandedCost    float64
+			ReturnedQty   int
+		}
+		if err := rows.Scan(&it.ID, &it.ProductID, &it.ProductName, &it.SKU, &it.HSNCode,
+			&it.Quantity, &it.PurchasePrice, &it.Discount, &it.Taxable, &it.GSTPercent, &it.GSTAmount,
+			&it.CGSTAmount, &it.SGSTAmount, &it.IGSTAmount, &it.LineTotal, &it.Charges, &it.LandedCost,
+			&it.ReturnedQty); err != nil {
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		items = append(items, gin.H{
+			"id":               it.ID,
+			"product_id":       it.ProductID,
+			"product_name":     it.ProductName,
+			"sku":              it.SKU,
+			"hsn_code":         it.HSNCode,
+			"quantity":         it.Quantity,
+			"returned_qty":     it.ReturnedQty,
+			"purchase_price":   it.PurchasePrice,
+			"discount_amount":  it.Discount,
+			"taxable_amount":   it.Taxable,
+			"gst_percent":      it.GSTPercent,
+			"gst_amount":       it.GSTAmount,
+			"cgst_amount":      it.CGSTAmount,
+			"sgst_amount":      it.SGSTAmount,
+			"igst_amount":      it.IGSTAmount,
+			"line_total":       it.LineTotal,
+			"charges_amount":   it.Charges,
+			"landed_unit_cost": it.LandedCost,
+		})
+	}
+	rows.Close()
+
+	chargeRows, err := db.DB.Query(ctx, `
+		SELECT name, amount, basis
+		FROM purchase_invoice_charges
+		WHERE purchase_invoice_id = $1
+		ORDER BY id
+	`, id)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
 		return
 	}
+	defer chargeRows.Close()
 
-	utils.SendSuccessResponse(c, http.StatusCreated, gin.H{
-		"purchase_id":    purchaseID,
-		"total_amount":   totalAmount,
-		"total_quantity": totalQty,
-	}, "Purchase recorded")
+	charges := []PurchaseCharge{}
+	for chargeRows.Next() {
+		var ch PurchaseCharge
+		if err := chargeRows.Scan(&ch.Name, &ch.Amount, &ch.Basis); err != nil {
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		charges = append(charges, ch)
+	}
+	chargeRows.Close()
+
+	resp := gin.H{
+		"purchase": header,
+		"items":    items,
+		"charges":  charges,
+	}
+	if header.PurchaseOrderID != nil {
+		exceptions, err := loadMatchExceptions(ctx, db.DB, id)
+		if err != nil {
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		resp["match_exceptions"] = exceptions
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, resp, "Purchase details fetched successfully")
 }
 
+// GET /purchases?supplier_id=&from=&to=&min_amount=&max_amount=&invoice_number=&include_cancelled=&page=&limit=
+// from/to filter on the supplier's invoice date.
 func ListPurchases(c *gin.Context) {
 	ctx := c.Request.Context()
 
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 200 {
+		limit = 20
+	}
+	offset := (page - 1) * limit
+
+	where := "WHERE 1=1"
+	params := []interface{}{}
+	add := func(cond string, v interface{}) {
+		params = append(params, v)
+		where += fmt.Sprintf(" AND "+cond, len(params))
+	}
+
+	if c.Query("include_cancelled") != "true" {
+		where += " AND pi.deleted_at IS NULL"
+	}
+	if v := c.Query("supplier_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			utils.SendErrorResponse(c, http.StatusBadRequest, "invalid supplier_id")
+			return
+		}
+		add("pi.supplier_id = $%d", id)
+	}
+	for _, f := range []struct{ key, cond string }{
+		{"from", "COALESCE(pi.invoice_date, pi.created_at::date) >= $%d::date"},
+		{"to", "COALESCE(pi.invoice_date, pi.created_at::date) <= $%d::date"},
+	} {
+		if v := c.Query(f.key); v != "" {
+			if _, err := time.Parse(utils.DateFormat, v); err != nil {
+				utils.SendErrorResponse(c, http.StatusBadRequest, f.key+" must be YYYY-MM-DD")
+				return
+			}
+			add(f.cond, v)
+		}
+	}
+	for _, f := range []struct{ key, cond string }{
+		{"min_amount", "pi.total_invoice_amount >= $%d"},
+		{"max_amount", "pi.total_invoice_amount <= $%d"},
+	} {
+		if v := c.Query(f.key); v != "" {
+			amt, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				utils.SendErrorResponse(c, http.StatusBadRequest, "invalid "+f.key)
+				return
+			}
+			add(f.cond, amt)
+		}
+	}
+	if v := strings.TrimSpace(c.Query("invoice_number")); v != "" {
+		add("pi.invoice_number ILIKE '%%' || $%d || '%%'", v)
+	}
+
+	var total int
+	err := db.DB.QueryRow(ctx, `
+		SELECT COUNT(*) FROM purchase_invoices pi `+where, params...).Scan(&total)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	params = append(params, limit, offset)
 	rows, err := db.DB.Query(ctx, `
-		SELECT id, invoice_number, supplier_name, created_at
-		FROM purchases
-		WHERE deleted_at IS NULL
-		ORDER BY created_at DESC
-	`)
+		SELECT pi.id, pi.invoice_number, pi.supplier_id, COALESCE(s.name, ''),
+		       COALESCE(pi.invoice_date, pi.created_at::date), pi.due_date, pi.status,
+		       COALESCE(pi.total_invoice_amount, 0), pi.amount_paid,
+		       COALESCE(pi.total_quantity, 0), pi.created_at
+		FROM purchase_invoices pi
+		LEFT JOIN suppliers s ON s.id = pi.supplier_id
+		`+where+`
+		ORDER BY COALESCE(pi.invoice_date, pi.created_at::date) DESC, pi.id DESC
+		LIMIT $`+strconv.Itoa(len(params)-1)+` OFFSET $`+strconv.Itoa(len(params)), params...)
 	if err != nil {
 		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
 		return
@@ -164,19 +524,752 @@ func ListPurchases(c *gin.Context) {
 		var r struct {
 			ID            int64
 			InvoiceNumber string
+			SupplierID    int64
 			SupplierName  string
+			InvoiceDate   time.Time
+			DueDate       *time.Time
+			Status        string
+			TotalAmount   float64
+			AmountPaid    float64
+			TotalQuantity int
 			CreatedAt     time.Time
 		}
 
-		rows.Scan(&r.ID, &r.InvoiceNumber, &r.SupplierName, &r.CreatedAt)
+		if err := rows.Scan(&r.ID, &r.InvoiceNumber, &r.SupplierID, &r.SupplierName,
+			&r.InvoiceDate, &r.DueDate, &r.Status, &r.TotalAmount, &r.AmountPaid,
+			&r.TotalQuantity, &r.CreatedAt); err != nil {
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
 
+		dueDate := ""
+		if r.DueDate != nil {
+			dueDate = utils.FormatDate(*r.DueDate)
+		}
 		resp = append(resp, gin.H{
 			"id":             r.ID,
 			"invoice_number": r.InvoiceNumber,
+			"supplier_id":    r.SupplierID,
 			"supplier_name":  r.SupplierName,
+			"invoice_date":   utils.FormatDate(r.InvoiceDate),
+			"due_date":       dueDate,
+			"status":         r.Status,
+			"total_amount":   r.TotalAmount,
+			"amount_paid":    r.AmountPaid,
+			"total_quantity": r.TotalQuantity,
 			"created_at":     utils.FormatDateTime(r.CreatedAt),
 		})
 	}
 
-	utils.SendSuccessResponse(c, http.StatusOK, resp, "Purchases fetched successfully")
+	utils.SendSuccessResponse(c, http.StatusOK, gin.H{
+		"page":      page,
+		"limit":     limit,
+		"total":     total,
+		"purchases": resp,
+	}, "Purchases fetched successfully")
+}
+
+// ---------- Internal Logic ----------
+
+func sendPurchaseError(c *gin.Context, err error) {
+	var stockErr *InsufficientStockError
+	switch {
+	case errors.As(err, &stockErr):
+		utils.SendErrorResponseWithData(c, http.StatusConflict, ErrInsufficientStock.Error(), stockErr.Lines)
+	case errors.Is(err, ErrPurchaseNotFound), errors.Is(err, ErrPurchaseOrderNotFound):
+		utils.SendErrorResponse(c, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrDuplicatePurchaseInvoice):
+		utils.SendErrorResponse(c, http.StatusConflict, err.Error())
+	case isDuplicatePurchaseInvoice(err):
+		// the same bill entered concurrently got past the duplicate check
+		utils.SendErrorResponse(c, http.StatusConflict, ErrDuplicatePurchaseInvoice.Error())
+	case errors.Is(err, ErrInvalidPurchase),
+		errors.Is(err, ErrSupplierNotFound),
+		errors.Is(err, ErrPurchaseCancelled),
+		errors.Is(err, ErrPurchaseHasReturns),
+		errors.Is(err, ErrPurchasePaid),
+		errors.Is(err, ErrPurchaseSupplierChange),
+		errors.Is(err, ErrPurchaseOrderSupplier),
+		errors.Is(err, ErrPurchaseOrderStatus):
+		utils.SendErrorResponse(c, http.StatusBadRequest, err.Error())
+	default:
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// isDuplicatePurchaseInvoice reports whether err is a unique violation of
+// idx_purchase_invoices_supplier_number.
+func isDuplicatePurchaseInvoice(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505" &&
+		pgErr.ConstraintName == "idx_purchase_invoices_supplier_number"
+}
+
+// normalizePurchaseRequest trims the request, checks the lines and returns
+// the invoice date.
+func normalizePurchaseRequest(req *PurchaseRequest) (time.Time, error) {
+	req.InvoiceNo = strings.TrimSpace(req.InvoiceNo)
+	req.Notes = strings.TrimSpace(req.Notes)
+
+	if req.InvoiceNo == "" {
+		return time.Time{}, fmt.Errorf("%w: invoice_number is required", ErrInvalidPurchase)
+	}
+	if len(req.Items) == 0 {
+		return time.Time{}, fmt.Errorf("%w: at least one item is required", ErrInvalidPurchase)
+	}
+	for i, it := range req.Items {
+		if it.ProductID <= 0 || it.Quantity <= 0 || it.PurchasePrice < 0 || it.GSTPercent < 0 {
+			return time.Time{}, fmt.Errorf("%w: item %d needs a product, a positive quantity and non-negative price and GST",
+				ErrInvalidPurchase, i+1)
+		}
+	}
+
+	for i, it := range req.Items {
+		if err := validatePurchaseDiscount(it.DiscountType, it.DiscountValue); err != nil {
+			return time.Time{}, fmt.Errorf("%w: item %d %s", ErrInvalidPurchase, i+1, err)
+		}
+	}
+	if err := validatePurchaseDiscount(req.DiscountType, req.DiscountValue); err != nil {
+		return time.Time{}, fmt.Errorf("%w: bill %s", ErrInvalidPurchase, err)
+	}
+	for i := range req.Charges {
+		ch := &req.Charges[i]
+		ch.Name = strings.TrimSpace(ch.Name)
+		ch.Basis = strings.ToUpper(strings.TrimSpace(ch.Basis))
+		if ch.Basis == "" {
+			ch.Basis = ChargeByValue
+		}
+		if ch.Name == "" || ch.Amount < 0 {
+			return time.Time{}, fmt.Errorf("%w: charge %d needs a name and a non-negative amount", ErrInvalidPurchase, i+1)
+		}
+		if ch.Basis != ChargeByValue && ch.Basis != ChargeByQuantity {
+			return time.Time{}, fmt.Errorf("%w: charge %d basis must be VALUE or QUANTITY", ErrInvalidPurchase, i+1)
+		}
+	}
+
+	invoiceDate := time.Now()
+	if req.InvoiceDate != "" {
+		t, err := time.ParseInLocation(utils.DateFormat, req.InvoiceDate, time.Local)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%w: invoice_date must be YYYY-MM-DD", ErrInvalidPurchase)
+		}
+		invoiceDate = t
+	}
+	return invoiceDate, nil
+}
+
+func validatePurchaseDiscount(discountType string, value float64) error {
+	switch discountType {
+	case "", "INR", "FLAT":
+	case "%", "PCT", "PERCENT":
+		if value > 100 {
+			return errors.New("discount cannot exceed 100%")
+		}
+	default:
+		return errors.New("discount_type must be INR or %")
+	}
+	if value < 0 {
+		return errors.New("discount must not be negative")
+	}
+	return nil
+}
+
+// normalizePurchaseDiscountType stores discount types the way sales do.
+func normalizePurchaseDiscountType(t string) string {
+	switch t {
+	case "", "INR", "FLAT":
+		return "INR"
+	default:
+		return "%"
+	}
+}
+
+// purchaseLine holds the computed values of one purchase item.
+type purchaseLine struct {
+	Item           PurchaseItem
+	Gross          float64
+	DiscountAmount float64 // line discount plus its share of the bill discount
+	Taxable        float64
+	GSTAmount      float64
+	CGST           float64
+	SGST           float64
+	IGST           float64
+	LineTotal      float64 // taxable + GST
+	ChargesAmount  float64 // share of the invoice's charges
+	LandedUnitCost float64 // (taxable + charges) / quantity; GST is recovered as input credit
+}
+
+type purchaseTotals struct {
+	Gross    float64
+	Discount float64
+	Taxable  float64
+	GST      float64
+	CGST     float64
+	SGST     float64
+	IGST     float64
+	Charges  float64
+	Total    float64
+	Quantity int
+}
+
+// computePurchaseLines prices the request's lines: line discounts, the bill
+// discount shared by net value, GST on what is left, and the charges spread
+// by value or quantity into each line's landed cost.
+func computePurchaseLines(req PurchaseRequest, interState bool) ([]purchaseLine, purchaseTotals) {
+	var t purchaseTotals
+	lines := make([]purchaseLine, len(req.Items))
+
+	nets := make([]float64, len(req.Items))
+	netTotal := 0.0
+	for i, it := range req.Items {
+		gross := round2(float64(it.Quantity) * it.PurchasePrice)
+		disc := round2(min(discountAmount(gross, it.DiscountType, it.DiscountValue), gross))
+		lines[i] = purchaseLine{Item: it, Gross: gross, DiscountAmount: disc}
+		nets[i] = gross - disc
+		netTotal += nets[i]
+		t.Quantity += it.Quantity
+	}
+
+	billDisc := round2(min(discountAmount(netTotal, req.DiscountType, req.DiscountValue), netTotal))
+	for i, share := range apportion(billDisc, nets) {
+		lines[i].DiscountAmount = round2(lines[i].DiscountAmount + share)
+		lines[i].Taxable = round2(nets[i] - share)
+	}
+
+	taxables := make([]float64, len(lines))
+	qtys := make([]float64, len(lines))
+	for i, l := range lines {
+		taxables[i] = l.Taxable
+		qtys[i] = float64(l.Item.Quantity)
+	}
+	for _, ch := range req.Charges {
+		weights := taxables
+		if ch.Basis == ChargeByQuantity {
+			weights = qtys
+		}
+		for i, share := range apportion(round2(ch.Amount), weights) {
+			lines[i].ChargesAmount = round2(lines[i].ChargesAmount + share)
+		}
+		t.Charges += round2(ch.Amount)
+	}
+
+	for i := range lines {
+		l := &lines[i]
+		l.GSTAmount = round2(l.Taxable * l.Item.GSTPercent / 100.0)
+		l.CGST, l.SGST, l.IGST = splitGST(l.GSTAmount, interState)
+		l.LineTotal = round2(l.Taxable + l.GSTAmount)
+		l.LandedUnitCost = math.Round((l.Taxable+l.ChargesAmount)/float64(l.Item.Quantity)*10000) / 10000
+
+		t.Gross += l.Gross
+		t.Discount += l.DiscountAmount
+		t.Taxable += l.Taxable
+		t.GST += l.GSTAmount
+		t.CGST += l.CGST
+		t.SGST += l.SGST
+		t.IGST += l.IGST
+	}
+
+	t.Gross = round2(t.Gross)
+	t.Discount = round2(t.Discount)
+	t.Taxable = round2(t.Taxable)
+	t.GST = round2(t.GST)
+	t.CGST = round2(t.CGST)
+	t.SGST = round2(t.SGST)
+	t.IGST = round2(t.IGST)
+	t.Charges = round2(t.Charges)
+	t.Total = round2(t.Taxable + t.GST + t.Charges)
+	return lines, t
+}
+
+// apportion splits amount in proportion to weights, rounded to paise, with
+// the rounding left on the last weighted line so the shares add up exactly.
+// With no weight at all the amount is split evenly.
+func apportion(amount float64, weights []float64) []float64 {
+	shares := make([]float64, len(weights))
+	if len(weights) == 0 || amount == 0 {
+		return shares
+	}
+
+	total := 0.0
+	last := len(weights) - 1
+	for i, w := range weights {
+		total += w
+		if w > 0 {
+			last = i
+		}
+	}
+
+	allocated := 0.0
+	for i, w := range weights {
+		if i == last {
+			break
+		}
+		if total > 0 {
+			shares[i] = round2(amount * w / total)
+		} else {
+			shares[i] = round2(amount / float64(len(weights)))
+		}
+		allocated += shares[i]
+	}
+	shares[last] = round2(amount - allocated)
+	return shares
+}
+
+// savePurchase inserts (purchaseID 0) or rewrites a purchase invoice and its
+// lines, posting stock for invoices not raised against a purchase order and
+// matching those that are. Updates must have reversed the old lines' stock.
+func savePurchase(ctx context.Context, tx pgx.Tx, purchaseID int64, req PurchaseRequest, invoiceDate time.Time, userID int) (purchaseResult, error) {
+	var res purchaseResult
+
+	// supplier registration state decides IGST vs CGST/SGST; credit terms set the due date
+	var supplierState string
+	var creditDays int
+	err := tx.QueryRow(ctx, `
+		SELECT COALESCE(state_code, ''), credit_days
+		FROM suppliers
+		WHERE id = $1 AND deleted_at IS NULL
+	`, req.SupplierID).Scan(&supplierState, &creditDays)
+	if err == pgx.ErrNoRows {
+		return res, ErrSupplierNotFound
+	}
+	if err != nil {
+		return res, fmt.Errorf("load supplier: %w", err)
+	}
+	interState := isInterState(supplierState)
+	dueDate := invoiceDate.AddDate(0, 0, creditDays)
+
+	if req.PurchaseOrderID > 0 {
+		status, poSupplierID, err := lockPurchaseOrder(ctx, tx, req.PurchaseOrderID)
+		if err != nil {
+			return res, err
+		}
+		if poSupplierID != int64(req.SupplierID) {
+			return res, ErrPurchaseOrderSupplier
+		}
+		if status == PODraft || status == POCancelled {
+			return res, fmt.Errorf("%w: purchase order has not been sent or is cancelled", ErrPurchaseOrderStatus)
+		}
+	}
+
+	var duplicate bool
+	err = tx.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM purchase_invoices
+			WHERE supplier_id = $1 AND LOWER(invoice_number) = LOWER($2)
+			  AND id <> $3 AND deleted_at IS NULL
+		)
+	`, req.SupplierID, req.InvoiceNo, purchaseID).Scan(&duplicate)
+	if err != nil {
+		return res, fmt.Errorf("check duplicate invoice: %w", err)
+	}
+	if duplicate {
+		return res, fmt.Errorf("%w: %s", ErrDuplicatePurchaseInvoice, req.InvoiceNo)
+	}
+
+	lines, totals := computePurchaseLines(req, interState)
+
+	var poID interface{}
+	if req.PurchaseOrderID > 0 {
+		poID = req.PurchaseOrderID
+	}
+
+	if purchaseID == 0 {
+		err = tx.QueryRow(ctx, `
+			INSERT INTO purchase_invoices
+			(invoice_number, supplier_id, total_amount_before_discount, discount_amount,
+			 total_gst, total_invoice_amount, total_items, total_quantity, notes, created_by,
+			 total_cgst, total_sgst, total_igst, supplier_state_code, invoice_date, due_date,
+			 purchase_order_id, discount_type, discount_value, taxable_amount, total_charges)
+			VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,$20,$21)
+			RETURNING id
+		`,
+			req.InvoiceNo,
+			req.SupplierID,
+			totals.Gross,
+			totals.Discount,
+			totals.GST,
+			totals.Total,
+			len(req.Items),
+			totals.Quantity,
+			req.Notes,
+			nullableUserID(userID),
+			totals.CGST,
+			totals.SGST,
+			totals.IGST,
+			supplierState,
+			invoiceDate.Format(utils.DateFormat),
+			dueDate.Format(utils.DateFormat),
+			poID,
+			normalizePurchaseDiscountType(req.DiscountType),
+			req.DiscountValue,
+			totals.Taxable,
+			totals.Charges,
+		).Scan(&purchaseID)
+		if err != nil {
+			return res, fmt.Errorf("insert purchase header: %w", err)
+		}
+	} else {
+		_, err = tx.Exec(ctx, `
+			UPDATE purchase_invoices
+			SET invoice_number = $1, total_amount_before_discount = $2, discount_amount = $3,
+			    total_gst = $4, total_invoice_amount = $5, total_items = $6, total_quantity = $7,
+			    notes = $8, total_cgst = $9, total_sgst = $10, total_igst = $11,
+			    supplier_state_code = $12, invoice_date = $13, due_date = $14,
+			    discount_type = $15, discount_value = $16, taxable_amount = $17, total_charges = $18,
+			    updated_at = NOW()
+			WHERE id = $19
+		`,
+			req.InvoiceNo,
+			totals.Gross,
+			totals.Discount,
+			totals.GST,
+			totals.Total,
+			len(req.Items),
+			totals.Quantity,
+			req.Notes,
+			totals.CGST,
+			totals.SGST,
+			totals.IGST,
+			supplierState,
+			invoiceDate.Format(utils.DateFormat),
+			dueDate.Format(utils.DateFormat),
+			normalizePurchaseDiscountType(req.DiscountType),
+			req.DiscountValue,
+			totals.Taxable,
+			totals.Charges,
+			purchaseID,
+		)
+		if err != nil {
+			return res, fmt.Errorf("update purchase header: %w", err)
+		}
+
+		for _, table := range []string{"purchase_match_exceptions", "purchase_invoice_items", "purchase_invoice_charges"} {
+			if _, err := tx.Exec(ctx, `DELETE FROM `+table+` WHERE purchase_invoice_id = $1`, purchaseID); err != nil {
+				return res, fmt.Errorf("clear %s: %w", table, err)
+			}
+		}
+	}
+
+	for _, ch := range req.Charges {
+		_, err = tx.Exec(ctx, `
+			INSERT INTO purchase_invoice_charges (purchase_invoice_id, name, amount, basis)
+			VALUES ($1, $2, $3, $4)
+		`, purchaseID, ch.Name, round2(ch.Amount), ch.Basis)
+		if err != nil {
+			return res, fmt.Errorf("insert purchase charge: %w", err)
+		}
+	}
+
+	if req.PurchaseOrderID == 0 {
+		productIDs := make([]int64, len(lines))
+		for i, l := range lines {
+			productIDs[i] = int64(l.Item.ProductID)
+		}
+		if err := lockStockRows(ctx, tx, productIDs); err != nil {
+			return res, err
+		}
+	}
+
+	// insert items + inventory_transactions
+	for _, l := range lines {
+		_, err = tx.Exec(ctx, `
+			INSERT INTO purchase_invoice_items
+			(purchase_invoice_id, product_id, quantity, purchase_price,
+			 gst_percent, gst_amount, line_total, cgst_amount, sgst_amount, igst_amount,
+			 discount_type, discount_value, discount_amount, taxable_amount,
+			 charges_amount, landed_unit_cost)
+			VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16)
+		`,
+			purchaseID,
+			l.Item.ProductID,
+			l.Item.Quantity,
+			l.Item.PurchasePrice,
+			l.Item.GSTPercent,
+			l.GSTAmount,
+			l.LineTotal,
+			l.CGST,
+			l.SGST,
+			l.IGST,
+			normalizePurchaseDiscountType(l.Item.DiscountType),
+			l.Item.DiscountValue,
+			l.DiscountAmount,
+			l.Taxable,
+			l.ChargesAmount,
+			l.LandedUnitCost,
+		)
+		if err != nil {
+			return res, fmt.Errorf("insert purchase item: %w", err)
+		}
+
+		// inventory + stock (positive quantity); PO-backed stock came in on its GRNs
+		if req.PurchaseOrderID == 0 {
+			if _, err := postInventoryCost(ctx, tx, int64(l.Item.ProductID), l.Item.Quantity, l.LandedUnitCost, RefPurchase, purchaseID, userID); err != nil {
+				return res, fmt.Errorf("insert inventory tx: %w", err)
+			}
+		}
+	}
+
+	res.ID = purchaseID
+	res.TotalAmount = totals.Total
+	res.TotalDiscount = totals.Discount
+	res.TotalCharges = totals.Charges
+	res.TotalQuantity = totals.Quantity
+	res.DueDate = dueDate
+
+	if req.PurchaseOrderID > 0 {
+		res.MatchStatus, res.MatchExceptions, err = matchPurchaseInvoice(ctx, tx, purchaseID, req.PurchaseOrderID)
+		if err != nil {
+			return res, err
+		}
+	}
+
+	return res, nil
+}
+
+// lockedPurchase is the part of a purchase invoice edits and cancels check.
+type lockedPurchase struct {
+	SupplierID      int64
+	PurchaseOrderID int64
+	Status          string
+	AmountPaid      float64
+	HasReturns      bool
+}
+
+// lockPurchase locks a purchase invoice and refuses cancelled ones and those
+// with debit notes against them.
+func lockPurchase(ctx context.Context, tx pgx.Tx, id int64) (lockedPurchase, error) {
+	var p lockedPurchase
+	err := tx.QueryRow(ctx, `
+		SELECT supplier_id, COALESCE(purchase_order_id, 0), status, amount_paid,
+		       EXISTS (SELECT 1 FROM purchase_returns WHERE purchase_invoice_id = $1)
+		FROM purchase_invoices
+		WHERE id = $1
+		FOR UPDATE
+	`, id).Scan(&p.SupplierID, &p.PurchaseOrderID, &p.Status, &p.AmountPaid, &p.HasReturns)
+	if err == pgx.ErrNoRows {
+		return p, ErrPurchaseNotFound
+	}
+	if err != nil {
+		return p, fmt.Errorf("load purchase: %w", err)
+	}
+	if p.Status == PurchaseCancelled {
+		return p, ErrPurchaseCancelled
+	}
+	if p.HasReturns {
+		return p, ErrPurchaseHasReturns
+	}
+	return p, nil
+}
+
+// purchaseQuantities totals an invoice's stored lines by product.
+func purchaseQuantities(ctx context.Context, tx pgx.Tx, id int64) (map[int64]int, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT product_id, SUM(quantity)
+		FROM purchase_invoice_items
+		WHERE purchase_invoice_id = $1
+		GROUP BY product_id
+		ORDER BY product_id
+	`, id)
+	if err != nil {
+		return nil, fmt.Errorf("load purchase items: %w", err)
+	}
+	defer rows.Close()
+
+	qty := map[int64]int{}
+	for rows.Next() {
+		var productID int64
+		var q int
+		if err := rows.Scan(&productID, &q); err != nil {
+			return nil, err
+		}
+		qty[productID] = q
+	}
+	return qty, rows.Err()
+}
+
+// reversePurchaseStock takes stock posted by a purchase back out, at the
+// landed cost it came in at and out of the purchase's own cost layers.
+// Outgoing quantities are checked under the oversell policy first, since the
+// goods may already have been sold.
+func reversePurchaseStock(ctx context.Context, tx pgx.Tx, id int64, outgoing, posted map[int64]int, userID int) ([]StockShortage, error) {
+	productIDs := make([]int64, 0, len(posted))
+	for productID := range posted {
+		productIDs = append(productIDs, productID)
+	}
+	sort.Slice(productIDs, func(i, j int) bool { return productIDs[i] < productIDs[j] })
+	if err := lockStockRows(ctx, tx, productIDs); err != nil {
+		return nil, err
+	}
+
+	var shortages []StockShortage
+	if len(outgoing) > 0 && config.OversellPolicy != config.OversellAllow {
+		var err error
+		shortages, err = checkStock(ctx, tx, outgoing)
+		if err != nil {
+			return nil, err
+		}
+		if len(shortages) > 0 && config.OversellPolicy == config.OversellBlock {
+			return nil, &InsufficientStockError{Lines: shortages}
+		}
+	}
+
+	costs, err := purchaseLandedCosts(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	from := receiptRef{RefType: RefPurchase, RefIDs: []int64{id}}
+	for _, productID := range productIDs {
+		qty := posted[productID]
+		cost, ok := costs[productID]
+		if !ok {
+			cost = -1
+		}
+		if _, err := reverseInventoryCost(ctx, tx, productID, qty, cost, from, RefPurchase, id, userID); err != nil {
+			return nil, err
+		}
+	}
+	return shortages, nil
+}
+
+// purchaseLandedCosts is the landed cost per unit of each product on an
+// invoice's stored lines, averaged over lines that repeat a product.
+func purchaseLandedCosts(ctx context.Context, tx pgx.Tx, id int64) (map[int64]float64, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT product_id,
+		       SUM(quantity * COALESCE(landed_unit_cost, purchase_price)) / NULLIF(SUM(quantity), 0)
+		FROM purchase_invoice_items
+		WHERE purchase_invoice_id = $1
+		GROUP BY product_id
+	`, id)
+	if err != nil {
+		return nil, fmt.Errorf("load purchase costs: %w", err)
+	}
+	defer rows.Close()
+
+	costs := map[int64]float64{}
+	for rows.Next() {
+		var productID int64
+		var cost *float64
+		if err := rows.Scan(&productID, &cost); err != nil {
+			return nil, err
+		}
+		if cost != nil {
+			costs[productID] = *cost
+		}
+	}
+	return costs, rows.Err()
+}
+
+func updatePurchase(ctx context.Context, id int64, req PurchaseRequest, invoiceDate time.Time, userID int) (purchaseResult, error) {
+	tx, err := db.DB.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return purchaseResult{}, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	p, err := lockPurchase(ctx, tx, id)
+	if err != nil {
+		return purchaseResult{}, err
+	}
+	if req.SupplierID != 0 && int64(req.SupplierID) != p.SupplierID {
+		return purchaseResult{}, ErrPurchaseSupplierChange
+	}
+	req.SupplierID = int(p.SupplierID)
+	req.PurchaseOrderID = p.PurchaseOrderID
+
+	var warnings []StockShortage
+	if p.PurchaseOrderID == 0 {
+		old, err := purchaseQuantities(ctx, tx, id)
+		if err != nil {
+			return purchaseResult{}, err
+		}
+
+		// only products whose quantity drops take stock out on balance
+		outgoing := map[int64]int{}
+		for productID, q := range old {
+			outgoing[productID] = q
+		}
+		for _, it := range req.Items {
+			outgoing[int64(it.ProductID)] -= it.Quantity
+		}
+		for productID, q := range outgoing {
+			if q <= 0 {
+				delete(outgoing, productID)
+			}
+		}
+
+		// the old and new lines' stock rows are locked together, in product
+		// order, before anything moves
+		productIDs := make([]int64, 0, len(old)+len(req.Items))
+		for productID := range old {
+			productIDs = append(productIDs, productID)
+		}
+		for _, it := range req.Items {
+			productIDs = append(productIDs, int64(it.ProductID))
+		}
+		if err := lockStockRows(ctx, tx, productIDs); err != nil {
+			return purchaseResult{}, err
+		}
+
+		warnings, err = reversePurchaseStock(ctx, tx, id, outgoing, old, userID)
+		if err != nil {
+			return purchaseResult{}, err
+		}
+	}
+
+	res, err := savePurchase(ctx, tx, id, req, invoiceDate, userID)
+	if err != nil {
+		return purchaseResult{}, err
+	}
+	if p.AmountPaid > res.TotalAmount+0.005 {
+		return purchaseResult{}, fmt.Errorf("%w: %.2f paid exceeds the new total %.2f", ErrPurchasePaid, p.AmountPaid, res.TotalAmount)
+	}
+	res.StockWarnings = warnings
+
+	if err := tx.Commit(ctx); err != nil {
+		return purchaseResult{}, fmt.Errorf("commit tx: %w", err)
+	}
+	return res, nil
+}
+
+func cancelPurchase(ctx context.Context, id int64, reason string, userID int) ([]StockShortage, error) {
+	tx, err := db.DB.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	p, err := lockPurchase(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if p.AmountPaid > 0.005 {
+		return nil, fmt.Errorf("%w: remove the supplier payments first", ErrPurchasePaid)
+	}
+
+	var warnings []StockShortage
+	if p.PurchaseOrderID == 0 {
+		posted, err := purchaseQuantities(ctx, tx, id)
+		if err != nil {
+			return nil, err
+		}
+		warnings, err = reversePurchaseStock(ctx, tx, id, posted, posted, userID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE purchase_invoices
+		SET status = $1, cancel_reason = NULLIF($2, ''), cancelled_at = NOW(), deleted_at = NOW()
+		WHERE id = $3
+	`, PurchaseCancelled, reason, id)
+	if err != nil {
+		return nil, fmt.Errorf("cancel purchase: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("commit tx: %w", err)
+	}
+	return warnings, nil
 }
@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"tulsi-pos/db"
+	"tulsi-pos/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// Match statuses and exception kinds for supplier invoices billed against a
+// purchase order.
+const (
+	MatchMatched  = "MATCHED"
+	MatchMismatch = "MISMATCH"
+
+	MatchPrice      = "PRICE"
+	MatchQuantity   = "QUANTITY"
+	MatchNotOrdered = "NOT_ORDERED"
+)
+
+// MatchException is one difference between a supplier invoice and what was
+// ordered and received.
+type MatchException struct {
+	ProductID   int64   `json:"product_id"`
+	ProductName string  `json:"product_name"`
+	Kind        string  `json:"kind"`
+	Expected    float64 `json:"expected"`
+	Actual      float64 `json:"actual"`
+}
+
+// matchPurchaseInvoice compares a purchase invoice with its purchase order and
+// goods receipts, product by product: the billed price must equal the PO price,
+// and the billed quantity must equal what was received but not yet billed on
+// an earlier invoice. The differences are stored and the invoice is marked
+// MATCHED or MISMATCH.
+func matchPurchaseInvoice(ctx context.Context, tx pgx.Tx, purchaseID, poID int64) (string, []MatchException, error) {
+	rows, err := tx.Query(ctx, `
+		WITH billed AS (
+			SELECT pii.product_id, SUM(pii.quantity) AS qty,
+			       SUM(pii.quantity * pii.purchase_price) / NULLIF(SUM(pii.quantity), 0) AS price
+			FROM purchase_invoice_items pii
+			WHERE pii.purchase_invoice_id = $1
+			GROUP BY pii.product_id
+		),
+		ordered AS (
+			SELECT product_id, SUM(received_qty) AS received,
+			       SUM(quantity * unit_price) / NULLIF(SUM(quantity), 0) AS price
+			FROM purchase_order_items
+			WHERE purchase_order_id = $2
+			GROUP BY product_id
+		),
+		billed_before AS (
+			SELECT pii.product_id, SUM(pii.quantity) AS qty
+			FROM purchase_invoice_items pii
+			JOIN purchase_invoices pi ON pi.id = pii.purchase_invoice_id
+			WHERE pi.purchase_order_id = $2 AND pi.id <> $1 AND pi.deleted_at IS NULL
+			GROUP BY pii.product_id
+		)
+		SELECT b.product_id, p.name, b.qty, b.price,
+		       o.product_id IS NOT NULL, COALESCE(o.price, 0),
+		       COALESCE(o.received, 0) - COALESCE(bb.qty, 0)
+		FROM billed b
+		JOIN products p ON p.id = b.product_id
+		LEFT JOIN ordered o ON o.product_id = b.product_id
+		LEFT JOIN billed_before bb ON bb.product_id = b.product_id
+		ORDER BY b.product_id
+	`, purchaseID, poID)
+	if err != nil {
+		return "", nil, fmt.Errorf("match purchase invoice: %w", err)
+	}
+
+	exceptions := []MatchException{}
+	for rows.Next() {
+		var (
+			productID               int64
+			name                    string
+			billedQty, unbilledQty  int
+			billedPrice, orderPrice float64
+			onOrder                 bool
+		)
+		if err := rows.Scan(&productID, &name, &billedQty, &billedPrice, &onOrder,
+			&orderPrice, &unbilledQty); err != nil {
+			rows.Close()
+			return "", nil, err
+		}
+
+		if !onOrder {
+			exceptions = append(exceptions, MatchException{productID, name, MatchNotOrdered, 0, float64(billedQty)})
+			continue
+		}
+		if math.Abs(billedPrice-orderPrice) > 0.005 {
+			exceptions = append(exceptions, MatchException{productID, name, MatchPrice, round2(orderPrice), round2(billedPrice)})
+		}
+		if billedQty != unbilledQty {
+			exceptions = append(exceptions, MatchException{productID, name, MatchQuantity, float64(unbilledQty), float64(billedQty)})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return "", nil, err
+	}
+
+	for _, e := range exceptions {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO purchase_match_exceptions (purchase_invoice_id, product_id, kind, expected, actual)
+			VALUES ($1, $2, $3, $4, $5)
+		`, purchaseID, e.ProductID, e.Kind, e.Expected, e.Actual)
+		if err != nil {
+			return "", nil, fmt.Errorf("insert match exception: %w", err)
+		}
+	}
+
+	status := MatchMatched
+	if len(exceptions) > 0 {
+		status = MatchMismatch
+	}
+	_, err = tx.Exec(ctx, `
+		UPDATE purchase_invoices
+		SET purchase_order_id = $1, match_status = $2
+		WHERE id = $3
+	`, poID, status, purchaseID)
+	if err != nil {
+		return "", nil, fmt.Errorf("update match status: %w", err)
+	}
+
+	return status, exceptions, nil
+}
+
+// loadPurchaseOrderInvoices lists the supplier invoices billed against a PO
+// with their match exceptions.
+func loadPurchaseOrderInvoices(ctx context.Context, poID int64) ([]gin.H, error) {
+	rows, err := db.DB.Query(ctx, `
+		SELECT pi.id, pi.invoice_number, COALESCE(pi.match_status, ''),
+		       COALESCE(pi.total_invoice_amount, 0), pi.created_at
+		FROM purchase_invoices pi
+		WHERE pi.purchase_order_id = $1 AND pi.deleted_at IS NULL
+		ORDER BY pi.id
+	`, poID)
+	if err != nil {
+		return nil, fmt.Errorf("load purchase invoices: %w", err)
+	}
+
+	invoices := []gin.H{}
+	var ids []int64
+	for rows.Next() {
+		var (
+			id             int64
+			number, status string
+			total          float64
+			createdAt      time.Time
+		)
+		if err := rows.Scan(&id, &number, &status, &total, &createdAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+		invoices = append(invoices, gin.H{
+			"id":             id,
+			"invoice_number": number,
+			"match_status":   status,
+			"total_amount":   total,
+			"created_at":     utils.FormatDateTime(createdAt),
+		})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, id := range ids {
+		exceptions, err := loadMatchExceptions(ctx, db.DB, id)
+		if err != nil {
+			return nil, err
+		}
+		invoices[i]["exceptions"] = exceptions
+	}
+
+	return invoices, nil
+}
+
+func loadMatchExceptions(ctx context.Context, q db.Querier, purchaseID int64) ([]MatchException, error) {
+	rows, err := q.Query(ctx, `
+		SELECT e.product_id, p.name, e.kind, e.expected, e.actual
+		FROM purchase_match_exceptions e
+		JOIN products p ON p.id = e.product_id
+		WHERE e.purchase_invoice_id = $1
+		ORDER BY e.id
+	`, purchaseID)
+	if err != nil {
+		return nil, fmt.Errorf("load match exceptions: %w", err)
+	}
+	defer rows.Close()
+
+	exceptions := []MatchException{}
+	for rows.Next() {
+		var e MatchException
+		if err := rows.Scan(&e.ProductID, &e.ProductName, &e.Kind, &e.Expected, &e.Actual); err != nil {
+			return nil, err
+		}
+		exceptions = append(exceptions, e)
+	}
+
+	return exceptions, rows.Err()
+}
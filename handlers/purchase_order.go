@@ -0,0 +1,605 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"tulsi-pos/db"
+	"tulsi-pos/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// Purchase order statuses. A PO is editable only while DRAFT, receives goods
+// once SENT, and is CLOSED by hand when the remaining quantity will not come.
+const (
+	PODraft     = "DRAFT"
+	POSent      = "SENT"
+	POPartial   = "PARTIAL"
+	POReceived  = "RECEIVED"
+	POClosed    = "CLOSED"
+	POCancelled = "CANCELLED"
+)
+
+// ---------- Request DTOs ----------
+
+type PurchaseOrderItemInput struct {
+	ProductID  int64   `json:"product_id" binding:"required"`
+	Quantity   int     `json:"quantity" binding:"required,min=1"`
+	UnitPrice  float64 `json:"unit_price" binding:"min=0"`
+	GSTPercent float64 `json:"gst_percent" binding:"min=0,max=100"`
+}
+
+type PurchaseOrderInput struct {
+	SupplierID int64                    `json:"supplier_id" binding:"required"`
+	Notes      string                   `json:"notes"`
+	Items      []PurchaseOrderItemInput `json:"items" binding:"required,min=1,dive"`
+}
+
+var (
+	ErrPurchaseOrderNotFound = errors.New("purchase order not found")
+	ErrPurchaseOrderStatus   = errors.New("purchase order status does not allow this")
+	ErrSupplierNotFound      = errors.New("supplier not found")
+)
+
+// ---------- Public Handlers ----------
+
+// POST /purchase-orders
+func CreatePurchaseOrder(c *gin.Context) {
+	var in PurchaseOrderInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid payload: "+err.Error())
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	tx, err := db.DB.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	err = checkSupplier(ctx, tx, in.SupplierID)
+	if err == nil {
+		err = checkOrderProducts(ctx, tx, in.Items)
+	}
+	if err != nil {
+		sendPurchaseOrderError(c, err)
+		return
+	}
+
+	id, number, total, err := insertPurchaseOrder(ctx, tx, in.SupplierID, strings.TrimSpace(in.Notes), poLinesFromInput(in.Items), c.GetInt("user_id"))
+	if err != nil {
+		sendPurchaseOrderError(c, err)
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SendSuccessResponse(c, http.StatusCreated, gin.H{
+		"id":           id,
+		"po_number":    number,
+		"status":       PODraft,
+		"total_amount": total,
+	}, "purchase order created")
+}
+
+// PUT /purchase-orders/:id
+// Replaces supplier, notes and lines of a DRAFT purchase order.
+func UpdatePurchaseOrder(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid purchase order id")
+		return
+	}
+
+	var in PurchaseOrderInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid payload: "+err.Error())
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	tx, err := db.DB.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	status, _, err := lockPurchaseOrder(ctx, tx, id)
+	if err == nil && status != PODraft {
+		err = fmt.Errorf("%w: only DRAFT orders can be edited", ErrPurchaseOrderStatus)
+	}
+	if err == nil {
+		err = checkSupplier(ctx, tx, in.SupplierID)
+	}
+	if err == nil {
+		err = checkOrderProducts(ctx, tx, in.Items)
+	}
+	if err != nil {
+		sendPurchaseOrderError(c, err)
+		return
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM purchase_order_items WHERE purchase_order_id = $1`, id); err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	total, err := insertPurchaseOrderItems(ctx, tx, id, poLinesFromInput(in.Items))
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE purchase_orders
+		SET supplier_id = $1, notes = NULLIF($2, ''), total_amount = $3
+		WHERE id = $4
+	`, in.SupplierID, strings.TrimSpace(in.Notes), total, id)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, gin.H{"id": id, "total_amount": total}, "purchase order updated")
+}
+
+// POST /purchase-orders/:id/send
+func SendPurchaseOrder(c *gin.Context) {
+	changePurchaseOrderStatus(c, POSent, []string{PODraft}, "purchase order sent")
+}
+
+// POST /purchase-orders/:id/cancel
+// Only orders nothing has been received against can be cancelled.
+func CancelPurchaseOrder(c *gin.Context) {
+	changePurchaseOrderStatus(c, POCancelled, []string{PODraft, POSent}, "purchase order cancelled")
+}
+
+// POST /purchase-orders/:id/close
+// Accepts the short quantity of a partly received order as never coming.
+func ClosePurchaseOrder(c *gin.Context) {
+	changePurchaseOrderStatus(c, POClosed, []string{POSent, POPartial}, "purchase order closed")
+}
+
+// POST /purchase-orders/generate?category=
+// Drafts one purchase order per supplier for every low-stock product, using
+// the supplier, price and GST rate of the product's last purchase. Products
+// that were never purchased are returned as unassigned.
+func GeneratePurchaseOrders(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	tx, err := db.DB.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	// one generator at a time, so two runs cannot both see the same shortfall
+	if _, err := tx.Exec(ctx, `LOCK TABLE purchase_orders IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	suggestions, err := loadReorderSuggestions(ctx, tx, c.Query("category"))
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	bySupplier := map[int64][]ReorderSuggestion{}
+	var supplierOrder []int64
+	unassigned := []ReorderSuggestion{}
+	for _, s := range suggestions {
+		if s.SuggestedQty <= 0 {
+			continue
+		}
+		if s.SupplierID == nil {
+			unassigned = append(unassigned, s)
+			continue
+		}
+		if _, ok := bySupplier[*s.SupplierID]; !ok {
+			supplierOrder = append(supplierOrder, *s.SupplierID)
+		}
+		bySupplier[*s.SupplierID] = append(bySupplier[*s.SupplierID], s)
+	}
+
+	created := []gin.H{}
+	for _, supplierID := range supplierOrder {
+		lines := bySupplier[supplierID]
+		items := make([]poLine, 0, len(lines))
+		for _, s := range lines {
+			items = append(items, poLine{
+				ProductID:  s.ProductID,
+				Quantity:   s.SuggestedQty,
+				UnitPrice:  s.LastPrice,
+				GSTPercent: s.LastGST,
+			})
+		}
+
+		id, number, total, err := insertPurchaseOrder(ctx, tx, supplierID, "Generated from low stock", items, c.GetInt("user_id"))
+		if err != nil {
+			if errors.Is(err, ErrSeriesNotFound) {
+				utils.SendErrorResponse(c, http.StatusBadRequest, err.Error())
+				return
+			}
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		created = append(created, gin.H{
+			"id":            id,
+			"po_number":     number,
+			"supplier_id":   supplierID,
+			"supplier_name": lines[0].SupplierName,
+			"items":         len(items),
+			"total_amount":  total,
+		})
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SendSuccessResponse(c, http.StatusCreated, gin.H{
+		"purchase_orders": created,
+		"unassigned":      unassigned,
+	}, fmt.Sprintf("%d purchase orders drafted", len(created)))
+}
+
+// GET /purchase-orders?status=&supplier_id=
+func ListPurchaseOrders(c *gin.Context) {
+	where := "WHERE po.deleted_at IS NULL"
+	params := []interface{}{}
+	if status := strings.ToUpper(c.Query("status")); status != "" {
+		params = append(params, status)
+		where += fmt.Sprintf(" AND po.status = $%d", len(params))
+	}
+	if supplierID, err := strconv.ParseInt(c.Query("supplier_id"), 10, 64); err == nil {
+		params = append(params, supplierID)
+		where += fmt.Sprintf(" AND po.supplier_id = $%d", len(params))
+	}
+
+	rows, err := db.DB.Query(c.Request.Context(), `
+		SELECT po.id, po.po_number, po.supplier_id, s.name, po.status,
+		       COALESCE(po.total_amount, 0), po.created_at
+		FROM purchase_orders po
+		JOIN suppliers s ON s.id = po.supplier_id
+		`+where+`
+		ORDER BY po.created_at DESC
+	`, params...)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	resp := []gin.H{}
+	for rows.Next() {
+		var (
+			id, supplierID               int64
+			number, supplierName, status string
+			total                        float64
+			createdAt                    time.Time
+		)
+		if err := rows.Scan(&id, &number, &supplierID, &supplierName, &status, &total, &createdAt); err != nil {
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		resp = append(resp, gin.H{
+			"id":            id,
+			"po_number":     number,
+			"supplier_id":   supplierID,
+			"supplier_name": supplierName,
+			"status":        status,
+			"total_amount":  total,
+			"created_at":    utils.FormatDateTime(createdAt),
+		})
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, resp, "Purchase orders fetched successfully")
+}
+
+// GET /purchase-orders/:id
+func GetPurchaseOrderByID(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid purchase order id")
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	var header struct {
+		ID           int64   `json:"id"`
+		PONumber     string  `json:"po_number"`
+		SupplierID   int64   `json:"supplier_id"`
+		SupplierName string  `json:"supplier_name"`
+		Status       string  `json:"status"`
+		TotalAmount  float64 `json:"total_amount"`
+		Notes        string  `json:"notes"`
+		CreatedAt    string  `json:"created_at"`
+	}
+
+	var createdAt time.Time
+	err = db.DB.QueryRow(ctx, `
+		SELECT po.id, po.po_number, po.supplier_id, s.name, po.status,
+		       COALESCE(po.total_amount, 0), COALESCE(po.notes, ''), po.created_at
+		FROM purchase_orders po
+		JOIN suppliers s ON s.id = po.supplier_id
+		WHERE po.id = $1 AND po.deleted_at IS NULL
+	`, id).Scan(&header.ID, &header.PONumber, &header.SupplierID, &header.SupplierName,
+		&header.Status, &header.TotalAmount, &header.Notes, &createdAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			utils.SendErrorResponse(c, http.StatusNotFound, "purchase order not found")
+			return
+		}
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	header.CreatedAt = utils.FormatDateTime(createdAt)
+
+	rows, err := db.DB.Query(ctx, `
+		SELECT poi.id, poi.product_id, p.name, COALESCE(p.sku, ''), poi.quantity,
+		       poi.received_qty, poi.unit_price, poi.gst_percent, poi.line_total
+		FROM purchase_order_items poi
+		JOIN products p ON p.id = poi.product_id
+		WHERE poi.purchase_order_id = $1
+		ORDER BY poi.id
+	`, id)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	items := []gin.H{}
+	for rows.Next() {
+		var (
+			itemID, productID            int64
+			name, sku                    string
+			qty, received                int
+			unitPrice, gstPercent, total float64
+		)
+		if err := rows.Scan(&itemID, &productID, &name, &sku, &qty, &received,
+			&unitPrice, &gstPercent, &total); err != nil {
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		items = append(items, gin.H{
+			"id":           itemID,
+			"product_id":   productID,
+			"product_name": name,
+			"sku":          sku,
+			"quantity":     qty,
+			"received_qty": received,
+			"pending_qty":  max(qty-received, 0),
+			"over_qty":     max(received-qty, 0),
+			"unit_price":   unitPrice,
+			"gst_percent":  gstPercent,
+			"line_total":   total,
+		})
+	}
+	rows.Close()
+
+	receipts, err := loadGoodsReceipts(ctx, id)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	invoices, err := loadPurchaseOrderInvoices(ctx, id)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, gin.H{
+		"purchase_order": header,
+		"items":          items,
+		"receipts":       receipts,
+		"invoices":       invoices,
+	}, "Purchase order fetched successfully")
+}
+
+// ---------- Internal Logic ----------
+
+type poLine struct {
+	ProductID  int64
+	Quantity   int
+	UnitPrice  float64
+	GSTPercent float64
+}
+
+// insertPurchaseOrder numbers and stores a DRAFT purchase order.
+func insertPurchaseOrder(ctx context.Context, tx pgx.Tx, supplierID int64, notes string, lines []poLine, userID int) (int64, string, float64, error) {
+	number, err := nextDocumentNumber(ctx, tx, SeriesPurchaseOrder, time.Now())
+	if err != nil {
+		return 0, "", 0, err
+	}
+
+	var id int64
+	err = tx.QueryRow(ctx, `
+		INSERT INTO purchase_orders (po_number, supplier_id, status, notes, created_by)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5)
+		RETURNING id
+	`, number, supplierID, PODraft, notes, nullableUserID(userID)).Scan(&id)
+	if err != nil {
+		return 0, "", 0, fmt.Errorf("insert purchase order: %w", err)
+	}
+
+	total, err := insertPurchaseOrderItems(ctx, tx, id, lines)
+	if err != nil {
+		return 0, "", 0, err
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE purchase_orders SET total_amount = $1 WHERE id = $2`, total, id)
+	if err != nil {
+		return 0, "", 0, fmt.Errorf("update purchase order total: %w", err)
+	}
+
+	return id, number, total, nil
+}
+
+func insertPurchaseOrderItems(ctx context.Context, tx pgx.Tx, poID int64, lines []poLine) (float64, error) {
+	total := 0.0
+	for _, l := range lines {
+		lineTotal := round2(float64(l.Quantity) * l.UnitPrice * (1 + l.GSTPercent/100))
+		total += lineTotal
+
+		_, err := tx.Exec(ctx, `
+			INSERT INTO purchase_order_items (
+				purchase_order_id, product_id, quantity, unit_price, gst_percent, line_total
+			) VALUES ($1, $2, $3, $4, $5, $6)
+		`, poID, l.ProductID, l.Quantity, l.UnitPrice, l.GSTPercent, lineTotal)
+		if err != nil {
+			return 0, fmt.Errorf("insert purchase order item: %w", err)
+		}
+	}
+	return round2(total), nil
+}
+
+func poLinesFromInput(items []PurchaseOrderItemInput) []poLine {
+	lines := make([]poLine, 0, len(items))
+	for _, it := range items {
+		lines = append(lines, poLine{
+			ProductID:  it.ProductID,
+			Quantity:   it.Quantity,
+			UnitPrice:  it.UnitPrice,
+			GSTPercent: it.GSTPercent,
+		})
+	}
+	return lines
+}
+
+func checkSupplier(ctx context.Context, q db.Querier, supplierID int64) error {
+	var exists bool
+	err := q.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM suppliers WHERE id = $1 AND deleted_at IS NULL)
+	`, supplierID).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("load supplier: %w", err)
+	}
+	if !exists {
+		return ErrSupplierNotFound
+	}
+	return nil
+}
+
+// checkOrderProducts reports the first line whose product is missing or
+// deleted.
+func checkOrderProducts(ctx context.Context, q db.Querier, items []PurchaseOrderItemInput) error {
+	ids := make([]int64, 0, len(items))
+	for _, it := range items {
+		ids = append(ids, it.ProductID)
+	}
+
+	rows, err := q.Query(ctx, `
+		SELECT id FROM products WHERE id = ANY($1) AND deleted_at IS NULL
+	`, ids)
+	if err != nil {
+		return fmt.Errorf("load products: %w", err)
+	}
+	defer rows.Close()
+
+	found := make(map[int64]bool, len(ids))
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return fmt.Errorf("scan product: %w", err)
+		}
+		found[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("load products: %w", err)
+	}
+
+	for _, id := range ids {
+		if !found[id] {
+			return fmt.Errorf("%w: %d", ErrProductNotFound, id)
+		}
+	}
+	return nil
+}
+
+// lockPurchaseOrder locks a purchase order for the rest of tx and returns its
+// status and supplier.
+func lockPurchaseOrder(ctx context.Context, tx pgx.Tx, id int64) (string, int64, error) {
+	var status string
+	var supplierID int64
+	err := tx.QueryRow(ctx, `
+		SELECT status, supplier_id
+		FROM purchase_orders
+		WHERE id = $1 AND deleted_at IS NULL
+		FOR UPDATE
+	`, id).Scan(&status, &supplierID)
+	if err == pgx.ErrNoRows {
+		return "", 0, ErrPurchaseOrderNotFound
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("load purchase order: %w", err)
+	}
+	return status, supplierID, nil
+}
+
+func changePurchaseOrderStatus(c *gin.Context, to string, from []string, msg string) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid purchase order id")
+		return
+	}
+
+	res, err := db.DB.Exec(c.Request.Context(), `
+		UPDATE purchase_orders
+		SET status = $1
+		WHERE id = $2 AND deleted_at IS NULL AND status = ANY($3)
+	`, to, id, from)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if res.RowsAffected() == 0 {
+		utils.SendErrorResponse(c, http.StatusBadRequest,
+			fmt.Sprintf("purchase order not found or not in %s", strings.Join(from, "/")))
+		return
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, gin.H{"id": id, "status": to}, msg)
+}
+
+func sendPurchaseOrderError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrPurchaseOrderNotFound):
+		utils.SendErrorResponse(c, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrPurchaseOrderStatus),
+		errors.Is(err, ErrSupplierNotFound),
+		errors.Is(err, ErrProductNotFound),
+		errors.Is(err, ErrSeriesNotFound),
+		errors.Is(err, ErrReceiptItemNotOnOrder):
+		utils.SendErrorResponse(c, http.StatusBadRequest, err.Error())
+	default:
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+	}
+}
@@ -0,0 +1,543 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"tulsi-pos/db"
+	"tulsi-pos/services"
+	"tulsi-pos/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// ---------- Request DTOs ----------
+
+type PurchaseReturnItemInput struct {
+	PurchaseInvoiceItemID int64 `json:"purchase_invoice_item_id" binding:"required"`
+	Quantity              int   `json:"quantity" binding:"required,min=1"`
+}
+
+type PurchaseReturnInput struct {
+	Reason string                    `json:"reason"`
+	Items  []PurchaseReturnItemInput `json:"items" binding:"required,min=1,dive"`
+}
+
+var (
+	ErrPurchaseNotFound               = errors.New("purchase invoice not found")
+	ErrPurchaseItemNotFound           = errors.New("purchase invoice item not found")
+	ErrPurchaseReturnQuantityExceeded = errors.New("return quantity exceeds quantity purchased")
+)
+
+// ---------- Public Handlers ----------
+
+// POST /purchases/:id/returns
+// Sends goods back to the supplier: stock goes out, input GST is reversed and
+// a numbered debit note is raised.
+func CreatePurchaseReturn(c *gin.Context) {
+	purchaseID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || purchaseID <= 0 {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid purchase id")
+		return
+	}
+
+	var in PurchaseReturnInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid payload: "+err.Error())
+		return
+	}
+
+	ctx := c.Request.Context()
+	returnID, debitNoteNumber, total, err := createPurchaseReturn(ctx, purchaseID, c.GetInt("user_id"), in)
+	if err != nil {
+		var stockErr *InsufficientStockError
+		switch {
+		case errors.As(err, &stockErr):
+			utils.SendErrorResponseWithData(c, http.StatusConflict, ErrInsufficientStock.Error(), stockErr.Lines)
+		case errors.Is(err, ErrPurchaseNotFound):
+			utils.SendErrorResponse(c, http.StatusNotFound, "purchase invoice not found")
+		case errors.Is(err, ErrPurchaseItemNotFound),
+			errors.Is(err, ErrPurchaseReturnQuantityExceeded):
+			utils.SendErrorResponse(c, http.StatusBadRequest, err.Error())
+		default:
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	if key, err := services.GenerateAndUploadDebitNotePDF(ctx, returnID); err != nil {
+		log.Printf("failed to generate/upload debit note pdf for %d: %v", returnID, err)
+	} else {
+		log.Printf("debit note %d pdf uploaded to %s", returnID, key)
+	}
+
+	utils.SendSuccessResponse(c, http.StatusCreated, gin.H{
+		"return_id":         returnID,
+		"debit_note_number": debitNoteNumber,
+		"total_amount":      total,
+	}, "debit note created")
+}
+
+// GET /purchases/:id/returns
+func ListPurchaseReturns(c *gin.Context) {
+	purchaseID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || purchaseID <= 0 {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid purchase id")
+		return
+	}
+
+	rows, err := db.DB.Query(c.Request.Context(), `
+		SELECT id, debit_note_number, COALESCE(reason, ''), taxable_amount,
+		       total_gst, total_amount, total_quantity, created_at
+		FROM purchase_returns
+		WHERE purchase_invoice_id = $1
+		ORDER BY created_at DESC
+	`, purchaseID)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	resp := []gin.H{}
+	for rows.Next() {
+		var r struct {
+			ID              int64
+			DebitNoteNumber string
+			Reason          string
+			TaxableAmount   float64
+			TotalGST        float64
+			TotalAmount     float64
+			TotalQuantity   int
+			CreatedAt       time.Time
+		}
+		if err := rows.Scan(&r.ID, &r.DebitNoteNumber, &r.Reason, &r.TaxableAmount,
+			&r.TotalGST, &r.TotalAmount, &r.TotalQuantity, &r.CreatedAt); err != nil {
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		resp = append(resp, gin.H{
+			"id":                r.ID,
+			"debit_note_number": r.DebitNoteNumber,
+			"reason":            r.Reason,
+			"taxable_amount":    r.TaxableAmount,
+			"total_gst":         r.TotalGST,
+			"total_amount":      r.TotalAmount,
+			"total_quantity":    r.TotalQuantity,
+			"created_at":        utils.FormatDateTime(r.CreatedAt),
+		})
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, resp, "Debit notes fetched successfully")
+}
+
+// GET /purchases/returns/:id
+func GetPurchaseReturnByID(c *gin.Context) {
+	returnID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || returnID <= 0 {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid return id")
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	var header struct {
+		ID                int64   `json:"id"`
+		DebitNoteNumber   string  `json:"debit_note_number"`
+		PurchaseInvoiceID int64   `json:"purchase_invoice_id"`
+		InvoiceNumber     string  `json:"invoice_number"`
+		SupplierID        int64   `json:"supplier_id"`
+		SupplierName      string  `json:"supplier_name"`
+		Reason            string  `json:"reason"`
+		TaxableAmount     float64 `json:"taxable_amount"`
+		TotalGST          float64 `json:"total_gst"`
+		TotalCGST         float64 `json:"total_cgst"`
+		TotalSGST         float64 `json:"total_sgst"`
+		TotalIGST         float64 `json:"total_igst"`
+		RoundOff          float64 `json:"round_off"`
+		TotalAmount       float64 `json:"total_amount"`
+		TotalQuantity     int     `json:"total_quantity"`
+		DebitNotePDFKey   *string `json:"debit_note_pdf_key"`
+		CreatedAt         string  `json:"created_at"`
+	}
+
+	var createdAt time.Time
+	err = db.DB.QueryRow(ctx, `
+		SELECT pr.id, pr.debit_note_number, pr.purchase_invoice_id, pi.invoice_number,
+		       pr.supplier_id, s.name, COALESCE(pr.reason, ''), pr.taxable_amount, pr.total_gst,
+		       pr.total_cgst, pr.total_sgst, pr.total_igst, pr.round_off,
+		       pr.total_amount, pr.total_quantity, pr.debit_note_pdf_key, pr.created_at
+		FROM purchase_returns pr
+		JOIN purchase_invoices pi ON pi.id = pr.purchase_invoice_id
+		JOIN suppliers s ON s.id = pr.supplier_id
+		WHERE pr.id = $1
+	`, returnID).Scan(
+		&header.ID, &header.DebitNoteNumber, &header.PurchaseInvoiceID, &header.InvoiceNumber,
+		&header.SupplierID, &header.SupplierName, &header.Reason, &header.TaxableAmount, &header.TotalGST,
+		&header.TotalCGST, &header.TotalSGST, &header.TotalIGST, &header.RoundOff,
+		&header.TotalAmount, &header.TotalQuantity, &header.DebitNotePDFKey, &createdAt,
+	)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			utils.SendErrorResponse(c, http.StatusNotFound, "debit note not found")
+			return
+		}
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	header.CreatedAt = utils.FormatDateTime(createdAt)
+
+	rows, err := db.DB.Query(ctx, `
+		SELECT pri.id, pri.purchase_invoice_item_id, p.name, pri.quantity, pri.purchase_price,
+		       pri.taxable_amount, pri.gst_percent, pri.gst_amount,
+		       pri.cgst_amount, pri.sgst_amount, pri.igst_amount, pri.line_total
+		FROM purchase_return_items pri
+		JOIN products p ON p.id = pri.product_id
+		WHERE pri.purchase_return_id = $1
+		ORDER BY pri.id
+	`, returnID)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	items := []gin.H{}
+	for rows.Next() {
+		var it struct {
+			ID                    int64
+			PurchaseInvoiceItemID int64
+			ProductName           string
+			Quantity              int
+			PurchasePrice         float64
+			TaxableAmount         float64
+			GSTPercent            float64
+			GSTAmount             float64
+			CGSTAmount            float64
+			SGSTAmount            float64
+			IGSTAmount            float64
+			LineTotal             float64
+		}
+		if err := rows.Scan(&it.ID, &it.PurchaseInvoiceItemID, &it.ProductName, &it.Quantity,
+			&it.PurchasePrice, &it.TaxableAmount, &it.GSTPercent, &it.GSTAmount,
+			&it.CGSTAmount, &it.SGSTAmount, &it.IGSTAmount, &it.LineTotal); err != nil {
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		items = append(items, gin.H{
+			"id":                       it.ID,
+			"purchase_invoice_item_id": it.PurchaseInvoiceItemID,
+			"product_name":             it.ProductName,
+			"quantity":                 it.Quantity,
+			"purchase_price":           it.PurchasePrice,
+			"taxable_amount":           it.TaxableAmount,
+			"gst_percent":              it.GSTPercent,
+			"gst_amount":               it.GSTAmount,
+			"cgst_amount":              it.CGSTAmount,
+			"sgst_amount":              it.SGSTAmount,
+			"igst_amount":              it.IGSTAmount,
+			"line_total":               it.LineTotal,
+		})
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, gin.H{
+		"debit_note": header,
+		"items":      items,
+	}, "Debit note details fetched successfully")
+}
+
+// POST /purchases/returns/:id/generate-pdf
+func GenerateDebitNotePDF(c *gin.Context) {
+	returnID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || returnID <= 0 {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid return id")
+		return
+	}
+
+	key, err := services.GenerateAndUploadDebitNotePDF(c.Request.Context(), returnID)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, gin.H{"pdf_key": key}, "debit note pdf generated")
+}
+
+// ---------- Internal Logic ----------
+
+// createPurchaseReturn posts a debit note against a purchase invoice. Like
+// credit notes, values are reversed in proportion to the quantity returned
+// and the line that exhausts an invoice item takes the remainder. The goods
+// must still be on hand, whatever the oversell policy.
+func createPurchaseReturn(ctx context.Context, purchaseID int64, userID int, in PurchaseReturnInput) (int64, string, float64, error) {
+	tx, err := db.DB.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, "", 0, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// lock the purchase so concurrent returns against it are serialized
+	var supplierID, purchaseOrderID int64
+	var supplierState string
+	err = tx.QueryRow(ctx, `
+		SELECT supplier_id, COALESCE(supplier_state_code, ''), COALESCE(purchase_order_id, 0)
+		FROM purchase_invoices
+		WHERE id = $1 AND deleted_at IS NULL
+		FOR UPDATE
+	`, purchaseID).Scan(&supplierID, &supplierState, &purchaseOrderID)
+	if err == pgx.ErrNoRows {
+		return 0, "", 0, ErrPurchaseNotFound
+	}
+	if err != nil {
+		return 0, "", 0, fmt.Errorf("load purchase: %w", err)
+	}
+
+	type returnLine struct {
+		PurchaseInvoiceItemID int64
+		ProductID             int64
+		Quantity              int
+		PurchasePrice         float64
+		UnitCost              float64 // landed cost the goods came in at
+		TaxableAmount         float64
+		GSTPercent            float64
+		GSTAmount             float64
+		CGST                  float64
+		SGST                  float64
+		IGST                  float64
+		LineTotal             float64
+	}
+
+	// merge duplicate lines for the same invoice item
+	requested := map[int64]int{}
+	order := []int64{}
+	for _, it := range in.Items {
+		if _, ok := requested[it.PurchaseInvoiceItemID]; !ok {
+			order = append(order, it.PurchaseInvoiceItemID)
+		}
+		requested[it.PurchaseInvoiceItemID] += it.Quantity
+	}
+
+	var lines []returnLine
+	outgoing := map[int64]int{}
+	taxableAmount := 0.0
+	totalGST := 0.0
+	totalCGST, totalSGST, totalIGST := 0.0, 0.0, 0.0
+	totalAmount := 0.0
+	totalQuantity := 0
+
+	for _, itemID := range order {
+		qty := requested[itemID]
+
+		var (
+			productID                          int64
+			boughtQty                          int
+			price, gstPercent, gstAmt, lineTot float64
+			igstAmt, landedCost                float64
+		)
+		err = tx.QueryRow(ctx, `
+			SELECT product_id, quantity, purchase_price, gst_percent, gst_amount,
+			       COALESCE(igst_amount, 0), line_total,
+			       COALESCE(landed_unit_cost, purchase_price)
+			FROM purchase_invoice_items
+			WHERE id = $1 AND purchase_invoice_id = $2
+		`, itemID, purchaseID).Scan(&productID, &boughtQty, &price, &gstPercent, &gstAmt, &igstAmt, &lineTot, &landedCost)
+		if err == pgx.ErrNoRows {
+			return 0, "", 0, fmt.Errorf("%w: %d", ErrPurchaseItemNotFound, itemID)
+		}
+		if err != nil {
+			return 0, "", 0, fmt.Errorf("load purchase item: %w", err)
+		}
+
+		var returnedQty int
+		var returnedTaxable, returnedGST float64
+		err = tx.QueryRow(ctx, `
+			SELECT COALESCE(SUM(quantity), 0),
+			       COALESCE(SUM(taxable_amount), 0),
+			       COALESCE(SUM(gst_amount), 0)
+			FROM purchase_return_items
+			WHERE purchase_invoice_item_id = $1
+		`, itemID).Scan(&returnedQty, &returnedTaxable, &returnedGST)
+		if err != nil {
+			return 0, "", 0, fmt.Errorf("load returned quantity: %w", err)
+		}
+
+		if qty > boughtQty-returnedQty {
+			return 0, "", 0, fmt.Errorf("%w: item %d bought %d, already returned %d, requested %d",
+				ErrPurchaseReturnQuantityExceeded, itemID, boughtQty, returnedQty, qty)
+		}
+
+		lineTaxable := lineTot - gstAmt
+		var taxable, gst float64
+		if returnedQty+qty == boughtQty {
+			taxable = round2(lineTaxable - returnedTaxable)
+			gst = round2(gstAmt - returnedGST)
+		} else {
+			ratio := float64(qty) / float64(boughtQty)
+			taxable = round2(lineTaxable * ratio)
+			gst = round2(gstAmt * ratio)
+		}
+
+		// the debit note follows the tax split of the original purchase
+		cgst, sgst, igst := splitGST(gst, igstAmt > 0 || (gstAmt > 0 && isInterState(supplierState)))
+
+		line := returnLine{
+			PurchaseInvoiceItemID: itemID,
+			ProductID:             productID,
+			Quantity:              qty,
+			PurchasePrice:         price,
+			UnitCost:              landedCost,
+			TaxableAmount:         taxable,
+			GSTPercent:            gstPercent,
+			GSTAmount:             gst,
+			CGST:                  cgst,
+			SGST:                  sgst,
+			IGST:                  igst,
+			LineTotal:             round2(taxable + gst),
+		}
+		lines = append(lines, line)
+		outgoing[productID] += qty
+
+		taxableAmount += line.TaxableAmount
+		totalGST += line.GSTAmount
+		totalCGST += line.CGST
+		totalSGST += line.SGST
+		totalIGST += line.IGST
+		totalAmount += line.LineTotal
+		totalQuantity += line.Quantity
+	}
+
+	shortages, err := checkStock(ctx, tx, outgoing)
+	if err != nil {
+		return 0, "", 0, err
+	}
+	if len(shortages) > 0 {
+		itemProducts := map[int64]int64{}
+		for _, l := range lines {
+			itemProducts[l.PurchaseInvoiceItemID] = l.ProductID
+		}
+		productIDs := make([]int64, len(in.Items))
+		for i, it := range in.Items {
+			productIDs[i] = itemProducts[it.PurchaseInvoiceItemID]
+		}
+		markShortLines(shortages, productIDs)
+		return 0, "", 0, &InsufficientStockError{Lines: shortages}
+	}
+
+	roundedTotal := math.Round(totalAmount)
+	roundOff := round2(roundedTotal - totalAmount)
+	totalAmount = roundedTotal
+
+	debitNoteNumber, err := nextDocumentNumber(ctx, tx, SeriesDebitNote, time.Now())
+	if err != nil {
+		return 0, "", 0, err
+	}
+
+	var returnID int64
+	err = tx.QueryRow(ctx, `
+		INSERT INTO purchase_returns (
+			debit_note_number, purchase_invoice_id, supplier_id, reason,
+			taxable_amount, total_gst, total_cgst, total_sgst, total_igst,
+			round_off, total_amount, total_quantity, created_by
+		) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13)
+		RETURNING id
+	`,
+		debitNoteNumber,
+		purchaseID,
+		supplierID,
+		in.Reason,
+		round2(taxableAmount),
+		round2(totalGST),
+		round2(totalCGST),
+		round2(totalSGST),
+		round2(totalIGST),
+		roundOff,
+		totalAmount,
+		totalQuantity,
+		nullableUserID(userID),
+	).Scan(&returnID)
+	if err != nil {
+		return 0, "", 0, fmt.Errorf("insert purchase return: %w", err)
+	}
+
+	// the goods leave as they came in: on the bill at its landed cost, or on
+	// the order's GRNs at the cost those receipts were valued at
+	from := receiptRef{RefType: RefPurchase, RefIDs: []int64{purchaseID}}
+	if purchaseOrderID > 0 {
+		from.RefType = RefGoodsReceipt
+		from.RefIDs, err = purchaseOrderReceipts(ctx, tx, purchaseOrderID)
+		if err != nil {
+			return 0, "", 0, err
+		}
+	}
+
+	for _, l := range lines {
+		_, err = tx.Exec(ctx, `
+			INSERT INTO purchase_return_items (
+				purchase_return_id, purchase_invoice_item_id, product_id, quantity,
+				purchase_price, taxable_amount, gst_percent, gst_amount,
+				cgst_amount, sgst_amount, igst_amount, line_total
+			) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)
+		`,
+			returnID,
+			l.PurchaseInvoiceItemID,
+			l.ProductID,
+			l.Quantity,
+			l.PurchasePrice,
+			l.TaxableAmount,
+			l.GSTPercent,
+			l.GSTAmount,
+			l.CGST,
+			l.SGST,
+			l.IGST,
+			l.LineTotal,
+		)
+		if err != nil {
+			return 0, "", 0, fmt.Errorf("insert purchase return item: %w", err)
+		}
+
+		// goods go back to the supplier
+		unitCost := l.UnitCost
+		if purchaseOrderID > 0 {
+			unitCost = -1
+		}
+		if _, err := reverseInventoryCost(ctx, tx, l.ProductID, l.Quantity, unitCost, from, RefPurchaseReturn, returnID, userID); err != nil {
+			return 0, "", 0, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, "", 0, fmt.Errorf("commit tx: %w", err)
+	}
+
+	return returnID, debitNoteNumber, totalAmount, nil
+}
+
+// purchaseOrderReceipts lists the goods receipts posted against an order.
+func purchaseOrderReceipts(ctx context.Context, tx pgx.Tx, orderID int64) ([]int64, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT id FROM goods_receipts WHERE purchase_order_id = $1 ORDER BY id
+	`, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("load goods receipts: %w", err)
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
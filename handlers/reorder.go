@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"tulsi-pos/db"
+	"tulsi-pos/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReorderSuggestion is a product at or below its reorder point.
+type ReorderSuggestion struct {
+	ProductID    int64   `json:"product_id"`
+	Name         string  `json:"name"`
+	SKU          string  `json:"sku"`
+	Category     string  `json:"category"`
+	OnHand       int     `json:"on_hand"`
+	OnOrder      int     `json:"on_order"`
+	ReorderPoint int     `json:"reorder_point"`
+	ReorderQty   int     `json:"reorder_qty"`
+	SuggestedQty int     `json:"suggested_qty"`
+	SupplierID   *int64  `json:"supplier_id"`
+	SupplierName string  `json:"supplier_name"`
+	LastPrice    float64 `json:"last_purchase_price"`
+	LastGST      float64 `json:"last_gst_percent"`
+}
+
+// GET /inventory/low-stock?category=
+func GetLowStock(c *gin.Context) {
+	suggestions, err := loadReorderSuggestions(c.Request.Context(), db.DB, c.Query("category"))
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, suggestions, "Low stock products fetched successfully")
+}
+
+// loadReorderSuggestions lists tracked products whose on-hand quantity is at
+// or below the reorder point, with what is already on order and the supplier,
+// price and rate of the last purchase invoice that carried them. Stock already
+// on order counts towards the suggestion, so drafting POs twice does not
+// double the order.
+func loadReorderSuggestions(ctx context.Context, q db.Querier, category string) ([]ReorderSuggestion, error) {
+	where := ""
+	params := []interface{}{}
+	if category != "" {
+		params = append(params, category)
+		where = fmt.Sprintf("AND p.category = $%d", len(params))
+	}
+
+	rows, err := q.Query(ctx, `
+		SELECT p.id, p.name, COALESCE(p.sku, ''), COALESCE(p.category, ''),
+		       COALESCE(ps.on_hand, 0), COALESCE(oo.qty, 0),
+		       p.reorder_point, p.reorder_qty,
+		       last.supplier_id, COALESCE(last.supplier_name, ''),
+		       COALESCE(last.purchase_price, p.purchase_price, 0),
+		       COALESCE(last.gst_percent, p.gst_percent, 0)
+		FROM products p
+		LEFT JOIN product_stock ps ON ps.product_id = p.id
+		LEFT JOIN (
+			SELECT poi.product_id, SUM(GREATEST(poi.quantity - poi.received_qty, 0)) AS qty
+			FROM purchase_order_items poi
+			JOIN purchase_orders po ON po.id = poi.purchase_order_id
+			WHERE po.status IN ('DRAFT', 'SENT', 'PARTIAL') AND po.deleted_at IS NULL
+			GROUP BY poi.product_id
+		) oo ON oo.product_id = p.id
+		LEFT JOIN LATERAL (
+			SELECT pi.supplier_id, s.name AS supplier_name, pii.purchase_price, pii.gst_percent
+			FROM purchase_invoice_items pii
+			JOIN purchase_invoices pi ON pi.id = pii.purchase_invoice_id
+			JOIN suppliers s ON s.id = pi.supplier_id
+			WHERE pii.product_id = p.id AND pi.deleted_at IS NULL AND s.deleted_at IS NULL
+			ORDER BY pi.created_at DESC, pi.id DESC
+			LIMIT 1
+		) last ON TRUE
+		WHERE p.deleted_at IS NULL
+		  AND p.reorder_point > 0
+		  AND COALESCE(ps.on_hand, 0) <= p.reorder_point
+		  `+where+`
+		ORDER BY COALESCE(ps.on_hand, 0) - p.reorder_point, p.name
+	`, params...)
+	if err != nil {
+		return nil, fmt.Errorf("load low stock: %w", err)
+	}
+	defer rows.Close()
+
+	suggestions := []ReorderSuggestion{}
+	for rows.Next() {
+		var s ReorderSuggestion
+		if err := rows.Scan(&s.ProductID, &s.Name, &s.SKU, &s.Category, &s.OnHand, &s.OnOrder,
+			&s.ReorderPoint, &s.ReorderQty, &s.SupplierID, &s.SupplierName,
+			&s.LastPrice, &s.LastGST); err != nil {
+			return nil, err
+		}
+		s.SuggestedQty = suggestedOrderQty(s.OnHand+s.OnOrder, s.ReorderPoint, s.ReorderQty)
+		suggestions = append(suggestions, s)
+	}
+
+	return suggestions, rows.Err()
+}
+
+// suggestedOrderQty is zero once stock plus open orders clear the reorder
+// point; otherwise the fixed reorder quantity, or enough to reach twice the
+// reorder point when none is set.
+func suggestedOrderQty(available, reorderPoint, reorderQty int) int {
+	if available > reorderPoint {
+		return 0
+	}
+	if reorderQty > 0 {
+		return reorderQty
+	}
+	return 2*reorderPoint - available
+}
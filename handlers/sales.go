@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"math"
@@ -10,7 +11,9 @@ import (
 	"strings"
 	"time"
 
+	"tulsi-pos/config"
 	"tulsi-pos/db"
+	"tulsi-pos/middleware"
 	"tulsi-pos/services"
 	"tulsi-pos/utils"
 
@@ -21,32 +24,43 @@ import (
 // ---------- Request DTOs ----------
 
 type InvoiceItemInput struct {
-	ProductID     int64   `json:"product_id" binding:"required"`
-	Quantity      int     `json:"quantity" binding:"required,min=1"`
-	MRP           float64 `json:"mrp"`
-	SalesRate     float64 `json:"sales_rate" binding:"required"`
-	DiscountType  string  `json:"discount_type"`  // "INR" or "%"
-	DiscountValue float64 `json:"discount_value"` // flat or percent
-	GSTPercent    float64 `json:"gst_percent"`
+	ProductID     int64    `json:"product_id" binding:"required"`
+	Quantity      int      `json:"quantity" binding:"required,min=1"`
+	MRP           float64  `json:"mrp"`
+	SalesRate     float64  `json:"sales_rate" binding:"required"`
+	DiscountType  string   `json:"discount_type"`  // "INR" or "%"
+	DiscountValue float64  `json:"discount_value"` // flat or percent
+	GSTPercent    *float64 `json:"gst_percent"`    // omit to use the tax rule for the product
 }
 
 type InvoiceInput struct {
 	CustomerName   string             `json:"customer_name"`
 	CustomerMobile string             `json:"customer_mobile"`
-	PaymentMode    string             `json:"payment_mode"` // cash/card/upi
-	IsConfirmed    bool               `json:"is_confirmed"` // true => INVOICED
+	CustomerGSTIN  string             `json:"customer_gstin"`  // set for B2B sales
+	PaymentMode    string             `json:"payment_mode"`    // cash/card/upi
+	IsConfirmed    bool               `json:"is_confirmed"`    // true => INVOICED
+	Series         string             `json:"series"`          // document series code, defaults to SALES_INVOICE
+	PlaceOfSupply  string             `json:"place_of_supply"` // GST state code, defaults to the store state
 	Items          []InvoiceItemInput `json:"items" binding:"required,min=1"`
+	Payments       []PaymentInput     `json:"payments"` // nil => keep recorded tenders
+	UserID         int                `json:"-"`
+	// AllowRateOverride is set from the caller's gst_rate_override permission.
+	AllowRateOverride bool `json:"-"`
 }
 
 type invoiceMetaDTO struct {
 	CustomerName   string      `json:"customer_name"`
 	CustomerMobile string      `json:"customer_mobile"`
+	CustomerGSTIN  string      `json:"customer_gstin"`
 	PaymentMode    string      `json:"payment_mode"`
 	IsConfirmed    interface{} `json:"is_confirmed"` // can be bool or 0/1 number or "true"/"false"
+	Series         string      `json:"series"`
+	PlaceOfSupply  string      `json:"place_of_supply"`
 }
 type invoiceRequestDTO struct {
-	Invoice invoiceMetaDTO     `json:"invoice"`
-	Items   []InvoiceItemInput `json:"items" binding:"required,min=1"`
+	Invoice  invoiceMetaDTO     `json:"invoice"`
+	Items    []InvoiceItemInput `json:"items" binding:"required,min=1"`
+	Payments []PaymentInput     `json:"payments" binding:"dive"`
 }
 
 // ---------- Public Handlers ----------
@@ -66,10 +80,30 @@ func CreateInvoice(c *gin.Context) {
 		return
 	}
 
+	in.UserID = c.GetInt("user_id")
+	in.AllowRateOverride = middleware.HasPermission(c, middleware.PermGSTRateOverride)
+
 	ctx := c.Request.Context()
 
-	invoiceID, finalStatus, err := upsertInvoice(ctx, nil, in)
+	invoiceID, finalStatus, shortages, err := upsertInvoice(ctx, nil, in)
 	if err != nil {
+		var stockErr *InsufficientStockError
+		if errors.As(err, &stockErr) {
+			utils.SendErrorResponseWithData(c, http.StatusConflict, ErrInsufficientStock.Error(), stockErr.Lines)
+			return
+		}
+		if errors.Is(err, ErrRateOverrideDenied) {
+			utils.SendErrorResponse(c, http.StatusForbidden, err.Error())
+			return
+		}
+		if errors.Is(err, ErrProductNotFound) {
+			utils.SendErrorResponse(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		if errors.Is(err, ErrSeriesNotFound) || errors.Is(err, ErrSeriesWrongType) || isPaymentError(err) {
+			utils.SendErrorResponse(c, http.StatusBadRequest, err.Error())
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -83,10 +117,15 @@ func CreateInvoice(c *gin.Context) {
 		}
 	}
 
-	utils.SendSuccessResponse(c, http.StatusCreated, gin.H{
+	resp := gin.H{
 		"invoice_id":   invoiceID,
 		"final_status": finalStatus,
-	}, "invoice created")
+	}
+	if len(shortages) > 0 {
+		resp["stock_warnings"] = shortages
+	}
+
+	utils.SendSuccessResponse(c, http.StatusCreated, resp, "invoice created")
 }
 
 // PUT /sales/invoices/:id
@@ -110,11 +149,19 @@ func UpdateInvoice(c *gin.Context) {
 		return
 	}
 
+	in.UserID = c.GetInt("user_id")
+	in.AllowRateOverride = middleware.HasPermission(c, middleware.PermGSTRateOverride)
+
 	ctx := c.Request.Context()
 
 	idPtr := &invoiceID
-	updatedID, finalStatus, err := upsertInvoice(ctx, idPtr, in)
+	updatedID, finalStatus, shortages, err := upsertInvoice(ctx, idPtr, in)
 	if err != nil {
+		var stockErr *InsufficientStockError
+		if errors.As(err, &stockErr) {
+			utils.SendErrorResponseWithData(c, http.StatusConflict, ErrInsufficientStock.Error(), stockErr.Lines)
+			return
+		}
 		if err == ErrInvoiceLocked {
 			utils.SendErrorResponse(c, http.StatusBadRequest, "invoice already invoiced, cannot update")
 			return
@@ -123,6 +170,15 @@ func UpdateInvoice(c *gin.Context) {
 			utils.SendErrorResponse(c, http.StatusNotFound, "invoice not found")
 			return
 		}
+		if errors.Is(err, ErrRateOverrideDenied) {
+			utils.SendErrorResponse(c, http.StatusForbidden, err.Error())
+			return
+		}
+		if errors.Is(err, ErrSeriesNotFound) || errors.Is(err, ErrSeriesWrongType) ||
+			errors.Is(err, ErrProductNotFound) || isPaymentError(err) {
+			utils.SendErrorResponse(c, http.StatusBadRequest, err.Error())
+			return
+		}
 		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -135,10 +191,15 @@ func UpdateInvoice(c *gin.Context) {
 		}
 	}
 
-	utils.SendSuccessResponse(c, http.StatusOK, gin.H{
+	resp := gin.H{
 		"invoice_id":   updatedID,
 		"final_status": finalStatus,
-	}, "invoice updated")
+	}
+	if len(shortages) > 0 {
+		resp["stock_warnings"] = shortages
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, resp, "invoice updated")
 }
 
 // ---------- Internal Logic ----------
@@ -153,8 +214,32 @@ func convertRequestToInvoiceInput(r invoiceRequestDTO) (InvoiceInput, error) {
 	in := InvoiceInput{
 		CustomerName:   r.Invoice.CustomerName,
 		CustomerMobile: r.Invoice.CustomerMobile,
+		CustomerGSTIN:  strings.ToUpper(strings.TrimSpace(r.Invoice.CustomerGSTIN)),
 		PaymentMode:    r.Invoice.PaymentMode,
+		Series:         r.Invoice.Series,
+		PlaceOfSupply:  strings.TrimSpace(r.Invoice.PlaceOfSupply),
 		Items:          r.Items,
+		Payments:       r.Payments,
+	}
+
+	if in.CustomerGSTIN != "" {
+		if !utils.IsValidGSTIN(in.CustomerGSTIN) {
+			return in, fmt.Errorf("invalid customer_gstin %q", in.CustomerGSTIN)
+		}
+		// a registered buyer's place of supply is its state of registration
+		if in.PlaceOfSupply == "" {
+			in.PlaceOfSupply = in.CustomerGSTIN[:2]
+		}
+	}
+
+	if in.PlaceOfSupply != "" && !utils.IsValidStateCode(in.PlaceOfSupply) {
+		return in, fmt.Errorf("invalid place_of_supply %q", in.PlaceOfSupply)
+	}
+
+	for i := range in.Payments {
+		if err := normalizePayment(&in.Payments[i]); err != nil {
+			return in, err
+		}
 	}
 
 	// parse IsConfirmed (supports bool, number, string)
@@ -189,10 +274,13 @@ func parseBoolish(v interface{}) bool {
 // shared function used by both create & update
 // invoiceID == nil  → create
 // invoiceID != nil  → update
-func upsertInvoice(ctx context.Context, invoiceID *int64, in InvoiceInput) (int64, string, error) {
+// upsertInvoice saves a draft or confirms an invoice. When the invoice is
+// confirmed under the warn oversell policy, the lines sold beyond stock are
+// returned alongside the id and status.
+func upsertInvoice(ctx context.Context, invoiceID *int64, in InvoiceInput) (int64, string, []StockShortage, error) {
 	tx, err := db.DB.BeginTx(ctx, pgx.TxOptions{})
 	if err != nil {
-		return 0, "", fmt.Errorf("begin tx: %w", err)
+		return 0, "", nil, fmt.Errorf("begin tx: %w", err)
 	}
 	defer tx.Rollback(ctx)
 
@@ -203,7 +291,9 @@ func upsertInvoice(ctx context.Context, invoiceID *int64, in InvoiceInput) (int6
 	fmt.Println("in.PaymentMode", in.PaymentMode)
 	fmt.Println("invoiceID", invoiceID)
 
-	// If update, load existing status
+	// If update, load existing status. The row stays locked until tx ends,
+	// so two confirms of one draft cannot both allocate a number or move
+	// stock; the second waits and then finds it INVOICED.
 	var existingStatus string
 	var id int64
 	if invoiceID != nil {
@@ -211,61 +301,71 @@ func upsertInvoice(ctx context.Context, invoiceID *int64, in InvoiceInput) (int6
 			SELECT id, status
 			FROM sales_invoices
 			WHERE id = $1 AND deleted_at IS NULL
+			FOR UPDATE
 		`, *invoiceID).Scan(&id, &existingStatus)
 
 		if err == pgx.ErrNoRows {
-			return 0, "", ErrInvoiceNotFound
+			return 0, "", nil, ErrInvoiceNotFound
 		}
 		if err != nil {
-			return 0, "", fmt.Errorf("load invoice: %w", err)
+			return 0, "", nil, fmt.Errorf("load invoice: %w", err)
 		}
 		if existingStatus == "INVOICED" {
-			return 0, "", ErrInvoiceLocked
+			return 0, "", nil, ErrInvoiceLocked
 		}
 	} else {
 		id = 0
 		existingStatus = "DRAFT"
 	}
 
+	placeOfSupply := in.PlaceOfSupply
+	if placeOfSupply == "" {
+		placeOfSupply = config.StoreStateCode
+	}
+	interState := isInterState(placeOfSupply)
+
 	// Compute totals
 	totalAmountBeforeDiscount := 0.0
 	totalDiscount := 0.0
 	taxableAmount := 0.0
 	totalGST := 0.0
+	totalCGST, totalSGST, totalIGST := 0.0, 0.0, 0.0
 	totalInvoiceAmount := 0.0
 	totalItems := len(in.Items)
 	totalQuantity := 0
 
+	// GST rates come from the tax rules for each product's HSN code, picked
+	// on the net taxable value per unit; a different rate sent by the client
+	// is an override and needs permission.
+	lines := make([]invoiceLine, 0, len(in.Items))
 	for _, it := range in.Items {
-		gross := float64(it.Quantity) * it.SalesRate
-		totalAmountBeforeDiscount += gross
-		totalQuantity += it.Quantity
+		l := computeInvoiceLine(it)
 
-		discAmount := 0.0
-		switch it.DiscountType {
-		case "%", "PCT", "PERCENT":
-			discAmount = gross * it.DiscountValue / 100.0
-		case "INR", "", "FLAT":
-			discAmount = it.DiscountValue
-		default:
-			discAmount = it.DiscountValue
-		}
-		if discAmount < 0 {
-			discAmount = 0
+		rate, err := productGSTPercent(ctx, tx, it.ProductID, l.Taxable/float64(it.Quantity), time.Now())
+		if err != nil {
+			return 0, "", nil, err
 		}
-		totalDiscount += discAmount
-
-		taxable := gross - discAmount
-		if taxable < 0 {
-			taxable = 0
+		if it.GSTPercent != nil && math.Abs(*it.GSTPercent-rate) > 0.001 {
+			if !in.AllowRateOverride {
+				return 0, "", nil, fmt.Errorf("%w: product %d rule rate %.2f, requested %.2f",
+					ErrRateOverrideDenied, it.ProductID, rate, *it.GSTPercent)
+			}
+			rate = *it.GSTPercent
+			l.GSTOverridden = true
 		}
-		taxableAmount += taxable
 
-		gstAmount := taxable * it.GSTPercent / 100.0
-		totalGST += gstAmount
+		l.applyGST(rate, interState)
+		lines = append(lines, l)
 
-		lineTotal := taxable + gstAmount
-		totalInvoiceAmount += lineTotal
+		totalAmountBeforeDiscount += l.Gross
+		totalQuantity += it.Quantity
+		totalDiscount += l.DiscountAmount
+		taxableAmount += l.Taxable
+		totalGST += l.GSTAmount
+		totalCGST += l.CGST
+		totalSGST += l.SGST
+		totalIGST += l.IGST
+		totalInvoiceAmount += l.LineTotal
 	}
 
 	roundedTotal := math.Round(totalInvoiceAmount)
@@ -279,10 +379,25 @@ func upsertInvoice(ctx context.Context, invoiceID *int64, in InvoiceInput) (int6
 
 	now := time.Now()
 
+	// Drafts carry no number; one is taken from the series only when the
+	// invoice turns INVOICED so the issued sequence stays gap-free.
+	var invoiceNumber *string
+	var invoicedAt *time.Time
+	if finalStatus == "INVOICED" {
+		series := in.Series
+		if series == "" {
+			series = SeriesSalesInvoice
+		}
+		number, err := allocateDocumentNumber(ctx, tx, SeriesSalesInvoice, series, now)
+		if err != nil {
+			return 0, "", nil, err
+		}
+		invoiceNumber = &number
+		invoicedAt = &now
+	}
+
 	// Insert or update invoice header
 	if invoiceID == nil {
-		invoiceNumber := fmt.Sprintf("INV%s%04d", now.Format("20060102"), now.UnixNano()%10000)
-
 		err = tx.QueryRow(ctx, `
 			INSERT INTO sales_invoices (
 				invoice_number,
@@ -299,12 +414,20 @@ func upsertInvoice(ctx context.Context, invoiceID *int64, in InvoiceInput) (int6
 				total_invoice_amount,
 				total_items,
 				total_quantity,
-				payment_mode
+				payment_mode,
+				invoiced_at,
+				place_of_supply,
+				total_cgst,
+				total_sgst,
+				total_igst,
+				customer_gstin
 			) VALUES (
 				$1,$2,$3,$4,
 				$5,$6,$7,$8,
 				$9,$10,$11,$12,
-				$13,$14,$15
+				$13,$14,$15,$16,
+				$17,$18,$19,$20,
+				NULLIF($21, '')
 			)
 			RETURNING id
 		`,
@@ -323,9 +446,15 @@ func upsertInvoice(ctx context.Context, invoiceID *int64, in InvoiceInput) (int6
 			totalItems,
 			totalQuantity,
 			in.PaymentMode,
+			invoicedAt,
+			placeOfSupply,
+			totalCGST,
+			totalSGST,
+			totalIGST,
+			in.CustomerGSTIN,
 		).Scan(&id)
 		if err != nil {
-			return 0, "", fmt.Errorf("insert invoice: %w", err)
+			return 0, "", nil, fmt.Errorf("insert invoice: %w", err)
 		}
 	} else {
 		// update header
@@ -345,7 +474,14 @@ func upsertInvoice(ctx context.Context, invoiceID *int64, in InvoiceInput) (int6
 			    total_items = $12,
 			    total_quantity = $13,
 			    payment_mode = $14,
-			    updated_at = $15
+			    updated_at = $15,
+			    invoice_number = COALESCE($17, invoice_number),
+			    invoiced_at = COALESCE($18, invoiced_at),
+			    place_of_supply = $19,
+			    total_cgst = $20,
+			    total_sgst = $21,
+			    total_igst = $22,
+			    customer_gstin = NULLIF($23, '')
 			WHERE id = $16 AND deleted_at IS NULL
 		`,
 			in.CustomerName,
@@ -364,9 +500,16 @@ func upsertInvoice(ctx context.Context, invoiceID *int64, in InvoiceInput) (int6
 			in.PaymentMode,
 			now,
 			id,
+			invoiceNumber,
+			invoicedAt,
+			placeOfSupply,
+			totalCGST,
+			totalSGST,
+			totalIGST,
+			in.CustomerGSTIN,
 		)
 		if err != nil {
-			return 0, "", fmt.Errorf("update invoice: %w", err)
+			return 0, "", nil, fmt.Errorf("update invoice: %w", err)
 		}
 
 		// soft delete old items
@@ -376,35 +519,16 @@ func upsertInvoice(ctx context.Context, invoiceID *int64, in InvoiceInput) (int6
 			WHERE sales_invoice_id = $2 AND deleted_at IS NULL
 		`, now, id)
 		if err != nil {
-			return 0, "", fmt.Errorf("soft delete old items: %w", err)
+			return 0, "", nil, fmt.Errorf("soft delete old items: %w", err)
 		}
 	}
 
 	// Insert new items
-	for _, it := range in.Items {
-		gross := float64(it.Quantity) * it.SalesRate
+	itemIDs := make([]int64, len(lines))
+	for i, l := range lines {
+		it := l.Item
 
-		discAmount := 0.0
-		switch it.DiscountType {
-		case "%", "PCT", "PERCENT":
-			discAmount = gross * it.DiscountValue / 100.0
-		case "INR", "", "FLAT":
-			discAmount = it.DiscountValue
-		default:
-			discAmount = it.DiscountValue
-		}
-		if discAmount < 0 {
-			discAmount = 0
-		}
-
-		taxable := gross - discAmount
-		if taxable < 0 {
-			taxable = 0
-		}
-		gstAmount := taxable * it.GSTPercent / 100.0
-		lineTotal := taxable + gstAmount
-
-		_, err = tx.Exec(ctx, `
+		err = tx.QueryRow(ctx, `
 			INSERT INTO sales_invoice_items (
 				sales_invoice_id,
 				product_id,
@@ -416,10 +540,15 @@ func upsertInvoice(ctx context.Context, invoiceID *int64, in InvoiceInput) (int6
 				discount_amount,
 				gst_percent,
 				gst_amount,
-				line_total
+				cgst_amount,
+				sgst_amount,
+				igst_amount,
+				line_total,
+				gst_overridden
 			) VALUES (
-				$1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11
+				$1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15
 			)
+			RETURNING id
 		`,
 			id,
 			it.ProductID,
@@ -428,41 +557,160 @@ func upsertInvoice(ctx context.Context, invoiceID *int64, in InvoiceInput) (int6
 			it.SalesRate,
 			it.DiscountType,
 			it.DiscountValue,
-			discAmount,
-			it.GSTPercent,
-			gstAmount,
-			lineTotal,
-		)
+			l.DiscountAmount,
+			l.GSTPercent,
+			l.GSTAmount,
+			l.CGST,
+			l.SGST,
+			l.IGST,
+			l.LineTotal,
+			l.GSTOverridden,
+		).Scan(&itemIDs[i])
+		if err != nil {
+			return 0, "", nil, fmt.Errorf("insert item: %w", err)
+		}
+	}
+
+	// Tenders: an explicit list replaces what was recorded. Older clients that
+	// only send payment_mode are treated as paying the full amount in that mode.
+	if in.Payments != nil {
+		if err := replaceInvoicePayments(ctx, tx, id, in.Payments, in.UserID); err != nil {
+			return 0, "", nil, err
+		}
+	} else if finalStatus == "INVOICED" && in.PaymentMode != "" {
+		var recorded int
+		err = tx.QueryRow(ctx, `
+			SELECT COUNT(*)
+			FROM sales_invoice_payments
+			WHERE sales_invoice_id = $1 AND deleted_at IS NULL
+		`, id).Scan(&recorded)
 		if err != nil {
-			return 0, "", fmt.Errorf("insert item: %w", err)
+			return 0, "", nil, fmt.Errorf("load payments: %w", err)
+		}
+		if recorded == 0 {
+			legacy := PaymentInput{PaymentMode: in.PaymentMode, Amount: totalInvoiceAmount}
+			if err := normalizePayment(&legacy); err != nil {
+				return 0, "", nil, err
+			}
+			if err := insertInvoicePayment(ctx, tx, id, legacy, in.UserID); err != nil {
+				return 0, "", nil, err
+			}
 		}
 	}
 
-	// If INVOICED -> create inventory transactions
+	summary, err := refreshInvoicePayments(ctx, tx, id, totalInvoiceAmount)
+	if err != nil {
+		return 0, "", nil, err
+	}
+	if finalStatus == "INVOICED" && math.Abs(summary.BalanceDue) > 0.005 {
+		return 0, "", nil, fmt.Errorf("%w: total %.2f, paid %.2f, balance %.2f",
+			ErrPaymentIncomplete, totalInvoiceAmount, summary.AmountPaid, summary.BalanceDue)
+	}
+
+	// If INVOICED -> check stock and create inventory transactions
+	var shortages []StockShortage
 	if finalStatus == "INVOICED" {
-		for _, it := range in.Items {
-			// negative quantity for sale
+		requested := map[int64]int{}
+		productIDs := make([]int64, len(in.Items))
+		for i, it := range in.Items {
+			requested[it.ProductID] += it.Quantity
+			productIDs[i] = it.ProductID
+		}
+		if config.OversellPolicy == config.OversellAllow {
+			if err := lockStockRows(ctx, tx, productIDs); err != nil {
+				return 0, "", nil, err
+			}
+		} else {
+			shortages, err = checkStock(ctx, tx, requested)
+			if err != nil {
+				return 0, "", nil, err
+			}
+			markShortLines(shortages, productIDs)
+			if len(shortages) > 0 && config.OversellPolicy == config.OversellBlock {
+				return 0, "", nil, &InsufficientStockError{Lines: shortages}
+			}
+		}
+
+		for i, l := range lines {
+			// negative quantity for sale; the cost it leaves stock at is the line's COGS
+			p, err := postInventoryCost(ctx, tx, l.Item.ProductID, -l.Item.Quantity, -1, RefSale, id, in.UserID)
+			if err != nil {
+				return 0, "", nil, err
+			}
 			_, err = tx.Exec(ctx, `
-				INSERT INTO inventory_transactions (
-					product_id, quantity, ref_type, ref_id
-				) VALUES ($1, $2, $3, $4)
-			`,
-				it.ProductID,
-				-it.Quantity,
-				"sale",
-				id,
-			)
+				UPDATE sales_invoice_items SET unit_cost = $1, cogs = $2 WHERE id = $3
+			`, roundCost(p.UnitCost), -p.Value, itemIDs[i])
 			if err != nil {
-				return 0, "", fmt.Errorf("insert inventory transaction: %w", err)
+				return 0, "", nil, fmt.Errorf("record cogs: %w", err)
 			}
 		}
 	}
 
 	if err := tx.Commit(ctx); err != nil {
-		return 0, "", fmt.Errorf("commit tx: %w", err)
+		return 0, "", nil, fmt.Errorf("commit tx: %w", err)
 	}
 
-	return id, finalStatus, nil
+	return id, finalStatus, shortages, nil
+}
+
+// invoiceLine holds the computed values of one invoice item.
+type invoiceLine struct {
+	Item           InvoiceItemInput
+	Gross          float64
+	DiscountAmount float64
+	Taxable        float64
+	GSTPercent     float64
+	GSTOverridden  bool
+	GSTAmount      float64
+	CGST           float64
+	SGST           float64
+	IGST           float64
+	LineTotal      float64
+}
+
+// computeInvoiceLine works out the gross, discount and taxable value of an
+// item; tax is added afterwards by applyGST once the rate is known.
+func computeInvoiceLine(it InvoiceItemInput) invoiceLine {
+	gross := float64(it.Quantity) * it.SalesRate
+	discAmount := discountAmount(gross, it.DiscountType, it.DiscountValue)
+
+	taxable := gross - discAmount
+	if taxable < 0 {
+		taxable = 0
+	}
+
+	return invoiceLine{
+		Item:           it,
+		Gross:          gross,
+		DiscountAmount: discAmount,
+		Taxable:        taxable,
+		LineTotal:      taxable,
+	}
+}
+
+// discountAmount turns a flat ("INR") or percent ("%") discount into an
+// amount off gross.
+func discountAmount(gross float64, discountType string, value float64) float64 {
+	amount := 0.0
+	switch discountType {
+	case "%", "PCT", "PERCENT":
+		amount = gross * value / 100.0
+	case "INR", "", "FLAT":
+		amount = value
+	default:
+		amount = value
+	}
+	if amount < 0 {
+		amount = 0
+	}
+	return amount
+}
+
+func (l *invoiceLine) applyGST(gstPercent float64, interState bool) {
+	l.GSTPercent = gstPercent
+	l.GSTAmount = l.Taxable * gstPercent / 100.0
+	l.CGST, l.SGST, l.IGST = splitGST(l.GSTAmount, interState)
+	l.LineTotal = l.Taxable + l.GSTAmount
 }
 
 func normalizeDiscountType(items []InvoiceItemInput) string {
@@ -488,35 +736,50 @@ func GetInvoiceByID(c *gin.Context) {
 
 	var header struct {
 		ID                        int64   `json:"id"`
-		InvoiceNumber             string  `json:"invoice_number"`
+		InvoiceNumber             *string `json:"invoice_number"`
 		CustomerName              string  `json:"customer_name"`
 		CustomerMobile            string  `json:"customer_mobile"`
+		CustomerGSTIN             string  `json:"customer_gstin"`
 		Status                    string  `json:"status"`
 		TotalAmountBeforeDiscount float64 `json:"total_amount_before_discount"`
 		TotalDiscount             float64 `json:"total_discount"`
 		TaxableAmount             float64 `json:"taxable_amount"`
 		TotalGST                  float64 `json:"total_gst"`
+		TotalCGST                 float64 `json:"total_cgst"`
+		TotalSGST                 float64 `json:"total_sgst"`
+		TotalIGST                 float64 `json:"total_igst"`
+		PlaceOfSupply             string  `json:"place_of_supply"`
 		TotalInvoiceAmount        float64 `json:"total_invoice_amount"`
 		PaymentMode               string  `json:"payment_mode"`
+		AmountPaid                float64 `json:"amount_paid"`
+		ChangeDue                 float64 `json:"change_due"`
 		CreatedAt                 string  `json:"created_at"`
+		InvoicedAt                string  `json:"invoiced_at"`
 		InvoicePDFKey             *string `json:"invoice_pdf_key"`
 	}
 
 	var createdAt time.Time
+	var invoicedAt *time.Time
 	err = db.DB.QueryRow(ctx, `
-		SELECT id, invoice_number, customer_name, customer_mobile, status,
+		SELECT id, invoice_number, customer_name, customer_mobile,
+		       COALESCE(customer_gstin, ''), status,
 		       total_amount_before_discount, total_discount, taxable_amount,
-		       total_gst, total_invoice_amount, payment_mode,
-		       created_at, invoice_pdf_key
+		       total_gst, COALESCE(total_cgst, 0), COALESCE(total_sgst, 0),
+		       COALESCE(total_igst, 0), COALESCE(place_of_supply, ''),
+		       total_invoice_amount, payment_mode,
+		       amount_paid, change_due,
+		       created_at, invoice_pdf_key, invoiced_at
 		FROM sales_invoices
 		WHERE id = $1 AND deleted_at IS NULL
 	`, invoiceID).Scan(
 		&header.ID, &header.InvoiceNumber, &header.CustomerName,
-		&header.CustomerMobile, &header.Status,
+		&header.CustomerMobile, &header.CustomerGSTIN, &header.Status,
 		&header.TotalAmountBeforeDiscount, &header.TotalDiscount,
 		&header.TaxableAmount, &header.TotalGST,
+		&header.TotalCGST, &header.TotalSGST, &header.TotalIGST, &header.PlaceOfSupply,
 		&header.TotalInvoiceAmount, &header.PaymentMode,
-		&createdAt, &header.InvoicePDFKey,
+		&header.AmountPaid, &header.ChangeDue,
+		&createdAt, &header.InvoicePDFKey, &invoicedAt,
 	)
 	if err != nil {
 		if err == pgx.ErrNoRows {
@@ -527,10 +790,18 @@ func GetInvoiceByID(c *gin.Context) {
 		return
 	}
 	header.CreatedAt = utils.FormatDateTime(createdAt)
+	if invoicedAt != nil {
+		header.InvoicedAt = utils.FormatDateTime(*invoicedAt)
+	}
 
 	rows, err := db.DB.Query(ctx, `
 		SELECT sii.id, p.name, sii.quantity, sii.sales_rate, sii.discount_amount,
-		       sii.gst_percent, sii.gst_amount, sii.line_total
+		       sii.gst_percent, sii.gst_amount,
+		       COALESCE(sii.cgst_amount, 0), COALESCE(sii.sgst_amount, 0), COALESCE(sii.igst_amount, 0),
+		       sii.line_total,
+		       (SELECT COALESCE(SUM(sri.quantity), 0)
+		        FROM sales_return_items sri
+		        WHERE sri.sales_invoice_item_id = sii.id) AS returned_quantity
 		FROM sales_invoice_items sii
 		JOIN products p ON p.id = sii.product_id
 		WHERE sii.sales_invoice_id = $1 AND sii.deleted_at IS NULL
@@ -551,12 +822,17 @@ func GetInvoiceByID(c *gin.Context) {
 			DiscountAmount float64
 			GSTPercent     float64
 			GSTAmount      float64
+			CGSTAmount     float64
+			SGSTAmount     float64
+			IGSTAmount     float64
 			LineTotal      float64
+			ReturnedQty    int
 		}
 
 		_ = rows.Scan(&it.ID, &it.ProductName, &it.Quantity,
 			&it.SalesRate, &it.DiscountAmount, &it.GSTPercent,
-			&it.GSTAmount, &it.LineTotal,
+			&it.GSTAmount, &it.CGSTAmount, &it.SGSTAmount, &it.IGSTAmount,
+			&it.LineTotal, &it.ReturnedQty,
 		)
 
 		items = append(items, gin.H{
@@ -567,13 +843,31 @@ func GetInvoiceByID(c *gin.Context) {
 			"discount_amount": it.DiscountAmount,
 			"gst_percent":     it.GSTPercent,
 			"gst_amount":      it.GSTAmount,
+			"cgst_amount":     it.CGSTAmount,
+			"sgst_amount":     it.SGSTAmount,
+			"igst_amount":     it.IGSTAmount,
 			"line_total":      it.LineTotal,
+			"returned_qty":    it.ReturnedQty,
 		})
 	}
 
+	payments, err := loadInvoicePayments(ctx, db.DB, invoiceID)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	hsnSummary, err := services.LoadInvoiceHSNSummary(ctx, db.DB, invoiceID)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
 	utils.SendSuccessResponse(c, http.StatusOK, gin.H{
-		"invoice": header,
-		"items":   items,
+		"invoice":     header,
+		"items":       items,
+		"payments":    payments,
+		"hsn_summary": hsnSummary,
 	}, "Invoice details fetched successfully")
 }
 
@@ -631,7 +925,7 @@ func ListInvoices(c *gin.Context) {
 	for rows.Next() {
 		var r struct {
 			ID                 int64
-			InvoiceNumber      string
+			InvoiceNumber      *string
 			CustomerName       string
 			CustomerMobile     string
 			Status             string
@@ -0,0 +1,397 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"tulsi-pos/db"
+	"tulsi-pos/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// ---------- Request DTOs ----------
+
+type PaymentInput struct {
+	PaymentMode string  `json:"payment_mode" binding:"required"` // CASH, CARD, UPI
+	Amount      float64 `json:"amount" binding:"required,gt=0"`  // amount tendered
+	Reference   string  `json:"reference"`                       // UPI txn id / card last-4
+}
+
+type paymentSummary struct {
+	TotalInvoiceAmount float64 `json:"total_invoice_amount"`
+	Tendered           float64 `json:"tendered"`
+	AmountPaid         float64 `json:"amount_paid"`
+	ChangeDue          float64 `json:"change_due"`
+	BalanceDue         float64 `json:"balance_due"`
+}
+
+var (
+	ErrInvalidPaymentMode = errors.New("payment_mode must be CASH, CARD or UPI")
+	ErrInvalidCardRef     = errors.New("card reference must be the last 4 digits")
+	ErrNonCashOverpaid    = errors.New("card/UPI tenders exceed the invoice total")
+	ErrPaymentIncomplete  = errors.New("tenders do not cover the invoice total")
+	ErrPaymentNotFound    = errors.New("payment not found")
+)
+
+var cardLast4 = regexp.MustCompile(`^[0-9]{4}$`)
+
+// ---------- Public Handlers ----------
+
+// GET /sales/invoices/:id/payments
+func ListInvoicePayments(c *gin.Context) {
+	invoiceID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || invoiceID <= 0 {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid invoice id")
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	var total, paid, change float64
+	err = db.DB.QueryRow(ctx, `
+		SELECT total_invoice_amount, amount_paid, change_due
+		FROM sales_invoices
+		WHERE id = $1 AND deleted_at IS NULL
+	`, invoiceID).Scan(&total, &paid, &change)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			utils.SendErrorResponse(c, http.StatusNotFound, "invoice not found")
+			return
+		}
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	payments, err := loadInvoicePayments(ctx, db.DB, invoiceID)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	tendered := 0.0
+	for _, p := range payments {
+		tendered += p["amount"].(float64)
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, gin.H{
+		"payments": payments,
+		"summary": paymentSummary{
+			TotalInvoiceAmount: total,
+			Tendered:           round2(tendered),
+			AmountPaid:         paid,
+			ChangeDue:          change,
+			BalanceDue:         round2(total - paid),
+		},
+	}, "Payments fetched successfully")
+}
+
+// POST /sales/invoices/:id/payments
+// Tenders can only be added while the invoice is a DRAFT; confirming it then
+// requires the tenders to settle the total exactly (after change on cash).
+func AddInvoicePayment(c *gin.Context) {
+	invoiceID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || invoiceID <= 0 {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid invoice id")
+		return
+	}
+
+	var in PaymentInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid payload: "+err.Error())
+		return
+	}
+	if err := normalizePayment(&in); err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	summary, err := changeDraftPayments(c.Request.Context(), invoiceID, func(ctx context.Context, tx pgx.Tx) error {
+		return insertInvoicePayment(ctx, tx, invoiceID, in, c.GetInt("user_id"))
+	})
+	if err != nil {
+		sendPaymentError(c, err)
+		return
+	}
+
+	utils.SendSuccessResponse(c, http.StatusCreated, summary, "payment recorded")
+}
+
+// DELETE /sales/invoices/:id/payments/:paymentId
+func DeleteInvoicePayment(c *gin.Context) {
+	invoiceID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || invoiceID <= 0 {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid invoice id")
+		return
+	}
+	paymentID, err := strconv.ParseInt(c.Param("paymentId"), 10, 64)
+	if err != nil || paymentID <= 0 {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid payment id")
+		return
+	}
+
+	summary, err := changeDraftPayments(c.Request.Context(), invoiceID, func(ctx context.Context, tx pgx.Tx) error {
+		res, err := tx.Exec(ctx, `
+			UPDATE sales_invoice_payments
+			SET deleted_at = NOW()
+			WHERE id = $1 AND sales_invoice_id = $2 AND deleted_at IS NULL
+		`, paymentID, invoiceID)
+		if err != nil {
+			return fmt.Errorf("delete payment: %w", err)
+		}
+		if res.RowsAffected() == 0 {
+			return ErrPaymentNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		sendPaymentError(c, err)
+		return
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, summary, "payment removed")
+}
+
+// ---------- Internal Logic ----------
+
+func sendPaymentError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrInvoiceNotFound), errors.Is(err, ErrPaymentNotFound):
+		utils.SendErrorResponse(c, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrInvoiceLocked):
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invoice already invoiced, payments cannot change")
+	case errors.Is(err, ErrNonCashOverpaid):
+		utils.SendErrorResponse(c, http.StatusBadRequest, err.Error())
+	default:
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func isPaymentError(err error) bool {
+	return errors.Is(err, ErrInvalidPaymentMode) ||
+		errors.Is(err, ErrInvalidCardRef) ||
+		errors.Is(err, ErrNonCashOverpaid) ||
+		errors.Is(err, ErrPaymentIncomplete)
+}
+
+// changeDraftPayments runs fn against a locked DRAFT invoice and re-settles
+// its tenders afterwards.
+func changeDraftPayments(ctx context.Context, invoiceID int64, fn func(context.Context, pgx.Tx) error) (paymentSummary, error) {
+	tx, err := db.DB.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return paymentSummary{}, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var status string
+	var total float64
+	err = tx.QueryRow(ctx, `
+		SELECT status, total_invoice_amount
+		FROM sales_invoices
+		WHERE id = $1 AND deleted_at IS NULL
+		FOR UPDATE
+	`, invoiceID).Scan(&status, &total)
+	if err == pgx.ErrNoRows {
+		return paymentSummary{}, ErrInvoiceNotFound
+	}
+	if err != nil {
+		return paymentSummary{}, fmt.Errorf("load invoice: %w", err)
+	}
+	if status == "INVOICED" {
+		return paymentSummary{}, ErrInvoiceLocked
+	}
+
+	if err := fn(ctx, tx); err != nil {
+		return paymentSummary{}, err
+	}
+
+	summary, err := refreshInvoicePayments(ctx, tx, invoiceID, total)
+	if err != nil {
+		return paymentSummary{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return paymentSummary{}, fmt.Errorf("commit tx: %w", err)
+	}
+
+	return summary, nil
+}
+
+// normalizePayment upper-cases the mode and validates the reference.
+func normalizePayment(p *PaymentInput) error {
+	p.PaymentMode = strings.ToUpper(strings.TrimSpace(p.PaymentMode))
+	p.Reference = strings.TrimSpace(p.Reference)
+
+	switch p.PaymentMode {
+	case "CASH", "UPI":
+	case "CARD":
+		if p.Reference != "" && !cardLast4.MatchString(p.Reference) {
+			return ErrInvalidCardRef
+		}
+	default:
+		return ErrInvalidPaymentMode
+	}
+	return nil
+}
+
+func insertInvoicePayment(ctx context.Context, tx pgx.Tx, invoiceID int64, p PaymentInput, userID int) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO sales_invoice_payments (
+			sales_invoice_id, payment_mode, amount, reference, created_by
+		) VALUES ($1, $2, $3, $4, $5)
+	`, invoiceID, p.PaymentMode, round2(p.Amount), p.Reference, nullableUserID(userID))
+	if err != nil {
+		return fmt.Errorf("insert payment: %w", err)
+	}
+	return nil
+}
+
+// replaceInvoicePayments swaps the tenders of an invoice for the given set.
+func replaceInvoicePayments(ctx context.Context, tx pgx.Tx, invoiceID int64, payments []PaymentInput, userID int) error {
+	_, err := tx.Exec(ctx, `
+		UPDATE sales_invoice_payments
+		SET deleted_at = $1
+		WHERE sales_invoice_id = $2 AND deleted_at IS NULL
+	`, time.Now(), invoiceID)
+	if err != nil {
+		return fmt.Errorf("soft delete old payments: %w", err)
+	}
+
+	for _, p := range payments {
+		if err := insertInvoicePayment(ctx, tx, invoiceID, p, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// settleTenders works out how much of the tendered money is applied to the
+// invoice. Card and UPI are charged exactly, so only cash can be over-tendered;
+// the excess is returned as change.
+func settleTenders(total float64, modes []string, amounts []float64) (paymentSummary, error) {
+	tendered, cash := 0.0, 0.0
+	for i, amt := range amounts {
+		tendered += amt
+		if modes[i] == "CASH" {
+			cash += amt
+		}
+	}
+
+	if tendered-cash > total+0.005 {
+		return paymentSummary{}, fmt.Errorf("%w: non-cash %.2f, total %.2f", ErrNonCashOverpaid, tendered-cash, total)
+	}
+
+	change := math.Max(0, tendered-total)
+	paid := tendered - change
+
+	return paymentSummary{
+		TotalInvoiceAmount: total,
+		Tendered:           round2(tendered),
+		AmountPaid:         round2(paid),
+		ChangeDue:          round2(change),
+		BalanceDue:         round2(total - paid),
+	}, nil
+}
+
+// refreshInvoicePayments recomputes the settlement of an invoice's tenders and
+// stores it on the header. payment_mode becomes the single mode used, or SPLIT.
+func refreshInvoicePayments(ctx context.Context, tx pgx.Tx, invoiceID int64, total float64) (paymentSummary, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT payment_mode, amount
+		FROM sales_invoice_payments
+		WHERE sales_invoice_id = $1 AND deleted_at IS NULL
+		ORDER BY id
+	`, invoiceID)
+	if err != nil {
+		return paymentSummary{}, fmt.Errorf("load payments: %w", err)
+	}
+
+	var modes []string
+	var amounts []float64
+	for rows.Next() {
+		var mode string
+		var amount float64
+		if err := rows.Scan(&mode, &amount); err != nil {
+			rows.Close()
+			return paymentSummary{}, err
+		}
+		modes = append(modes, mode)
+		amounts = append(amounts, amount)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return paymentSummary{}, err
+	}
+
+	summary, err := settleTenders(total, modes, amounts)
+	if err != nil {
+		return paymentSummary{}, err
+	}
+
+	var headerMode interface{}
+	if len(modes) > 0 {
+		headerMode = modes[0]
+		for _, m := range modes[1:] {
+			if m != modes[0] {
+				headerMode = "SPLIT"
+				break
+			}
+		}
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE sales_invoices
+		SET amount_paid = $1,
+		    change_due = $2,
+		    payment_mode = COALESCE($3, payment_mode)
+		WHERE id = $4
+	`, summary.AmountPaid, summary.ChangeDue, headerMode, invoiceID)
+	if err != nil {
+		return paymentSummary{}, fmt.Errorf("update invoice payments: %w", err)
+	}
+
+	return summary, nil
+}
+
+func loadInvoicePayments(ctx context.Context, q db.Querier, invoiceID int64) ([]gin.H, error) {
+	rows, err := q.Query(ctx, `
+		SELECT id, payment_mode, amount, COALESCE(reference, ''), created_at
+		FROM sales_invoice_payments
+		WHERE sales_invoice_id = $1 AND deleted_at IS NULL
+		ORDER BY id
+	`, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payments := []gin.H{}
+	for rows.Next() {
+		var (
+			id        int64
+			mode, ref string
+			amount    float64
+			createdAt time.Time
+		)
+		if err := rows.Scan(&id, &mode, &amount, &ref, &createdAt); err != nil {
+			return nil, err
+		}
+		payments = append(payments, gin.H{
+			"id":           id,
+			"payment_mode": mode,
+			"amount":       amount,
+			"reference":    ref,
+			"created_at":   utils.FormatDateTime(createdAt),
+		})
+	}
+
+	return payments, rows.Err()
+}
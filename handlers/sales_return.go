@@ -0,0 +1,472 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"tulsi-pos/db"
+	"tulsi-pos/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// ---------- Request DTOs ----------
+
+type SalesReturnItemInput struct {
+	SalesInvoiceItemID int64 `json:"sales_invoice_item_id" binding:"required"`
+	Quantity           int   `json:"quantity" binding:"required,min=1"`
+}
+
+type SalesReturnInput struct {
+	Reason string                 `json:"reason"`
+	Items  []SalesReturnItemInput `json:"items" binding:"required,min=1,dive"`
+}
+
+var (
+	ErrInvoiceNotInvoiced     = errors.New("only INVOICED invoices can be returned")
+	ErrInvoiceItemNotFound    = errors.New("invoice item not found")
+	ErrReturnQuantityExceeded = errors.New("return quantity exceeds quantity sold")
+)
+
+// ---------- Public Handlers ----------
+
+// POST /sales/invoices/:id/returns
+func CreateSalesReturn(c *gin.Context) {
+	invoiceID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || invoiceID <= 0 {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid invoice id")
+		return
+	}
+
+	var in SalesReturnInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid payload: "+err.Error())
+		return
+	}
+
+	returnID, creditNoteNumber, total, err := createSalesReturn(c.Request.Context(), invoiceID, c.GetInt("user_id"), in)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvoiceNotFound):
+			utils.SendErrorResponse(c, http.StatusNotFound, "invoice not found")
+		case errors.Is(err, ErrInvoiceNotInvoiced),
+			errors.Is(err, ErrInvoiceItemNotFound),
+			errors.Is(err, ErrReturnQuantityExceeded):
+			utils.SendErrorResponse(c, http.StatusBadRequest, err.Error())
+		default:
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	utils.SendSuccessResponse(c, http.StatusCreated, gin.H{
+		"return_id":          returnID,
+		"credit_note_number": creditNoteNumber,
+		"total_amount":       total,
+	}, "credit note created")
+}
+
+// GET /sales/invoices/:id/returns
+func ListSalesReturns(c *gin.Context) {
+	invoiceID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || invoiceID <= 0 {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid invoice id")
+		return
+	}
+
+	rows, err := db.DB.Query(c.Request.Context(), `
+		SELECT id, credit_note_number, COALESCE(reason, ''), taxable_amount,
+		       total_gst, total_amount, total_quantity, created_at
+		FROM sales_returns
+		WHERE sales_invoice_id = $1
+		ORDER BY created_at DESC
+	`, invoiceID)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	resp := []gin.H{}
+	for rows.Next() {
+		var r struct {
+			ID               int64
+			CreditNoteNumber string
+			Reason           string
+			TaxableAmount    float64
+			TotalGST         float64
+			TotalAmount      float64
+			TotalQuantity    int
+			CreatedAt        time.Time
+		}
+		if err := rows.Scan(&r.ID, &r.CreditNoteNumber, &r.Reason, &r.TaxableAmount,
+			&r.TotalGST, &r.TotalAmount, &r.TotalQuantity, &r.CreatedAt); err != nil {
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		resp = append(resp, gin.H{
+			"id":                 r.ID,
+			"credit_note_number": r.CreditNoteNumber,
+			"reason":             r.Reason,
+			"taxable_amount":     r.TaxableAmount,
+			"total_gst":          r.TotalGST,
+			"total_amount":       r.TotalAmount,
+			"total_quantity":     r.TotalQuantity,
+			"created_at":         utils.FormatDateTime(r.CreatedAt),
+		})
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, resp, "Credit notes fetched successfully")
+}
+
+// GET /sales/returns/:id
+func GetSalesReturnByID(c *gin.Context) {
+	returnID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || returnID <= 0 {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid return id")
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	var header struct {
+		ID               int64   `json:"id"`
+		CreditNoteNumber string  `json:"credit_note_number"`
+		SalesInvoiceID   int64   `json:"sales_invoice_id"`
+		InvoiceNumber    string  `json:"invoice_number"`
+		Reason           string  `json:"reason"`
+		TaxableAmount    float64 `json:"taxable_amount"`
+		TotalGST         float64 `json:"total_gst"`
+		TotalCGST        float64 `json:"total_cgst"`
+		TotalSGST        float64 `json:"total_sgst"`
+		TotalIGST        float64 `json:"total_igst"`
+		RoundOff         float64 `json:"round_off"`
+		TotalAmount      float64 `json:"total_amount"`
+		TotalQuantity    int     `json:"total_quantity"`
+		CreatedAt        string  `json:"created_at"`
+	}
+
+	var createdAt time.Time
+	err = db.DB.QueryRow(ctx, `
+		SELECT sr.id, sr.credit_note_number, sr.sales_invoice_id, si.invoice_number,
+		       COALESCE(sr.reason, ''), sr.taxable_amount, sr.total_gst,
+		       sr.total_cgst, sr.total_sgst, sr.total_igst, sr.round_off,
+		       sr.total_amount, sr.total_quantity, sr.created_at
+		FROM sales_returns sr
+		JOIN sales_invoices si ON si.id = sr.sales_invoice_id
+		WHERE sr.id = $1
+	`, returnID).Scan(
+		&header.ID, &header.CreditNoteNumber, &header.SalesInvoiceID, &header.InvoiceNumber,
+		&header.Reason, &header.TaxableAmount, &header.TotalGST,
+		&header.TotalCGST, &header.TotalSGST, &header.TotalIGST, &header.RoundOff,
+		&header.TotalAmount, &header.TotalQuantity, &createdAt,
+	)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			utils.SendErrorResponse(c, http.StatusNotFound, "credit note not found")
+			return
+		}
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	header.CreatedAt = utils.FormatDateTime(createdAt)
+
+	rows, err := db.DB.Query(ctx, `
+		SELECT sri.id, sri.sales_invoice_item_id, p.name, sri.quantity, sri.sales_rate,
+		       sri.taxable_amount, sri.gst_percent, sri.gst_amount,
+		       sri.cgst_amount, sri.sgst_amount, sri.igst_amount, sri.line_total
+		FROM sales_return_items sri
+		JOIN products p ON p.id = sri.product_id
+		WHERE sri.sales_return_id = $1
+		ORDER BY sri.id
+	`, returnID)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	items := []gin.H{}
+	for rows.Next() {
+		var it struct {
+			ID                 int64
+			SalesInvoiceItemID int64
+			ProductName        string
+			Quantity           int
+			SalesRate          float64
+			TaxableAmount      float64
+			GSTPercent         float64
+			GSTAmount          float64
+			CGSTAmount         float64
+			SGSTAmount         float64
+			IGSTAmount         float64
+			LineTotal          float64
+		}
+		if err := rows.Scan(&it.ID, &it.SalesInvoiceItemID, &it.ProductName, &it.Quantity,
+			&it.SalesRate, &it.TaxableAmount, &it.GSTPercent, &it.GSTAmount,
+			&it.CGSTAmount, &it.SGSTAmount, &it.IGSTAmount, &it.LineTotal); err != nil {
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		items = append(items, gin.H{
+			"id":                    it.ID,
+			"sales_invoice_item_id": it.SalesInvoiceItemID,
+			"product_name":          it.ProductName,
+			"quantity":              it.Quantity,
+			"sales_rate":            it.SalesRate,
+			"taxable_amount":        it.TaxableAmount,
+			"gst_percent":           it.GSTPercent,
+			"gst_amount":            it.GSTAmount,
+			"cgst_amount":           it.CGSTAmount,
+			"sgst_amount":           it.SGSTAmount,
+			"igst_amount":           it.IGSTAmount,
+			"line_total":            it.LineTotal,
+		})
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, gin.H{
+		"credit_note": header,
+		"items":       items,
+	}, "Credit note details fetched successfully")
+}
+
+// ---------- Internal Logic ----------
+
+// createSalesReturn posts a credit note against an INVOICED sales invoice.
+// GST and line values are reversed in proportion to the quantity returned;
+// the line that exhausts an invoice item takes whatever is left so repeated
+// partial returns never drift from the original amounts through rounding.
+func createSalesReturn(ctx context.Context, invoiceID int64, userID int, in SalesReturnInput) (int64, string, float64, error) {
+	tx, err := db.DB.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, "", 0, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// lock the invoice so concurrent returns against it are serialized
+	var status string
+	err = tx.QueryRow(ctx, `
+		SELECT status
+		FROM sales_invoices
+		WHERE id = $1 AND deleted_at IS NULL
+		FOR UPDATE
+	`, invoiceID).Scan(&status)
+	if err == pgx.ErrNoRows {
+		return 0, "", 0, ErrInvoiceNotFound
+	}
+	if err != nil {
+		return 0, "", 0, fmt.Errorf("load invoice: %w", err)
+	}
+	if status != "INVOICED" {
+		return 0, "", 0, ErrInvoiceNotInvoiced
+	}
+
+	type returnLine struct {
+		SalesInvoiceItemID int64
+		ProductID          int64
+		Quantity           int
+		SalesRate          float64
+		TaxableAmount      float64
+		GSTPercent         float64
+		GSTAmount          float64
+		CGST               float64
+		SGST               float64
+		IGST               float64
+		LineTotal          float64
+		UnitCost           float64 // cost the units left stock at; -1 if not recorded
+	}
+
+	// merge duplicate lines for the same invoice item
+	requested := map[int64]int{}
+	order := []int64{}
+	for _, it := range in.Items {
+		if _, ok := requested[it.SalesInvoiceItemID]; !ok {
+			order = append(order, it.SalesInvoiceItemID)
+		}
+		requested[it.SalesInvoiceItemID] += it.Quantity
+	}
+
+	var lines []returnLine
+	taxableAmount := 0.0
+	totalGST := 0.0
+	totalCGST, totalSGST, totalIGST := 0.0, 0.0, 0.0
+	totalAmount := 0.0
+	totalQuantity := 0
+
+	for _, itemID := range order {
+		qty := requested[itemID]
+
+		var (
+			productID                              int64
+			soldQty                                int
+			salesRate, gstPercent, gstAmt, lineTot float64
+			igstAmt, unitCost                      float64
+		)
+		err = tx.QueryRow(ctx, `
+			SELECT product_id, quantity, sales_rate, gst_percent, gst_amount,
+			       COALESCE(igst_amount, 0), line_total, COALESCE(unit_cost, -1)
+			FROM sales_invoice_items
+			WHERE id = $1 AND sales_invoice_id = $2 AND deleted_at IS NULL
+		`, itemID, invoiceID).Scan(&productID, &soldQty, &salesRate, &gstPercent, &gstAmt, &igstAmt, &lineTot, &unitCost)
+		if err == pgx.ErrNoRows {
+			return 0, "", 0, fmt.Errorf("%w: %d", ErrInvoiceItemNotFound, itemID)
+		}
+		if err != nil {
+			return 0, "", 0, fmt.Errorf("load invoice item: %w", err)
+		}
+
+		var returnedQty int
+		var returnedTaxable, returnedGST float64
+		err = tx.QueryRow(ctx, `
+			SELECT COALESCE(SUM(quantity), 0),
+			       COALESCE(SUM(taxable_amount), 0),
+			       COALESCE(SUM(gst_amount), 0)
+			FROM sales_return_items
+			WHERE sales_invoice_item_id = $1
+		`, itemID).Scan(&returnedQty, &returnedTaxable, &returnedGST)
+		if err != nil {
+			return 0, "", 0, fmt.Errorf("load returned quantity: %w", err)
+		}
+
+		if qty > soldQty-returnedQty {
+			return 0, "", 0, fmt.Errorf("%w: item %d sold %d, already returned %d, requested %d",
+				ErrReturnQuantityExceeded, itemID, soldQty, returnedQty, qty)
+		}
+
+		lineTaxable := lineTot - gstAmt
+		var taxable, gst float64
+		if returnedQty+qty == soldQty {
+			taxable = round2(lineTaxable - returnedTaxable)
+			gst = round2(gstAmt - returnedGST)
+		} else {
+			ratio := float64(qty) / float64(soldQty)
+			taxable = round2(lineTaxable * ratio)
+			gst = round2(gstAmt * ratio)
+		}
+
+		// the credit note follows the tax split of the original sale
+		cgst, sgst, igst := splitGST(gst, igstAmt > 0)
+
+		line := returnLine{
+			SalesInvoiceItemID: itemID,
+			ProductID:          productID,
+			Quantity:           qty,
+			SalesRate:          salesRate,
+			TaxableAmount:      taxable,
+			GSTPercent:         gstPercent,
+			GSTAmount:          gst,
+			CGST:               cgst,
+			SGST:               sgst,
+			IGST:               igst,
+			LineTotal:          round2(taxable + gst),
+			UnitCost:           unitCost,
+		}
+		lines = append(lines, line)
+
+		taxableAmount += line.TaxableAmount
+		totalGST += line.GSTAmount
+		totalCGST += line.CGST
+		totalSGST += line.SGST
+		totalIGST += line.IGST
+		totalAmount += line.LineTotal
+		totalQuantity += line.Quantity
+	}
+
+	roundedTotal := math.Round(totalAmount)
+	roundOff := round2(roundedTotal - totalAmount)
+	totalAmount = roundedTotal
+
+	creditNoteNumber, err := nextDocumentNumber(ctx, tx, SeriesCreditNote, time.Now())
+	if err != nil {
+		return 0, "", 0, err
+	}
+
+	var returnID int64
+	err = tx.QueryRow(ctx, `
+		INSERT INTO sales_returns (
+			credit_note_number, sales_invoice_id, reason,
+			taxable_amount, total_gst, total_cgst, total_sgst, total_igst,
+			round_off, total_amount, total_quantity, created_by
+		) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)
+		RETURNING id
+	`,
+		creditNoteNumber,
+		invoiceID,
+		in.Reason,
+		round2(taxableAmount),
+		round2(totalGST),
+		round2(totalCGST),
+		round2(totalSGST),
+		round2(totalIGST),
+		roundOff,
+		totalAmount,
+		totalQuantity,
+		nullableUserID(userID),
+	).Scan(&returnID)
+	if err != nil {
+		return 0, "", 0, fmt.Errorf("insert sales return: %w", err)
+	}
+
+	for _, l := range lines {
+		// goods come back into stock (positive quantity) at the cost they left at
+		p, err := postInventoryCost(ctx, tx, l.ProductID, l.Quantity, l.UnitCost, RefSaleReturn, returnID, userID)
+		if err != nil {
+			return 0, "", 0, err
+		}
+
+		_, err = tx.Exec(ctx, `
+			INSERT INTO sales_return_items (
+				sales_return_id, sales_invoice_item_id, product_id, quantity,
+				sales_rate, taxable_amount, gst_percent, gst_amount,
+				cgst_amount, sgst_amount, igst_amount, line_total,
+				unit_cost, cogs
+			) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14)
+		`,
+			returnID,
+			l.SalesInvoiceItemID,
+			l.ProductID,
+			l.Quantity,
+			l.SalesRate,
+			l.TaxableAmount,
+			l.GSTPercent,
+			l.GSTAmount,
+			l.CGST,
+			l.SGST,
+			l.IGST,
+			l.LineTotal,
+			roundCost(p.UnitCost),
+			p.Value,
+		)
+		if err != nil {
+			return 0, "", 0, fmt.Errorf("insert sales return item: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, "", 0, fmt.Errorf("commit tx: %w", err)
+	}
+
+	return returnID, creditNoteNumber, totalAmount, nil
+}
+
+// round2 rounds a money value to paise.
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// nullableUserID maps the zero user id (unauthenticated) to NULL so the
+// users foreign key is not violated.
+func nullableUserID(userID int) interface{} {
+	if userID <= 0 {
+		return nil
+	}
+	return userID
+}
@@ -0,0 +1,572 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"tulsi-pos/config"
+	"tulsi-pos/db"
+	"tulsi-pos/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// Adjustment reason codes. Damage, theft and samples can only take stock out
+// and found stock can only bring it in; corrections go either way.
+const (
+	ReasonDamage     = "damage"
+	ReasonTheft      = "theft"
+	ReasonSample     = "sample"
+	ReasonFound      = "found"
+	ReasonCorrection = "correction"
+)
+
+// Adjustment statuses.
+const (
+	AdjustmentPending  = "PENDING"
+	AdjustmentPosted   = "POSTED"
+	AdjustmentRejected = "REJECTED"
+)
+
+// ---------- Request DTOs ----------
+
+type StockAdjustmentItemInput struct {
+	ProductID int64 `json:"product_id" binding:"required"`
+	Quantity  int   `json:"quantity" binding:"required"` // signed: negative takes stock out
+}
+
+type StockAdjustmentInput struct {
+	ReasonCode string                     `json:"reason_code" binding:"required"`
+	Note       string                     `json:"note"`
+	Items      []StockAdjustmentItemInput `json:"items" binding:"required,min=1,dive"`
+}
+
+type adjustmentReviewInput struct {
+	Note string `json:"note"`
+}
+
+var (
+	ErrInvalidReasonCode     = errors.New("reason_code must be damage, theft, sample, found or correction")
+	ErrAdjustmentDirection   = errors.New("quantity sign does not match the reason code")
+	ErrAdjustmentNotFound    = errors.New("stock adjustment not found")
+	ErrAdjustmentNotPending  = errors.New("stock adjustment is not pending approval")
+	ErrAdjustmentSelfApprove = errors.New("an adjustment cannot be approved by the user who raised it")
+)
+
+// ---------- Public Handlers ----------
+
+// POST /inventory/adjustments
+// Adjustments worth more than config.AdjustmentApprovalLimit are saved as
+// PENDING and only reach stock once an admin approves them.
+func CreateStockAdjustment(c *gin.Context) {
+	var in StockAdjustmentInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid payload: "+err.Error())
+		return
+	}
+
+	id, number, status, value, err := createStockAdjustment(c.Request.Context(), c.GetInt("user_id"), in)
+	if err != nil {
+		var stockErr *InsufficientStockError
+		switch {
+		case errors.Is(err, ErrInvalidReasonCode),
+			errors.Is(err, ErrAdjustmentDirection),
+			errors.Is(err, ErrProductNotFound),
+			errors.Is(err, ErrSeriesNotFound):
+			utils.SendErrorResponse(c, http.StatusBadRequest, err.Error())
+		case errors.As(err, &stockErr):
+			utils.SendErrorResponseWithData(c, http.StatusConflict, ErrInsufficientStock.Error(), stockErr.Lines)
+		default:
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	msg := "stock adjustment posted"
+	if status == AdjustmentPending {
+		msg = "stock adjustment awaiting approval"
+	}
+
+	utils.SendSuccessResponse(c, http.StatusCreated, gin.H{
+		"id":                id,
+		"adjustment_number": number,
+		"status":            status,
+		"total_value":       value,
+	}, msg)
+}
+
+// GET /inventory/adjustments?status=
+func ListStockAdjustments(c *gin.Context) {
+	where := ""
+	params := []interface{}{}
+	if status := strings.ToUpper(c.Query("status")); status != "" {
+		where = "WHERE sa.status = $1"
+		params = append(params, status)
+	}
+
+	rows, err := db.DB.Query(c.Request.Context(), `
+		SELECT sa.id, sa.adjustment_number, sa.reason_code, COALESCE(sa.note, ''),
+		       sa.status, sa.total_value, COALESCE(u.name, ''), sa.created_at
+		FROM stock_adjustments sa
+		LEFT JOIN users u ON u.id = sa.created_by
+		`+where+`
+		ORDER BY sa.created_at DESC
+	`, params...)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	resp := []gin.H{}
+	for rows.Next() {
+		var (
+			id                           int64
+			number, reason, note, status string
+			createdBy                    string
+			totalValue                   float64
+			createdAt                    time.Time
+		)
+		if err := rows.Scan(&id, &number, &reason, &note, &status, &totalValue,
+			&createdBy, &createdAt); err != nil {
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		resp = append(resp, gin.H{
+			"id":                id,
+			"adjustment_number": number,
+			"reason_code":       reason,
+			"note":              note,
+			"status":            status,
+			"total_value":       totalValue,
+			"created_by":        createdBy,
+			"created_at":        utils.FormatDateTime(createdAt),
+		})
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, resp, "Stock adjustments fetched successfully")
+}
+
+// GET /inventory/adjustments/:id
+func GetStockAdjustmentByID(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid adjustment id")
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	var header struct {
+		ID               int64   `json:"id"`
+		AdjustmentNumber string  `json:"adjustment_number"`
+		ReasonCode       string  `json:"reason_code"`
+		Note             string  `json:"note"`
+		Status           string  `json:"status"`
+		TotalValue       float64 `json:"total_value"`
+		CreatedBy        string  `json:"created_by"`
+		CreatedAt        string  `json:"created_at"`
+		ReviewedBy       string  `json:"reviewed_by,omitempty"`
+		ReviewedAt       string  `json:"reviewed_at,omitempty"`
+		ReviewNote       string  `json:"review_note,omitempty"`
+	}
+
+	var createdAt time.Time
+	var reviewedAt *time.Time
+	err = db.DB.QueryRow(ctx, `
+		SELECT sa.id, sa.adjustment_number, sa.reason_code, COALESCE(sa.note, ''),
+		       sa.status, sa.total_value, COALESCE(cu.name, ''), sa.created_at,
+		       COALESCE(ru.name, ''), sa.reviewed_at, COALESCE(sa.review_note, '')
+		FROM stock_adjustments sa
+		LEFT JOIN users cu ON cu.id = sa.created_by
+		LEFT JOIN users ru ON ru.id = sa.reviewed_by
+		WHERE sa.id = $1
+	`, id).Scan(&header.ID, &header.AdjustmentNumber, &header.ReasonCode, &header.Note,
+		&header.Status, &header.TotalValue, &header.CreatedBy, &createdAt,
+		&header.ReviewedBy, &reviewedAt, &header.ReviewNote)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			utils.SendErrorResponse(c, http.StatusNotFound, "stock adjustment not found")
+			return
+		}
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	header.CreatedAt = utils.FormatDateTime(createdAt)
+	if reviewedAt != nil {
+		header.ReviewedAt = utils.FormatDateTime(*reviewedAt)
+	}
+
+	rows, err := db.DB.Query(ctx, `
+		SELECT sai.id, sai.product_id, p.name, COALESCE(p.sku, ''),
+		       sai.quantity, sai.unit_cost, sai.value
+		FROM stock_adjustment_items sai
+		JOIN products p ON p.id = sai.product_id
+		WHERE sai.stock_adjustment_id = $1
+		ORDER BY sai.id
+	`, id)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	items := []gin.H{}
+	for rows.Next() {
+		var (
+			itemID, productID int64
+			name, sku         string
+			qty               int
+			unitCost, value   float64
+		)
+		if err := rows.Scan(&itemID, &productID, &name, &sku, &qty, &unitCost, &value); err != nil {
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		items = append(items, gin.H{
+			"id":           itemID,
+			"product_id":   productID,
+			"product_name": name,
+			"sku":          sku,
+			"quantity":     qty,
+			"unit_cost":    unitCost,
+			"value":        value,
+		})
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, gin.H{
+		"adjustment": header,
+		"items":      items,
+	}, "Stock adjustment fetched successfully")
+}
+
+// POST /inventory/adjustments/:id/approve
+func ApproveStockAdjustment(c *gin.Context) {
+	reviewStockAdjustment(c, true)
+}
+
+// POST /inventory/adjustments/:id/reject
+func RejectStockAdjustment(c *gin.Context) {
+	reviewStockAdjustment(c, false)
+}
+
+// ---------- Internal Logic ----------
+
+func reviewStockAdjustment(c *gin.Context, approve bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid adjustment id")
+		return
+	}
+
+	var in adjustmentReviewInput
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&in); err != nil {
+			utils.SendErrorResponse(c, http.StatusBadRequest, "invalid payload: "+err.Error())
+			return
+		}
+	}
+
+	status, err := decideStockAdjustment(c.Request.Context(), id, c.GetInt("user_id"), approve, strings.TrimSpace(in.Note))
+	if err != nil {
+		var stockErr *InsufficientStockError
+		switch {
+		case errors.Is(err, ErrAdjustmentNotFound):
+			utils.SendErrorResponse(c, http.StatusNotFound, err.Error())
+		case errors.Is(err, ErrAdjustmentNotPending):
+			utils.SendErrorResponse(c, http.StatusBadRequest, err.Error())
+		case errors.Is(err, ErrAdjustmentSelfApprove):
+			utils.SendErrorResponse(c, http.StatusForbidden, err.Error())
+		case errors.As(err, &stockErr):
+			utils.SendErrorResponseWithData(c, http.StatusConflict, ErrInsufficientStock.Error(), stockErr.Lines)
+		default:
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, gin.H{
+		"id":     id,
+		"status": status,
+	}, "stock adjustment "+strings.ToLower(status))
+}
+
+// validateAdjustmentDirection checks the quantity sign against the reason.
+func validateAdjustmentDirection(reason string, qty int) error {
+	switch reason {
+	case ReasonDamage, ReasonTheft, ReasonSample:
+		if qty >= 0 {
+			return fmt.Errorf("%w: %s must have a negative quantity", ErrAdjustmentDirection, reason)
+		}
+	case ReasonFound:
+		if qty <= 0 {
+			return fmt.Errorf("%w: %s must have a positive quantity", ErrAdjustmentDirection, reason)
+		}
+	case ReasonCorrection:
+		if qty == 0 {
+			return fmt.Errorf("%w: quantity cannot be zero", ErrAdjustmentDirection)
+		}
+	default:
+		return ErrInvalidReasonCode
+	}
+	return nil
+}
+
+// createStockAdjustment saves an adjustment valued at purchase price and posts
+// it straight to stock unless its value needs an admin's approval.
+func createStockAdjustment(ctx context.Context, userID int, in StockAdjustmentInput) (int64, string, string, float64, error) {
+	reason := strings.ToLower(strings.TrimSpace(in.ReasonCode))
+	for _, it := range in.Items {
+		if err := validateAdjustmentDirection(reason, it.Quantity); err != nil {
+			return 0, "", "", 0, err
+		}
+	}
+
+	tx, err := db.DB.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, "", "", 0, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	lines := make([]adjustmentLine, 0, len(in.Items))
+	totalValue := 0.0
+	for _, it := range in.Items {
+		var unitCost float64
+		err := tx.QueryRow(ctx, `
+			SELECT COALESCE(purchase_price, 0)
+			FROM products
+			WHERE id = $1 AND deleted_at IS NULL
+		`, it.ProductID).Scan(&unitCost)
+		if err == pgx.ErrNoRows {
+			return 0, "", "", 0, fmt.Errorf("%w: %d", ErrProductNotFound, it.ProductID)
+		}
+		if err != nil {
+			return 0, "", "", 0, fmt.Errorf("load product: %w", err)
+		}
+
+		l := adjustmentLine{ProductID: it.ProductID, Quantity: it.Quantity, UnitCost: unitCost}
+		l.Value = round2(math.Abs(float64(it.Quantity)) * unitCost)
+		totalValue += l.Value
+		lines = append(lines, l)
+	}
+
+	status := AdjustmentPosted
+	if round2(totalValue) > config.AdjustmentApprovalLimit {
+		status = AdjustmentPending
+	}
+	if status == AdjustmentPosted {
+		if err := checkAdjustmentStock(ctx, tx, lines); err != nil {
+			var stockErr *InsufficientStockError
+			if errors.As(err, &stockErr) {
+				productIDs := make([]int64, len(lines))
+				for i, l := range lines {
+					productIDs[i] = l.ProductID
+				}
+				markShortLines(stockErr.Lines, productIDs)
+			}
+			return 0, "", "", 0, err
+		}
+	}
+
+	id, number, err := saveStockAdjustment(ctx, tx, reason, strings.TrimSpace(in.Note), status, lines, userID)
+	if err != nil {
+		return 0, "", "", 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, "", "", 0, fmt.Errorf("commit tx: %w", err)
+	}
+
+	return id, number, status, round2(totalValue), nil
+}
+
+type adjustmentLine struct {
+	ProductID int64
+	Quantity  int
+	UnitCost  float64
+	Value     float64
+}
+
+// checkAdjustmentStock checks the stock an adjustment takes out under the
+// oversell policy, as a sale would, so damage or theft cannot write off
+// units that are not on hand. The stock rows stay locked until tx ends.
+func checkAdjustmentStock(ctx context.Context, tx pgx.Tx, lines []adjustmentLine) error {
+	if config.OversellPolicy == config.OversellAllow {
+		return nil
+	}
+
+	net := map[int64]int{}
+	for _, l := range lines {
+		net[l.ProductID] += l.Quantity
+	}
+	outgoing := map[int64]int{}
+	for productID, qty := range net {
+		if qty < 0 {
+			outgoing[productID] = -qty
+		}
+	}
+	if len(outgoing) == 0 {
+		return nil
+	}
+
+	shortages, err := checkStock(ctx, tx, outgoing)
+	if err != nil {
+		return err
+	}
+	if len(shortages) > 0 && config.OversellPolicy == config.OversellBlock {
+		return &InsufficientStockError{Lines: shortages}
+	}
+	return nil
+}
+
+// saveStockAdjustment numbers and stores an adjustment document, and posts its
+// lines to stock when status is POSTED.
+func saveStockAdjustment(ctx context.Context, tx pgx.Tx, reason, note, status string, lines []adjustmentLine, userID int) (int64, string, error) {
+	totalValue := 0.0
+	for _, l := range lines {
+		totalValue += l.Value
+	}
+
+	number, err := nextDocumentNumber(ctx, tx, SeriesAdjustment, time.Now())
+	if err != nil {
+		return 0, "", err
+	}
+
+	var postedAt interface{}
+	if status == AdjustmentPosted {
+		postedAt = time.Now()
+	}
+
+	var id int64
+	err = tx.QueryRow(ctx, `
+		INSERT INTO stock_adjustments (
+			adjustment_number, reason_code, note, status, total_value, created_by, posted_at
+		) VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6, $7)
+		RETURNING id
+	`, number, reason, note, status, round2(totalValue), nullableUserID(userID), postedAt).Scan(&id)
+	if err != nil {
+		return 0, "", fmt.Errorf("insert stock adjustment: %w", err)
+	}
+
+	// post in product id order, the order checkStock and approval lock stock
+	// rows in, so a multi-line adjustment cannot deadlock against a sale
+	lines = append([]adjustmentLine(nil), lines...)
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].ProductID < lines[j].ProductID })
+
+	for _, l := range lines {
+		_, err = tx.Exec(ctx, `
+			INSERT INTO stock_adjustment_items (
+				stock_adjustment_id, product_id, quantity, unit_cost, value
+			) VALUES ($1, $2, $3, $4, $5)
+		`, id, l.ProductID, l.Quantity, l.UnitCost, l.Value)
+		if err != nil {
+			return 0, "", fmt.Errorf("insert stock adjustment item: %w", err)
+		}
+
+		if status == AdjustmentPosted {
+			if _, err := postInventory(ctx, tx, l.ProductID, l.Quantity, RefAdjustment, id, userID); err != nil {
+				return 0, "", err
+			}
+		}
+	}
+
+	return id, number, nil
+}
+
+// decideStockAdjustment approves (and posts) or rejects a PENDING adjustment.
+func decideStockAdjustment(ctx context.Context, id int64, userID int, approve bool, note string) (string, error) {
+	tx, err := db.DB.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return "", fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var status string
+	var createdBy *int
+	err = tx.QueryRow(ctx, `
+		SELECT status, created_by
+		FROM stock_adjustments
+		WHERE id = $1
+		FOR UPDATE
+	`, id).Scan(&status, &createdBy)
+	if err == pgx.ErrNoRows {
+		return "", ErrAdjustmentNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("load stock adjustment: %w", err)
+	}
+	if status != AdjustmentPending {
+		return "", ErrAdjustmentNotPending
+	}
+	if approve && createdBy != nil && *createdBy == userID {
+		return "", ErrAdjustmentSelfApprove
+	}
+
+	newStatus := AdjustmentRejected
+	if approve {
+		newStatus = AdjustmentPosted
+
+		rows, err := tx.Query(ctx, `
+			SELECT product_id, quantity
+			FROM stock_adjustment_items
+			WHERE stock_adjustment_id = $1
+			ORDER BY product_id, id
+		`, id)
+		if err != nil {
+			return "", fmt.Errorf("load stock adjustment items: %w", err)
+		}
+
+		var moves []adjustmentLine
+		for rows.Next() {
+			var m adjustmentLine
+			if err := rows.Scan(&m.ProductID, &m.Quantity); err != nil {
+				rows.Close()
+				return "", err
+			}
+			moves = append(moves, m)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return "", err
+		}
+
+		// stock may have gone out since the adjustment was raised
+		if err := checkAdjustmentStock(ctx, tx, moves); err != nil {
+			return "", err
+		}
+
+		for _, m := range moves {
+			if _, err := postInventory(ctx, tx, m.ProductID, m.Quantity, RefAdjustment, id, userID); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE stock_adjustments
+		SET status = $1,
+		    reviewed_by = $2,
+		    reviewed_at = NOW(),
+		    review_note = NULLIF($3, ''),
+		    posted_at = CASE WHEN $1 = 'POSTED' THEN NOW() ELSE posted_at END
+		WHERE id = $4
+	`, newStatus, nullableUserID(userID), note, id)
+	if err != nil {
+		return "", fmt.Errorf("update stock adjustment: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return "", fmt.Errorf("commit tx: %w", err)
+	}
+
+	return newStatus, nil
+}
@@ -0,0 +1,727 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"tulsi-pos/db"
+	"tulsi-pos/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// Stock-take scopes and statuses.
+const (
+	ScopeAll      = "ALL"
+	ScopeCategory = "CATEGORY"
+	ScopeGender   = "GENDER"
+
+	StockTakeOpen      = "OPEN"
+	StockTakePosted    = "POSTED"
+	StockTakeCancelled = "CANCELLED"
+)
+
+// ---------- Request DTOs ----------
+
+type StockTakeInput struct {
+	ScopeType  string `json:"scope_type" binding:"required"` // ALL, CATEGORY, GENDER
+	ScopeValue string `json:"scope_value"`
+	Note       string `json:"note"`
+}
+
+// StockCountEntry identifies a product by id, barcode or SKU. A scan is an
+// entry with a barcode and no quantity, and counts as one unit.
+type StockCountEntry struct {
+	ProductID int64  `json:"product_id"`
+	Barcode   string `json:"barcode"`
+	Quantity  *int   `json:"quantity" binding:"omitempty,min=0"`
+}
+
+type StockCountInput struct {
+	Mode    string            `json:"mode"` // add (default, for scans) or set
+	Entries []StockCountEntry `json:"entries" binding:"required,min=1,dive"`
+}
+
+// VarianceLine compares the frozen system quantity with the count.
+type VarianceLine struct {
+	ProductID     int64   `json:"product_id"`
+	Name          string  `json:"name"`
+	SKU           string  `json:"sku"`
+	SystemQty     int     `json:"system_qty"`
+	CountedQty    int     `json:"counted_qty"`
+	Counted       bool    `json:"counted"`
+	Variance      int     `json:"variance"`
+	UnitCost      float64 `json:"unit_cost"`
+	VarianceValue float64 `json:"variance_value"`
+}
+
+var (
+	ErrInvalidScope       = errors.New("scope_type must be ALL, CATEGORY or GENDER, with scope_value for CATEGORY and GENDER")
+	ErrStockTakeNotFound  = errors.New("stock take not found")
+	ErrStockTakeNotOpen   = errors.New("stock take is not open")
+	ErrStockTakeEmpty     = errors.New("no products in stock take scope")
+	ErrUnknownCountEntry  = errors.New("products not found in stock take")
+	ErrInvalidCountMode   = errors.New("mode must be add or set")
+	ErrStockTakeUncounted = errors.New("stock take has uncounted products")
+)
+
+// ---------- Public Handlers ----------
+
+// POST /inventory/stock-takes
+// Opens a session and freezes the on-hand quantity of every product in scope.
+func CreateStockTake(c *gin.Context) {
+	var in StockTakeInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid payload: "+err.Error())
+		return
+	}
+
+	id, lines, err := createStockTake(c.Request.Context(), c.GetInt("user_id"), in)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidScope), errors.Is(err, ErrStockTakeEmpty):
+			utils.SendErrorResponse(c, http.StatusBadRequest, err.Error())
+		default:
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	utils.SendSuccessResponse(c, http.StatusCreated, gin.H{
+		"id":       id,
+		"products": lines,
+	}, "stock take opened")
+}
+
+// GET /inventory/stock-takes?status=
+func ListStockTakes(c *gin.Context) {
+	where := ""
+	params := []interface{}{}
+	if status := strings.ToUpper(c.Query("status")); status != "" {
+		where = "WHERE st.status = $1"
+		params = append(params, status)
+	}
+
+	rows, err := db.DB.Query(c.Request.Context(), `
+		SELECT st.id, st.scope_type, COALESCE(st.scope_value, ''), st.status,
+		       COALESCE(st.note, ''), st.created_at,
+		       COUNT(sti.id), COUNT(sti.counted_qty)
+		FROM stock_takes st
+		LEFT JOIN stock_take_items sti ON sti.stock_take_id = st.id
+		`+where+`
+		GROUP BY st.id
+		ORDER BY st.created_at DESC
+	`, params...)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	resp := []gin.H{}
+	for rows.Next() {
+		var (
+			id                                  int64
+			scopeType, scopeValue, status, note string
+			createdAt                           time.Time
+			products, counted                   int
+		)
+		if err := rows.Scan(&id, &scopeType, &scopeValue, &status, &note, &createdAt,
+			&products, &counted); err != nil {
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		resp = append(resp, gin.H{
+			"id":          id,
+			"scope_type":  scopeType,
+			"scope_value": scopeValue,
+			"status":      status,
+			"note":        note,
+			"products":    products,
+			"counted":     counted,
+			"created_at":  utils.FormatDateTime(createdAt),
+		})
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, resp, "Stock takes fetched successfully")
+}
+
+// POST /inventory/stock-takes/:id/counts
+// In add mode (the default) each entry adds to what has been counted so far,
+// so a handheld can send every scan as it happens; set mode replaces it.
+func SubmitStockCounts(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid stock take id")
+		return
+	}
+
+	var in StockCountInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid payload: "+err.Error())
+		return
+	}
+
+	unknown, err := submitStockCounts(c.Request.Context(), id, c.GetInt("user_id"), in)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUnknownCountEntry):
+			utils.SendErrorResponseWithData(c, http.StatusBadRequest, err.Error(), gin.H{"unknown": unknown})
+		case errors.Is(err, ErrStockTakeNotFound):
+			utils.SendErrorResponse(c, http.StatusNotFound, err.Error())
+		case errors.Is(err, ErrStockTakeNotOpen), errors.Is(err, ErrInvalidCountMode):
+			utils.SendErrorResponse(c, http.StatusBadRequest, err.Error())
+		default:
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, gin.H{"entries": len(in.Entries)}, "counts recorded")
+}
+
+// GET /inventory/stock-takes/:id
+// Returns the session with its variance report.
+func GetStockTakeByID(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid stock take id")
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	var header struct {
+		ID                int64  `json:"id"`
+		ScopeType         string `json:"scope_type"`
+		ScopeValue        string `json:"scope_value"`
+		Status            string `json:"status"`
+		Note              string `json:"note"`
+		StockAdjustmentID *int64 `json:"stock_adjustment_id"`
+		CreatedAt         string `json:"created_at"`
+		ApprovedAt        string `json:"approved_at,omitempty"`
+	}
+
+	var createdAt time.Time
+	var approvedAt *time.Time
+	err = db.DB.QueryRow(ctx, `
+		SELECT id, scope_type, COALESCE(scope_value, ''), status, COALESCE(note, ''),
+		       stock_adjustment_id, created_at, approved_at
+		FROM stock_takes
+		WHERE id = $1
+	`, id).Scan(&header.ID, &header.ScopeType, &header.ScopeValue, &header.Status,
+		&header.Note, &header.StockAdjustmentID, &createdAt, &approvedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			utils.SendErrorResponse(c, http.StatusNotFound, "stock take not found")
+			return
+		}
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	header.CreatedAt = utils.FormatDateTime(createdAt)
+	if approvedAt != nil {
+		header.ApprovedAt = utils.FormatDateTime(*approvedAt)
+	}
+
+	lines, err := loadStockTakeVariance(ctx, db.DB, id)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var summary struct {
+		Products      int     `json:"products"`
+		Counted       int     `json:"counted"`
+		WithVariance  int     `json:"with_variance"`
+		ShortQty      int     `json:"short_qty"`
+		ExcessQty     int     `json:"excess_qty"`
+		VarianceValue float64 `json:"variance_value"`
+	}
+	for _, l := range lines {
+		summary.Products++
+		if l.Counted {
+			summary.Counted++
+		}
+		if l.Variance != 0 {
+			summary.WithVariance++
+		}
+		if l.Variance < 0 {
+			summary.ShortQty -= l.Variance
+		} else {
+			summary.ExcessQty += l.Variance
+		}
+		summary.VarianceValue += l.VarianceValue
+	}
+	summary.VarianceValue = round2(summary.VarianceValue)
+
+	// only lines that differ are of interest unless the caller wants them all
+	report := lines
+	if c.Query("all") != "true" {
+		report = []VarianceLine{}
+		for _, l := range lines {
+			if l.Variance != 0 || !l.Counted {
+				report = append(report, l)
+			}
+		}
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, gin.H{
+		"stock_take": header,
+		"summary":    summary,
+		"variance":   report,
+	}, "Stock take fetched successfully")
+}
+
+// POST /inventory/stock-takes/:id/approve?uncounted=zero
+// Posts every variance as one correction adjustment. Products nobody counted
+// block approval unless uncounted=zero says they really are gone.
+func ApproveStockTake(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid stock take id")
+		return
+	}
+
+	adjustmentID, number, err := approveStockTake(c.Request.Context(), id, c.GetInt("user_id"), c.Query("uncounted") == "zero")
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrStockTakeNotFound):
+			utils.SendErrorResponse(c, http.StatusNotFound, err.Error())
+		case errors.Is(err, ErrStockTakeNotOpen),
+			errors.Is(err, ErrStockTakeUncounted),
+			errors.Is(err, ErrSeriesNotFound):
+			utils.SendErrorResponse(c, http.StatusBadRequest, err.Error())
+		default:
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	resp := gin.H{"id": id, "status": StockTakePosted}
+	if adjustmentID != 0 {
+		resp["stock_adjustment_id"] = adjustmentID
+		resp["adjustment_number"] = number
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, resp, "stock take posted")
+}
+
+// POST /inventory/stock-takes/:id/cancel
+func CancelStockTake(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid stock take id")
+		return
+	}
+
+	res, err := db.DB.Exec(c.Request.Context(), `
+		UPDATE stock_takes
+		SET status = $1
+		WHERE id = $2 AND status = $3
+	`, StockTakeCancelled, id, StockTakeOpen)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if res.RowsAffected() == 0 {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "stock take not found or not open")
+		return
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, nil, "stock take cancelled")
+}
+
+// ---------- Internal Logic ----------
+
+func createStockTake(ctx context.Context, userID int, in StockTakeInput) (int64, int64, error) {
+	scopeType := strings.ToUpper(strings.TrimSpace(in.ScopeType))
+	scopeValue := strings.TrimSpace(in.ScopeValue)
+
+	var scopeFilter string
+	switch scopeType {
+	case ScopeAll:
+		scopeValue = ""
+	case ScopeCategory:
+		scopeFilter = "AND p.category = $1"
+	case ScopeGender:
+		scopeFilter = "AND p.gender = $1"
+	default:
+		return 0, 0, ErrInvalidScope
+	}
+	if scopeType != ScopeAll && scopeValue == "" {
+		return 0, 0, ErrInvalidScope
+	}
+
+	tx, err := db.DB.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, 0, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var params []interface{}
+	if scopeFilter != "" {
+		params = append(params, scopeValue)
+	}
+	rows, err := tx.Query(ctx, `
+		SELECT p.id
+		FROM products p
+		WHERE p.deleted_at IS NULL `+scopeFilter+`
+	`, params...)
+	if err != nil {
+		return 0, 0, fmt.Errorf("load products: %w", err)
+	}
+	var productIDs []int64
+	for rows.Next() {
+		var productID int64
+		if err := rows.Scan(&productID); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		productIDs = append(productIDs, productID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, fmt.Errorf("load products: %w", err)
+	}
+	if len(productIDs) == 0 {
+		return 0, 0, ErrStockTakeEmpty
+	}
+
+	// stock postings insert their ledger row while holding the product_stock
+	// row, so once every row is locked no movement is in flight and the
+	// ledger's last id per product covers exactly what on_hand does
+	if err := lockStockRows(ctx, tx, productIDs); err != nil {
+		return 0, 0, err
+	}
+
+	var id int64
+	err = tx.QueryRow(ctx, `
+		INSERT INTO stock_takes (scope_type, scope_value, status, note, created_by)
+		VALUES ($1, NULLIF($2, ''), $3, NULLIF($4, ''), $5)
+		RETURNING id
+	`, scopeType, scopeValue, StockTakeOpen, strings.TrimSpace(in.Note), nullableUserID(userID)).Scan(&id)
+	if err != nil {
+		return 0, 0, fmt.Errorf("insert stock take: %w", err)
+	}
+
+	res, err := tx.Exec(ctx, `
+		INSERT INTO stock_take_items (stock_take_id, product_id, system_qty, unit_cost, snapshot_txn_id)
+		SELECT $1, p.id, ps.on_hand, COALESCE(p.purchase_price, 0),
+		       (SELECT COALESCE(MAX(it.id), 0) FROM inventory_transactions it WHERE it.product_id = p.id)
+		FROM products p
+		JOIN product_stock ps ON ps.product_id = p.id
+		WHERE p.id = ANY($2)
+	`, id, productIDs)
+	if err != nil {
+		return 0, 0, fmt.Errorf("snapshot stock: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, 0, fmt.Errorf("commit tx: %w", err)
+	}
+
+	return id, res.RowsAffected(), nil
+}
+
+// lockOpenStockTake locks a stock take for the rest of tx and checks it is OPEN.
+func lockOpenStockTake(ctx context.Context, tx pgx.Tx, id int64) error {
+	var status string
+	err := tx.QueryRow(ctx, `
+		SELECT status
+		FROM stock_takes
+		WHERE id = $1
+		FOR UPDATE
+	`, id).Scan(&status)
+	if err == pgx.ErrNoRows {
+		return ErrStockTakeNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("load stock take: %w", err)
+	}
+	if status != StockTakeOpen {
+		return ErrStockTakeNotOpen
+	}
+	return nil
+}
+
+// submitStockCounts records counts against the session's frozen products. If
+// any entry does not resolve to a product in the session nothing is saved and
+// the offending identifiers are returned.
+func submitStockCounts(ctx context.Context, id int64, userID int, in StockCountInput) ([]string, error) {
+	mode := strings.ToLower(strings.TrimSpace(in.Mode))
+	if mode == "" {
+		mode = "add"
+	}
+	if mode != "add" && mode != "set" {
+		return nil, ErrInvalidCountMode
+	}
+
+	tx, err := db.DB.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := lockOpenStockTake(ctx, tx, id); err != nil {
+		return nil, err
+	}
+
+	// resolve every entry to a stock take line first
+	type count struct {
+		itemID    int64
+		productID int64
+		qty       int
+	}
+	counts := make([]count, 0, len(in.Entries))
+	var unknown []string
+	for _, e := range in.Entries {
+		qty := 1
+		if e.Quantity != nil {
+			qty = *e.Quantity
+		}
+
+		// a product id wins over a code, and a code is a barcode before it
+		// is a SKU, so a scan always lands on the same line
+		code := strings.TrimSpace(e.Barcode)
+		var ct count
+		err := tx.QueryRow(ctx, `
+			SELECT sti.id, sti.product_id
+			FROM stock_take_items sti
+			JOIN products p ON p.id = sti.product_id
+			WHERE sti.stock_take_id = $1
+			  AND ((($2)::bigint > 0 AND p.id = $2) OR ($3 <> '' AND (p.barcode = $3 OR p.sku = $3)))
+			ORDER BY p.id = $2 DESC, p.barcode = $3 DESC NULLS LAST, p.id
+			LIMIT 1
+		`, id, e.ProductID, code).Scan(&ct.itemID, &ct.productID)
+		if err == pgx.ErrNoRows {
+			if code != "" {
+				unknown = append(unknown, code)
+			} else {
+				unknown = append(unknown, strconv.FormatInt(e.ProductID, 10))
+			}
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("resolve count entry: %w", err)
+		}
+		ct.qty = qty
+		counts = append(counts, ct)
+	}
+	if len(unknown) > 0 {
+		return unknown, ErrUnknownCountEntry
+	}
+
+	// counted_txn_id marks how far the product's ledger had got when the
+	// shelf was counted; stock moved after it is not in the count. The stock
+	// rows are locked first so no movement of a counted product is in flight.
+	productIDs := make([]int64, 0, len(counts))
+	for _, ct := range counts {
+		productIDs = append(productIDs, ct.productID)
+	}
+	if err := lockStockRows(ctx, tx, productIDs); err != nil {
+		return nil, err
+	}
+	for _, ct := range counts {
+		query := `
+			UPDATE stock_take_items
+			SET counted_qty = COALESCE(counted_qty, 0) + $1, counted_by = $2, counted_at = NOW(),
+			    counted_txn_id = (SELECT COALESCE(MAX(it.id), 0) FROM inventory_transactions it WHERE it.product_id = stock_take_items.product_id)
+			WHERE id = $3
+		`
+		if mode == "set" {
+			query = `
+				UPDATE stock_take_items
+				SET counted_qty = $1, counted_by = $2, counted_at = NOW(),
+				    counted_txn_id = (SELECT COALESCE(MAX(it.id), 0) FROM inventory_transactions it WHERE it.product_id = stock_take_items.product_id)
+				WHERE id = $3
+			`
+		}
+		if _, err := tx.Exec(ctx, query, ct.qty, nullableUserID(userID), ct.itemID); err != nil {
+			return nil, fmt.Errorf("record count: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("commit tx: %w", err)
+	}
+
+	return nil, nil
+}
+
+// loadStockTakeVariance lists every product in the session. Uncounted products
+// report a counted quantity of zero.
+func loadStockTakeVariance(ctx context.Context, q db.Querier, id int64) ([]VarianceLine, error) {
+	rows, err := q.Query(ctx, `
+		SELECT sti.product_id, p.name, COALESCE(p.sku, ''), sti.system_qty,
+		       sti.counted_qty, COALESCE(sti.unit_cost, 0)
+		FROM stock_take_items sti
+		JOIN products p ON p.id = sti.product_id
+		WHERE sti.stock_take_id = $1
+		ORDER BY p.name, sti.product_id
+	`, id)
+	if err != nil {
+		return nil, fmt.Errorf("load stock take items: %w", err)
+	}
+	defer rows.Close()
+
+	lines := []VarianceLine{}
+	for rows.Next() {
+		var l VarianceLine
+		var counted *int
+		if err := rows.Scan(&l.ProductID, &l.Name, &l.SKU, &l.SystemQty, &counted, &l.UnitCost); err != nil {
+			return nil, err
+		}
+		if counted != nil {
+			l.Counted = true
+			l.CountedQty = *counted
+		}
+		l.Variance = l.CountedQty - l.SystemQty
+		l.VarianceValue = round2(float64(l.Variance) * l.UnitCost)
+		lines = append(lines, l)
+	}
+
+	return lines, rows.Err()
+}
+
+// stockMovedSinceSnapshot locks the stock rows of the session's products,
+// in product id order like checkStock, and returns the net quantity each one
+// moved between the snapshot and its count. Those movements are already on
+// the shelf when it is counted, and already in on_hand, so approving must
+// not post them again. An uncounted product is taken as empty now, so every
+// movement since the snapshot counts.
+func stockMovedSinceSnapshot(ctx context.Context, tx pgx.Tx, id int64) (map[int64]int, error) {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO product_stock (product_id, on_hand)
+		SELECT product_id, 0
+		FROM stock_take_items
+		WHERE stock_take_id = $1
+		ON CONFLICT (product_id) DO NOTHING
+	`, id)
+	if err != nil {
+		return nil, fmt.Errorf("init stock rows: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		SELECT ps.product_id
+		FROM product_stock ps
+		WHERE ps.product_id IN (SELECT product_id FROM stock_take_items WHERE stock_take_id = $1)
+		ORDER BY ps.product_id
+		FOR UPDATE
+	`, id)
+	if err != nil {
+		return nil, fmt.Errorf("lock stock rows: %w", err)
+	}
+
+	// sessions frozen before snapshot_txn_id was kept have nothing to go by
+	rows, err := tx.Query(ctx, `
+		SELECT sti.product_id, COALESCE(SUM(it.quantity), 0)
+		FROM stock_take_items sti
+		JOIN inventory_transactions it
+		  ON it.product_id = sti.product_id
+		 AND it.id > sti.snapshot_txn_id
+		 AND (sti.counted_qty IS NULL OR it.id <= sti.counted_txn_id)
+		WHERE sti.stock_take_id = $1 AND sti.snapshot_txn_id IS NOT NULL
+		GROUP BY sti.product_id
+	`, id)
+	if err != nil {
+		return nil, fmt.Errorf("load stock movements: %w", err)
+	}
+	defer rows.Close()
+
+	moved := map[int64]int{}
+	for rows.Next() {
+		var productID int64
+		var qty int
+		if err := rows.Scan(&productID, &qty); err != nil {
+			return nil, err
+		}
+		moved[productID] = qty
+	}
+
+	return moved, rows.Err()
+}
+
+// approveStockTake posts the variances as a correction adjustment that is
+// already approved — approving the count is the approval — and closes the
+// session. Stock that moved between the snapshot and the count is taken off
+// each variance, so the product ends at its counted quantity plus whatever
+// moved after the count. It returns zero if nothing differed.
+func approveStockTake(ctx context.Context, id int64, userID int, uncountedAsZero bool) (int64, string, error) {
+	tx, err := db.DB.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, "", fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := lockOpenStockTake(ctx, tx, id); err != nil {
+		return 0, "", err
+	}
+
+	variance, err := loadStockTakeVariance(ctx, tx, id)
+	if err != nil {
+		return 0, "", err
+	}
+
+	moved, err := stockMovedSinceSnapshot(ctx, tx, id)
+	if err != nil {
+		return 0, "", err
+	}
+
+	var lines []adjustmentLine
+	uncounted := 0
+	for _, l := range variance {
+		if !l.Counted {
+			uncounted++
+		}
+		qty := l.Variance - moved[l.ProductID]
+		if qty == 0 {
+			continue
+		}
+		lines = append(lines, adjustmentLine{
+			ProductID: l.ProductID,
+			Quantity:  qty,
+			UnitCost:  l.UnitCost,
+			Value:     round2(math.Abs(float64(qty)) * l.UnitCost),
+		})
+	}
+	if uncounted > 0 && !uncountedAsZero {
+		return 0, "", fmt.Errorf("%w: %d products", ErrStockTakeUncounted, uncounted)
+	}
+
+	var adjustmentID int64
+	var number string
+	var adjustmentRef interface{}
+	if len(lines) > 0 {
+		adjustmentID, number, err = saveStockAdjustment(ctx, tx, ReasonCorrection,
+			fmt.Sprintf("Stock take #%d", id), AdjustmentPosted, lines, userID)
+		if err != nil {
+			return 0, "", err
+		}
+		adjustmentRef = adjustmentID
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE stock_takes
+		SET status = $1, stock_adjustment_id = $2, approved_by = $3, approved_at = NOW()
+		WHERE id = $4
+	`, StockTakePosted, adjustmentRef, nullableUserID(userID), id)
+	if err != nil {
+		return 0, "", fmt.Errorf("update stock take: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, "", fmt.Errorf("commit tx: %w", err)
+	}
+
+	return adjustmentID, number, nil
+}
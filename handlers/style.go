@@ -0,0 +1,841 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"tulsi-pos/db"
+	"tulsi-pos/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// Style is a parent product (one kurta design) whose variants are the
+// size x colour combinations, each a products row with its own SKU and
+// barcode. Variants share the style's HSN code, category, gender and GST
+// rate; prices default to the style's and can be overridden per variant.
+type Style struct {
+	ID            int64          `json:"id"`
+	StyleCode     string         `json:"style_code"`
+	Name          string         `json:"name"`
+	HSNCode       string         `json:"hsn_code"`
+	Gender        string         `json:"gender"`
+	Category      string         `json:"category"`
+	PurchasePrice float64        `json:"purchase_price"`
+	SalesPrice    float64        `json:"sales_price"`
+	GSTPercent    float64        `json:"gst_percent"`
+	Sizes         []string       `json:"sizes"`   // in display order, e.g. S, M, L, XL
+	Colours       []string       `json:"colours"` // in display order
+	Variants      []VariantInput `json:"variants,omitempty"`
+	CreatedAt     time.Time      `json:"created_at"`
+}
+
+// VariantInput overrides the style's prices for one size/colour.
+type VariantInput struct {
+	Size          string   `json:"size"`
+	Colour        string   `json:"colour"`
+	PurchasePrice *float64 `json:"purchase_price"`
+	SalesPrice    *float64 `json:"sales_price"`
+}
+
+type Variant struct {
+	ProductID     int64   `json:"product_id"`
+	SKU           string  `json:"sku"`
+	Barcode       string  `json:"barcode"`
+	Size          string  `json:"size"`
+	Colour        string  `json:"colour"`
+	PurchasePrice float64 `json:"purchase_price"`
+	SalesPrice    float64 `json:"sales_price"`
+	OnHand        int     `json:"on_hand"`
+}
+
+// SizeColourGrid lays quantities out with a row per colour and a column per
+// size, in the style's order.
+type SizeColourGrid struct {
+	Sizes        []string `json:"sizes"`
+	Colours      []string `json:"colours"`
+	Quantities   [][]int  `json:"quantities"` // [colour][size]
+	SizeTotals   []int    `json:"size_totals"`
+	ColourTotals []int    `json:"colour_totals"`
+	Total        int      `json:"total"`
+}
+
+var (
+	ErrStyleNotFound  = errors.New("style not found")
+	ErrDuplicateStyle = errors.New("another style already has this style code")
+	ErrVariantOverMRP = errors.New("style change puts variants above their MRP")
+)
+
+// VariantMRPError lists, by variant product id, the variants a style change
+// would bill above their MRP.
+type VariantMRPError struct {
+	Variants map[string]string
+}
+
+func (e *VariantMRPError) Error() string {
+	return fmt.Sprintf("%s: %d variants", ErrVariantOverMRP, len(e.Variants))
+}
+
+func (e *VariantMRPError) Unwrap() error { return ErrVariantOverMRP }
+
+const styleColumns = `
+	id, style_code, name, COALESCE(hsn_code, ''), COALESCE(gender, ''), COALESCE(category, ''),
+	purchase_price, sales_price, gst_percent, sizes, colours, created_at`
+
+func scanStyle(row pgx.Row, s *Style) error {
+	return row.Scan(&s.ID, &s.StyleCode, &s.Name, &s.HSNCode, &s.Gender, &s.Category,
+		&s.PurchasePrice, &s.SalesPrice, &s.GSTPercent, &s.Sizes, &s.Colours, &s.CreatedAt)
+}
+
+// POST /styles
+// Creates the style and one variant product per size x colour.
+func CreateStyle(c *gin.Context) {
+	var s Style
+	if err := c.ShouldBindJSON(&s); err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid input")
+		return
+	}
+
+	if fieldErrs := validateStyle(&s); len(fieldErrs) > 0 {
+		utils.SendErrorResponseWithData(c, http.StatusBadRequest, "invalid style", fieldErrs)
+		return
+	}
+
+	ctx := c.Request.Context()
+	tx, err := db.DB.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "failed to start tx")
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var taken bool
+	err = tx.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM product_styles WHERE style_code = $1 AND deleted_at IS NULL)
+	`, s.StyleCode).Scan(&taken)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if taken {
+		utils.SendErrorResponse(c, http.StatusConflict, ErrDuplicateStyle.Error())
+		return
+	}
+
+	err = tx.QueryRow(ctx, `
+		INSERT INTO product_styles (
+			style_code, name, hsn_code, gender, category,
+			purchase_price, sales_price, gst_percent, sizes, colours
+		) VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), NULLIF($5, ''), $6, $7, $8, $9, $10)
+		RETURNING id
+	`, s.StyleCode, s.Name, s.HSNCode, s.Gender, s.Category,
+		s.PurchasePrice, s.SalesPrice, s.GSTPercent, s.Sizes, s.Colours).Scan(&s.ID)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	variants, err := createMissingVariants(ctx, tx, s, c.GetInt("user_id"))
+	if err != nil {
+		sendStyleError(c, err)
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "failed to commit tx")
+		return
+	}
+
+	utils.SendSuccessResponse(c, http.StatusCreated, gin.H{
+		"id":       s.ID,
+		"variants": variants,
+	}, "style created")
+}
+
+// GET /styles?category=&gender=
+func GetStyles(c *gin.Context) {
+	rows, err := db.DB.Query(c.Request.Context(), `
+		SELECT `+styleColumns+`
+		FROM product_styles
+		WHERE deleted_at IS NULL
+		  AND ($1 = '' OR category = $1)
+		  AND ($2 = '' OR gender = $2)
+		ORDER BY name, id
+	`, c.Query("category"), c.Query("gender"))
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	styles := []Style{}
+	for rows.Next() {
+		var s Style
+		if err := scanStyle(rows, &s); err != nil {
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		styles = append(styles, s)
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, styles, "Styles fetched successfully")
+}
+
+// GET /styles/:id
+// The style with its variants and a size x colour grid of stock on hand.
+func GetStyleByID(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid style id")
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	var s Style
+	err = scanStyle(db.DB.QueryRow(ctx, `
+		SELECT `+styleColumns+`
+		FROM product_styles
+		WHERE id = $1 AND deleted_at IS NULL
+	`, id), &s)
+	if err == pgx.ErrNoRows {
+		utils.SendErrorResponse(c, http.StatusNotFound, ErrStyleNotFound.Error())
+		return
+	}
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	variants, err := loadVariants(ctx, db.DB, id)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	qty := map[[2]string]int{}
+	for _, v := range variants {
+		qty[[2]string{v.Colour, v.Size}] += v.OnHand
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, gin.H{
+		"style":      s,
+		"variants":   variants,
+		"stock_grid": buildSizeColourGrid(s, qty),
+	}, "Style details fetched successfully")
+}
+
+// PUT /styles/:id
+// Updates the style and carries the shared attributes to every variant.
+// Variants still on the style's old price move to the new one; overridden
+// prices are left alone. New sizes or colours get their variants created;
+// existing ones cannot be removed here (delete the variant product instead).
+func UpdateStyle(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid style id")
+		return
+	}
+
+	var s Style
+	if err := c.ShouldBindJSON(&s); err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid input")
+		return
+	}
+	s.ID = id
+
+	if fieldErrs := validateStyle(&s); len(fieldErrs) > 0 {
+		utils.SendErrorResponseWithData(c, http.StatusBadRequest, "invalid style", fieldErrs)
+		return
+	}
+
+	variants, err := updateStyle(c.Request.Context(), s, c.GetInt("user_id"))
+	if err != nil {
+		sendStyleError(c, err)
+		return
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, gin.H{
+		"id":               id,
+		"created_variants": variants,
+	}, "style updated")
+}
+
+// GET /reports/style-stock?category=&gender=
+// Stock on hand and its value rolled up to each style, with its size x
+// colour grid.
+func GetStyleStockReport(c *gin.Context) {
+	sendStyleReport(c, `
+		SELECT p.style_id, COALESCE(p.colour, ''), COALESCE(p.size, ''),
+		       COALESCE(ps.on_hand, 0), COALESCE(ps.stock_value, 0)
+		FROM products p
+		LEFT JOIN product_stock ps ON ps.product_id = p.id
+		WHERE p.style_id IS NOT NULL AND p.deleted_at IS NULL
+	`, nil, "Style stock fetched successfully")
+}
+
+// GET /reports/style-sales?from=&to=&category=&gender=
+// Units sold (net of returns) and taxable sales per style in the period, with
+// the units as a size x colour grid.
+func GetStyleSalesReport(c *gin.Context) {
+	from, to, err := parsePeriod(c)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	sendStyleReport(c, `
+		SELECT p.style_id, COALESCE(p.colour, ''), COALESCE(p.size, ''), m.qty, m.taxable
+		FROM (
+			SELECT sii.product_id, sii.quantity AS qty, sii.line_total - sii.gst_amount AS taxable
+			FROM sales_invoice_items sii
+			JOIN sales_invoices si ON si.id = sii.sales_invoice_id
+			WHERE si.status = 'INVOICED' AND si.deleted_at IS NULL AND sii.deleted_at IS NULL
+			  AND si.invoiced_at >= $1 AND si.invoiced_at < $2
+			UNION ALL
+			SELECT sri.product_id, -sri.quantity, -sri.taxable_amount
+			FROM sales_return_items sri
+			JOIN sales_returns sr ON sr.id = sri.sales_return_id
+			WHERE sr.created_at >= $1 AND sr.created_at < $2
+		) m
+		JOIN products p ON p.id = m.product_id
+		WHERE p.style_id IS NOT NULL
+	`, []interface{}{from, to}, "Style sales fetched successfully")
+}
+
+// ---------- Internal Logic ----------
+
+func sendStyleError(c *gin.Context, err error) {
+	var mrpErr *VariantMRPError
+	switch {
+	case errors.As(err, &mrpErr):
+		utils.SendErrorResponseWithData(c, http.StatusBadRequest, ErrVariantOverMRP.Error(), mrpErr.Variants)
+	case errors.Is(err, ErrStyleNotFound):
+		utils.SendErrorResponse(c, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrDuplicateSKU), errors.Is(err, ErrDuplicateStyle):
+		utils.SendErrorResponse(c, http.StatusConflict, err.Error())
+	default:
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// validateStyle normalizes s and returns a message per invalid field.
+func validateStyle(s *Style) map[string]string {
+	s.StyleCode = strings.ToUpper(strings.TrimSpace(s.StyleCode))
+	s.Name = strings.TrimSpace(s.Name)
+	s.HSNCode = strings.TrimSpace(s.HSNCode)
+	s.Gender = strings.TrimSpace(s.Gender)
+	s.Category = strings.TrimSpace(s.Category)
+	s.Sizes = cleanAttributeValues(s.Sizes)
+	s.Colours = cleanAttributeValues(s.Colours)
+
+	errs := map[string]string{}
+	if s.StyleCode == "" {
+		errs["style_code"] = "style_code is required"
+	}
+	if s.Name == "" {
+		errs["name"] = "name is required"
+	}
+	if len(s.Sizes) == 0 && len(s.Colours) == 0 {
+		errs["sizes"] = "at least one size or colour is required"
+	}
+	if s.PurchasePrice < 0 {
+		errs["purchase_price"] = "purchase_price must not be negative"
+	}
+	if s.SalesPrice < 0 {
+		errs["sales_price"] = "sales_price must not be negative"
+	}
+	if !utils.IsValidGSTRate(s.GSTPercent) {
+		errs["gst_percent"] = fmt.Sprintf("gst_percent must be one of %v", utils.GSTRates)
+	}
+	for i := range s.Variants {
+		v := &s.Variants[i]
+		v.Size = strings.TrimSpace(v.Size)
+		v.Colour = strings.TrimSpace(v.Colour)
+		if !containsFold(s.Sizes, v.Size) || !containsFold(s.Colours, v.Colour) {
+			errs[fmt.Sprintf("variants[%d]", i)] = "size and colour must be among the style's sizes and colours"
+		} else if (v.PurchasePrice != nil && *v.PurchasePrice < 0) || (v.SalesPrice != nil && *v.SalesPrice < 0) {
+			errs[fmt.Sprintf("variants[%d]", i)] = "prices must not be negative"
+		}
+	}
+	return errs
+}
+
+// cleanAttributeValues trims values and drops blanks and repeats, keeping
+// the first spelling and the order given.
+func cleanAttributeValues(values []string) []string {
+	out := []string{}
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v != "" && !containsFold(out, v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func containsFold(values []string, v string) bool {
+	if v == "" {
+		return len(values) == 0
+	}
+	for _, x := range values {
+		if strings.EqualFold(x, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// styleCombos lists the style's size/colour pairs; a style with only sizes
+// (or only colours) has a blank for the other.
+func styleCombos(s Style) [][2]string {
+	sizes, colours := s.Sizes, s.Colours
+	if len(sizes) == 0 {
+		sizes = []string{""}
+	}
+	if len(colours) == 0 {
+		colours = []string{""}
+	}
+	combos := make([][2]string, 0, len(sizes)*len(colours))
+	for _, colour := range colours {
+		for _, size := range sizes {
+			combos = append(combos, [2]string{size, colour})
+		}
+	}
+	return combos
+}
+
+// variantSKU is STYLECODE-SIZE-COLOUR with spaces squeezed out.
+func variantSKU(styleCode, size, colour string) string {
+	parts := []string{styleCode}
+	for _, p := range []string{size, colour} {
+		if p != "" {
+			parts = append(parts, strings.ToUpper(strings.Join(strings.Fields(p), "")))
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+func variantName(name, size, colour string) string {
+	attrs := []string{}
+	for _, p := range []string{size, colour} {
+		if p != "" {
+			attrs = append(attrs, p)
+		}
+	}
+	if len(attrs) == 0 {
+		return name
+	}
+	return name + " - " + strings.Join(attrs, " / ")
+}
+
+// createMissingVariants adds a product for every size/colour of s that does
+// not have one yet, with an in-store EAN-13 barcode.
+func createMissingVariants(ctx context.Context, tx pgx.Tx, s Style, userID int) ([]Variant, error) {
+	existing := map[[2]string]bool{}
+	rows, err := tx.Query(ctx, `
+		SELECT LOWER(COALESCE(size, '')), LOWER(COALESCE(colour, ''))
+		FROM products
+		WHERE style_id = $1 AND deleted_at IS NULL
+	`, s.ID)
+	if err != nil {
+		return nil, fmt.Errorf("load variants: %w", err)
+	}
+	for rows.Next() {
+		var size, colour string
+		if err := rows.Scan(&size, &colour); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		existing[[2]string{size, colour}] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	created := []Variant{}
+	for _, combo := range styleCombos(s) {
+		size, colour := combo[0], combo[1]
+		if existing[[2]string{strings.ToLower(size), strings.ToLower(colour)}] {
+			continue
+		}
+
+		v := Variant{
+			SKU:           variantSKU(s.StyleCode, size, colour),
+			Size:          size,
+			Colour:        colour,
+			PurchasePrice: s.PurchasePrice,
+			SalesPrice:    s.SalesPrice,
+		}
+		for _, o := range s.Variants {
+			if strings.EqualFold(o.Size, size) && strings.EqualFold(o.Colour, colour) {
+				if o.PurchasePrice != nil {
+					v.PurchasePrice = *o.PurchasePrice
+				}
+				if o.SalesPrice != nil {
+					v.SalesPrice = *o.SalesPrice
+				}
+			}
+		}
+
+		var taken bool
+		err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM products WHERE sku = $1)`, v.SKU).Scan(&taken)
+		if err != nil {
+			return nil, fmt.Errorf("check sku: %w", err)
+		}
+		if taken {
+			return nil, fmt.Errorf("%w: %s", ErrDuplicateSKU, v.SKU)
+		}
+
+		err = tx.QueryRow(ctx, `
+			INSERT INTO products (
+				name, sku, hsn_code, gender, category, purchase_price, sales_price, gst_percent,
+				style_id, size, colour
+			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NULLIF($10, ''), NULLIF($11, ''))
+			RETURNING id
+		`, variantName(s.Name, size, colour), v.SKU, s.HSNCode, s.Gender, s.Category,
+			v.PurchasePrice, v.SalesPrice, s.GSTPercent, s.ID, size, colour).Scan(&v.ProductID)
+		if err != nil {
+			return nil, fmt.Errorf("insert variant: %w", err)
+		}
+
+		v.Barcode = utils.InStoreEAN13(v.ProductID)
+		if _, err := tx.Exec(ctx, `UPDATE products SET barcode = $1 WHERE id = $2`, v.Barcode, v.ProductID); err != nil {
+			return nil, fmt.Errorf("set barcode: %w", err)
+		}
+
+		prices := productPrices{PurchasePrice: v.PurchasePrice, SalesPrice: v.SalesPrice, GSTPercent: s.GSTPercent}
+		if _, err := recordPriceChanges(ctx, tx, v.ProductID, nil, prices, userID); err != nil {
+			return nil, err
+		}
+
+		created = append(created, v)
+	}
+	return created, nil
+}
+
+func updateStyle(ctx context.Context, s Style, userID int) ([]Variant, error) {
+	tx, err := db.DB.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var old Style
+	err = scanStyle(tx.QueryRow(ctx, `
+		SELECT `+styleColumns+`
+		FROM product_styles
+		WHERE id = $1 AND deleted_at IS NULL
+		FOR UPDATE
+	`, s.ID), &old)
+	if err == pgx.ErrNoRows {
+		return nil, ErrStyleNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load style: %w", err)
+	}
+
+	var taken bool
+	err = tx.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM product_styles WHERE style_code = $1 AND id <> $2 AND deleted_at IS NULL
+		)
+	`, s.StyleCode, s.ID).Scan(&taken)
+	if err != nil {
+		return nil, fmt.Errorf("check style code: %w", err)
+	}
+	if taken {
+		return nil, ErrDuplicateStyle
+	}
+
+	// sizes and colours only grow; keep the old ones in their place
+	for _, v := range old.Sizes {
+		if !containsFold(s.Sizes, v) {
+			s.Sizes = append(s.Sizes, v)
+		}
+	}
+	for _, v := range old.Colours {
+		if !containsFold(s.Colours, v) {
+			s.Colours = append(s.Colours, v)
+		}
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE product_styles
+		SET style_code = $1, name = $2, hsn_code = NULLIF($3, ''), gender = NULLIF($4, ''),
+		    category = NULLIF($5, ''), purchase_price = $6, sales_price = $7, gst_percent = $8,
+		    sizes = $9, colours = $10, updated_at = NOW()
+		WHERE id = $11
+	`, s.StyleCode, s.Name, s.HSNCode, s.Gender, s.Category,
+		s.PurchasePrice, s.SalesPrice, s.GSTPercent, s.Sizes, s.Colours, s.ID)
+	if err != nil {
+		return nil, fmt.Errorf("update style: %w", err)
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT id, COALESCE(size, ''), COALESCE(colour, ''),
+		       COALESCE(purchase_price, 0), COALESCE(sales_price, 0), COALESCE(gst_percent, 0),
+		       COALESCE(mrp, 0)
+		FROM products
+		WHERE style_id = $1 AND deleted_at IS NULL
+		ORDER BY id
+		FOR UPDATE
+	`, s.ID)
+	if err != nil {
+		return nil, fmt.Errorf("load variants: %w", err)
+	}
+	type variantRow struct {
+		id           int64
+		size, colour string
+		prices       productPrices
+		mrp          float64
+	}
+	var variants []variantRow
+	for rows.Next() {
+		var v variantRow
+		if err := rows.Scan(&v.id, &v.size, &v.colour,
+			&v.prices.PurchasePrice, &v.prices.SalesPrice, &v.prices.GSTPercent, &v.mrp); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		variants = append(variants, v)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	overMRP := map[string]string{}
+	for _, v := range variants {
+		prices := v.prices
+		prices.GSTPercent = s.GSTPercent
+		if round2(prices.PurchasePrice) == round2(old.PurchasePrice) {
+			prices.PurchasePrice = s.PurchasePrice
+		}
+		if round2(prices.SalesPrice) == round2(old.SalesPrice) {
+			prices.SalesPrice = s.SalesPrice
+		}
+
+		// the new price or rate must still bill the variant within its MRP
+		msg, err := mrpError(ctx, tx, Product{
+			HSNCode: s.HSNCode, SalesPrice: prices.SalesPrice, GSTPercent: prices.GSTPercent, MRP: v.mrp,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if msg != "" {
+			overMRP[strconv.FormatInt(v.id, 10)] = msg
+			continue
+		}
+
+		_, err = tx.Exec(ctx, `
+			UPDATE products
+			SET name = $1, hsn_code = $2, gender = $3, category = $4,
+			    purchase_price = $5, sales_price = $6, gst_percent = $7, updated_at = NOW()
+			WHERE id = $8
+		`, variantName(s.Name, v.size, v.colour), s.HSNCode, s.Gender, s.Category,
+			prices.PurchasePrice, prices.SalesPrice, prices.GSTPercent, v.id)
+		if err != nil {
+			return nil, fmt.Errorf("update variant: %w", err)
+		}
+		if _, err := recordPriceChanges(ctx, tx, v.id, &v.prices, prices, userID); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(overMRP) > 0 {
+		return nil, &VariantMRPError{Variants: overMRP}
+	}
+
+	created, err := createMissingVariants(ctx, tx, s, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("commit tx: %w", err)
+	}
+	return created, nil
+}
+
+func loadVariants(ctx context.Context, q db.Querier, styleID int64) ([]Variant, error) {
+	rows, err := q.Query(ctx, `
+		SELECT p.id, COALESCE(p.sku, ''), COALESCE(p.barcode, ''),
+		       COALESCE(p.size, ''), COALESCE(p.colour, ''),
+		       COALESCE(p.purchase_price, 0), COALESCE(p.sales_price, 0),
+		       COALESCE(ps.on_hand, 0)
+		FROM products p
+		LEFT JOIN product_stock ps ON ps.product_id = p.id
+		WHERE p.style_id = $1 AND p.deleted_at IS NULL
+		ORDER BY p.id
+	`, styleID)
+	if err != nil {
+		return nil, fmt.Errorf("load variants: %w", err)
+	}
+	defer rows.Close()
+
+	variants := []Variant{}
+	for rows.Next() {
+		var v Variant
+		if err := rows.Scan(&v.ProductID, &v.SKU, &v.Barcode, &v.Size, &v.Colour,
+			&v.PurchasePrice, &v.SalesPrice, &v.OnHand); err != nil {
+			return nil, err
+		}
+		variants = append(variants, v)
+	}
+	return variants, rows.Err()
+}
+
+// buildSizeColourGrid places quantities keyed by {colour, size} on the
+// style's grid. Values outside the style's sizes and colours (a variant
+// whose attribute was renamed) get their own row or column at the end.
+func buildSizeColourGrid(s Style, qty map[[2]string]int) SizeColourGrid {
+	g := SizeColourGrid{
+		Sizes:   append([]string{}, s.Sizes...),
+		Colours: append([]string{}, s.Colours...),
+	}
+	for k := range qty {
+		if !containsFold(g.Colours, k[0]) && k[0] != "" {
+			g.Colours = append(g.Colours, k[0])
+		}
+		if !containsFold(g.Sizes, k[1]) && k[1] != "" {
+			g.Sizes = append(g.Sizes, k[1])
+		}
+	}
+	if len(g.Sizes) == 0 {
+		g.Sizes = []string{""}
+	}
+	if len(g.Colours) == 0 {
+		g.Colours = []string{""}
+	}
+
+	index := func(values []string, v string) int {
+		for i, x := range values {
+			if strings.EqualFold(x, v) {
+				return i
+			}
+		}
+		return 0
+	}
+
+	g.Quantities = make([][]int, len(g.Colours))
+	for i := range g.Quantities {
+		g.Quantities[i] = make([]int, len(g.Sizes))
+	}
+	g.SizeTotals = make([]int, len(g.Sizes))
+	g.ColourTotals = make([]int, len(g.Colours))
+	for k, q := range qty {
+		ci, si := index(g.Colours, k[0]), index(g.Sizes, k[1])
+		g.Quantities[ci][si] += q
+		g.SizeTotals[si] += q
+		g.ColourTotals[ci] += q
+		g.Total += q
+	}
+	return g
+}
+
+type StyleReportRow struct {
+	StyleID   int64          `json:"style_id"`
+	StyleCode string         `json:"style_code"`
+	Name      string         `json:"name"`
+	Category  string         `json:"category"`
+	Gender    string         `json:"gender"`
+	Quantity  int            `json:"quantity"`
+	Amount    float64        `json:"amount"` // stock value, or taxable sales
+	Grid      SizeColourGrid `json:"grid"`
+}
+
+// sendStyleReport runs query, which returns (style_id, colour, size,
+// quantity, amount) rows, and answers with one row per style.
+func sendStyleReport(c *gin.Context, query string, params []interface{}, message string) {
+	ctx := c.Request.Context()
+
+	styleRows, err := db.DB.Query(ctx, `
+		SELECT `+styleColumns+`
+		FROM product_styles
+		WHERE deleted_at IS NULL
+		  AND ($1 = '' OR category = $1)
+		  AND ($2 = '' OR gender = $2)
+		ORDER BY name, id
+	`, c.Query("category"), c.Query("gender"))
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer styleRows.Close()
+
+	var styles []Style
+	for styleRows.Next() {
+		var s Style
+		if err := scanStyle(styleRows, &s); err != nil {
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		styles = append(styles, s)
+	}
+	styleRows.Close()
+
+	rows, err := db.DB.Query(ctx, query, params...)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	qty := map[int64]map[[2]string]int{}
+	amount := map[int64]float64{}
+	for rows.Next() {
+		var styleID int64
+		var colour, size string
+		var q int
+		var amt float64
+		if err := rows.Scan(&styleID, &colour, &size, &q, &amt); err != nil {
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if qty[styleID] == nil {
+			qty[styleID] = map[[2]string]int{}
+		}
+		qty[styleID][[2]string{colour, size}] += q
+		amount[styleID] += amt
+	}
+	if err := rows.Err(); err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	report := []StyleReportRow{}
+	totalQty := 0
+	totalAmount := 0.0
+	for _, s := range styles {
+		if _, ok := qty[s.ID]; !ok {
+			continue
+		}
+		r := StyleReportRow{
+			StyleID:   s.ID,
+			StyleCode: s.StyleCode,
+			Name:      s.Name,
+			Category:  s.Category,
+			Gender:    s.Gender,
+			Amount:    round2(amount[s.ID]),
+			Grid:      buildSizeColourGrid(s, qty[s.ID]),
+		}
+		r.Quantity = r.Grid.Total
+		totalQty += r.Quantity
+		totalAmount += r.Amount
+		report = append(report, r)
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, gin.H{
+		"styles":         report,
+		"total_quantity": totalQty,
+		"total_amount":   round2(totalAmount),
+	}, message)
+}
@@ -1,24 +1,80 @@
 package handlers
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"net/mail"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"tulsi-pos/db"
 	"tulsi-pos/utils"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
 )
 
 type Supplier struct {
-	ID          int64     `json:"id"`
-	Name        string    `json:"name" binding:"required"`
-	ContactInfo string    `json:"contact_info"`
-	CreatedAt   time.Time `json:"created_at"`
+	ID                int64     `json:"id"`
+	Name              string    `json:"name"`
+	ContactInfo       string    `json:"contact_info"`
+	GSTIN             string    `json:"gstin"`
+	PAN               string    `json:"pan"`
+	StateCode         string    `json:"state_code"` // derived from the GSTIN when one is given
+	AddressLine1      string    `json:"address_line1"`
+	AddressLine2      string    `json:"address_line2"`
+	City              string    `json:"city"`
+	Pincode           string    `json:"pincode"`
+	Phone             string    `json:"phone"`
+	Email             string    `json:"email"`
+	BankAccountName   string    `json:"bank_account_name"`
+	BankAccountNumber string    `json:"bank_account_number"`
+	BankIFSC          string    `json:"bank_ifsc"`
+	BankName          string    `json:"bank_name"`
+	CreditDays        int       `json:"credit_days"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+var (
+	pincodePattern     = regexp.MustCompile(`^[1-9][0-9]{5}$`)
+	phonePattern       = regexp.MustCompile(`^\+?[0-9]{10,13}$`)
+	bankAccountPattern = regexp.MustCompile(`^[0-9]{9,18}$`)
+)
+
+const supplierColumns = `
+	id, name, COALESCE(contact_info, ''), COALESCE(gstin, ''), COALESCE(pan, ''),
+	COALESCE(state_code, ''), COALESCE(address_line1, ''), COALESCE(address_line2, ''),
+	COALESCE(city, ''), COALESCE(pincode, ''), COALESCE(phone, ''), COALESCE(email, ''),
+	COALESCE(bank_account_name, ''), COALESCE(bank_account_number, ''),
+	COALESCE(bank_ifsc, ''), COALESCE(bank_name, ''), credit_days, created_at`
+
+// maskPrivate hides all but the last four characters of the PAN and bank
+// account number, for callers who are not signed in.
+func (s *Supplier) maskPrivate() {
+	s.PAN = maskTail(s.PAN)
+	s.BankAccountNumber = maskTail(s.BankAccountNumber)
+}
+
+func maskTail(v string) string {
+	if len(v) <= 4 {
+		return strings.Repeat("X", len(v))
+	}
+	return strings.Repeat("X", len(v)-4) + v[len(v)-4:]
+}
+
+func scanSupplier(row pgx.Row, s *Supplier) error {
+	return row.Scan(&s.ID, &s.Name, &s.ContactInfo, &s.GSTIN, &s.PAN,
+		&s.StateCode, &s.AddressLine1, &s.AddressLine2,
+		&s.City, &s.Pincode, &s.Phone, &s.Email,
+		&s.BankAccountName, &s.BankAccountNumber,
+		&s.BankIFSC, &s.BankName, &s.CreditDays, &s.CreatedAt)
 }
 
 // POST /suppliers
+// Validation failures come back as 400 with a field -> message map in data.
 func CreateSupplier(c *gin.Context) {
 	var s Supplier
 	if err := c.ShouldBindJSON(&s); err != nil {
@@ -26,12 +82,32 @@ func CreateSupplier(c *gin.Context) {
 		return
 	}
 
+	ctx := c.Request.Context()
+	fieldErrs, err := validateSupplier(ctx, &s, 0)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if len(fieldErrs) > 0 {
+		utils.SendErrorResponseWithData(c, http.StatusBadRequest, "invalid supplier", fieldErrs)
+		return
+	}
+
 	var id int64
-	err := db.DB.QueryRow(c.Request.Context(), `
-		INSERT INTO suppliers (name, contact_info)
-		VALUES ($1, $2)
+	err = db.DB.QueryRow(ctx, `
+		INSERT INTO suppliers (
+			name, contact_info, gstin, pan, state_code,
+			address_line1, address_line2, city, pincode, phone, email,
+			bank_account_name, bank_account_number, bank_ifsc, bank_name, credit_days
+		) VALUES (
+			$1, NULLIF($2, ''), NULLIF($3, ''), NULLIF($4, ''), NULLIF($5, ''),
+			NULLIF($6, ''), NULLIF($7, ''), NULLIF($8, ''), NULLIF($9, ''), NULLIF($10, ''), NULLIF($11, ''),
+			NULLIF($12, ''), NULLIF($13, ''), NULLIF($14, ''), NULLIF($15, ''), $16
+		)
 		RETURNING id
-	`, s.Name, s.ContactInfo).Scan(&id)
+	`, s.Name, s.ContactInfo, s.GSTIN, s.PAN, s.StateCode,
+		s.AddressLine1, s.AddressLine2, s.City, s.Pincode, s.Phone, s.Email,
+		s.BankAccountName, s.BankAccountNumber, s.BankIFSC, s.BankName, s.CreditDays).Scan(&id)
 
 	if err != nil {
 		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
@@ -39,14 +115,16 @@ func CreateSupplier(c *gin.Context) {
 	}
 
 	utils.SendSuccessResponse(c, http.StatusCreated, gin.H{
-		"id": id,
+		"id":         id,
+		"state_code": s.StateCode,
 	}, "supplier created")
 }
 
 // GET /suppliers
+// PAN and bank account numbers are masked unless the caller is signed in.
 func GetSuppliers(c *gin.Context) {
 	rows, err := db.DB.Query(c.Request.Context(), `
-		SELECT id, name, contact_info, created_at
+		SELECT `+supplierColumns+`
 		FROM suppliers
 		WHERE deleted_at IS NULL
 		ORDER BY created_at DESC
@@ -60,9 +138,12 @@ func GetSuppliers(c *gin.Context) {
 	suppliers := []Supplier{}
 	for rows.Next() {
 		var s Supplier
-		if err := rows.Scan(&s.ID, &s.Name, &s.ContactInfo, &s.CreatedAt); err != nil {
+		if err := scanSupplier(rows, &s); err != nil {
 			continue
 		}
+		if c.GetInt("user_id") == 0 {
+			s.maskPrivate()
+		}
 		suppliers = append(suppliers, s)
 	}
 
@@ -70,6 +151,7 @@ func GetSuppliers(c *gin.Context) {
 }
 
 // GET /suppliers/:id
+// PAN and bank account number are masked unless the caller is signed in.
 func GetSupplierByID(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
@@ -79,16 +161,19 @@ func GetSupplierByID(c *gin.Context) {
 	}
 
 	var s Supplier
-	err = db.DB.QueryRow(c.Request.Context(), `
-		SELECT id, name, contact_info, created_at
+	err = scanSupplier(db.DB.QueryRow(c.Request.Context(), `
+		SELECT `+supplierColumns+`
 		FROM suppliers
 		WHERE id = $1 AND deleted_at IS NULL
-	`, id).Scan(&s.ID, &s.Name, &s.ContactInfo, &s.CreatedAt)
+	`, id), &s)
 
 	if err != nil {
 		utils.SendErrorResponse(c, http.StatusNotFound, "supplier not found")
 		return
 	}
+	if c.GetInt("user_id") == 0 {
+		s.maskPrivate()
+	}
 
 	utils.SendSuccessResponse(c, http.StatusOK, s, "Supplier details fetched successfully")
 }
@@ -108,11 +193,28 @@ func UpdateSupplier(c *gin.Context) {
 		return
 	}
 
-	res, err := db.DB.Exec(c.Request.Context(), `
+	ctx := c.Request.Context()
+	fieldErrs, err := validateSupplier(ctx, &s, id)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if len(fieldErrs) > 0 {
+		utils.SendErrorResponseWithData(c, http.StatusBadRequest, "invalid supplier", fieldErrs)
+		return
+	}
+
+	res, err := db.DB.Exec(ctx, `
 		UPDATE suppliers
-		SET name = $1, contact_info = $2
-		WHERE id = $3 AND deleted_at IS NULL
-	`, s.Name, s.ContactInfo, id)
+		SET name = $1, contact_info = NULLIF($2, ''), gstin = NULLIF($3, ''), pan = NULLIF($4, ''),
+		    state_code = NULLIF($5, ''), address_line1 = NULLIF($6, ''), address_line2 = NULLIF($7, ''),
+		    city = NULLIF($8, ''), pincode = NULLIF($9, ''), phone = NULLIF($10, ''), email = NULLIF($11, ''),
+		    bank_account_name = NULLIF($12, ''), bank_account_number = NULLIF($13, ''),
+		    bank_ifsc = NULLIF($14, ''), bank_name = NULLIF($15, ''), credit_days = $16
+		WHERE id = $17 AND deleted_at IS NULL
+	`, s.Name, s.ContactInfo, s.GSTIN, s.PAN, s.StateCode,
+		s.AddressLine1, s.AddressLine2, s.City, s.Pincode, s.Phone, s.Email,
+		s.BankAccountName, s.BankAccountNumber, s.BankIFSC, s.BankName, s.CreditDays, id)
 
 	if err != nil {
 		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
@@ -124,7 +226,9 @@ func UpdateSupplier(c *gin.Context) {
 		return
 	}
 
-	utils.SendSuccessResponse(c, http.StatusOK, nil, "supplier updated")
+	utils.SendSuccessResponse(c, http.StatusOK, gin.H{
+		"state_code": s.StateCode,
+	}, "supplier updated")
 }
 
 // DELETE /suppliers/:id
@@ -154,3 +258,99 @@ func DeleteSupplier(c *gin.Context) {
 
 	utils.SendSuccessResponse(c, http.StatusOK, nil, "supplier deleted")
 }
+
+// validateSupplier normalizes s and returns a message per invalid field. A
+// GSTIN fixes the state code and PAN; values given alongside it must agree.
+// id is the supplier being updated (0 on create) so it does not clash with
+// its own GSTIN.
+func validateSupplier(ctx context.Context, s *Supplier, id int64) (map[string]string, error) {
+	s.Name = strings.TrimSpace(s.Name)
+	s.ContactInfo = strings.TrimSpace(s.ContactInfo)
+	s.GSTIN = strings.ToUpper(strings.TrimSpace(s.GSTIN))
+	s.PAN = strings.ToUpper(strings.TrimSpace(s.PAN))
+	s.StateCode = strings.TrimSpace(s.StateCode)
+	s.AddressLine1 = strings.TrimSpace(s.AddressLine1)
+	s.AddressLine2 = strings.TrimSpace(s.AddressLine2)
+	s.City = strings.TrimSpace(s.City)
+	s.Pincode = strings.TrimSpace(s.Pincode)
+	s.Phone = strings.NewReplacer(" ", "", "-", "").Replace(s.Phone)
+	s.Email = strings.ToLower(strings.TrimSpace(s.Email))
+	s.BankAccountName = strings.TrimSpace(s.BankAccountName)
+	s.BankAccountNumber = strings.TrimSpace(s.BankAccountNumber)
+	s.BankIFSC = strings.ToUpper(strings.TrimSpace(s.BankIFSC))
+	s.BankName = strings.TrimSpace(s.BankName)
+
+	errs := map[string]string{}
+
+	if s.Name == "" {
+		errs["name"] = "name is required"
+	} else if len(s.Name) > 100 {
+		errs["name"] = "name must be at most 100 characters"
+	}
+
+	if s.GSTIN != "" {
+		if err := utils.ValidateGSTIN(s.GSTIN); err != nil {
+			errs["gstin"] = err.Error()
+		} else {
+			state := s.GSTIN[:2]
+			if s.StateCode != "" && s.StateCode != state {
+				errs["state_code"] = fmt.Sprintf("state_code %s does not match GSTIN state %s", s.StateCode, state)
+			}
+			s.StateCode = state
+
+			pan := utils.GSTINPAN(s.GSTIN)
+			if s.PAN != "" && s.PAN != pan {
+				errs["pan"] = "pan does not match the PAN in the GSTIN"
+			}
+			s.PAN = pan
+		}
+	}
+
+	if _, ok := errs["state_code"]; !ok && s.StateCode != "" && !utils.IsValidStateCode(s.StateCode) {
+		errs["state_code"] = "invalid state_code"
+	}
+	if _, ok := errs["pan"]; !ok && s.PAN != "" && !utils.IsValidPAN(s.PAN) {
+		errs["pan"] = "pan must be 5 letters, 4 digits and a letter"
+	}
+	if s.Pincode != "" && !pincodePattern.MatchString(s.Pincode) {
+		errs["pincode"] = "pincode must be 6 digits"
+	}
+	if s.Phone != "" && !phonePattern.MatchString(s.Phone) {
+		errs["phone"] = "phone must be 10 to 13 digits"
+	}
+	if s.Email != "" {
+		if addr, err := mail.ParseAddress(s.Email); err != nil || addr.Address != s.Email {
+			errs["email"] = "invalid email"
+		}
+	}
+	if s.BankAccountNumber != "" && !bankAccountPattern.MatchString(s.BankAccountNumber) {
+		errs["bank_account_number"] = "bank_account_number must be 9 to 18 digits"
+	}
+	if s.BankIFSC != "" && !utils.IsValidIFSC(s.BankIFSC) {
+		errs["bank_ifsc"] = "invalid IFSC"
+	}
+	if s.BankAccountNumber != "" && s.BankIFSC == "" {
+		errs["bank_ifsc"] = "bank_ifsc is required with a bank account number"
+	}
+	if s.CreditDays < 0 {
+		errs["credit_days"] = "credit_days must not be negative"
+	}
+
+	if _, ok := errs["gstin"]; !ok && s.GSTIN != "" {
+		var taken bool
+		err := db.DB.QueryRow(ctx, `
+			SELECT EXISTS (
+				SELECT 1 FROM suppliers
+				WHERE gstin = $1 AND id <> $2 AND deleted_at IS NULL
+			)
+		`, s.GSTIN, id).Scan(&taken)
+		if err != nil {
+			return nil, fmt.Errorf("check gstin: %w", err)
+		}
+		if taken {
+			errs["gstin"] = "another supplier already has this GSTIN"
+		}
+	}
+
+	return errs, nil
+}
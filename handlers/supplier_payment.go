@@ -0,0 +1,509 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"tulsi-pos/db"
+	"tulsi-pos/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// ---------- Request DTOs ----------
+
+type SupplierPaymentAllocationInput struct {
+	PurchaseInvoiceID int64   `json:"purchase_invoice_id" binding:"required"`
+	Amount            float64 `json:"amount" binding:"required,gt=0"`
+}
+
+// SupplierPaymentInput records money paid to a supplier. Without allocations
+// the amount is applied to the supplier's open invoices, earliest due first.
+type SupplierPaymentInput struct {
+	PaymentDate string                           `json:"payment_date"`                    // YYYY-MM-DD, defaults to today
+	PaymentMode string                           `json:"payment_mode" binding:"required"` // CASH, BANK, UPI, CHEQUE
+	Amount      float64                          `json:"amount" binding:"required,gt=0"`
+	Reference   string                           `json:"reference"` // UTR / cheque number
+	Notes       string                           `json:"notes"`
+	Allocations []SupplierPaymentAllocationInput `json:"allocations" binding:"dive"`
+}
+
+// SupplierLedgerEntry is one purchase invoice, debit note or payment on a
+// supplier's account. Credit is what we owe, debit what was settled.
+type SupplierLedgerEntry struct {
+	Date      string  `json:"date"`
+	RefType   string  `json:"ref_type"`
+	RefID     int64   `json:"ref_id"`
+	RefNumber string  `json:"ref_number"`
+	RefLink   string  `json:"ref_link,omitempty"`
+	Debit     float64 `json:"debit"`
+	Credit    float64 `json:"credit"`
+	Balance   float64 `json:"balance"`
+}
+
+var (
+	ErrInvalidSupplierPaymentMode = errors.New("payment_mode must be CASH, BANK, UPI or CHEQUE")
+	ErrAllocationExceedsBalance   = errors.New("allocation exceeds the invoice balance")
+	ErrAllocationExceedsPayment   = errors.New("allocations exceed the payment amount")
+	ErrSupplierPaymentNotFound    = errors.New("supplier payment not found")
+)
+
+// purchaseBalanceSQL is what is still owed on purchase invoice pi after
+// payments and debit notes.
+const purchaseBalanceSQL = `(pi.total_invoice_amount - pi.amount_paid - COALESCE((
+	SELECT SUM(pr.total_amount) FROM purchase_returns pr WHERE pr.purchase_invoice_id = pi.id
+), 0))`
+
+// ---------- Public Handlers ----------
+
+// POST /suppliers/:id/payments
+func CreateSupplierPayment(c *gin.Context) {
+	supplierID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || supplierID <= 0 {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid supplier id")
+		return
+	}
+
+	var in SupplierPaymentInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid payload: "+err.Error())
+		return
+	}
+
+	in.PaymentMode = strings.ToUpper(strings.TrimSpace(in.PaymentMode))
+	switch in.PaymentMode {
+	case "CASH", "BANK", "UPI", "CHEQUE":
+	default:
+		utils.SendErrorResponse(c, http.StatusBadRequest, ErrInvalidSupplierPaymentMode.Error())
+		return
+	}
+
+	paymentDate := time.Now()
+	if in.PaymentDate != "" {
+		paymentDate, err = time.ParseInLocation(utils.DateFormat, in.PaymentDate, time.Local)
+		if err != nil {
+			utils.SendErrorResponse(c, http.StatusBadRequest, "payment_date must be YYYY-MM-DD")
+			return
+		}
+	}
+
+	id, allocations, err := createSupplierPayment(c.Request.Context(), supplierID, paymentDate, c.GetInt("user_id"), in)
+	if err != nil {
+		sendSupplierPaymentError(c, err)
+		return
+	}
+
+	allocated := 0.0
+	for _, a := range allocations {
+		allocated += a.Amount
+	}
+
+	utils.SendSuccessResponse(c, http.StatusCreated, gin.H{
+		"id":          id,
+		"allocations": allocations,
+		"unallocated": round2(in.Amount - allocated),
+	}, "supplier payment recorded")
+}
+
+// GET /suppliers/:id/payments
+func ListSupplierPayments(c *gin.Context) {
+	supplierID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || supplierID <= 0 {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid supplier id")
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	rows, err := db.DB.Query(ctx, `
+		SELECT sp.id, sp.payment_date, sp.payment_mode, sp.amount,
+		       COALESCE(sp.reference, ''), COALESCE(sp.notes, ''), sp.created_at,
+		       COALESCE(a.allocated, 0)
+		FROM supplier_payments sp
+		LEFT JOIN (
+			SELECT supplier_payment_id, SUM(amount) AS allocated
+			FROM supplier_payment_allocations
+			GROUP BY supplier_payment_id
+		) a ON a.supplier_payment_id = sp.id
+		WHERE sp.supplier_id = $1 AND sp.deleted_at IS NULL
+		ORDER BY sp.payment_date DESC, sp.id DESC
+	`, supplierID)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	resp := []gin.H{}
+	for rows.Next() {
+		var (
+			id                     int64
+			paymentDate, createdAt time.Time
+			mode, ref, notes       string
+			amount, allocated      float64
+		)
+		if err := rows.Scan(&id, &paymentDate, &mode, &amount, &ref, &notes, &createdAt, &allocated); err != nil {
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		resp = append(resp, gin.H{
+			"id":           id,
+			"payment_date": utils.FormatDate(paymentDate),
+			"payment_mode": mode,
+			"amount":       amount,
+			"reference":    ref,
+			"notes":        notes,
+			"allocated":    allocated,
+			"unallocated":  round2(amount - allocated),
+			"created_at":   utils.FormatDateTime(createdAt),
+		})
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, resp, "Supplier payments fetched successfully")
+}
+
+// DELETE /suppliers/:id/payments/:paymentId
+// Voids a payment and gives its allocations back to the invoices.
+func DeleteSupplierPayment(c *gin.Context) {
+	supplierID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || supplierID <= 0 {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid supplier id")
+		return
+	}
+	paymentID, err := strconv.ParseInt(c.Param("paymentId"), 10, 64)
+	if err != nil || paymentID <= 0 {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid payment id")
+		return
+	}
+
+	if err := deleteSupplierPayment(c.Request.Context(), supplierID, paymentID); err != nil {
+		sendSupplierPaymentError(c, err)
+		return
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, nil, "supplier payment removed")
+}
+
+// GET /suppliers/:id/ledger?from=&to=
+// Entries are in date order with a running balance of what we owe the
+// supplier; anything before from is rolled into the opening balance.
+func GetSupplierLedger(c *gin.Context) {
+	supplierID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || supplierID <= 0 {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid supplier id")
+		return
+	}
+
+	// dates compare as YYYY-MM-DD strings; parsing only validates them
+	from, to := c.Query("from"), c.Query("to")
+	if from != "" {
+		if _, err := time.ParseInLocation(utils.DateFormat, from, time.Local); err != nil {
+			utils.SendErrorResponse(c, http.StatusBadRequest, "from must be YYYY-MM-DD")
+			return
+		}
+	}
+	if to != "" {
+		if _, err := time.ParseInLocation(utils.DateFormat, to, time.Local); err != nil {
+			utils.SendErrorResponse(c, http.StatusBadRequest, "to must be YYYY-MM-DD")
+			return
+		}
+	}
+
+	ctx := c.Request.Context()
+
+	var name string
+	err = db.DB.QueryRow(ctx, `
+		SELECT name FROM suppliers WHERE id = $1 AND deleted_at IS NULL
+	`, supplierID).Scan(&name)
+	if err == pgx.ErrNoRows {
+		utils.SendErrorResponse(c, http.StatusNotFound, "supplier not found")
+		return
+	}
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	rows, err := db.DB.Query(ctx, `
+		SELECT entry_date, ref_type, ref_id, ref_number, debit, credit
+		FROM (
+			SELECT COALESCE(pi.invoice_date, pi.created_at::date) AS entry_date, pi.created_at,
+			       'purchase' AS ref_type, pi.id AS ref_id, pi.invoice_number AS ref_number,
+			       0::numeric AS debit, COALESCE(pi.total_invoice_amount, 0) AS credit
+			FROM purchase_invoices pi
+			WHERE pi.supplier_id = $1 AND pi.deleted_at IS NULL
+			UNION ALL
+			SELECT pr.created_at::date, pr.created_at,
+			       'purchase_return', pr.id, pr.debit_note_number,
+			       pr.total_amount, 0
+			FROM purchase_returns pr
+			WHERE pr.supplier_id = $1
+			UNION ALL
+			SELECT sp.payment_date, sp.created_at,
+			       'payment', sp.id, sp.payment_mode || COALESCE(' ' || NULLIF(sp.reference, ''), ''),
+			       sp.amount, 0
+			FROM supplier_payments sp
+			WHERE sp.supplier_id = $1 AND sp.deleted_at IS NULL
+		) e
+		ORDER BY entry_date, created_at, ref_id
+	`, supplierID)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	opening, balance := 0.0, 0.0
+	entries := []SupplierLedgerEntry{}
+	for rows.Next() {
+		var e SupplierLedgerEntry
+		var date time.Time
+		if err := rows.Scan(&date, &e.RefType, &e.RefID, &e.RefNumber, &e.Debit, &e.Credit); err != nil {
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		e.Date = utils.FormatDate(date)
+		if to != "" && e.Date > to {
+			break
+		}
+
+		balance += e.Credit - e.Debit
+		if from != "" && e.Date < from {
+			opening = balance
+			continue
+		}
+
+		e.Balance = round2(balance)
+		if link, ok := refLinks[e.RefType]; ok {
+			e.RefLink = fmt.Sprintf(link, e.RefID)
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, gin.H{
+		"supplier_id":     supplierID,
+		"supplier_name":   name,
+		"opening_balance": round2(opening),
+		"closing_balance": round2(balance),
+		"entries":         entries,
+	}, "Supplier ledger fetched successfully")
+}
+
+// ---------- Internal Logic ----------
+
+func sendSupplierPaymentError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrSupplierNotFound), errors.Is(err, ErrSupplierPaymentNotFound),
+		errors.Is(err, ErrPurchaseNotFound):
+		utils.SendErrorResponse(c, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrAllocationExceedsBalance), errors.Is(err, ErrAllocationExceedsPayment):
+		utils.SendErrorResponse(c, http.StatusBadRequest, err.Error())
+	default:
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// createSupplierPayment records a payment and applies it to purchase invoices.
+// The supplier row is locked so concurrent payments cannot both claim the
+// same open balance.
+func createSupplierPayment(ctx context.Context, supplierID int64, paymentDate time.Time, userID int, in SupplierPaymentInput) (int64, []SupplierPaymentAllocationInput, error) {
+	tx, err := db.DB.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, `
+		SELECT id FROM suppliers WHERE id = $1 AND deleted_at IS NULL FOR UPDATE
+	`, supplierID).Scan(&supplierID)
+	if err == pgx.ErrNoRows {
+		return 0, nil, ErrSupplierNotFound
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("load supplier: %w", err)
+	}
+
+	amount := round2(in.Amount)
+	var allocations []SupplierPaymentAllocationInput
+	if len(in.Allocations) == 0 {
+		allocations, err = autoAllocateSupplierPayment(ctx, tx, supplierID, amount)
+		if err != nil {
+			return 0, nil, err
+		}
+	} else {
+		allocations, err = checkSupplierPaymentAllocations(ctx, tx, supplierID, in.Allocations)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+
+	allocated := 0.0
+	for _, a := range allocations {
+		allocated += a.Amount
+	}
+	if allocated > amount+0.005 {
+		return 0, nil, fmt.Errorf("%w: allocated %.2f, paid %.2f", ErrAllocationExceedsPayment, allocated, amount)
+	}
+
+	var id int64
+	err = tx.QueryRow(ctx, `
+		INSERT INTO supplier_payments (
+			supplier_id, payment_date, payment_mode, amount, reference, notes, created_by
+		) VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), $7)
+		RETURNING id
+	`, supplierID, paymentDate.Format(utils.DateFormat), in.PaymentMode, amount,
+		strings.TrimSpace(in.Reference), strings.TrimSpace(in.Notes), nullableUserID(userID)).Scan(&id)
+	if err != nil {
+		return 0, nil, fmt.Errorf("insert supplier payment: %w", err)
+	}
+
+	for _, a := range allocations {
+		_, err = tx.Exec(ctx, `
+			INSERT INTO supplier_payment_allocations (supplier_payment_id, purchase_invoice_id, amount)
+			VALUES ($1, $2, $3)
+		`, id, a.PurchaseInvoiceID, a.Amount)
+		if err != nil {
+			return 0, nil, fmt.Errorf("insert payment allocation: %w", err)
+		}
+
+		_, err = tx.Exec(ctx, `
+			UPDATE purchase_invoices SET amount_paid = amount_paid + $1 WHERE id = $2
+		`, a.Amount, a.PurchaseInvoiceID)
+		if err != nil {
+			return 0, nil, fmt.Errorf("update amount paid: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, nil, fmt.Errorf("commit tx: %w", err)
+	}
+
+	return id, allocations, nil
+}
+
+// checkSupplierPaymentAllocations merges duplicate invoices and makes sure
+// each allocation fits the invoice's open balance.
+func checkSupplierPaymentAllocations(ctx context.Context, tx pgx.Tx, supplierID int64, in []SupplierPaymentAllocationInput) ([]SupplierPaymentAllocationInput, error) {
+	requested := map[int64]float64{}
+	order := []int64{}
+	for _, a := range in {
+		if _, ok := requested[a.PurchaseInvoiceID]; !ok {
+			order = append(order, a.PurchaseInvoiceID)
+		}
+		requested[a.PurchaseInvoiceID] += a.Amount
+	}
+
+	allocations := make([]SupplierPaymentAllocationInput, 0, len(order))
+	for _, invoiceID := range order {
+		var number string
+		var balance float64
+		err := tx.QueryRow(ctx, `
+			SELECT pi.invoice_number, `+purchaseBalanceSQL+`
+			FROM purchase_invoices pi
+			WHERE pi.id = $1 AND pi.supplier_id = $2 AND pi.deleted_at IS NULL
+			FOR UPDATE OF pi
+		`, invoiceID, supplierID).Scan(&number, &balance)
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("%w: %d", ErrPurchaseNotFound, invoiceID)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("load purchase invoice: %w", err)
+		}
+
+		amount := round2(requested[invoiceID])
+		if amount > balance+0.005 {
+			return nil, fmt.Errorf("%w: invoice %s balance %.2f, allocated %.2f",
+				ErrAllocationExceedsBalance, number, balance, amount)
+		}
+		allocations = append(allocations, SupplierPaymentAllocationInput{PurchaseInvoiceID: invoiceID, Amount: amount})
+	}
+
+	return allocations, nil
+}
+
+// autoAllocateSupplierPayment spreads amount over the supplier's open
+// invoices, earliest due date first.
+func autoAllocateSupplierPayment(ctx context.Context, tx pgx.Tx, supplierID int64, amount float64) ([]SupplierPaymentAllocationInput, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT pi.id, `+purchaseBalanceSQL+`
+		FROM purchase_invoices pi
+		WHERE pi.supplier_id = $1 AND pi.deleted_at IS NULL
+		  AND `+purchaseBalanceSQL+` > 0
+		ORDER BY COALESCE(pi.due_date, pi.created_at::date), pi.id
+		FOR UPDATE OF pi
+	`, supplierID)
+	if err != nil {
+		return nil, fmt.Errorf("load open invoices: %w", err)
+	}
+	defer rows.Close()
+
+	allocations := []SupplierPaymentAllocationInput{}
+	remaining := amount
+	for rows.Next() && remaining > 0.005 {
+		var a SupplierPaymentAllocationInput
+		var balance float64
+		if err := rows.Scan(&a.PurchaseInvoiceID, &balance); err != nil {
+			return nil, err
+		}
+		a.Amount = round2(min(balance, remaining))
+		remaining = round2(remaining - a.Amount)
+		allocations = append(allocations, a)
+	}
+
+	return allocations, rows.Err()
+}
+
+func deleteSupplierPayment(ctx context.Context, supplierID, paymentID int64) error {
+	tx, err := db.DB.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	res, err := tx.Exec(ctx, `
+		UPDATE supplier_payments
+		SET deleted_at = NOW()
+		WHERE id = $1 AND supplier_id = $2 AND deleted_at IS NULL
+	`, paymentID, supplierID)
+	if err != nil {
+		return fmt.Errorf("delete supplier payment: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return ErrSupplierPaymentNotFound
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE purchase_invoices pi
+		SET amount_paid = pi.amount_paid - a.amount
+		FROM (
+			SELECT purchase_invoice_id, SUM(amount) AS amount
+			FROM supplier_payment_allocations
+			WHERE supplier_payment_id = $1
+			GROUP BY purchase_invoice_id
+		) a
+		WHERE pi.id = a.purchase_invoice_id
+	`, paymentID)
+	if err != nil {
+		return fmt.Errorf("reverse allocations: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `DELETE FROM supplier_payment_allocations WHERE supplier_payment_id = $1`, paymentID)
+	if err != nil {
+		return fmt.Errorf("delete allocations: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"tulsi-pos/config"
+	"tulsi-pos/db"
+	"tulsi-pos/utils"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrProductNotFound    = errors.New("product not found")
+	ErrRateOverrideDenied = errors.New("overriding the GST rate requires the gst_rate_override permission")
+)
+
+// isInterState reports whether a supply between the store and the given state
+// crosses a state boundary. Unknown states are treated as local.
+func isInterState(stateCode string) bool {
+	return stateCode != "" && config.StoreStateCode != "" && stateCode != config.StoreStateCode
+}
+
+// splitGST divides a GST amount into its components. Inter-state supplies
+// carry IGST only; intra-state supplies split it evenly into CGST and SGST,
+// with any odd paisa going to SGST so the parts always add up.
+func splitGST(gstAmount float64, interState bool) (cgst, sgst, igst float64) {
+	gst := round2(gstAmount)
+	if interState {
+		return 0, 0, gst
+	}
+	cgst = round2(gst / 2)
+	sgst = round2(gst - cgst)
+	return cgst, sgst, 0
+}
+
+// resolveGSTPercent looks up the tax rule for an HSN code that applies to a
+// per-unit taxable value on the given date. Rules match on HSN prefix, so a
+// rule for "6203" covers "62034200"; the most specific code wins, then the
+// latest effective date. Slabs are (min_unit_value, max_unit_value].
+func resolveGSTPercent(ctx context.Context, q db.Querier, hsnCode string, unitValue float64, at time.Time) (float64, bool, error) {
+	if hsnCode == "" {
+		return 0, false, nil
+	}
+
+	var rate float64
+	err := q.QueryRow(ctx, `
+		SELECT gst_percent
+		FROM tax_rules
+		WHERE deleted_at IS NULL
+		  AND $1 LIKE hsn_code || '%'
+		  AND effective_from <= $2
+		  AND (effective_to IS NULL OR effective_to >= $2)
+		  AND (min_unit_value = 0 OR $3 > min_unit_value)
+		  AND (max_unit_value IS NULL OR $3 <= max_unit_value)
+		ORDER BY LENGTH(hsn_code) DESC, effective_from DESC, min_unit_value DESC
+		LIMIT 1
+	`, hsnCode, at.Format(utils.DateFormat), unitValue).Scan(&rate)
+	if err == pgx.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("resolve tax rule: %w", err)
+	}
+	return rate, true, nil
+}
+
+// productGSTPercent returns the GST rate for selling a product at unitValue,
+// falling back to products.gst_percent when no tax rule covers its HSN code.
+func productGSTPercent(ctx context.Context, q db.Querier, productID int64, unitValue float64, at time.Time) (float64, error) {
+	var hsnCode string
+	var defaultRate float64
+	err := q.QueryRow(ctx, `
+		SELECT COALESCE(hsn_code, ''), COALESCE(gst_percent, 0)
+		FROM products
+		WHERE id = $1 AND deleted_at IS NULL
+	`, productID).Scan(&hsnCode, &defaultRate)
+	if err == pgx.ErrNoRows {
+		return 0, fmt.Errorf("%w: %d", ErrProductNotFound, productID)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("load product: %w", err)
+	}
+
+	rate, ok, err := resolveGSTPercent(ctx, q, hsnCode, unitValue, at)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return defaultRate, nil
+	}
+	return rate, nil
+}
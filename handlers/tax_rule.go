@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"tulsi-pos/db"
+	"tulsi-pos/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TaxRule struct {
+	ID            int64    `json:"id"`
+	HSNCode       string   `json:"hsn_code" binding:"required"`
+	MinUnitValue  float64  `json:"min_unit_value"`
+	MaxUnitValue  *float64 `json:"max_unit_value"`
+	GSTPercent    float64  `json:"gst_percent" binding:"min=0,max=100"`
+	EffectiveFrom string   `json:"effective_from" binding:"required"` // YYYY-MM-DD
+	EffectiveTo   *string  `json:"effective_to"`
+}
+
+// POST /tax-rules
+func CreateTaxRule(c *gin.Context) {
+	var r TaxRule
+	if err := c.ShouldBindJSON(&r); err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid input")
+		return
+	}
+
+	// the code is matched as a prefix, so anything but digits would widen it
+	r.HSNCode = strings.TrimSpace(r.HSNCode)
+	if !utils.IsValidHSNPrefix(r.HSNCode) {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "hsn_code must be 2 to 8 digits")
+		return
+	}
+	if !utils.IsValidGSTRate(r.GSTPercent) {
+		utils.SendErrorResponse(c, http.StatusBadRequest, fmt.Sprintf("gst_percent must be one of %v", utils.GSTRates))
+		return
+	}
+	if r.MinUnitValue < 0 || (r.MaxUnitValue != nil && *r.MaxUnitValue <= r.MinUnitValue) {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "max_unit_value must be greater than min_unit_value")
+		return
+	}
+
+	from, err := time.Parse(utils.DateFormat, r.EffectiveFrom)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid effective_from")
+		return
+	}
+	if r.EffectiveTo != nil {
+		to, err := time.Parse(utils.DateFormat, *r.EffectiveTo)
+		if err != nil || to.Before(from) {
+			utils.SendErrorResponse(c, http.StatusBadRequest, "invalid effective_to")
+			return
+		}
+	}
+
+	var id int64
+	err = db.DB.QueryRow(c.Request.Context(), `
+		INSERT INTO tax_rules
+		(hsn_code, min_unit_value, max_unit_value, gst_percent, effective_from, effective_to, created_by)
+		VALUES ($1,$2,$3,$4,$5,$6,$7)
+		RETURNING id
+	`, r.HSNCode, r.MinUnitValue, r.MaxUnitValue, r.GSTPercent,
+		r.EffectiveFrom, r.EffectiveTo, nullableUserID(c.GetInt("user_id"))).Scan(&id)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SendSuccessResponse(c, http.StatusCreated, gin.H{"id": id}, "tax rule created")
+}
+
+// GET /tax-rules?hsn_code=
+func ListTaxRules(c *gin.Context) {
+	where := "WHERE deleted_at IS NULL"
+	params := []interface{}{}
+	if hsn := c.Query("hsn_code"); hsn != "" {
+		where += " AND hsn_code = $1"
+		params = append(params, hsn)
+	}
+
+	rows, err := db.DB.Query(c.Request.Context(), `
+		SELECT id, hsn_code, min_unit_value, max_unit_value, gst_percent,
+		       effective_from, effective_to
+		FROM tax_rules
+		`+where+`
+		ORDER BY hsn_code, effective_from DESC, min_unit_value
+	`, params...)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	rules := []TaxRule{}
+	for rows.Next() {
+		var r TaxRule
+		var from time.Time
+		var to *time.Time
+		if err := rows.Scan(&r.ID, &r.HSNCode, &r.MinUnitValue, &r.MaxUnitValue,
+			&r.GSTPercent, &from, &to); err != nil {
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		r.EffectiveFrom = utils.FormatDate(from)
+		if to != nil {
+			s := utils.FormatDate(*to)
+			r.EffectiveTo = &s
+		}
+		rules = append(rules, r)
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, rules, "Tax rules fetched successfully")
+}
+
+// DELETE /tax-rules/:id
+func DeleteTaxRule(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	res, err := db.DB.Exec(c.Request.Context(), `
+		UPDATE tax_rules
+		SET deleted_at = NOW()
+		WHERE id = $1 AND deleted_at IS NULL
+	`, id)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if res.RowsAffected() == 0 {
+		utils.SendErrorResponse(c, http.StatusNotFound, "tax rule not found")
+		return
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, nil, "tax rule deleted")
+}
+
+// GET /tax-rules/resolve?product_id=&unit_value=&date=
+func ResolveTaxRate(c *gin.Context) {
+	productID, err := strconv.ParseInt(c.Query("product_id"), 10, 64)
+	if err != nil || productID <= 0 {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid product_id")
+		return
+	}
+	unitValue, err := strconv.ParseFloat(c.Query("unit_value"), 64)
+	if err != nil || unitValue < 0 {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "invalid unit_value")
+		return
+	}
+
+	at := time.Now()
+	if d := c.Query("date"); d != "" {
+		at, err = time.Parse(utils.DateFormat, d)
+		if err != nil {
+			utils.SendErrorResponse(c, http.StatusBadRequest, "invalid date")
+			return
+		}
+	}
+
+	rate, err := productGSTPercent(c.Request.Context(), db.DB, productID, unitValue, at)
+	if err != nil {
+		if errors.Is(err, ErrProductNotFound) {
+			utils.SendErrorResponse(c, http.StatusNotFound, "product not found")
+			return
+		}
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, gin.H{
+		"product_id":  productID,
+		"unit_value":  unitValue,
+		"gst_percent": rate,
+	}, "Tax rate resolved successfully")
+}
@@ -0,0 +1,368 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"tulsi-pos/config"
+	"tulsi-pos/db"
+	"tulsi-pos/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// stockPosting is what a stock movement did to the product's quantity and
+// value.
+type stockPosting struct {
+	Balance    int     // on hand after the movement
+	UnitCost   float64 // cost per unit moved
+	Value      float64 // signed value moved
+	ValueAfter float64 // stock value after the movement
+}
+
+// receiptRef names the movements that brought stock in, so reversing them
+// takes back their own FIFO layers.
+type receiptRef struct {
+	RefType string
+	RefIDs  []int64
+}
+
+// postInventoryCost records a stock movement like postInventory and values
+// it at unitCost. When unitCost is negative, incoming units are taken at the
+// current average cost and outgoing units are costed by the store's
+// valuation method: the moving average, or the oldest FIFO layers.
+//
+// FIFO layers are kept under both methods so the store can switch methods
+// without rebuilding them. When stock runs negative the missing units are
+// costed at the average and later receipts make up the shortfall first.
+func postInventoryCost(ctx context.Context, tx pgx.Tx, productID int64, qty int, unitCost float64, refType string, refID int64, userID int) (stockPosting, error) {
+	return postStock(ctx, tx, productID, qty, unitCost, nil, refType, refID, userID)
+}
+
+// reverseInventoryCost takes qty units of a receipt back out of stock. They
+// come out of the receipt's own FIFO layers first, and leave at unitCost, or
+// at what those layers cost when unitCost is negative.
+func reverseInventoryCost(ctx context.Context, tx pgx.Tx, productID int64, qty int, unitCost float64, from receiptRef, refType string, refID int64, userID int) (stockPosting, error) {
+	return postStock(ctx, tx, productID, -qty, unitCost, &from, refType, refID, userID)
+}
+
+func postStock(ctx context.Context, tx pgx.Tx, productID int64, qty int, unitCost float64, from *receiptRef, refType string, refID int64, userID int) (stockPosting, error) {
+	var p stockPosting
+
+	_, err := tx.Exec(ctx, `
+		INSERT INTO product_stock (product_id, on_hand, updated_at)
+		VALUES ($1, 0, NOW())
+		ON CONFLICT (product_id) DO NOTHING
+	`, productID)
+	if err != nil {
+		return p, fmt.Errorf("update stock: %w", err)
+	}
+
+	var onHand int
+	var avgCost, stockValue, fallbackCost float64
+	err = tx.QueryRow(ctx, `
+		SELECT ps.on_hand, ps.avg_cost, ps.stock_value, COALESCE(pr.purchase_price, 0)
+		FROM product_stock ps
+		JOIN products pr ON pr.id = ps.product_id
+		WHERE ps.product_id = $1
+		FOR UPDATE OF ps
+	`, productID).Scan(&onHand, &avgCost, &stockValue, &fallbackCost)
+	if err != nil {
+		return p, fmt.Errorf("lock stock: %w", err)
+	}
+	if avgCost <= 0 {
+		avgCost = fallbackCost
+	}
+	if stockValue == 0 && onHand > 0 {
+		// stock booked before valuation was recorded
+		stockValue = round2(float64(onHand) * avgCost)
+	}
+	if onHand > 0 {
+		if err := openCostLayer(ctx, tx, productID, onHand, stockValue, avgCost); err != nil {
+			return p, err
+		}
+	}
+
+	p.Balance = onHand + qty
+	switch {
+	case qty > 0:
+		p.UnitCost = unitCost
+		if p.UnitCost < 0 {
+			p.UnitCost = avgCost
+		}
+		p.Value = round2(float64(qty) * p.UnitCost)
+
+		if onHand <= 0 {
+			// nothing valued on hand: the receipt sets the cost
+			avgCost = p.UnitCost
+			p.ValueAfter = round2(float64(p.Balance) * p.UnitCost)
+		} else {
+			p.ValueAfter = round2(stockValue + p.Value)
+			avgCost = p.ValueAfter / float64(p.Balance)
+		}
+
+	case qty < 0:
+		out := -qty
+		layered, uncovered := 0.0, out
+		if from != nil {
+			layered, uncovered, err = consumeCostLayers(ctx, tx, productID, uncovered, from)
+			if err != nil {
+				return p, err
+			}
+		}
+		older, uncovered, err := consumeCostLayers(ctx, tx, productID, uncovered, nil)
+		if err != nil {
+			return p, err
+		}
+		cost := float64(out) * avgCost
+		switch {
+		case unitCost >= 0:
+			cost = float64(out) * unitCost
+		case from != nil:
+			cost = layered + older + float64(uncovered)*avgCost
+		case config.ValuationMethod == config.ValuationFIFO:
+			cost = older + float64(uncovered)*avgCost
+		}
+
+		p.Value = -round2(cost)
+		if p.Balance == 0 && onHand > 0 {
+			// the last units carry whatever value is left
+			p.Value = -round2(stockValue)
+		}
+		p.UnitCost = -p.Value / float64(out)
+		p.ValueAfter = round2(stockValue + p.Value)
+		if p.Balance > 0 {
+			avgCost = p.ValueAfter / float64(p.Balance)
+		} else {
+			p.ValueAfter = round2(float64(p.Balance) * avgCost)
+		}
+
+	default:
+		p.ValueAfter = stockValue
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE product_stock
+		SET on_hand = $1, avg_cost = $2, stock_value = $3, updated_at = NOW()
+		WHERE product_id = $4
+	`, p.Balance, roundCost(avgCost), p.ValueAfter, productID)
+	if err != nil {
+		return p, fmt.Errorf("update stock: %w", err)
+	}
+
+	var txnID int64
+	err = tx.QueryRow(ctx, `
+		INSERT INTO inventory_transactions (
+			product_id, quantity, ref_type, ref_id, balance_after, created_by,
+			unit_cost, value, value_after
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id
+	`, productID, qty, refType, refID, p.Balance, nullableUserID(userID),
+		roundCost(p.UnitCost), p.Value, p.ValueAfter).Scan(&txnID)
+	if err != nil {
+		return p, fmt.Errorf("insert inventory transaction: %w", err)
+	}
+
+	if qty > 0 {
+		// units that only cover a shortfall are already sold
+		if remaining := min(qty, p.Balance); remaining > 0 {
+			_, err = tx.Exec(ctx, `
+				INSERT INTO inventory_cost_layers (
+					product_id, inventory_transaction_id, quantity, remaining, unit_cost
+				) VALUES ($1, $2, $3, $4, $5)
+			`, productID, txnID, qty, remaining, roundCost(p.UnitCost))
+			if err != nil {
+				return p, fmt.Errorf("insert cost layer: %w", err)
+			}
+		}
+	}
+
+	return p, nil
+}
+
+// openCostLayer gives stock on hand that no layer covers, booked before
+// layers were kept, an opening layer of its own. It carries whatever value
+// the layers do not, or the average cost, and is consumed before any receipt.
+func openCostLayer(ctx context.Context, tx pgx.Tx, productID int64, onHand int, stockValue, avgCost float64) error {
+	var layered int
+	var layeredValue float64
+	err := tx.QueryRow(ctx, `
+		SELECT COALESCE(SUM(remaining), 0), COALESCE(SUM(remaining * unit_cost), 0)
+		FROM inventory_cost_layers
+		WHERE product_id = $1 AND remaining > 0
+	`, productID).Scan(&layered, &layeredValue)
+	if err != nil {
+		return fmt.Errorf("load cost layers: %w", err)
+	}
+	gap := onHand - layered
+	if gap <= 0 {
+		return nil
+	}
+
+	unitCost := avgCost
+	if rest := stockValue - layeredValue; rest > 0 {
+		unitCost = rest / float64(gap)
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO inventory_cost_layers (product_id, quantity, remaining, unit_cost)
+		VALUES ($1, $2, $2, $3)
+	`, productID, gap, roundCost(unitCost))
+	if err != nil {
+		return fmt.Errorf("insert opening cost layer: %w", err)
+	}
+	return nil
+}
+
+// consumeCostLayers takes qty units from the product's oldest open layers,
+// opening layers first and only those opened by from when it is set, and
+// returns their cost and how many units no layer covered.
+func consumeCostLayers(ctx context.Context, tx pgx.Tx, productID int64, qty int, from *receiptRef) (float64, int, error) {
+	if qty <= 0 {
+		return 0, 0, nil
+	}
+	where := "WHERE product_id = $1 AND remaining > 0"
+	params := []interface{}{productID}
+	if from != nil {
+		where += ` AND inventory_transaction_id IN (
+			SELECT id FROM inventory_transactions
+			WHERE product_id = $1 AND ref_type = $2 AND ref_id = ANY($3))`
+		params = append(params, from.RefType, from.RefIDs)
+	}
+	rows, err := tx.Query(ctx, `
+		SELECT id, remaining, unit_cost
+		FROM inventory_cost_layers
+		`+where+`
+		ORDER BY inventory_transaction_id IS NOT NULL, id
+		FOR UPDATE
+	`, params...)
+	if err != nil {
+		return 0, 0, fmt.Errorf("load cost layers: %w", err)
+	}
+
+	type layer struct {
+		id        int64
+		remaining int
+		unitCost  float64
+	}
+	var layers []layer
+	for rows.Next() {
+		var l layer
+		if err := rows.Scan(&l.id, &l.remaining, &l.unitCost); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		layers = append(layers, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	cost := 0.0
+	for _, l := range layers {
+		if qty == 0 {
+			break
+		}
+		take := min(qty, l.remaining)
+		_, err := tx.Exec(ctx, `
+			UPDATE inventory_cost_layers SET remaining = remaining - $1 WHERE id = $2
+		`, take, l.id)
+		if err != nil {
+			return 0, 0, fmt.Errorf("consume cost layer: %w", err)
+		}
+		cost += float64(take) * l.unitCost
+		qty -= take
+	}
+	return cost, qty, nil
+}
+
+// roundCost keeps unit costs to four decimals, as stored.
+func roundCost(v float64) float64 {
+	return math.Round(v*10000) / 10000
+}
+
+type StockValuationRow struct {
+	ProductID int64   `json:"product_id"`
+	Name      string  `json:"name"`
+	SKU       string  `json:"sku"`
+	Category  string  `json:"category"`
+	OnHand    int     `json:"on_hand"`
+	UnitCost  float64 `json:"unit_cost"`
+	Value     float64 `json:"value"`
+}
+
+// GET /reports/stock-valuation?as_of=&category=
+// Quantity and value on hand per product at the end of as_of (default now),
+// from the running values on the stock ledger. A product's movements are
+// posted under its stock row lock, so the highest id carries the latest
+// running values; created_at is when the transaction began and can run out of
+// order. Movements posted before valuation was recorded are valued at the
+// product's purchase price.
+func GetStockValuation(c *gin.Context) {
+	asOf := time.Now()
+	until := asOf
+	if v := c.Query("as_of"); v != "" {
+		t, err := time.ParseInLocation(utils.DateFormat, v, time.Local)
+		if err != nil {
+			utils.SendErrorResponse(c, http.StatusBadRequest, "as_of must be YYYY-MM-DD")
+			return
+		}
+		asOf, until = t, t.AddDate(0, 0, 1)
+	}
+	category := strings.TrimSpace(c.Query("category"))
+
+	rows, err := db.DB.Query(c.Request.Context(), `
+		SELECT p.id, p.name, COALESCE(p.sku, ''), COALESCE(p.category, ''),
+		       last.balance_after,
+		       COALESCE(last.value_after, last.balance_after * COALESCE(p.purchase_price, 0))
+		FROM products p
+		JOIN LATERAL (
+			SELECT it.balance_after, it.value_after
+			FROM inventory_transactions it
+			WHERE it.product_id = p.id AND it.created_at < $1
+			ORDER BY it.id DESC
+			LIMIT 1
+		) last ON TRUE
+		WHERE last.balance_after <> 0
+		  AND ($2 = '' OR p.category = $2)
+		ORDER BY p.name, p.id
+	`, until, category)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	products := []StockValuationRow{}
+	totalQty := 0
+	totalValue := 0.0
+	for rows.Next() {
+		var r StockValuationRow
+		if err := rows.Scan(&r.ProductID, &r.Name, &r.SKU, &r.Category, &r.OnHand, &r.Value); err != nil {
+			utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		r.Value = round2(r.Value)
+		r.UnitCost = roundCost(r.Value / float64(r.OnHand))
+		totalQty += r.OnHand
+		totalValue += r.Value
+		products = append(products, r)
+	}
+	if err := rows.Err(); err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SendSuccessResponse(c, http.StatusOK, gin.H{
+		"as_of":          utils.FormatDate(asOf),
+		"method":         config.ValuationMethod,
+		"products":       products,
+		"total_quantity": totalQty,
+		"total_value":    round2(totalValue),
+	}, "Stock valuation fetched successfully")
+}
@@ -4,6 +4,7 @@ import (
 	"log"
 	"strconv"
 	awsclient "tulsi-pos/aws"
+	"tulsi-pos/config"
 	"tulsi-pos/db"
 	"tulsi-pos/handlers"
 	"tulsi-pos/middleware"
@@ -17,20 +18,27 @@ func main() {
 		log.Fatal(err)
 	}
 
+	config.InitConfig()
 	awsclient.InitAWS()
 
 	r := gin.Default()
 
 	r.POST("/products", handlers.CreateProduct)
 	r.GET("/products", handlers.GetProducts)
+	r.GET("/products/lookup", handlers.LookupProduct)
+	r.GET("/products/export", handlers.ExportProducts)
+	r.POST("/products/import", middleware.AuthRequired(), handlers.ImportProducts)
+	r.POST("/products/barcodes", middleware.AuthRequired(), handlers.GenerateBarcodes)
+	r.GET("/labels/sheets", handlers.GetLabelSheets)
+	r.POST("/labels", middleware.AuthRequired(), handlers.PrintLabels)
 
 	// r.POST("/purchases", handlers.CreatePurchase)
 	// r.POST("/sales", handlers.CreateSale)
 	r.POST("/purchases", middleware.AuthRequired(), handlers.CreatePurchase)
 	// r.POST("/sales", middleware.AuthRequired(), handlers.CreateSale)
 
-	r.POST("/sales-invoices", handlers.CreateInvoice)
-	r.PUT("/sales-invoices/:id", handlers.UpdateInvoice)
+	r.POST("/sales-invoices", middleware.OptionalAuth(), handlers.CreateInvoice)
+	r.PUT("/sales-invoices/:id", middleware.OptionalAuth(), handlers.UpdateInvoice)
 
 	// middleware.RequireRole("admin")
 
@@ -50,17 +58,94 @@ func main() {
 	})
 
 	r.GET("/products/:id", handlers.GetProductByID)
+	r.PUT("/products/:id", middleware.AuthRequired(), handlers.UpdateProduct)
+	r.DELETE("/products/:id", middleware.AuthRequired(), handlers.DeleteProduct)
+	r.GET("/products/:id/price-history", handlers.GetProductPriceHistory)
+
+	r.POST("/styles", middleware.AuthRequired(), handlers.CreateStyle)
+	r.GET("/styles", handlers.GetStyles)
+	r.GET("/styles/:id", handlers.GetStyleByID)
+	r.PUT("/styles/:id", middleware.AuthRequired(), handlers.UpdateStyle)
+	r.PUT("/products/:id/reorder", middleware.AuthRequired(), handlers.UpdateProductReorder)
 
 	r.GET("/sales/invoices/:id", handlers.GetInvoiceByID)
 	r.GET("/sales/invoices", handlers.ListInvoices)
 
+	r.GET("/sales/invoices/:id/payments", handlers.ListInvoicePayments)
+	r.POST("/sales/invoices/:id/payments", middleware.AuthRequired(), handlers.AddInvoicePayment)
+	r.DELETE("/sales/invoices/:id/payments/:paymentId", middleware.AuthRequired(), handlers.DeleteInvoicePayment)
+
+	r.POST("/sales/invoices/:id/returns", middleware.AuthRequired(), handlers.CreateSalesReturn)
+	r.GET("/sales/invoices/:id/returns", handlers.ListSalesReturns)
+	r.GET("/sales/returns/:id", handlers.GetSalesReturnByID)
+
 	r.GET("/purchases", handlers.ListPurchases)
+	r.GET("/purchases/:id", handlers.GetPurchaseByID)
+	r.PUT("/purchases/:id", middleware.AuthRequired(), handlers.UpdatePurchase)
+	r.POST("/purchases/:id/cancel", middleware.AuthRequired(), handlers.CancelPurchase)
+	r.POST("/purchases/:id/labels", middleware.AuthRequired(), handlers.PrintPurchaseLabels)
+	r.POST("/purchases/:id/returns", middleware.AuthRequired(), handlers.CreatePurchaseReturn)
+	r.GET("/purchases/:id/returns", handlers.ListPurchaseReturns)
+	r.GET("/purchases/returns/:id", handlers.GetPurchaseReturnByID)
+	r.POST("/purchases/returns/:id/generate-pdf", middleware.AuthRequired(), handlers.GenerateDebitNotePDF)
+
+	r.GET("/purchase-orders", handlers.ListPurchaseOrders)
+	r.GET("/purchase-orders/:id", handlers.GetPurchaseOrderByID)
+	r.POST("/purchase-orders/generate", middleware.AuthRequired(), handlers.GeneratePurchaseOrders)
+	r.POST("/purchase-orders", middleware.AuthRequired(), handlers.CreatePurchaseOrder)
+	r.PUT("/purchase-orders/:id", middleware.AuthRequired(), handlers.UpdatePurchaseOrder)
+	r.POST("/purchase-orders/:id/send", middleware.AuthRequired(), handlers.SendPurchaseOrder)
+	r.POST("/purchase-orders/:id/cancel", middleware.AuthRequired(), handlers.CancelPurchaseOrder)
+	r.POST("/purchase-orders/:id/close", middleware.AuthRequired(), handlers.ClosePurchaseOrder)
+	r.POST("/purchase-orders/:id/receipts", middleware.AuthRequired(), handlers.CreateGoodsReceipt)
+	r.GET("/goods-receipts/:id", handlers.GetGoodsReceiptByID)
+
+	r.GET("/document-series", handlers.ListDocumentSeries)
+	r.POST("/document-series", middleware.AuthRequired(), middleware.RequireRole("admin"), handlers.CreateDocumentSeries)
+	r.PUT("/document-series/:code", middleware.AuthRequired(), middleware.RequireRole("admin"), handlers.UpdateDocumentSeries)
+
+	r.GET("/tax-rules", handlers.ListTaxRules)
+	r.GET("/tax-rules/resolve", handlers.ResolveTaxRate)
+	r.POST("/tax-rules", middleware.AuthRequired(), middleware.RequireRole("admin"), handlers.CreateTaxRule)
+	r.DELETE("/tax-rules/:id", middleware.AuthRequired(), middleware.RequireRole("admin"), handlers.DeleteTaxRule)
+	r.GET("/promotions", handlers.ListPromotions)
+	r.POST("/promotions", middleware.AuthRequired(), middleware.RequireRole("admin"), handlers.CreatePromotion)
+	r.DELETE("/promotions/:id", middleware.AuthRequired(), middleware.RequireRole("admin"), handlers.DeletePromotion)
+
+	r.GET("/reports/gstr1", middleware.AuthRequired(), handlers.GetGSTR1)
+	r.GET("/reports/gstr3b", middleware.AuthRequired(), handlers.GetGSTR3B)
+	r.GET("/reports/hsn-summary", middleware.AuthRequired(), handlers.GetHSNSummaryReport)
+	r.GET("/reports/payables", middleware.AuthRequired(), handlers.GetPayablesReport)
+	r.GET("/reports/margin", middleware.AuthRequired(), handlers.GetMarginReport)
+	r.GET("/reports/stock-valuation", middleware.AuthRequired(), handlers.GetStockValuation)
+	r.GET("/reports/style-stock", middleware.AuthRequired(), handlers.GetStyleStockReport)
+	r.GET("/reports/style-sales", middleware.AuthRequired(), handlers.GetStyleSalesReport)
+
+	r.GET("/inventory/stock", handlers.GetStock)
+	r.GET("/inventory/low-stock", handlers.GetLowStock)
+	r.GET("/inventory/products/:id/ledger", handlers.GetProductLedger)
+	r.GET("/inventory/adjustments", handlers.ListStockAdjustments)
+	r.GET("/inventory/adjustments/:id", handlers.GetStockAdjustmentByID)
+	r.POST("/inventory/adjustments", middleware.AuthRequired(), handlers.CreateStockAdjustment)
+	r.POST("/inventory/adjustments/:id/approve", middleware.AuthRequired(), middleware.RequireRole("admin"), handlers.ApproveStockAdjustment)
+	r.POST("/inventory/adjustments/:id/reject", middleware.AuthRequired(), middleware.RequireRole("admin"), handlers.RejectStockAdjustment)
+
+	r.GET("/inventory/stock-takes", handlers.ListStockTakes)
+	r.GET("/inventory/stock-takes/:id", handlers.GetStockTakeByID)
+	r.POST("/inventory/stock-takes", middleware.AuthRequired(), handlers.CreateStockTake)
+	r.POST("/inventory/stock-takes/:id/counts", middleware.AuthRequired(), handlers.SubmitStockCounts)
+	r.POST("/inventory/stock-takes/:id/approve", middleware.AuthRequired(), middleware.RequireRole("admin"), handlers.ApproveStockTake)
+	r.POST("/inventory/stock-takes/:id/cancel", middleware.AuthRequired(), middleware.RequireRole("admin"), handlers.CancelStockTake)
 
 	r.POST("/suppliers", handlers.CreateSupplier)
-	r.GET("/suppliers", handlers.GetSuppliers)
-	r.GET("/suppliers/:id", handlers.GetSupplierByID)
+	r.GET("/suppliers", middleware.OptionalAuth(), handlers.GetSuppliers)
+	r.GET("/suppliers/:id", middleware.OptionalAuth(), handlers.GetSupplierByID)
 	r.PUT("/suppliers/:id", handlers.UpdateSupplier)
 	r.DELETE("/suppliers/:id", handlers.DeleteSupplier)
+	r.GET("/suppliers/:id/ledger", handlers.GetSupplierLedger)
+	r.GET("/suppliers/:id/payments", handlers.ListSupplierPayments)
+	r.POST("/suppliers/:id/payments", middleware.AuthRequired(), handlers.CreateSupplierPayment)
+	r.DELETE("/suppliers/:id/payments/:paymentId", middleware.AuthRequired(), handlers.DeleteSupplierPayment)
 
 	r.Run(":8080")
 }
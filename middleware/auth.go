@@ -11,6 +11,11 @@ import (
 
 var JwtSecret = []byte("YOUR_SUPER_SECRET_KEY")
 
+// Permissions granted to roles through role_permissions.
+const (
+	PermGSTRateOverride = "gst_rate_override"
+)
+
 func AuthRequired() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		tokenString := c.GetHeader("Authorization")
@@ -33,11 +38,70 @@ func AuthRequired() gin.HandlerFunc {
 			return
 		}
 
-		claims := token.Claims.(jwt.MapClaims)
+		setClaims(c, token.Claims.(jwt.MapClaims))
+
+		c.Next()
+	}
+}
 
-		c.Set("user_id", int(claims["user_id"].(float64)))
-		c.Set("roles", claims["roles"])
+// OptionalAuth identifies the user when a valid bearer token is sent but lets
+// anonymous requests through, for endpoints where only some actions need a
+// permission.
+func OptionalAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenString := c.GetHeader("Authorization")
+
+		if strings.HasPrefix(tokenString, "Bearer ") {
+			token, err := jwt.Parse(strings.TrimPrefix(tokenString, "Bearer "), func(token *jwt.Token) (interface{}, error) {
+				return JwtSecret, nil
+			})
+			if err == nil && token.Valid {
+				setClaims(c, token.Claims.(jwt.MapClaims))
+			}
+		}
 
 		c.Next()
 	}
 }
+
+func setClaims(c *gin.Context, claims jwt.MapClaims) {
+	c.Set("user_id", int(claims["user_id"].(float64)))
+	c.Set("roles", claims["roles"])
+	c.Set("permissions", claims["permissions"])
+}
+
+// RequireRole must run after AuthRequired; it rejects users whose token does
+// not carry the given role.
+func RequireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !HasRole(c, role) {
+			utils.SendErrorResponse(c, http.StatusForbidden, "insufficient permissions")
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func HasRole(c *gin.Context, role string) bool {
+	roles, _ := c.Get("roles")
+	list, _ := roles.([]interface{})
+	for _, r := range list {
+		if s, ok := r.(string); ok && s == role {
+			return true
+		}
+	}
+	return false
+}
+
+func HasPermission(c *gin.Context, permission string) bool {
+	perms, _ := c.Get("permissions")
+	list, _ := perms.([]interface{})
+	for _, p := range list {
+		if s, ok := p.(string); ok && s == permission {
+			return true
+		}
+	}
+	return false
+}
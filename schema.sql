@@ -1,3 +1,9 @@
+-- Tables are created IF NOT EXISTS, so a column added to a table later also
+-- gets an ALTER TABLE ... ADD COLUMN IF NOT EXISTS after it for existing databases.
+
+-- Extensions
+CREATE EXTENSION IF NOT EXISTS pg_trgm; -- fuzzy product name search
+
 -- Users & Roles
 CREATE TABLE IF NOT EXISTS roles (
     id SERIAL PRIMARY KEY,
@@ -21,15 +27,81 @@ CREATE TABLE IF NOT EXISTS user_roles (
     PRIMARY KEY (user_id, role_id)
 );
 
+-- Permissions granted to roles (beyond what the role name implies)
+CREATE TABLE IF NOT EXISTS permissions (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) UNIQUE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role_id INT REFERENCES roles(id),
+    permission_id INT REFERENCES permissions(id),
+    PRIMARY KEY (role_id, permission_id)
+);
+
 -- Suppliers
 CREATE TABLE IF NOT EXISTS suppliers (
     id SERIAL PRIMARY KEY,
     name VARCHAR(100) NOT NULL,
-    contact_info VARCHAR(255),
+    contact_info VARCHAR(255), -- free text, kept for suppliers created before the fields below
+    gstin VARCHAR(15),
+    pan VARCHAR(10),
+    state_code VARCHAR(2), -- GST state code, drives IGST vs CGST/SGST on purchases; taken from the GSTIN when set
+    address_line1 VARCHAR(255),
+    address_line2 VARCHAR(255),
+    city VARCHAR(100),
+    pincode VARCHAR(6),
+    phone VARCHAR(15),
+    email VARCHAR(255),
+    bank_account_name VARCHAR(100),
+    bank_account_number VARCHAR(18),
+    bank_ifsc VARCHAR(11),
+    bank_name VARCHAR(100),
+    credit_days INT NOT NULL DEFAULT 0, -- payment terms; purchase due date = invoice date + credit_days
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP
+);
+
+ALTER TABLE suppliers
+    ADD COLUMN IF NOT EXISTS gstin VARCHAR(15),
+    ADD COLUMN IF NOT EXISTS pan VARCHAR(10),
+    ADD COLUMN IF NOT EXISTS state_code VARCHAR(2),
+    ADD COLUMN IF NOT EXISTS address_line1 VARCHAR(255),
+    ADD COLUMN IF NOT EXISTS address_line2 VARCHAR(255),
+    ADD COLUMN IF NOT EXISTS city VARCHAR(100),
+    ADD COLUMN IF NOT EXISTS pincode VARCHAR(6),
+    ADD COLUMN IF NOT EXISTS phone VARCHAR(15),
+    ADD COLUMN IF NOT EXISTS email VARCHAR(255),
+    ADD COLUMN IF NOT EXISTS bank_account_name VARCHAR(100),
+    ADD COLUMN IF NOT EXISTS bank_account_number VARCHAR(18),
+    ADD COLUMN IF NOT EXISTS bank_ifsc VARCHAR(11),
+    ADD COLUMN IF NOT EXISTS bank_name VARCHAR(100),
+    ADD COLUMN IF NOT EXISTS credit_days INT NOT NULL DEFAULT 0;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_suppliers_gstin
+    ON suppliers (gstin) WHERE deleted_at IS NULL;
+
+-- Styles: a design sold in several sizes / colours, each a products row
+CREATE TABLE IF NOT EXISTS product_styles (
+    id SERIAL PRIMARY KEY,
+    style_code VARCHAR(50) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    hsn_code VARCHAR(50), -- shared by every variant
+    gender VARCHAR(20),
+    category VARCHAR(50),
+    purchase_price NUMERIC(10, 2) NOT NULL DEFAULT 0, -- default for variants without an override
+    sales_price NUMERIC(10, 2) NOT NULL DEFAULT 0,
+    gst_percent NUMERIC(5, 2) NOT NULL DEFAULT 0,
+    sizes TEXT[] NOT NULL DEFAULT '{}', -- display order
+    colours TEXT[] NOT NULL DEFAULT '{}',
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP,
     deleted_at TIMESTAMP
 );
 
+CREATE UNIQUE INDEX IF NOT EXISTS idx_product_styles_code
+    ON product_styles (style_code) WHERE deleted_at IS NULL;
+
 -- Products
 CREATE TABLE IF NOT EXISTS products (
     id SERIAL PRIMARY KEY,
@@ -41,7 +113,79 @@ CREATE TABLE IF NOT EXISTS products (
     category VARCHAR(50),
     purchase_price NUMERIC(10, 2),
     sales_price NUMERIC(10, 2),
+    mrp NUMERIC(10, 2), -- maximum retail price printed on the tag, GST included; sales_price plus GST may not exceed it
     gst_percent NUMERIC(5, 2),
+    reorder_point INT NOT NULL DEFAULT 0, -- reorder when on hand falls to this; 0 = not tracked
+    reorder_qty INT NOT NULL DEFAULT 0, -- quantity to order; 0 = order up to twice the reorder point
+    style_id INT REFERENCES product_styles(id), -- set on variants of a style
+    size VARCHAR(20),
+    colour VARCHAR(30),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP,
+    deleted_at TIMESTAMP
+);
+
+ALTER TABLE products
+    ADD COLUMN IF NOT EXISTS mrp NUMERIC(10, 2),
+    ADD COLUMN IF NOT EXISTS reorder_point INT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS reorder_qty INT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS style_id INT REFERENCES product_styles(id),
+    ADD COLUMN IF NOT EXISTS size VARCHAR(20),
+    ADD COLUMN IF NOT EXISTS colour VARCHAR(30),
+    ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_products_style_variant
+    ON products (style_id, LOWER(COALESCE(size, '')), LOWER(COALESCE(colour, '')))
+    WHERE style_id IS NOT NULL AND deleted_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_products_barcode ON products (barcode) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_products_sku_lower ON products (LOWER(sku)) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_products_name_trgm ON products USING gin (name gin_trgm_ops) WHERE deleted_at IS NULL;
+
+-- Every change to a product's purchase price, sales price or GST rate
+CREATE TABLE IF NOT EXISTS product_price_history (
+    id SERIAL PRIMARY KEY,
+    product_id INT REFERENCES products(id),
+    field VARCHAR(20) NOT NULL, -- purchase_price, sales_price, gst_percent
+    old_value NUMERIC(10, 2), -- NULL when the product was created
+    new_value NUMERIC(10, 2) NOT NULL,
+    effective_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    changed_by INT REFERENCES users(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_product_price_history_product
+    ON product_price_history (product_id, effective_at);
+
+-- GST rate rules by HSN code and per-unit taxable value (apparel slabs)
+CREATE TABLE IF NOT EXISTS tax_rules (
+    id SERIAL PRIMARY KEY,
+    hsn_code VARCHAR(50) NOT NULL, -- matched as a prefix of products.hsn_code
+    min_unit_value NUMERIC(10, 2) NOT NULL DEFAULT 0, -- exclusive
+    max_unit_value NUMERIC(10, 2), -- inclusive, NULL = no upper limit
+    gst_percent NUMERIC(5, 2) NOT NULL,
+    effective_from DATE NOT NULL,
+    effective_to DATE,
+    created_by INT REFERENCES users(id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_tax_rules_hsn ON tax_rules (hsn_code);
+
+-- Discounts offered at the counter, on one product, a style, a category or
+-- everything when no scope is set
+CREATE TABLE IF NOT EXISTS promotions (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    discount_type VARCHAR(20) NOT NULL, -- INR (per unit) or %
+    discount_value NUMERIC(10, 2) NOT NULL,
+    product_id INT REFERENCES products(id),
+    style_id INT REFERENCES product_styles(id),
+    category VARCHAR(50),
+    min_quantity INT NOT NULL DEFAULT 1, -- units on the line before it applies
+    effective_from DATE NOT NULL,
+    effective_to DATE, -- inclusive, NULL = open-ended
+    created_by INT REFERENCES users(id),
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     deleted_at TIMESTAMP
 );
@@ -52,40 +196,245 @@ CREATE TABLE IF NOT EXISTS purchase_invoices (
     invoice_number VARCHAR(100) NOT NULL,
     supplier_id INT REFERENCES suppliers(id),
     total_amount_before_discount NUMERIC(12, 2),
-    discount_amount NUMERIC(12, 2),
+    discount_type VARCHAR(20), -- bill-level discount: INR, %
+    discount_value NUMERIC(10, 2),
+    discount_amount NUMERIC(12, 2), -- line and bill discounts together
+    taxable_amount NUMERIC(12, 2),
     total_gst NUMERIC(12, 2),
+    total_cgst NUMERIC(12, 2),
+    total_sgst NUMERIC(12, 2),
+    total_igst NUMERIC(12, 2),
+    total_charges NUMERIC(12, 2) NOT NULL DEFAULT 0, -- freight, packing etc. (untaxed)
+    supplier_state_code VARCHAR(2),
     total_invoice_amount NUMERIC(12, 2),
     total_items INT,
     total_quantity INT,
     notes TEXT,
+    invoice_date DATE, -- supplier's bill date; defaults to the day it was booked
+    due_date DATE, -- invoice_date + supplier credit_days
+    amount_paid NUMERIC(12, 2) NOT NULL DEFAULT 0, -- sum of supplier payment allocations
+    purchase_order_id INT, -- set when billed against a purchase order (stock came in on GRNs)
+    match_status VARCHAR(20), -- MATCHED, MISMATCH; NULL without a purchase order
+    status VARCHAR(20) NOT NULL DEFAULT 'POSTED', -- POSTED, CANCELLED (cancelled rows also get deleted_at)
+    cancel_reason TEXT,
+    cancelled_at TIMESTAMP,
     created_by INT REFERENCES users(id),
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP,
     deleted_at TIMESTAMP
 );
 
+ALTER TABLE purchase_invoices
+    ADD COLUMN IF NOT EXISTS discount_type VARCHAR(20),
+    ADD COLUMN IF NOT EXISTS discount_value NUMERIC(10, 2),
+    ADD COLUMN IF NOT EXISTS taxable_amount NUMERIC(12, 2),
+    ADD COLUMN IF NOT EXISTS total_cgst NUMERIC(12, 2),
+    ADD COLUMN IF NOT EXISTS total_sgst NUMERIC(12, 2),
+    ADD COLUMN IF NOT EXISTS total_igst NUMERIC(12, 2),
+    ADD COLUMN IF NOT EXISTS total_charges NUMERIC(12, 2) NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS supplier_state_code VARCHAR(2),
+    ADD COLUMN IF NOT EXISTS invoice_date DATE,
+    ADD COLUMN IF NOT EXISTS due_date DATE,
+    ADD COLUMN IF NOT EXISTS amount_paid NUMERIC(12, 2) NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS purchase_order_id INT,
+    ADD COLUMN IF NOT EXISTS match_status VARCHAR(20),
+    ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'POSTED',
+    ADD COLUMN IF NOT EXISTS cancel_reason TEXT,
+    ADD COLUMN IF NOT EXISTS cancelled_at TIMESTAMP,
+    ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP;
+
+-- a supplier's bill can only be entered once. A database that already holds
+-- the same bill twice keeps working without the index until the extra copies
+-- are cancelled or deleted; list them with
+--   SELECT supplier_id, LOWER(invoice_number), ARRAY_AGG(id) FROM purchase_invoices
+--   WHERE deleted_at IS NULL GROUP BY 1, 2 HAVING COUNT(*) > 1;
+-- and run this file again.
+DO $$
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM purchase_invoices
+        WHERE deleted_at IS NULL
+        GROUP BY supplier_id, LOWER(invoice_number)
+        HAVING COUNT(*) > 1
+    ) THEN
+        RAISE WARNING 'duplicate supplier bills in purchase_invoices; idx_purchase_invoices_supplier_number not created';
+    ELSE
+        CREATE UNIQUE INDEX IF NOT EXISTS idx_purchase_invoices_supplier_number
+            ON purchase_invoices (supplier_id, LOWER(invoice_number))
+            WHERE deleted_at IS NULL;
+    END IF;
+END $$;
+
 CREATE TABLE IF NOT EXISTS purchase_invoice_items (
     id SERIAL PRIMARY KEY,
     purchase_invoice_id INT REFERENCES purchase_invoices(id),
     product_id INT REFERENCES products(id),
     quantity INT,
     purchase_price NUMERIC(10, 2),
+    discount_type VARCHAR(20), -- INR, %
+    discount_value NUMERIC(10, 2),
+    discount_amount NUMERIC(10, 2) NOT NULL DEFAULT 0, -- line discount plus share of the bill discount
+    taxable_amount NUMERIC(12, 2),
+    gst_percent NUMERIC(5, 2),
+    gst_amount NUMERIC(10, 2),
+    cgst_amount NUMERIC(10, 2),
+    sgst_amount NUMERIC(10, 2),
+    igst_amount NUMERIC(10, 2),
+    line_total NUMERIC(12, 2), -- taxable + GST
+    charges_amount NUMERIC(12, 2) NOT NULL DEFAULT 0, -- share of the invoice charges
+    landed_unit_cost NUMERIC(12, 4) -- (taxable + charges) / quantity
+);
+
+ALTER TABLE purchase_invoice_items
+    ADD COLUMN IF NOT EXISTS discount_type VARCHAR(20),
+    ADD COLUMN IF NOT EXISTS discount_value NUMERIC(10, 2),
+    ADD COLUMN IF NOT EXISTS discount_amount NUMERIC(10, 2) NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS taxable_amount NUMERIC(12, 2),
+    ADD COLUMN IF NOT EXISTS cgst_amount NUMERIC(10, 2),
+    ADD COLUMN IF NOT EXISTS sgst_amount NUMERIC(10, 2),
+    ADD COLUMN IF NOT EXISTS igst_amount NUMERIC(10, 2),
+    ADD COLUMN IF NOT EXISTS charges_amount NUMERIC(12, 2) NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS landed_unit_cost NUMERIC(12, 4);
+
+-- Freight, packing and other charges on a purchase invoice
+CREATE TABLE IF NOT EXISTS purchase_invoice_charges (
+    id SERIAL PRIMARY KEY,
+    purchase_invoice_id INT REFERENCES purchase_invoices(id),
+    name VARCHAR(100) NOT NULL,
+    amount NUMERIC(12, 2) NOT NULL,
+    basis VARCHAR(20) NOT NULL DEFAULT 'VALUE' -- VALUE, QUANTITY: how it is spread over the lines
+);
+
+-- Purchase orders raised to suppliers
+CREATE TABLE IF NOT EXISTS purchase_orders (
+    id SERIAL PRIMARY KEY,
+    po_number VARCHAR(100) UNIQUE NOT NULL,
+    supplier_id INT REFERENCES suppliers(id),
+    status VARCHAR(20) NOT NULL DEFAULT 'DRAFT', -- DRAFT, SENT, PARTIAL, RECEIVED, CLOSED, CANCELLED
+    total_amount NUMERIC(12, 2),
+    notes TEXT,
+    created_by INT REFERENCES users(id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS purchase_order_items (
+    id SERIAL PRIMARY KEY,
+    purchase_order_id INT REFERENCES purchase_orders(id),
+    product_id INT REFERENCES products(id),
+    quantity INT NOT NULL,
+    received_qty INT NOT NULL DEFAULT 0,
+    unit_price NUMERIC(10, 2),
+    gst_percent NUMERIC(5, 2),
+    line_total NUMERIC(12, 2)
+);
+
+ALTER TABLE purchase_order_items
+    ADD COLUMN IF NOT EXISTS received_qty INT NOT NULL DEFAULT 0;
+
+-- Goods receipt notes: stock arriving against a purchase order
+CREATE TABLE IF NOT EXISTS goods_receipts (
+    id SERIAL PRIMARY KEY,
+    grn_number VARCHAR(100) UNIQUE NOT NULL,
+    purchase_order_id INT REFERENCES purchase_orders(id),
+    supplier_id INT REFERENCES suppliers(id),
+    supplier_challan VARCHAR(100),
+    notes TEXT,
+    created_by INT REFERENCES users(id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS goods_receipt_items (
+    id SERIAL PRIMARY KEY,
+    goods_receipt_id INT REFERENCES goods_receipts(id),
+    purchase_order_item_id INT REFERENCES purchase_order_items(id),
+    product_id INT REFERENCES products(id),
+    quantity INT NOT NULL
+);
+
+-- Differences found when a supplier invoice is matched to its PO and GRNs
+CREATE TABLE IF NOT EXISTS purchase_match_exceptions (
+    id SERIAL PRIMARY KEY,
+    purchase_invoice_id INT REFERENCES purchase_invoices(id),
+    product_id INT REFERENCES products(id),
+    kind VARCHAR(20) NOT NULL, -- PRICE, QUANTITY, NOT_ORDERED
+    expected NUMERIC(12, 2),
+    actual NUMERIC(12, 2)
+);
+
+-- Purchase Returns (debit notes raised on suppliers for goods sent back)
+CREATE TABLE IF NOT EXISTS purchase_returns (
+    id SERIAL PRIMARY KEY,
+    debit_note_number VARCHAR(100) UNIQUE NOT NULL,
+    purchase_invoice_id INT REFERENCES purchase_invoices(id),
+    supplier_id INT REFERENCES suppliers(id),
+    reason TEXT,
+    taxable_amount NUMERIC(12, 2),
+    total_gst NUMERIC(12, 2),
+    total_cgst NUMERIC(12, 2),
+    total_sgst NUMERIC(12, 2),
+    total_igst NUMERIC(12, 2),
+    round_off NUMERIC(5, 2),
+    total_amount NUMERIC(12, 2),
+    total_quantity INT,
+    debit_note_pdf_key TEXT,
+    created_by INT REFERENCES users(id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS purchase_return_items (
+    id SERIAL PRIMARY KEY,
+    purchase_return_id INT REFERENCES purchase_returns(id),
+    purchase_invoice_item_id INT REFERENCES purchase_invoice_items(id),
+    product_id INT REFERENCES products(id),
+    quantity INT,
+    purchase_price NUMERIC(10, 2),
+    taxable_amount NUMERIC(12, 2),
     gst_percent NUMERIC(5, 2),
     gst_amount NUMERIC(10, 2),
+    cgst_amount NUMERIC(10, 2),
+    sgst_amount NUMERIC(10, 2),
+    igst_amount NUMERIC(10, 2),
     line_total NUMERIC(12, 2)
 );
 
--- View for ListPurchases
-CREATE OR REPLACE VIEW purchases AS
-SELECT pi.id, pi.invoice_number, s.name as supplier_name, pi.created_at, pi.deleted_at
-FROM purchase_invoices pi
-LEFT JOIN suppliers s ON pi.supplier_id = s.id;
+CREATE INDEX IF NOT EXISTS idx_purchase_return_items_invoice_item
+    ON purchase_return_items (purchase_invoice_item_id);
+
+-- Payments made to suppliers, allocated against their purchase invoices;
+-- any unallocated remainder is an advance
+CREATE TABLE IF NOT EXISTS supplier_payments (
+    id SERIAL PRIMARY KEY,
+    supplier_id INT REFERENCES suppliers(id),
+    payment_date DATE NOT NULL,
+    payment_mode VARCHAR(20) NOT NULL, -- CASH, BANK, UPI, CHEQUE
+    amount NUMERIC(12, 2) NOT NULL,
+    reference VARCHAR(100), -- UTR / cheque number
+    notes TEXT,
+    created_by INT REFERENCES users(id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS supplier_payment_allocations (
+    id SERIAL PRIMARY KEY,
+    supplier_payment_id INT REFERENCES supplier_payments(id),
+    purchase_invoice_id INT REFERENCES purchase_invoices(id),
+    amount NUMERIC(12, 2) NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_supplier_payments_supplier ON supplier_payments (supplier_id);
+
+-- ListPurchases reads the tables directly; the view it used is gone
+DROP VIEW IF EXISTS purchases;
 
 -- Sales Invoices
 CREATE TABLE IF NOT EXISTS sales_invoices (
     id SERIAL PRIMARY KEY,
-    invoice_number VARCHAR(100) UNIQUE NOT NULL,
+    invoice_number VARCHAR(100) UNIQUE, -- allocated from document_series when INVOICED
     customer_name VARCHAR(100),
     customer_mobile VARCHAR(20),
+    customer_gstin VARCHAR(15), -- registered (B2B) buyers only
     status VARCHAR(20) DEFAULT 'DRAFT', -- DRAFT, INVOICED
     total_amount_before_discount NUMERIC(12, 2),
     discount_type VARCHAR(20), -- INR, PERCENT
@@ -93,17 +442,35 @@ CREATE TABLE IF NOT EXISTS sales_invoices (
     total_discount NUMERIC(12, 2),
     taxable_amount NUMERIC(12, 2),
     total_gst NUMERIC(12, 2),
+    total_cgst NUMERIC(12, 2),
+    total_sgst NUMERIC(12, 2),
+    total_igst NUMERIC(12, 2),
+    place_of_supply VARCHAR(2), -- GST state code of the customer
     round_off NUMERIC(5, 2),
     total_invoice_amount NUMERIC(12, 2),
     total_items INT,
     total_quantity INT,
-    payment_mode VARCHAR(20),
+    payment_mode VARCHAR(20), -- CASH, CARD, UPI or SPLIT
+    amount_paid NUMERIC(12, 2) DEFAULT 0,
+    change_due NUMERIC(12, 2) DEFAULT 0,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     deleted_at TIMESTAMP,
-    invoice_pdf_key TEXT
+    invoice_pdf_key TEXT,
+    invoiced_at TIMESTAMP
 );
 
+ALTER TABLE sales_invoices
+    ADD COLUMN IF NOT EXISTS customer_gstin VARCHAR(15),
+    ADD COLUMN IF NOT EXISTS total_cgst NUMERIC(12, 2),
+    ADD COLUMN IF NOT EXISTS total_sgst NUMERIC(12, 2),
+    ADD COLUMN IF NOT EXISTS total_igst NUMERIC(12, 2),
+    ADD COLUMN IF NOT EXISTS place_of_supply VARCHAR(2),
+    ADD COLUMN IF NOT EXISTS amount_paid NUMERIC(12, 2) DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS change_due NUMERIC(12, 2) DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS invoiced_at TIMESTAMP;
+ALTER TABLE sales_invoices ALTER COLUMN invoice_number DROP NOT NULL;
+
 CREATE TABLE IF NOT EXISTS sales_invoice_items (
     id SERIAL PRIMARY KEY,
     sales_invoice_id INT REFERENCES sales_invoices(id),
@@ -116,20 +483,254 @@ CREATE TABLE IF NOT EXISTS sales_invoice_items (
     discount_amount NUMERIC(10, 2),
     gst_percent NUMERIC(5, 2),
     gst_amount NUMERIC(10, 2),
+    cgst_amount NUMERIC(10, 2),
+    sgst_amount NUMERIC(10, 2),
+    igst_amount NUMERIC(10, 2),
     line_total NUMERIC(12, 2),
+    gst_overridden BOOLEAN DEFAULT FALSE, -- rate differs from the tax rule
+    unit_cost NUMERIC(12, 4), -- cost of goods sold per unit, set when invoiced
+    cogs NUMERIC(12, 2),
+    deleted_at TIMESTAMP
+);
+
+ALTER TABLE sales_invoice_items
+    ADD COLUMN IF NOT EXISTS cgst_amount NUMERIC(10, 2),
+    ADD COLUMN IF NOT EXISTS sgst_amount NUMERIC(10, 2),
+    ADD COLUMN IF NOT EXISTS igst_amount NUMERIC(10, 2),
+    ADD COLUMN IF NOT EXISTS gst_overridden BOOLEAN DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS unit_cost NUMERIC(12, 4),
+    ADD COLUMN IF NOT EXISTS cogs NUMERIC(12, 2);
+
+-- Sales invoice tenders (split / multi-mode payments)
+CREATE TABLE IF NOT EXISTS sales_invoice_payments (
+    id SERIAL PRIMARY KEY,
+    sales_invoice_id INT REFERENCES sales_invoices(id),
+    payment_mode VARCHAR(20) NOT NULL, -- CASH, CARD, UPI
+    amount NUMERIC(12, 2) NOT NULL, -- amount tendered
+    reference VARCHAR(100), -- UPI txn id, card last-4
+    created_by INT REFERENCES users(id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     deleted_at TIMESTAMP
 );
 
+-- Sales Returns (credit notes against INVOICED sales invoices)
+CREATE TABLE IF NOT EXISTS sales_returns (
+    id SERIAL PRIMARY KEY,
+    credit_note_number VARCHAR(100) UNIQUE NOT NULL,
+    sales_invoice_id INT REFERENCES sales_invoices(id),
+    reason TEXT,
+    taxable_amount NUMERIC(12, 2),
+    total_gst NUMERIC(12, 2),
+    total_cgst NUMERIC(12, 2),
+    total_sgst NUMERIC(12, 2),
+    total_igst NUMERIC(12, 2),
+    round_off NUMERIC(5, 2),
+    total_amount NUMERIC(12, 2),
+    total_quantity INT,
+    created_by INT REFERENCES users(id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE sales_returns
+    ADD COLUMN IF NOT EXISTS total_cgst NUMERIC(12, 2),
+    ADD COLUMN IF NOT EXISTS total_sgst NUMERIC(12, 2),
+    ADD COLUMN IF NOT EXISTS total_igst NUMERIC(12, 2);
+
+CREATE TABLE IF NOT EXISTS sales_return_items (
+    id SERIAL PRIMARY KEY,
+    sales_return_id INT REFERENCES sales_returns(id),
+    sales_invoice_item_id INT REFERENCES sales_invoice_items(id),
+    product_id INT REFERENCES products(id),
+    quantity INT,
+    sales_rate NUMERIC(10, 2),
+    taxable_amount NUMERIC(12, 2),
+    gst_percent NUMERIC(5, 2),
+    gst_amount NUMERIC(10, 2),
+    cgst_amount NUMERIC(10, 2),
+    sgst_amount NUMERIC(10, 2),
+    igst_amount NUMERIC(10, 2),
+    line_total NUMERIC(12, 2),
+    unit_cost NUMERIC(12, 4), -- back into stock at the sale's cost
+    cogs NUMERIC(12, 2) -- cost of goods sold reversed
+);
+
+ALTER TABLE sales_return_items
+    ADD COLUMN IF NOT EXISTS cgst_amount NUMERIC(10, 2),
+    ADD COLUMN IF NOT EXISTS sgst_amount NUMERIC(10, 2),
+    ADD COLUMN IF NOT EXISTS igst_amount NUMERIC(10, 2),
+    ADD COLUMN IF NOT EXISTS unit_cost NUMERIC(12, 4),
+    ADD COLUMN IF NOT EXISTS cogs NUMERIC(12, 2);
+
+CREATE INDEX IF NOT EXISTS idx_sales_return_items_invoice_item
+    ON sales_return_items (sales_invoice_item_id);
+
+-- Document numbering (gap-free, reset every financial year)
+CREATE TABLE IF NOT EXISTS document_series (
+    id SERIAL PRIMARY KEY,
+    code VARCHAR(50) UNIQUE NOT NULL, -- SALES_INVOICE, CREDIT_NOTE, DEBIT_NOTE, ...
+    document_type VARCHAR(50) NOT NULL, -- the document numbered: one of the application's own series codes
+    prefix VARCHAR(50) NOT NULL,
+    padding INT NOT NULL DEFAULT 6,
+    is_active BOOLEAN DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE document_series
+    ADD COLUMN IF NOT EXISTS document_type VARCHAR(50);
+-- the application's own series number their namesakes; series added through
+-- the API before document types were kept were sales invoice series
+UPDATE document_series
+SET document_type = CASE
+    WHEN code IN ('SALES_INVOICE', 'CREDIT_NOTE', 'DEBIT_NOTE', 'ADJUSTMENT', 'PURCHASE_ORDER', 'GOODS_RECEIPT') THEN code
+    ELSE 'SALES_INVOICE'
+END
+WHERE document_type IS NULL;
+ALTER TABLE document_series ALTER COLUMN document_type SET NOT NULL;
+
+CREATE TABLE IF NOT EXISTS document_series_counters (
+    series_id INT REFERENCES document_series(id),
+    financial_year VARCHAR(7) NOT NULL, -- 2026-27
+    last_number INT NOT NULL,
+    PRIMARY KEY (series_id, financial_year)
+);
+
 -- Inventory
 CREATE TABLE IF NOT EXISTS inventory_transactions (
     id SERIAL PRIMARY KEY,
     product_id INT REFERENCES products(id),
     quantity INT, -- can be negative
-    ref_type VARCHAR(50), -- purchase, sale
+    ref_type VARCHAR(50), -- purchase, purchase_return, sale, sale_return, adjustment, goods_receipt
     ref_id INT,
+    balance_after INT, -- on-hand quantity right after this movement
+    unit_cost NUMERIC(12, 4), -- cost per unit moved, by the valuation method
+    value NUMERIC(14, 2), -- signed value moved
+    value_after NUMERIC(14, 2), -- stock value right after this movement
     created_by INT,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
+ALTER TABLE inventory_transactions
+    ADD COLUMN IF NOT EXISTS balance_after INT,
+    ADD COLUMN IF NOT EXISTS unit_cost NUMERIC(12, 4),
+    ADD COLUMN IF NOT EXISTS value NUMERIC(14, 2),
+    ADD COLUMN IF NOT EXISTS value_after NUMERIC(14, 2);
+
+CREATE INDEX IF NOT EXISTS idx_inventory_transactions_product
+    ON inventory_transactions (product_id, id);
+
+-- Current on-hand quantity per product, moved together with every
+-- inventory_transactions row so stock lookups never scan the ledger
+CREATE TABLE IF NOT EXISTS product_stock (
+    product_id INT PRIMARY KEY REFERENCES products(id),
+    on_hand INT NOT NULL DEFAULT 0,
+    avg_cost NUMERIC(12, 4) NOT NULL DEFAULT 0, -- moving weighted average cost
+    stock_value NUMERIC(14, 2) NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE product_stock
+    ADD COLUMN IF NOT EXISTS avg_cost NUMERIC(12, 4) NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS stock_value NUMERIC(14, 2) NOT NULL DEFAULT 0;
+
+-- Receipts still on hand, oldest first, for FIFO costing
+CREATE TABLE IF NOT EXISTS inventory_cost_layers (
+    id SERIAL PRIMARY KEY,
+    product_id INT REFERENCES products(id),
+    inventory_transaction_id INT REFERENCES inventory_transactions(id), -- NULL for stock on hand before layers were kept
+    quantity INT NOT NULL,
+    remaining INT NOT NULL,
+    unit_cost NUMERIC(12, 4) NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_inventory_cost_layers_open
+    ON inventory_cost_layers (product_id, id) WHERE remaining > 0;
+
+-- Manual stock adjustments (damage, theft, samples, found stock, corrections)
+CREATE TABLE IF NOT EXISTS stock_adjustments (
+    id SERIAL PRIMARY KEY,
+    adjustment_number VARCHAR(100) UNIQUE NOT NULL,
+    reason_code VARCHAR(20) NOT NULL, -- damage, theft, sample, found, correction
+    note TEXT,
+    status VARCHAR(20) NOT NULL, -- PENDING, POSTED, REJECTED
+    total_value NUMERIC(12, 2), -- absolute stock value at purchase price
+    created_by INT REFERENCES users(id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    reviewed_by INT REFERENCES users(id),
+    reviewed_at TIMESTAMP,
+    review_note TEXT,
+    posted_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS stock_adjustment_items (
+    id SERIAL PRIMARY KEY,
+    stock_adjustment_id INT REFERENCES stock_adjustments(id),
+    product_id INT REFERENCES products(id),
+    quantity INT NOT NULL, -- signed: negative takes stock out
+    unit_cost NUMERIC(10, 2),
+    value NUMERIC(12, 2)
+);
+
+-- Physical stock counts. System quantities are frozen when the session opens;
+-- approving it posts the variances as a correction adjustment.
+CREATE TABLE IF NOT EXISTS stock_takes (
+    id SERIAL PRIMARY KEY,
+    scope_type VARCHAR(20) NOT NULL, -- ALL, CATEGORY, GENDER
+    scope_value VARCHAR(50),
+    status VARCHAR(20) NOT NULL DEFAULT 'OPEN', -- OPEN, POSTED, CANCELLED
+    note TEXT,
+    stock_adjustment_id INT REFERENCES stock_adjustments(id),
+    created_by INT REFERENCES users(id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    approved_by INT REFERENCES users(id),
+    approved_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS stock_take_items (
+    id SERIAL PRIMARY KEY,
+    stock_take_id INT REFERENCES stock_takes(id),
+    product_id INT REFERENCES products(id),
+    system_qty INT NOT NULL,
+    counted_qty INT, -- NULL until counted
+    unit_cost NUMERIC(10, 2),
+    snapshot_txn_id INT, -- last inventory_transactions row of the product when system_qty was frozen
+    counted_txn_id INT, -- last inventory_transactions row of the product when last counted
+    counted_by INT REFERENCES users(id),
+    counted_at TIMESTAMP,
+    UNIQUE (stock_take_id, product_id)
+);
+
+ALTER TABLE stock_take_items
+    ADD COLUMN IF NOT EXISTS snapshot_txn_id INT,
+    ADD COLUMN IF NOT EXISTS counted_txn_id INT;
+
 -- Seed initial data
 INSERT INTO roles (name) VALUES ('admin'), ('cashier') ON CONFLICT DO NOTHING;
+INSERT INTO permissions (name) VALUES ('gst_rate_override') ON CONFLICT DO NOTHING;
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p
+WHERE r.name = 'admin' AND p.name = 'gst_rate_override'
+ON CONFLICT DO NOTHING;
+INSERT INTO document_series (code, document_type, prefix) VALUES
+    ('SALES_INVOICE', 'SALES_INVOICE', 'TUL'),
+    ('CREDIT_NOTE', 'CREDIT_NOTE', 'TUL/CN'),
+    ('DEBIT_NOTE', 'DEBIT_NOTE', 'TUL/DN'),
+    ('ADJUSTMENT', 'ADJUSTMENT', 'TUL/ADJ'),
+    ('PURCHASE_ORDER', 'PURCHASE_ORDER', 'TUL/PO'),
+    ('GOODS_RECEIPT', 'GOODS_RECEIPT', 'TUL/GRN')
+ON CONFLICT DO NOTHING;
+
+-- Stock moved before product_stock and running balances were kept
+INSERT INTO product_stock (product_id, on_hand)
+SELECT product_id, COALESCE(SUM(quantity), 0)
+FROM inventory_transactions
+WHERE product_id IS NOT NULL
+GROUP BY product_id
+ON CONFLICT DO NOTHING;
+UPDATE inventory_transactions it
+SET balance_after = b.balance
+FROM (
+    SELECT id, SUM(quantity) OVER (PARTITION BY product_id ORDER BY id) AS balance
+    FROM inventory_transactions
+    WHERE product_id IN (SELECT product_id FROM inventory_transactions WHERE balance_after IS NULL)
+) b
+WHERE b.id = it.id AND it.balance_after IS NULL;
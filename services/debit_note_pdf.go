@@ -0,0 +1,205 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	awsclient "tulsi-pos/aws"
+	"tulsi-pos/db"
+	"tulsi-pos/utils"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/jung-kurt/gofpdf"
+)
+
+type DebitNoteHeader struct {
+	ID              int64
+	DebitNoteNumber string
+	InvoiceNumber   string
+	SupplierName    string
+	SupplierState   string
+	Reason          string
+	TaxableAmount   float64
+	TotalCGST       float64
+	TotalSGST       float64
+	TotalIGST       float64
+	RoundOff        float64
+	TotalAmount     float64
+	CreatedAt       time.Time
+}
+
+type DebitNoteItem struct {
+	ProductName   string
+	Quantity      int
+	PurchasePrice float64
+	TaxableAmount float64
+	CGSTAmount    float64
+	SGSTAmount    float64
+	IGSTAmount    float64
+	LineTotal     float64
+}
+
+// GenerateAndUploadDebitNotePDF renders a purchase return's debit note in the
+// same layout as the sales invoice, uploads it next to the invoices and saves
+// the key on the return.
+func GenerateAndUploadDebitNotePDF(ctx context.Context, returnID int64) (string, error) {
+	if awsclient.S3 == nil {
+		return "", fmt.Errorf("S3 client not initialized")
+	}
+	if awsclient.InvoiceBucket == "" {
+		return "", fmt.Errorf("invoice bucket not configured")
+	}
+
+	// 1) Load header
+	var h DebitNoteHeader
+	err := db.DB.QueryRow(ctx, `
+		SELECT pr.id, pr.debit_note_number, pi.invoice_number, s.name,
+		       COALESCE(pi.supplier_state_code, ''), COALESCE(pr.reason, ''),
+		       pr.taxable_amount, pr.total_cgst, pr.total_sgst, pr.total_igst,
+		       pr.round_off, pr.total_amount, pr.created_at
+		FROM purchase_returns pr
+		JOIN purchase_invoices pi ON pi.id = pr.purchase_invoice_id
+		JOIN suppliers s ON s.id = pr.supplier_id
+		WHERE pr.id = $1
+	`, returnID).Scan(&h.ID, &h.DebitNoteNumber, &h.InvoiceNumber, &h.SupplierName,
+		&h.SupplierState, &h.Reason, &h.TaxableAmount, &h.TotalCGST, &h.TotalSGST,
+		&h.TotalIGST, &h.RoundOff, &h.TotalAmount, &h.CreatedAt)
+	if err != nil {
+		return "", fmt.Errorf("load debit note header: %w", err)
+	}
+
+	// 2) Load items
+	rows, err := db.DB.Query(ctx, `
+		SELECT p.name, pri.quantity, pri.purchase_price, pri.taxable_amount,
+		       pri.cgst_amount, pri.sgst_amount, pri.igst_amount, pri.line_total
+		FROM purchase_return_items pri
+		JOIN products p ON p.id = pri.product_id
+		WHERE pri.purchase_return_id = $1
+		ORDER BY pri.id
+	`, returnID)
+	if err != nil {
+		return "", fmt.Errorf("load debit note items: %w", err)
+	}
+	defer rows.Close()
+
+	var items []DebitNoteItem
+	for rows.Next() {
+		var it DebitNoteItem
+		if err := rows.Scan(&it.ProductName, &it.Quantity, &it.PurchasePrice, &it.TaxableAmount,
+			&it.CGSTAmount, &it.SGSTAmount, &it.IGSTAmount, &it.LineTotal); err != nil {
+			return "", err
+		}
+		items = append(items, it)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	// 3) Generate PDF in memory
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.AddPage()
+	pdf.SetFont("Arial", "B", 16)
+	pdf.Cell(40, 10, "Tulsi POS Debit Note")
+
+	pdf.Ln(12)
+	pdf.SetFont("Arial", "", 11)
+	pdf.Cell(40, 6, fmt.Sprintf("Debit note: %s", h.DebitNoteNumber))
+	pdf.Ln(5)
+	pdf.Cell(40, 6, fmt.Sprintf("Date: %s", h.CreatedAt.Format(utils.DateFormat)))
+	pdf.Ln(5)
+	pdf.Cell(40, 6, fmt.Sprintf("Against supplier invoice: %s", h.InvoiceNumber))
+	pdf.Ln(5)
+	pdf.Cell(40, 6, fmt.Sprintf("Supplier: %s", h.SupplierName))
+	if h.SupplierState != "" {
+		pdf.Ln(5)
+		pdf.Cell(40, 6, fmt.Sprintf("Supplier state: %s-%s", h.SupplierState, utils.GSTStateNames[h.SupplierState]))
+	}
+	if h.Reason != "" {
+		pdf.Ln(5)
+		pdf.Cell(40, 6, fmt.Sprintf("Reason: %s", h.Reason))
+	}
+	pdf.Ln(10)
+
+	pdf.SetFont("Arial", "B", 10)
+	pdf.Cell(55, 6, "Product")
+	pdf.Cell(10, 6, "Qty")
+	pdf.Cell(20, 6, "Rate")
+	pdf.Cell(22, 6, "Taxable")
+	pdf.Cell(18, 6, "CGST")
+	pdf.Cell(18, 6, "SGST")
+	pdf.Cell(18, 6, "IGST")
+	pdf.Cell(24, 6, "Total")
+	pdf.Ln(7)
+
+	pdf.SetFont("Arial", "", 9)
+	for _, it := range items {
+		pdf.Cell(55, 5, it.ProductName)
+		pdf.Cell(10, 5, fmt.Sprintf("%d", it.Quantity))
+		pdf.Cell(20, 5, fmt.Sprintf("%.2f", it.PurchasePrice))
+		pdf.Cell(22, 5, fmt.Sprintf("%.2f", it.TaxableAmount))
+		pdf.Cell(18, 5, fmt.Sprintf("%.2f", it.CGSTAmount))
+		pdf.Cell(18, 5, fmt.Sprintf("%.2f", it.SGSTAmount))
+		pdf.Cell(18, 5, fmt.Sprintf("%.2f", it.IGSTAmount))
+		pdf.Cell(24, 5, fmt.Sprintf("%.2f", it.LineTotal))
+		pdf.Ln(5)
+	}
+
+	pdf.Ln(8)
+	pdf.SetFont("Arial", "", 10)
+	pdf.Cell(40, 5, fmt.Sprintf("Taxable value: %.2f", h.TaxableAmount))
+	pdf.Ln(5)
+	if h.TotalIGST > 0 {
+		pdf.Cell(40, 5, fmt.Sprintf("IGST: %.2f", h.TotalIGST))
+		pdf.Ln(5)
+	}
+	if h.TotalCGST > 0 || h.TotalSGST > 0 {
+		pdf.Cell(40, 5, fmt.Sprintf("CGST: %.2f", h.TotalCGST))
+		pdf.Ln(5)
+		pdf.Cell(40, 5, fmt.Sprintf("SGST: %.2f", h.TotalSGST))
+		pdf.Ln(5)
+	}
+	if h.RoundOff != 0 {
+		pdf.Cell(40, 5, fmt.Sprintf("Round off: %.2f", h.RoundOff))
+		pdf.Ln(5)
+	}
+
+	pdf.Ln(3)
+	pdf.SetFont("Arial", "B", 12)
+	pdf.Cell(40, 6, fmt.Sprintf("Total: %.2f", h.TotalAmount))
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return "", fmt.Errorf("generate pdf: %w", err)
+	}
+
+	// 4) Upload to S3
+	key := fmt.Sprintf("debit-notes/%s/%s.pdf",
+		h.CreatedAt.Format("2006-01-02"),
+		h.DebitNoteNumber,
+	)
+
+	_, err = awsclient.S3.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(awsclient.InvoiceBucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(buf.Bytes()),
+		ContentType: aws.String("application/pdf"),
+	})
+	if err != nil {
+		return "", fmt.Errorf("upload to s3: %w", err)
+	}
+
+	// 5) Save key in DB
+	_, err = db.DB.Exec(ctx, `
+		UPDATE purchase_returns
+		SET debit_note_pdf_key = $1
+		WHERE id = $2
+	`, key, returnID)
+	if err != nil {
+		return "", fmt.Errorf("update debit note: %w", err)
+	}
+
+	return key, nil
+}
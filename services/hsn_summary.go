@@ -0,0 +1,97 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"tulsi-pos/db"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// HSNSummary is the taxable value and tax of one HSN code at one GST rate.
+type HSNSummary struct {
+	HSNCode    string  `json:"hsn_code"`
+	GSTPercent float64 `json:"gst_percent"`
+	Quantity   int     `json:"quantity"`
+	TotalValue float64 `json:"total_value"`
+	Taxable    float64 `json:"taxable_value"`
+	CGST       float64 `json:"cgst_amount"`
+	SGST       float64 `json:"sgst_amount"`
+	IGST       float64 `json:"igst_amount"`
+	TotalTax   float64 `json:"total_tax"`
+}
+
+// LoadInvoiceHSNSummary groups the lines of one invoice by HSN code and rate.
+func LoadInvoiceHSNSummary(ctx context.Context, q db.Querier, invoiceID int64) ([]HSNSummary, error) {
+	return scanHSNSummary(q.Query(ctx, `
+		SELECT COALESCE(p.hsn_code, ''), sii.gst_percent, SUM(sii.quantity),
+		       ROUND(SUM(sii.line_total), 2),
+		       ROUND(SUM(sii.line_total - sii.gst_amount), 2),
+		       ROUND(SUM(COALESCE(sii.cgst_amount, 0)), 2),
+		       ROUND(SUM(COALESCE(sii.sgst_amount, 0)), 2),
+		       ROUND(SUM(COALESCE(sii.igst_amount, 0)), 2)
+		FROM sales_invoice_items sii
+		JOIN products p ON p.id = sii.product_id
+		WHERE sii.sales_invoice_id = $1 AND sii.deleted_at IS NULL
+		GROUP BY 1, 2
+		ORDER BY 1, 2
+	`, invoiceID))
+}
+
+// LoadPeriodHSNSummary aggregates INVOICED sales in [from, to) by HSN code and
+// rate, net of credit notes issued in the same period.
+func LoadPeriodHSNSummary(ctx context.Context, q db.Querier, from, to time.Time) ([]HSNSummary, error) {
+	return scanHSNSummary(q.Query(ctx, `
+		SELECT hsn_code, gst_percent, SUM(qty),
+		       ROUND(SUM(val), 2), ROUND(SUM(txval), 2),
+		       ROUND(SUM(camt), 2), ROUND(SUM(samt), 2), ROUND(SUM(iamt), 2)
+		FROM (
+			SELECT COALESCE(p.hsn_code, '') AS hsn_code, sii.gst_percent,
+			       sii.quantity AS qty, sii.line_total AS val,
+			       sii.line_total - sii.gst_amount AS txval,
+			       COALESCE(sii.cgst_amount, 0) AS camt,
+			       COALESCE(sii.sgst_amount, 0) AS samt,
+			       COALESCE(sii.igst_amount, 0) AS iamt
+			FROM sales_invoice_items sii
+			JOIN sales_invoices si ON si.id = sii.sales_invoice_id
+			JOIN products p ON p.id = sii.product_id
+			WHERE si.status = 'INVOICED' AND si.deleted_at IS NULL AND sii.deleted_at IS NULL
+			  AND si.invoiced_at >= $1 AND si.invoiced_at < $2
+
+			UNION ALL
+
+			SELECT COALESCE(p.hsn_code, ''), sri.gst_percent,
+			       -sri.quantity, -sri.line_total, -sri.taxable_amount,
+			       -sri.cgst_amount, -sri.sgst_amount, -sri.igst_amount
+			FROM sales_return_items sri
+			JOIN sales_returns sr ON sr.id = sri.sales_return_id
+			JOIN products p ON p.id = sri.product_id
+			WHERE sr.created_at >= $1 AND sr.created_at < $2
+		) t
+		GROUP BY hsn_code, gst_percent
+		ORDER BY hsn_code, gst_percent
+	`, from, to))
+}
+
+func scanHSNSummary(rows pgx.Rows, err error) ([]HSNSummary, error) {
+	if err != nil {
+		return nil, fmt.Errorf("load hsn summary: %w", err)
+	}
+	defer rows.Close()
+
+	summary := []HSNSummary{}
+	for rows.Next() {
+		var s HSNSummary
+		if err := rows.Scan(&s.HSNCode, &s.GSTPercent, &s.Quantity, &s.TotalValue,
+			&s.Taxable, &s.CGST, &s.SGST, &s.IGST); err != nil {
+			return nil, err
+		}
+		s.TotalTax = math.Round((s.CGST+s.SGST+s.IGST)*100) / 100
+		summary = append(summary, s)
+	}
+
+	return summary, rows.Err()
+}
@@ -8,6 +8,7 @@ import (
 
 	awsclient "tulsi-pos/aws"
 	"tulsi-pos/db"
+	"tulsi-pos/utils"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
@@ -19,14 +20,23 @@ type InvoiceHeader struct {
 	InvoiceNumber      string
 	CustomerName       string
 	CustomerMobile     string
+	PlaceOfSupply      string
+	TaxableAmount      float64
+	TotalCGST          float64
+	TotalSGST          float64
+	TotalIGST          float64
 	TotalInvoiceAmount float64
 }
 
 type InvoiceItem struct {
-	ProductName string
-	Quantity    int
-	SalesRate   float64
-	LineTotal   float64
+	ProductName   string
+	Quantity      int
+	SalesRate     float64
+	TaxableAmount float64
+	CGSTAmount    float64
+	SGSTAmount    float64
+	IGSTAmount    float64
+	LineTotal     float64
 }
 
 func GenerateAndUploadInvoicePDF(ctx context.Context, invoiceID int64) (string, error) {
@@ -40,17 +50,24 @@ func GenerateAndUploadInvoicePDF(ctx context.Context, invoiceID int64) (string,
 	// 1) Load header
 	var h InvoiceHeader
 	err := db.DB.QueryRow(ctx, `
-		SELECT id, invoice_number, customer_name, customer_mobile, total_invoice_amount
+		SELECT id, COALESCE(invoice_number, 'DRAFT-' || id), customer_name, customer_mobile,
+		       COALESCE(place_of_supply, ''), taxable_amount,
+		       COALESCE(total_cgst, 0), COALESCE(total_sgst, 0), COALESCE(total_igst, 0),
+		       total_invoice_amount
 		FROM sales_invoices
 		WHERE id = $1 AND deleted_at IS NULL
-	`, invoiceID).Scan(&h.ID, &h.InvoiceNumber, &h.CustomerName, &h.CustomerMobile, &h.TotalInvoiceAmount)
+	`, invoiceID).Scan(&h.ID, &h.InvoiceNumber, &h.CustomerName, &h.CustomerMobile,
+		&h.PlaceOfSupply, &h.TaxableAmount, &h.TotalCGST, &h.TotalSGST, &h.TotalIGST,
+		&h.TotalInvoiceAmount)
 	if err != nil {
 		return "", fmt.Errorf("load invoice header: %w", err)
 	}
 
 	// 2) Load items
 	rows, err := db.DB.Query(ctx, `
-		SELECT p.name, sii.quantity, sii.sales_rate, sii.line_total
+		SELECT p.name, sii.quantity, sii.sales_rate, sii.line_total - sii.gst_amount,
+		       COALESCE(sii.cgst_amount, 0), COALESCE(sii.sgst_amount, 0),
+		       COALESCE(sii.igst_amount, 0), sii.line_total
 		FROM sales_invoice_items sii
 		JOIN products p ON p.id = sii.product_id
 		WHERE sii.sales_invoice_id = $1 AND sii.deleted_at IS NULL
@@ -63,12 +80,18 @@ func GenerateAndUploadInvoicePDF(ctx context.Context, invoiceID int64) (string,
 	var items []InvoiceItem
 	for rows.Next() {
 		var it InvoiceItem
-		if err := rows.Scan(&it.ProductName, &it.Quantity, &it.SalesRate, &it.LineTotal); err != nil {
+		if err := rows.Scan(&it.ProductName, &it.Quantity, &it.SalesRate, &it.TaxableAmount,
+			&it.CGSTAmount, &it.SGSTAmount, &it.IGSTAmount, &it.LineTotal); err != nil {
 			return "", err
 		}
 		items = append(items, it)
 	}
 
+	hsnSummary, err := LoadInvoiceHSNSummary(ctx, db.DB, invoiceID)
+	if err != nil {
+		return "", err
+	}
+
 	// 3) Generate PDF in memory
 	pdf := gofpdf.New("P", "mm", "A4", "")
 	pdf.AddPage()
@@ -82,25 +105,77 @@ func GenerateAndUploadInvoicePDF(ctx context.Context, invoiceID int64) (string,
 	pdf.Cell(40, 6, fmt.Sprintf("Customer: %s", h.CustomerName))
 	pdf.Ln(5)
 	pdf.Cell(40, 6, fmt.Sprintf("Mobile: %s", h.CustomerMobile))
+	if h.PlaceOfSupply != "" {
+		pdf.Ln(5)
+		pdf.Cell(40, 6, fmt.Sprintf("Place of supply: %s-%s", h.PlaceOfSupply, utils.GSTStateNames[h.PlaceOfSupply]))
+	}
 	pdf.Ln(10)
 
-	pdf.SetFont("Arial", "B", 11)
-	pdf.Cell(80, 6, "Product")
-	pdf.Cell(20, 6, "Qty")
-	pdf.Cell(30, 6, "Rate")
-	pdf.Cell(30, 6, "Total")
+	pdf.SetFont("Arial", "B", 10)
+	pdf.Cell(55, 6, "Product")
+	pdf.Cell(10, 6, "Qty")
+	pdf.Cell(20, 6, "Rate")
+	pdf.Cell(22, 6, "Taxable")
+	pdf.Cell(18, 6, "CGST")
+	pdf.Cell(18, 6, "SGST")
+	pdf.Cell(18, 6, "IGST")
+	pdf.Cell(24, 6, "Total")
 	pdf.Ln(7)
 
-	pdf.SetFont("Arial", "", 10)
+	pdf.SetFont("Arial", "", 9)
 	for _, it := range items {
-		pdf.Cell(80, 5, it.ProductName)
-		pdf.Cell(20, 5, fmt.Sprintf("%d", it.Quantity))
-		pdf.Cell(30, 5, fmt.Sprintf("%.2f", it.SalesRate))
-		pdf.Cell(30, 5, fmt.Sprintf("%.2f", it.LineTotal))
+		pdf.Cell(55, 5, it.ProductName)
+		pdf.Cell(10, 5, fmt.Sprintf("%d", it.Quantity))
+		pdf.Cell(20, 5, fmt.Sprintf("%.2f", it.SalesRate))
+		pdf.Cell(22, 5, fmt.Sprintf("%.2f", it.TaxableAmount))
+		pdf.Cell(18, 5, fmt.Sprintf("%.2f", it.CGSTAmount))
+		pdf.Cell(18, 5, fmt.Sprintf("%.2f", it.SGSTAmount))
+		pdf.Cell(18, 5, fmt.Sprintf("%.2f", it.IGSTAmount))
+		pdf.Cell(24, 5, fmt.Sprintf("%.2f", it.LineTotal))
 		pdf.Ln(5)
 	}
 
-	pdf.Ln(10)
+	pdf.Ln(6)
+	pdf.SetFont("Arial", "B", 10)
+	pdf.Cell(40, 6, "HSN summary")
+	pdf.Ln(6)
+	pdf.Cell(30, 6, "HSN")
+	pdf.Cell(15, 6, "GST %")
+	pdf.Cell(28, 6, "Taxable")
+	pdf.Cell(24, 6, "CGST")
+	pdf.Cell(24, 6, "SGST")
+	pdf.Cell(24, 6, "IGST")
+	pdf.Cell(28, 6, "Total tax")
+	pdf.Ln(7)
+
+	pdf.SetFont("Arial", "", 9)
+	for _, s := range hsnSummary {
+		pdf.Cell(30, 5, s.HSNCode)
+		pdf.Cell(15, 5, fmt.Sprintf("%.2f", s.GSTPercent))
+		pdf.Cell(28, 5, fmt.Sprintf("%.2f", s.Taxable))
+		pdf.Cell(24, 5, fmt.Sprintf("%.2f", s.CGST))
+		pdf.Cell(24, 5, fmt.Sprintf("%.2f", s.SGST))
+		pdf.Cell(24, 5, fmt.Sprintf("%.2f", s.IGST))
+		pdf.Cell(28, 5, fmt.Sprintf("%.2f", s.TotalTax))
+		pdf.Ln(5)
+	}
+
+	pdf.Ln(8)
+	pdf.SetFont("Arial", "", 10)
+	pdf.Cell(40, 5, fmt.Sprintf("Taxable value: %.2f", h.TaxableAmount))
+	pdf.Ln(5)
+	if h.TotalIGST > 0 {
+		pdf.Cell(40, 5, fmt.Sprintf("IGST: %.2f", h.TotalIGST))
+		pdf.Ln(5)
+	}
+	if h.TotalCGST > 0 || h.TotalSGST > 0 {
+		pdf.Cell(40, 5, fmt.Sprintf("CGST: %.2f", h.TotalCGST))
+		pdf.Ln(5)
+		pdf.Cell(40, 5, fmt.Sprintf("SGST: %.2f", h.TotalSGST))
+		pdf.Ln(5)
+	}
+
+	pdf.Ln(3)
 	pdf.SetFont("Arial", "B", 12)
 	pdf.Cell(40, 6, fmt.Sprintf("Total: %.2f", h.TotalInvoiceAmount))
 
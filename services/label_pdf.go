@@ -0,0 +1,177 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"tulsi-pos/utils"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// LabelSheet is the layout of a sheet of labels, in millimetres.
+type LabelSheet struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	PageWidth   float64 `json:"page_width"`
+	PageHeight  float64 `json:"page_height"`
+	Columns     int     `json:"columns"`
+	Rows        int     `json:"rows"`
+	LabelWidth  float64 `json:"label_width"`
+	LabelHeight float64 `json:"label_height"`
+	TopMargin   float64 `json:"top_margin"`
+	LeftMargin  float64 `json:"left_margin"`
+	ColumnGap   float64 `json:"column_gap"`
+	RowGap      float64 `json:"row_gap"`
+}
+
+// PerPage is how many labels fit on one sheet.
+func (s LabelSheet) PerPage() int {
+	return s.Columns * s.Rows
+}
+
+const DefaultLabelSheet = "a4-65"
+
+// LabelSheets are the sheet layouts labels can be printed on: the common A4
+// sheets and a single-label roll for thermal printers.
+var LabelSheets = map[string]LabelSheet{
+	"a4-65": {Name: "a4-65", Description: "A4, 65 per sheet (38.1 x 21.2 mm)", PageWidth: 210, PageHeight: 297,
+		Columns: 5, Rows: 13, LabelWidth: 38.1, LabelHeight: 21.2, TopMargin: 10.7, LeftMargin: 4.75, ColumnGap: 2.5},
+	"a4-40": {Name: "a4-40", Description: "A4, 40 per sheet (48.5 x 25.4 mm)", PageWidth: 210, PageHeight: 297,
+		Columns: 4, Rows: 10, LabelWidth: 48.5, LabelHeight: 25.4, TopMargin: 21.5, LeftMargin: 8},
+	"a4-24": {Name: "a4-24", Description: "A4, 24 per sheet (63.5 x 33.9 mm)", PageWidth: 210, PageHeight: 297,
+		Columns: 3, Rows: 8, LabelWidth: 63.5, LabelHeight: 33.9, TopMargin: 12.9, LeftMargin: 7.25, ColumnGap: 2.5},
+	"a4-21": {Name: "a4-21", Description: "A4, 21 per sheet (63.5 x 38.1 mm)", PageWidth: 210, PageHeight: 297,
+		Columns: 3, Rows: 7, LabelWidth: 63.5, LabelHeight: 38.1, TopMargin: 15.15, LeftMargin: 7.25, ColumnGap: 2.5},
+	"roll-50x25": {Name: "roll-50x25", Description: "Roll, one 50 x 25 mm label per page", PageWidth: 50, PageHeight: 25,
+		Columns: 1, Rows: 1, LabelWidth: 50, LabelHeight: 25},
+}
+
+// Label is one price tag.
+type Label struct {
+	Name    string
+	Size    string
+	Colour  string
+	MRP     float64
+	Barcode string
+}
+
+const (
+	labelPadding = 1.5  // mm inside the label edge
+	maxModule    = 0.33 // mm, the nominal EAN-13 bar width
+	quietModules = 10   // blank modules either side of a barcode
+)
+
+// RenderLabelsPDF lays labels out on sheet, leaving the first skip positions
+// of the first page blank so a part-used sheet can be fed again.
+func RenderLabelsPDF(sheet LabelSheet, skip int, labels []Label) ([]byte, error) {
+	// the page size is taken as given; "L" would swap it
+	pdf := gofpdf.NewCustom(&gofpdf.InitType{
+		OrientationStr: "P",
+		UnitStr:        "mm",
+		Size:           gofpdf.SizeType{Wd: sheet.PageWidth, Ht: sheet.PageHeight},
+	})
+	pdf.SetMargins(0, 0, 0)
+	pdf.SetAutoPageBreak(false, 0)
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+
+	perPage := sheet.PerPage()
+	for i, l := range labels {
+		pos := (skip + i) % perPage
+		if i == 0 || pos == 0 {
+			pdf.AddPage()
+		}
+		x := sheet.LeftMargin + float64(pos%sheet.Columns)*(sheet.LabelWidth+sheet.ColumnGap)
+		y := sheet.TopMargin + float64(pos/sheet.Columns)*(sheet.LabelHeight+sheet.RowGap)
+		if err := drawLabel(pdf, tr, x, y, sheet.LabelWidth, sheet.LabelHeight, l); err != nil {
+			return nil, err
+		}
+	}
+	if len(labels) == 0 {
+		pdf.AddPage()
+	}
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, fmt.Errorf("render labels: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// drawLabel prints one tag: name, size and colour, MRP, then the barcode
+// with its text underneath. Type sizes follow the label height.
+func drawLabel(pdf *gofpdf.Fpdf, tr func(string) string, x, y, w, h float64, l Label) error {
+	modules, err := utils.EncodeBarcode(l.Barcode)
+	if err != nil {
+		return fmt.Errorf("barcode for %s: %w", l.Name, err)
+	}
+
+	innerW := w - 2*labelPadding
+	innerH := h - 2*labelPadding
+	lineH := innerH * 0.17
+	fontPt := lineH / 0.3528 * 0.85 // mm to points, leaving some leading
+	left := x + labelPadding
+	top := y + labelPadding
+
+	pdf.SetFont("Arial", "B", fontPt)
+	pdf.SetXY(left, top)
+	pdf.CellFormat(innerW, lineH, fitText(pdf, tr(l.Name), innerW), "", 0, "L", false, 0, "")
+
+	var attrs []string
+	if l.Size != "" {
+		attrs = append(attrs, "Size "+l.Size)
+	}
+	if l.Colour != "" {
+		attrs = append(attrs, l.Colour)
+	}
+	mrp := fmt.Sprintf("MRP Rs. %.2f", l.MRP)
+	pdf.SetFont("Arial", "B", fontPt)
+	mrpW := pdf.GetStringWidth(mrp)
+	pdf.SetXY(left, top+lineH)
+	pdf.CellFormat(innerW, lineH, mrp, "", 0, "R", false, 0, "")
+	pdf.SetFont("Arial", "", fontPt)
+	pdf.SetXY(left, top+lineH)
+	pdf.CellFormat(innerW-mrpW-1, lineH, fitText(pdf, tr(strings.Join(attrs, " / ")), innerW-mrpW-1), "", 0, "L", false, 0, "")
+
+	// bars fill what is left above the human-readable line
+	textH := lineH * 0.8
+	barTop := top + 2*lineH + 0.5
+	barH := top + innerH - textH - barTop
+	module := min(innerW/float64(len(modules)+2*quietModules), maxModule)
+	barX := left + (innerW-module*float64(len(modules)))/2
+
+	pdf.SetFillColor(0, 0, 0)
+	for i := 0; i < len(modules); {
+		if modules[i] != '1' {
+			i++
+			continue
+		}
+		run := 1
+		for i+run < len(modules) && modules[i+run] == '1' {
+			run++
+		}
+		pdf.Rect(barX+float64(i)*module, barTop, float64(run)*module, barH, "F")
+		i += run
+	}
+
+	pdf.SetFont("Courier", "", textH/0.3528)
+	pdf.SetXY(left, barTop+barH)
+	pdf.CellFormat(innerW, textH, l.Barcode, "", 0, "C", false, 0, "")
+	return nil
+}
+
+// fitText shortens s, already translated to the font's single-byte
+// encoding, with an ellipsis until it fits in width.
+func fitText(pdf *gofpdf.Fpdf, s string, width float64) string {
+	if width <= 0 {
+		return ""
+	}
+	if pdf.GetStringWidth(s) <= width {
+		return s
+	}
+	for len(s) > 0 && pdf.GetStringWidth(s+"...") > width {
+		s = s[:len(s)-1]
+	}
+	return s + "..."
+}
@@ -0,0 +1,179 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var ean13Pattern = regexp.MustCompile(`^[0-9]{13}$`)
+
+// InStoreBarcodePrefix starts the EAN-13 codes the store prints for its own
+// products. The 20-29 range is reserved for in-store use, so these never
+// clash with a manufacturer's code.
+const InStoreBarcodePrefix = "200"
+
+// EAN13CheckDigit computes the check digit for the first 12 digits of an
+// EAN-13: digits weighted 1, 3, 1, 3... from the left.
+func EAN13CheckDigit(digits12 string) int {
+	sum := 0
+	for i := 0; i < 12; i++ {
+		d := int(digits12[i] - '0')
+		if i%2 == 1 {
+			d *= 3
+		}
+		sum += d
+	}
+	return (10 - sum%10) % 10
+}
+
+// IsValidEAN13 reports whether s is 13 digits with a correct check digit.
+func IsValidEAN13(s string) bool {
+	if !ean13Pattern.MatchString(s) {
+		return false
+	}
+	return EAN13CheckDigit(s[:12]) == int(s[12]-'0')
+}
+
+// InStoreEAN13 builds the store's EAN-13 for a product id.
+func InStoreEAN13(id int64) string {
+	body := fmt.Sprintf("%s%09d", InStoreBarcodePrefix, id)
+	return fmt.Sprintf("%s%d", body, EAN13CheckDigit(body))
+}
+
+// Barcode symbologies the store prints.
+const (
+	BarcodeEAN13   = "ean13"
+	BarcodeCode128 = "code128"
+)
+
+var (
+	ErrBarcodeEmpty   = errors.New("barcode is empty")
+	ErrBarcodeCharset = errors.New("Code 128 barcodes take printable ASCII only")
+)
+
+var (
+	ean13L = [10]string{"0001101", "0011001", "0010011", "0111101", "0100011", "0110001", "0101111", "0111011", "0110111", "0001011"}
+	ean13G = [10]string{"0100111", "0110011", "0011011", "0100001", "0011101", "0111001", "0000101", "0010001", "0001001", "0010111"}
+	ean13R = [10]string{"1110010", "1100110", "1101100", "1000010", "1011100", "1001110", "1010000", "1000100", "1001000", "1110100"}
+
+	// which of the left-hand digits use the G set, by the first digit
+	ean13Parity = [10]string{"LLLLLL", "LLGLGG", "LLGGLG", "LLGGGL", "LGLLGG", "LGGLLG", "LGGGLL", "LGLGLG", "LGLGGL", "LGGLGL"}
+)
+
+// BarcodeSymbology picks how a stored barcode is printed: EAN-13 when it is
+// a valid one, Code 128 otherwise.
+func BarcodeSymbology(code string) string {
+	if IsValidEAN13(code) {
+		return BarcodeEAN13
+	}
+	return BarcodeCode128
+}
+
+// EncodeBarcode returns the modules of code in its symbology, '1' for a bar
+// and '0' for a space, without quiet zones.
+func EncodeBarcode(code string) (string, error) {
+	if BarcodeSymbology(code) == BarcodeEAN13 {
+		return EncodeEAN13(code)
+	}
+	return EncodeCode128(code)
+}
+
+// EncodeEAN13 returns the 95 modules of a valid EAN-13.
+func EncodeEAN13(code string) (string, error) {
+	if !IsValidEAN13(code) {
+		return "", fmt.Errorf("%q is not a valid EAN-13", code)
+	}
+
+	var b strings.Builder
+	b.WriteString("101")
+	parity := ean13Parity[code[0]-'0']
+	for i := 1; i <= 6; i++ {
+		d := code[i] - '0'
+		if parity[i-1] == 'G' {
+			b.WriteString(ean13G[d])
+		} else {
+			b.WriteString(ean13L[d])
+		}
+	}
+	b.WriteString("01010")
+	for i := 7; i <= 12; i++ {
+		b.WriteString(ean13R[code[i]-'0'])
+	}
+	b.WriteString("101")
+	return b.String(), nil
+}
+
+// code128Widths are the bar and space widths of each Code 128 symbol value.
+var code128Widths = [107]string{
+	"212222", "222122", "222221", "121223", "121322", "131222", "122213", "122312", "132212", "221213",
+	"221312", "231212", "112232", "122132", "122231", "113222", "123122", "123221", "223211", "221132",
+	"221231", "213212", "223112", "312131", "311222", "321122", "321221", "312212", "322112", "322211",
+	"212123", "212321", "232121", "111323", "131123", "131321", "112313", "132113", "132311", "211313",
+	"231113", "231311", "112133", "112331", "132131", "113123", "113321", "133121", "313121", "211331",
+	"231131", "213113", "213311", "213131", "311123", "311321", "331121", "312113", "312311", "332111",
+	"314111", "221411", "431111", "111224", "111422", "121124", "121421", "141122", "141221", "112214",
+	"112412", "122114", "122411", "142112", "142211", "241211", "221114", "413111", "241112", "134111",
+	"111242", "121142", "121241", "114212", "124112", "124211", "411212", "421112", "421211", "212141",
+	"214121", "412121", "111143", "111341", "131141", "114113", "114311", "411113", "411311", "113141",
+	"114131", "311141", "411131", "211412", "211214", "211232", "2331112",
+}
+
+const (
+	code128CodeC  = 99
+	code128StartB = 104
+	code128StartC = 105
+	code128Stop   = 106
+)
+
+var digitsPattern = regexp.MustCompile(`^[0-9]+$`)
+
+// EncodeCode128 returns the modules of s as Code 128. Four or more digits
+// are packed two to a symbol with code set C, after a leading digit in code
+// set B when the count is odd; anything else uses code set B.
+func EncodeCode128(s string) (string, error) {
+	if s == "" {
+		return "", ErrBarcodeEmpty
+	}
+
+	var values []int
+	if len(s) >= 4 && digitsPattern.MatchString(s) {
+		if len(s)%2 == 0 {
+			values = append(values, code128StartC)
+		} else {
+			// an odd leading digit goes in code set B
+			values = append(values, code128StartB, int(s[0])-32, code128CodeC)
+			s = s[1:]
+		}
+		for i := 0; i < len(s); i += 2 {
+			values = append(values, int(s[i]-'0')*10+int(s[i+1]-'0'))
+		}
+	} else {
+		values = append(values, code128StartB)
+		for i := 0; i < len(s); i++ {
+			if s[i] < 32 || s[i] > 126 {
+				return "", ErrBarcodeCharset
+			}
+			values = append(values, int(s[i])-32)
+		}
+	}
+
+	check := values[0]
+	for i, v := range values[1:] {
+		check += v * (i + 1)
+	}
+	values = append(values, check%103, code128Stop)
+
+	var b strings.Builder
+	for _, v := range values {
+		for i, w := range code128Widths[v] {
+			bit := "1"
+			if i%2 == 1 {
+				bit = "0"
+			}
+			b.WriteString(strings.Repeat(bit, int(w-'0')))
+		}
+	}
+	return b.String(), nil
+}
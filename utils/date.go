@@ -1,6 +1,9 @@
 package utils
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 const (
 	DateFormat     = "2006-01-02"
@@ -27,3 +30,13 @@ func FormatCustomDate(t time.Time, layout string) string {
 	}
 	return t.Format(layout)
 }
+
+// FinancialYear returns the Indian financial year (April–March) containing t,
+// formatted as "2026-27".
+func FinancialYear(t time.Time) string {
+	start := t.Year()
+	if t.Month() < time.April {
+		start--
+	}
+	return fmt.Sprintf("%d-%02d", start, (start+1)%100)
+}
@@ -0,0 +1,149 @@
+package utils
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// GSTStateNames maps GST state codes to state / union territory names.
+var GSTStateNames = map[string]string{
+	"01": "Jammu and Kashmir",
+	"02": "Himachal Pradesh",
+	"03": "Punjab",
+	"04": "Chandigarh",
+	"05": "Uttarakhand",
+	"06": "Haryana",
+	"07": "Delhi",
+	"08": "Rajasthan",
+	"09": "Uttar Pradesh",
+	"10": "Bihar",
+	"11": "Sikkim",
+	"12": "Arunachal Pradesh",
+	"13": "Nagaland",
+	"14": "Manipur",
+	"15": "Mizoram",
+	"16": "Tripura",
+	"17": "Meghalaya",
+	"18": "Assam",
+	"19": "West Bengal",
+	"20": "Jharkhand",
+	"21": "Odisha",
+	"22": "Chhattisgarh",
+	"23": "Madhya Pradesh",
+	"24": "Gujarat",
+	"26": "Dadra and Nagar Haveli and Daman and Diu",
+	"27": "Maharashtra",
+	"29": "Karnataka",
+	"30": "Goa",
+	"31": "Lakshadweep",
+	"32": "Kerala",
+	"33": "Tamil Nadu",
+	"34": "Puducherry",
+	"35": "Andaman and Nicobar Islands",
+	"36": "Telangana",
+	"37": "Andhra Pradesh",
+	"38": "Ladakh",
+	"97": "Other Territory",
+}
+
+// IsValidStateCode reports whether code is a known two-digit GST state code.
+func IsValidStateCode(code string) bool {
+	_, ok := GSTStateNames[code]
+	return ok
+}
+
+var gstinPattern = regexp.MustCompile(`^[0-9]{2}[A-Z]{5}[0-9]{4}[A-Z][1-9A-Z]Z[0-9A-Z]$`)
+
+const gstinCharset = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+var (
+	ErrGSTINFormat     = errors.New("GSTIN must be 15 characters: state code, PAN, entity number, Z and check digit")
+	ErrGSTINState      = errors.New("GSTIN starts with an unknown state code")
+	ErrGSTINCheckDigit = errors.New("GSTIN check digit does not match")
+)
+
+// ValidateGSTIN checks the format, state code and check digit of a GSTIN and
+// says which one failed.
+func ValidateGSTIN(gstin string) error {
+	if !gstinPattern.MatchString(gstin) {
+		return ErrGSTINFormat
+	}
+	if !IsValidStateCode(gstin[:2]) {
+		return ErrGSTINState
+	}
+	if gstinCheckDigit(gstin[:14]) != gstin[14] {
+		return ErrGSTINCheckDigit
+	}
+	return nil
+}
+
+// IsValidGSTIN checks the format, state code and check digit of a GSTIN.
+func IsValidGSTIN(gstin string) bool {
+	return ValidateGSTIN(gstin) == nil
+}
+
+// GSTINPAN returns the PAN embedded in a valid GSTIN.
+func GSTINPAN(gstin string) string {
+	return gstin[2:12]
+}
+
+var (
+	panPattern  = regexp.MustCompile(`^[A-Z]{5}[0-9]{4}[A-Z]$`)
+	ifscPattern = regexp.MustCompile(`^[A-Z]{4}0[A-Z0-9]{6}$`)
+)
+
+// IsValidPAN checks the AAAAA9999A layout of a PAN.
+func IsValidPAN(pan string) bool {
+	return panPattern.MatchString(pan)
+}
+
+// IsValidIFSC checks the layout of a bank branch IFSC: four letters, a zero
+// and six branch characters.
+func IsValidIFSC(ifsc string) bool {
+	return ifscPattern.MatchString(ifsc)
+}
+
+// GSTRates are the rates goods can be taxed at: the standard slabs plus the
+// special rates for precious stones and metals.
+var GSTRates = []float64{0, 0.25, 3, 5, 12, 18, 28, 40}
+
+// IsValidGSTRate reports whether rate is one of GSTRates.
+func IsValidGSTRate(rate float64) bool {
+	for _, r := range GSTRates {
+		if rate == r {
+			return true
+		}
+	}
+	return false
+}
+
+var hsnPattern = regexp.MustCompile(`^[0-9]{4}([0-9]{2}){0,2}$`)
+
+// IsValidHSN checks that an HSN code is 4, 6 or 8 digits.
+func IsValidHSN(code string) bool {
+	return hsnPattern.MatchString(code)
+}
+
+var hsnPrefixPattern = regexp.MustCompile(`^[0-9]{2,8}$`)
+
+// IsValidHSNPrefix checks that code is 2 to 8 digits: an HSN chapter, heading
+// or full code that a tax rule can cover every longer code under.
+func IsValidHSNPrefix(code string) bool {
+	return hsnPrefixPattern.MatchString(code)
+}
+
+// gstinCheckDigit computes the mod-36 check character used by GSTINs.
+func gstinCheckDigit(body string) byte {
+	sum := 0
+	for i := 0; i < len(body); i++ {
+		v := strings.IndexByte(gstinCharset, body[i])
+		factor := 1
+		if i%2 == 1 {
+			factor = 2
+		}
+		p := v * factor
+		sum += p/36 + p%36
+	}
+	return gstinCharset[(36-sum%36)%36]
+}
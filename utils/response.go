@@ -24,3 +24,13 @@ func SendErrorResponse(c *gin.Context, code int, message string) {
 		Message: message,
 	})
 }
+
+// SendErrorResponseWithData is SendErrorResponse with details the client can
+// act on, e.g. the lines that failed a check.
+func SendErrorResponseWithData(c *gin.Context, code int, message string, data interface{}) {
+	c.JSON(code, APIResponse{
+		Code:    code,
+		Data:    data,
+		Message: message,
+	})
+}